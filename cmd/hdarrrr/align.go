@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+)
+
+// runAlign implements the align command and returns the exit code
+func runAlign(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("align", "<image> <image>...",
+		"Aligns the frames of a bracket and writes every aligned frame to the output\n"+
+			"directory as <name><suffix>.<format>, keeping the bit depth of PNG inputs.", stderr)
+	outputDir := fs.String("output-dir", "aligned", "Directory for the aligned frames")
+	method := fs.String("method", "mtb", "Alignment method ("+strings.Join(align.Methods, ", ")+")")
+	maxShift := fs.Int("max-shift", align.DefaultMaxShift, "Largest offset searched by the mtb method, in pixels")
+	reference := fs.Int("reference", 0, "1-based index of the frame the others are aligned to (default the middle frame)")
+	suffix := fs.String("suffix", "_aligned", "Suffix added to the name of every frame")
+	format := fs.String("format", "png", "Output format (png, jpg)")
+
+	if code, ok := parseCommand(fs, args, 2, false); !ok {
+		return code
+	}
+	paths := fs.Args()
+	if *format != "png" && *format != "jpg" {
+		fmt.Fprintf(stderr, "Error: unsupported -format %q (png, jpg)\n", *format)
+		return exitUsage
+	}
+	if *reference < 0 || *reference > len(paths) {
+		fmt.Fprintf(stderr, "Error: -reference must be between 1 and %d\n", len(paths))
+		return exitUsage
+	}
+	aligner, err := align.New(*method)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+
+	images := make([]image.Image, len(paths))
+	depths := make([]int, len(paths))
+	for i, path := range paths {
+		img, err := imaging.Decode(path)
+		if err == nil {
+			images[i], err = imaging.ConvertToHDRContext(ctx, img, 0)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading %s: %v\n", path, err)
+			return exitInvalidInput
+		}
+		depths[i] = imaging.BitDepth(img)
+	}
+
+	if mtb, ok := aligner.(*align.MTBAligner); ok {
+		mtb.MaxShift = *maxShift
+		mtb.Reference = *reference - 1
+	}
+	aligned, offsets, err := align.AlignOffsets(ctx, aligner, images)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error aligning images:", err)
+		if ctx.Err() != nil {
+			return exitFailure
+		}
+		return exitAlignment
+	}
+	if offsets == nil {
+		offsets = make([]image.Point, len(images))
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitEncoding
+	}
+	for i, img := range aligned {
+		output := filepath.Join(*outputDir, baseName(paths[i])+*suffix+"."+*format)
+		if err := imaging.SaveImage(toLDR(img, depths[i]), output); err != nil {
+			fmt.Fprintf(stderr, "Error saving %s: %v\n", output, err)
+			return exitEncoding
+		}
+		fmt.Fprintf(stdout, "%s -> %s (offset %+d, %+d)\n", paths[i], output, offsets[i].X, offsets[i].Y)
+	}
+	return exitOK
+}
+
+// toLDR converts an HDR frame with channels in [0, 1] back to an 8 or 16-bit
+// image
+func toLDR(img image.Image, depth int) image.Image {
+	m, ok := img.(*hdr.RGB)
+	if !ok {
+		return img
+	}
+
+	b := m.Bounds()
+	var out interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if depth >= 16 {
+		out = image.NewRGBA64(b)
+	} else {
+		out = image.NewRGBA(b)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := m.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.Set(x, y, color.RGBA64{
+				R: unit16(m.Pix[i]), G: unit16(m.Pix[i+1]), B: unit16(m.Pix[i+2]), A: 0xffff,
+			})
+			i += 3
+		}
+	}
+	return out
+}
+
+// unit16 scales a channel in [0, 1] to 16 bits
+func unit16(v float32) uint16 {
+	return uint16(max(0, min(1, v))*0xffff + 0.5)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+// writeShiftedFrame writes a textured 16-bit frame of 128x96 pixels, seen
+// through a camera moved by shift, at the given exposure
+func writeShiftedFrame(t *testing.T, path string, shift image.Point, exposure float64) {
+	t.Helper()
+	img := image.NewGray16(image.Rect(0, 0, 128, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			// Blocks of 4x4 pixels with hashed brightness
+			h := uint32((x+shift.X)>>2)*2654435761 ^ uint32((y+shift.Y)>>2)*2246822519
+			v := float64(h>>16&0xff) / 255 * exposure
+			img.Pix[2*(y*128+x)] = uint8(min(1, v) * 255)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunAlign(t *testing.T) {
+	dir := t.TempDir()
+	low := filepath.Join(dir, "low.png")
+	mid := filepath.Join(dir, "mid.png")
+	writeShiftedFrame(t, low, image.Pt(2, -3), 0.5)
+	writeShiftedFrame(t, mid, image.Point{}, 1)
+	outputDir := filepath.Join(dir, "aligned")
+
+	var stdout, stderr bytes.Buffer
+	code := runAlign(context.Background(), []string{"-output-dir", outputDir, "-reference", "2", low, mid}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "(offset -2, +3)") {
+		t.Errorf("Expected the low frame offset in the output:\n%s", stdout.String())
+	}
+
+	for _, name := range []string{"low_aligned.png", "mid_aligned.png"} {
+		img, err := imaging.Decode(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if depth := imaging.BitDepth(img); depth != 16 {
+			t.Errorf("%s: expected 16 bits per channel, got %d", name, depth)
+		}
+	}
+}
+
+func TestRunAlignFailures(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	large := filepath.Join(dir, "large.png")
+	writeShiftedFrame(t, large, image.Point{}, 1)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"missing frame", []string{frames[0], filepath.Join(dir, "missing.png")}, 3},
+		{"different sizes", []string{frames[0], large}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-output-dir", filepath.Join(dir, "out")}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			if code := runAlign(context.Background(), args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunAlignUsage(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"single frame", frames[:1]},
+		{"bad method", append([]string{"-method", "sift"}, frames...)},
+		{"bad format", append([]string{"-format", "gif"}, frames...)},
+		{"bad reference", append([]string{"-reference", "4"}, frames...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runAlign(context.Background(), tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// Bracket grouping strategies of the batch command
+const (
+	groupAuto     = "auto"
+	groupTime     = "time"
+	groupExposure = "exposure"
+	groupSize     = "size"
+)
+
+// batchName holds the fields available to the -name template
+type batchName struct {
+	// Index is the 1-based position of the bracket
+	Index int
+	// First and Last are the base names, without extension, of the first
+	// and last frames of the bracket
+	First, Last string
+	// Count is the number of frames in the bracket
+	Count int
+}
+
+// batchResult is the outcome of processing one bracket
+type batchResult struct {
+	output string
+	inputs []string
+	err    error
+}
+
+// runBatch implements the batch command and returns the exit code
+func runBatch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("batch", "<dir>", "Merges every bracket of exposures found in dir.", stderr)
+	outputDir := fs.String("output-dir", "", "Directory for the results (default <dir>/hdr)")
+	group := fs.String("group", groupAuto, "Bracket grouping: auto (capture time, exposure sequence and similarity), time, exposure or size")
+	size := fs.Int("size", 3, "Frames per bracket for -group size")
+	gap := fs.Duration("gap", 2*time.Second, "Largest time between frames of a bracket for -group time and auto")
+	workers := fs.Int("workers", max(1, runtime.NumCPU()/4), "Number of brackets processed concurrently")
+	name := fs.String("name", "{{.First}}_hdr.jpg", "Output file name template (fields: .Index, .First, .Last, .Count)")
+	pf := addPipelineFlags(fs)
+
+	if code, ok := parseCommand(fs, args, 1, true); !ok {
+		return code
+	}
+	dir := fs.Arg(0)
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	if *outputDir == "" {
+		*outputDir = filepath.Join(dir, "hdr")
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(*name)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: invalid -name template:", err)
+		return exitUsage
+	}
+
+	paths, err := scanImages(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error scanning directory:", err)
+		return exitFailure
+	}
+	groups, err := groupFrames(ctx, paths, *group, *size, *gap)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error grouping brackets:", err)
+		return exitFailure
+	}
+
+	results, err := planBatch(groups, tmpl, *outputDir)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+
+	fmt.Fprintf(stdout, "Processing %d brackets from %d images with %d workers\n", len(results), len(paths), max(1, *workers))
+	processBatch(ctx, results, *workers, cfg.Options(), func(r *batchResult) {
+		if r.err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", r.output, r.err)
+		} else {
+			fmt.Fprintf(stdout, "ok   %s (%d frames)\n", r.output, len(r.inputs))
+		}
+	})
+
+	return printBatchSummary(stdout, results)
+}
+
+// scanImages returns the supported image files directly inside dir, sorted
+// by name
+func scanImages(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && imaging.SupportedFormats[strings.ToLower(filepath.Ext(e.Name()))] {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no PNG or JPEG images in %s", dir)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// groupFrames splits the images at paths into brackets with the named
+// strategy and orders every bracket from darkest to brightest. The auto
+// strategy uses the bracket detector, which combines capture time, exposure
+// sequence and image similarity.
+func groupFrames(ctx context.Context, paths []string, strategy string, size int, gap time.Duration) ([][]imaging.Frame, error) {
+	var groups [][]imaging.Frame
+	var err error
+	switch strategy {
+	case groupAuto:
+		opts := imaging.DefaultBracketOptions()
+		opts.MaxGap = gap
+		return imaging.DetectBrackets(ctx, paths, opts)
+	case groupTime:
+		groups, err = imaging.GroupByTime(imaging.ReadFrames(paths), gap)
+	case groupExposure:
+		groups, err = imaging.GroupByExposureSequence(imaging.ReadFrames(paths))
+	case groupSize:
+		groups, err = imaging.GroupBySize(imaging.ReadFrames(paths), size)
+	default:
+		return nil, fmt.Errorf("unknown grouping %q (auto, time, exposure, size)", strategy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if err := imaging.OrderByExposure(g); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// planBatch names the output of every bracket, rejecting templates that
+// give two brackets the same file
+func planBatch(groups [][]imaging.Frame, tmpl *template.Template, outputDir string) ([]*batchResult, error) {
+	results := make([]*batchResult, len(groups))
+	seen := make(map[string]int, len(groups))
+	for i, frames := range groups {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, batchName{
+			Index: i + 1,
+			First: baseName(frames[0].Path),
+			Last:  baseName(frames[len(frames)-1].Path),
+			Count: len(frames),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("naming bracket %d: %w", i+1, err)
+		}
+
+		output := filepath.Join(outputDir, buf.String())
+		if j, ok := seen[output]; ok {
+			return nil, fmt.Errorf("brackets %d and %d would both be saved to %s; use .Index in -name", j, i+1, output)
+		}
+		seen[output] = i + 1
+		results[i] = &batchResult{output: output, inputs: imaging.Paths(frames)}
+	}
+	return results, nil
+}
+
+// baseName returns the file name of path without its extension
+func baseName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// processBatch runs the brackets on up to workers goroutines, calling done
+// after each one
+func processBatch(ctx context.Context, results []*batchResult, workers int, opts []processor.Option, done func(*batchResult)) {
+	jobs := make(chan *batchResult)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for w := 0; w < max(1, workers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				if err := ctx.Err(); err != nil {
+					r.err = err
+				} else {
+					r.err = processor.NewHDRProcessor(opts...).RunContext(ctx, r.output, r.inputs...)
+				}
+
+				mu.Lock()
+				done(r)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, r := range results {
+		jobs <- r
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// printBatchSummary prints the counts of successes and failures and returns
+// the exit code
+func printBatchSummary(w io.Writer, results []*batchResult) int {
+	var failed []*batchResult
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, r)
+		}
+	}
+
+	fmt.Fprintf(w, "\nProcessed %d brackets: %d succeeded, %d failed\n", len(results), len(results)-len(failed), len(failed))
+	for _, r := range failed {
+		reason := r.err.Error()
+		if errors.Is(r.err, context.Canceled) {
+			reason = "canceled"
+		}
+		fmt.Fprintf(w, "- %s (%s): %s\n", r.output, strings.Join(r.inputs, ", "), reason)
+	}
+
+	if len(failed) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
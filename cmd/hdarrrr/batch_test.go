@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+// writeBracketFiles writes count small PNG frames named frame_<n>.png to dir.
+// Every group of three frames gets darker, so brackets must be reordered.
+func writeBracketFiles(t *testing.T, dir string, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		img := image.NewGray(image.Rect(0, 0, 8, 8))
+		for p := range img.Pix {
+			img.Pix[p] = uint8(80 - 40*(i%3) + p)
+		}
+		file, err := os.Create(filepath.Join(dir, "frame_"+string(rune('a'+i))+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	writeBracketFiles(t, dir, 7)
+	// Files that are not images are ignored
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0644)
+
+	var stdout, stderr bytes.Buffer
+	code := runBatch(context.Background(), []string{
+		"-group", "size", "-size", "3", "-workers", "2",
+		"-name", "{{.Index}}_{{.First}}-{{.Last}}.png", dir,
+	}, &stdout, &stderr)
+
+	if code != 1 {
+		t.Errorf("Expected exit code 1 for the incomplete last bracket, got %d", code)
+	}
+	for _, name := range []string{"1_frame_c-frame_a.png", "2_frame_f-frame_d.png"} {
+		if _, err := os.Stat(filepath.Join(dir, "hdr", name)); err != nil {
+			t.Errorf("Expected output %s: %v", name, err)
+		}
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "Processed 3 brackets: 2 succeeded, 1 failed") {
+		t.Errorf("Unexpected summary:\n%s", out)
+	}
+	if !strings.Contains(out, "3_frame_g-frame_g.png") || !strings.Contains(out, "at least two images") {
+		t.Errorf("Expected the failed bracket to be listed:\n%s", out)
+	}
+}
+
+func TestRunBatchUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"missing dir", nil},
+		{"bad template", []string{"-name", "{{.Index", t.TempDir()}},
+		{"unknown flag", []string{"-bogus", t.TempDir()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runBatch(context.Background(), tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+		})
+	}
+}
+
+func TestGroupFrames(t *testing.T) {
+	dir := t.TempDir()
+	writeBracketFiles(t, dir, 6)
+	paths, err := scanImages(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		strategy string
+		want     [][]string
+	}{
+		{groupSize, [][]string{{"frame_c", "frame_b", "frame_a"}, {"frame_f", "frame_e", "frame_d"}}},
+		// The frames share the same content and have no EXIF data, so the
+		// detector keeps them together
+		{groupAuto, [][]string{{"frame_c", "frame_f", "frame_b", "frame_e", "frame_a", "frame_d"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			groups, err := groupFrames(context.Background(), paths, tt.strategy, 3, 2*time.Second)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var got [][]string
+			for _, g := range groups {
+				var names []string
+				for _, f := range g {
+					names = append(names, baseName(f.Path))
+				}
+				got = append(got, names)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := groupFrames(context.Background(), paths, groupTime, 3, time.Second); err == nil {
+		t.Error("Expected error grouping by time without EXIF data, got nil")
+	}
+	if _, err := groupFrames(context.Background(), paths, "bogus", 3, time.Second); err == nil {
+		t.Error("Expected error for unknown strategy, got nil")
+	}
+}
+
+func TestPlanBatchDuplicateNames(t *testing.T) {
+	groups := [][]imaging.Frame{{{Path: "a.jpg"}, {Path: "b.jpg"}}, {{Path: "c.jpg"}, {Path: "d.jpg"}}}
+	tmpl := template.Must(template.New("name").Parse("out.jpg"))
+
+	if _, err := planBatch(groups, tmpl, "hdr"); err == nil {
+		t.Error("Expected error for duplicate output names, got nil")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/metrics"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/mdouchement/hdr"
+)
+
+// runCompare implements the compare command and returns the exit code
+func runCompare(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("compare", "<image>...",
+		"Lays out images side by side in a single image, each captioned with its\n"+
+			"file name. Radiance maps (.hdr, .pfm) are tone mapped with the pipeline\n"+
+			"settings first.\n\n"+
+			"With -tonemappers, the arguments are instead a bracket, or a single radiance\n"+
+			"map, that is merged once and rendered with each tone mapper in turn. See\n"+
+			"the sweep command to vary the other tone mapping settings.\n\n"+
+			"With -reference or -metrics, the images are scored against a reference\n"+
+			"instead, and the scores printed as a table: PSNR, SSIM and MS-SSIM against\n"+
+			"an image of the same kind, TMQI of display images against a radiance map,\n"+
+			"and the fraction of pixels where an observer would notice a difference.\n"+
+			"With -tonemappers and no -reference, the merged radiance map is the\n"+
+			"reference.", stderr)
+	outputPath := fs.String("output", "compare.jpg", "Path for the comparison image")
+	toneMappers := fs.String("tonemappers", "", "Comma-separated tone mappers to compare ("+strings.Join(processor.ToneMappers, ", ")+")")
+	columns := fs.Int("columns", 0, "Number of columns (default all images in one row)")
+	gap := fs.Int("gap", 8, "Space between the images, in pixels")
+	reference := fs.String("reference", "", "Reference image or radiance map to score the images against")
+	metricList := fs.String("metrics", "", "Comma-separated metrics to compute ("+strings.Join(metrics.Names, ", ")+"; default all that apply)")
+	asJSON := fs.Bool("json", false, "Print the scores as JSON")
+	mapsDir := fs.String("visibility-maps", "", "Directory to write the visibility map of each image to")
+	white := fs.Float64("display-white", metrics.DefaultWhite, "Luminance of display white, and of radiance 1, in cd/m²")
+	pf := addPipelineFlags(fs)
+
+	if code, ok := parseCommand(fs, args, 1, false); !ok {
+		return code
+	}
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	var operators []string
+	if *toneMappers != "" {
+		for _, op := range strings.Split(*toneMappers, ",") {
+			op = strings.TrimSpace(op)
+			if !slices.Contains(processor.ToneMappers, op) {
+				fmt.Fprintf(stderr, "Error: unsupported tone mapper %q (%s)\n", op, strings.Join(processor.ToneMappers, ", "))
+				return exitUsage
+			}
+			operators = append(operators, op)
+		}
+	}
+	var names []string
+	if *metricList != "" {
+		for _, name := range strings.Split(*metricList, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(metrics.Names, name) {
+				fmt.Fprintf(stderr, "Error: unsupported metric %q (%s)\n", name, strings.Join(metrics.Names, ", "))
+				return exitUsage
+			}
+			names = append(names, name)
+		}
+	}
+	scoring := *reference != "" || names != nil
+	if scoring && *reference == "" && operators == nil {
+		fmt.Fprintln(stderr, "Error: -metrics needs a -reference, or -tonemappers to score against the merged radiance map")
+		return exitUsage
+	}
+
+	var ref image.Image
+	if *reference != "" {
+		if ref, err = imaging.Decode(*reference); err != nil {
+			fmt.Fprintf(stderr, "Error: loading %s: %v\n", *reference, err)
+			return exitFailure
+		}
+	}
+	_, refRadiance := ref.(hdr.Image)
+
+	p := processor.NewHDRProcessor(cfg.Options()...)
+	var panels []image.Image
+	var labels []string
+	if operators != nil {
+		var merged hdr.Image
+		merged, panels, err = renderToneMappers(ctx, p, cfg.Options(), operators, fs.Args(), stderr)
+		labels = operators
+		if ref == nil {
+			ref = merged
+		}
+	} else {
+		panels, err = loadPanels(ctx, p, fs.Args(), scoring && refRadiance)
+		for _, path := range fs.Args() {
+			labels = append(labels, filepath.Base(path))
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+
+	if scoring {
+		if err := printScores(stdout, ref, panels, labels, names, *white, *mapsDir, *asJSON); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	if *columns <= 0 {
+		*columns = len(panels)
+	}
+	sheet, err := imaging.LabeledMontage(panels, labels, *columns, *gap, color.Black)
+	if err == nil {
+		err = p.SaveContext(ctx, sheet, *outputPath)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error saving comparison:", err)
+		return exitCode(err)
+	}
+
+	fmt.Fprintf(stdout, "Comparison saved to %s\n", *outputPath)
+	for i, label := range labels {
+		fmt.Fprintf(stdout, "%d. %s\n", i+1, label)
+	}
+	return exitOK
+}
+
+// loadPanels reads the images to compare, tone mapping radiance maps with p
+// unless keepRadiance is set
+func loadPanels(ctx context.Context, p *processor.HDRProcessor, paths []string, keepRadiance bool) ([]image.Image, error) {
+	panels := make([]image.Image, len(paths))
+	for i, path := range paths {
+		img, err := imaging.Decode(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", path, err)
+		}
+		if m, ok := img.(hdr.Image); ok && !keepRadiance {
+			if img, err = p.ToneMapContext(ctx, m); err != nil {
+				return nil, fmt.Errorf("tone mapping %s: %w", path, err)
+			}
+		}
+		panels[i] = img
+	}
+	return panels, nil
+}
+
+// renderToneMappers merges the bracket at paths once, or loads it when it is
+// a single radiance map, and tone maps it with every operator. It returns
+// the radiance map and the tone mapped images.
+func renderToneMappers(ctx context.Context, p *processor.HDRProcessor, opts []processor.Option, operators, paths []string, stderr io.Writer) (hdr.Image, []image.Image, error) {
+	var merged hdr.Image
+	var err error
+	if len(paths) == 1 && imaging.IsRadiance(paths[0]) {
+		merged, err = loadRadiance(ctx, p, paths[0])
+	} else if len(paths) < 2 {
+		return nil, nil, fmt.Errorf("-tonemappers needs a bracket of at least two images or one radiance map")
+	} else {
+		merged, err = mergeBracket(ctx, p, orderExposures(stderr, paths...), stderr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	panels := make([]image.Image, len(operators))
+	for i, op := range operators {
+		tm := processor.NewHDRProcessor(append(opts, processor.WithToneMapper(op))...)
+		if panels[i], err = tm.ToneMapContext(ctx, merged); err != nil {
+			return nil, nil, fmt.Errorf("tone mapping with %s: %w", op, err)
+		}
+	}
+	return merged, panels, nil
+}
+
+// scoreReport is the scores of an image printed by compare -json
+type scoreReport struct {
+	Image string `json:"image"`
+	metrics.Scores
+	VisibilityMap string `json:"visibility_map,omitempty"`
+}
+
+// printScores scores the images against ref with the named metrics, or all
+// that apply, and prints them as a table or JSON. Images are shown at white
+// cd/m². With mapsDir, the visibility map of each image is written there.
+func printScores(w io.Writer, ref image.Image, images []image.Image, labels, names []string, white float64, mapsDir string, asJSON bool) error {
+	if mapsDir != "" {
+		if err := os.MkdirAll(mapsDir, 0755); err != nil {
+			return err
+		}
+	}
+	refFrame := metrics.NewFrame(ref, white)
+	reports := make([]scoreReport, len(images))
+	for i, img := range images {
+		test := metrics.NewFrame(img, white)
+		want := names
+		if want == nil {
+			want = metrics.Applicable(refFrame, test)
+		}
+		if mapsDir != "" && !slices.Contains(want, "visibility") {
+			want = append(slices.Clip(want), "visibility")
+		}
+		scores, err := metrics.Compare(refFrame, test, want)
+		if err != nil {
+			return fmt.Errorf("scoring %s: %w", labels[i], err)
+		}
+		reports[i] = scoreReport{Image: labels[i], Scores: scores}
+		if mapsDir != "" {
+			path := filepath.Join(mapsDir, baseName(labels[i])+"_visibility.png")
+			if err := imaging.SaveImage(scores.Visibility.Image(refFrame), path); err != nil {
+				return fmt.Errorf("saving visibility map: %w", err)
+			}
+			reports[i].VisibilityMap = path
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+
+	// Show the columns of the metrics computed for any image
+	var columns []string
+	for _, name := range metrics.Names {
+		for _, r := range reports {
+			if r.cell(name) != "-" {
+				columns = append(columns, name)
+				break
+			}
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "IMAGE")
+	for _, name := range columns {
+		fmt.Fprint(tw, "\t"+strings.ToUpper(name))
+	}
+	fmt.Fprintln(tw)
+	for _, r := range reports {
+		fmt.Fprint(tw, r.Image)
+		for _, name := range columns {
+			fmt.Fprint(tw, "\t"+r.cell(name))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// cell formats the named metric of the report for the table, or "-" when
+// it was not computed
+func (r scoreReport) cell(name string) string {
+	s := r.Scores
+	switch {
+	case name == "psnr" && s.PSNR != nil:
+		return fmt.Sprintf("%.2f dB", *s.PSNR)
+	case name == "ssim" && s.SSIM != nil:
+		return fmt.Sprintf("%.4f", *s.SSIM)
+	case name == "ms-ssim" && s.MSSSIM != nil:
+		return fmt.Sprintf("%.4f", *s.MSSSIM)
+	case name == "tmqi" && s.TMQI != nil:
+		return fmt.Sprintf("%.4f", s.TMQI.Q)
+	case name == "visibility" && s.Visibility != nil:
+		return fmt.Sprintf("%.1f%%", 100*s.Visibility.Visible)
+	}
+	return "-"
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+func TestRunCompare(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	radiance := filepath.Join(dir, "scene.hdr")
+	writeRadiance(t, radiance)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantSize   image.Point
+		wantLabels []string
+	}{
+		{"images", []string{frames[0], frames[1]}, 0, image.Pt(24, 29), []string{"1. frame_a.png", "2. frame_b.png"}},
+		// Cells have a 21 pixel caption strip; the 6x4 radiance map is centered
+		// in an 8x8 image area
+		{"image and radiance map", []string{"-gap", "2", frames[0], radiance}, 0, image.Pt(18, 29), nil},
+		{"tone mappers on a bracket", append([]string{"-tonemappers", "drago03,reinhard05", "-columns", "1"}, frames...), 0,
+			image.Pt(8, 29+8+29), []string{"1. drago03", "2. reinhard05"}},
+		{"tone mappers on a radiance map", []string{"-tonemappers", "reinhard05", radiance}, 0, image.Pt(6, 25), nil},
+		{"tone mappers on one image", []string{"-tonemappers", "drago03", frames[0]}, 1, image.Point{}, nil},
+		{"unknown tone mapper", []string{"-tonemappers", "drago03,aces", radiance}, 2, image.Point{}, nil},
+		{"missing image", []string{filepath.Join(dir, "missing.png")}, 1, image.Point{}, nil},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, "compare_"+string(rune('a'+i))+".png")
+			var stdout, stderr bytes.Buffer
+			code := runCompare(context.Background(), append([]string{"-output", output}, tt.args...), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			if code != 0 {
+				return
+			}
+
+			img, err := imaging.Decode(output)
+			if err != nil {
+				t.Fatalf("Failed to read comparison: %v", err)
+			}
+			if img.Bounds().Size() != tt.wantSize {
+				t.Errorf("Expected size %v, got %v", tt.wantSize, img.Bounds().Size())
+			}
+			for _, label := range tt.wantLabels {
+				if !strings.Contains(stdout.String(), label) {
+					t.Errorf("Expected %q in output:\n%s", label, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+// writeScene writes a 48x32 textured radiance map to dir, along with two
+// renderings of it for a display, and returns their paths
+func writeScene(t *testing.T, dir string) (radiance, soft, harsh string) {
+	t.Helper()
+	m := hdr.NewRGB(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			v := math.Pow(10, float64(x)/12-2) * (1 + 0.4*math.Sin(float64(x+y)))
+			m.SetRGB(x, y, hdrcolor.RGB{R: v, G: v, B: v})
+		}
+	}
+	radiance = filepath.Join(dir, "scene.hdr")
+	if err := imaging.SaveRadiance(m, radiance); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		path *string
+		name string
+		fn   func(v float64) float64
+	}{
+		{&soft, "soft.png", func(v float64) float64 { return math.Pow(v/(v+1), 1/2.2) }},
+		{&harsh, "harsh.png", func(v float64) float64 { return min(v, 1) }},
+	} {
+		img := image.NewGray16(m.Bounds())
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 48; x++ {
+				v, _, _, _ := m.HDRAt(x, y).HDRRGBA()
+				img.SetGray16(x, y, color.Gray16{Y: uint16(0xffff * r.fn(v))})
+			}
+		}
+		*r.path = filepath.Join(dir, r.name)
+		if err := imaging.SaveImage(img, *r.path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return radiance, soft, harsh
+}
+
+func TestRunCompareScores(t *testing.T) {
+	dir := t.TempDir()
+	radiance, soft, harsh := writeScene(t, dir)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout []string
+	}{
+		{"display reference", []string{"-reference", soft, soft, harsh}, 0,
+			[]string{"PSNR", "MS-SSIM", "VISIBILITY", "soft.png   100.00 dB  1.0000  1.0000   0.0%", "harsh.png"}},
+		{"radiance reference", []string{"-reference", radiance, "-metrics", "tmqi", soft, harsh}, 0,
+			[]string{"IMAGE      TMQI\n", "soft.png   0."}},
+		{"radiance maps", []string{"-reference", radiance, "-metrics", "psnr", radiance}, 0, []string{"scene.hdr  100.00 dB"}},
+		{"tone mappers against the merged map", []string{"-tonemappers", "drago03,reinhard05", "-metrics", "tmqi,visibility", radiance}, 0,
+			[]string{"TMQI", "drago03", "reinhard05"}},
+		{"metrics without a reference", []string{"-metrics", "psnr", soft}, 2, nil},
+		{"unknown metric", []string{"-reference", soft, "-metrics", "psnr,vmaf", harsh}, 2, nil},
+		{"inapplicable metric", []string{"-reference", soft, "-metrics", "tmqi", harsh}, 1, nil},
+		{"missing reference", []string{"-reference", filepath.Join(dir, "missing.png"), harsh}, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runCompare(context.Background(), tt.args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("Expected %q in output:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRunCompareScoresJSON(t *testing.T) {
+	dir := t.TempDir()
+	_, soft, harsh := writeScene(t, dir)
+	maps := filepath.Join(dir, "maps")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-reference", soft, "-metrics", "ssim", "-visibility-maps", maps, "-json", harsh}
+	if code := runCompare(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+
+	var reports []struct {
+		Image      string   `json:"image"`
+		PSNR       *float64 `json:"psnr"`
+		SSIM       *float64 `json:"ssim"`
+		Visibility *struct {
+			Visible float64 `json:"visible"`
+		} `json:"visibility"`
+		VisibilityMap string `json:"visibility_map"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, stdout.String())
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected one report, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Image != "harsh.png" || r.PSNR != nil || r.SSIM == nil || *r.SSIM >= 1 || r.Visibility == nil {
+		t.Errorf("Unexpected report: %+v", r)
+	}
+	if want := filepath.Join(maps, "harsh_visibility.png"); r.VisibilityMap != want {
+		t.Errorf("Expected visibility map %s, got %s", want, r.VisibilityMap)
+	}
+	img, err := imaging.Decode(r.VisibilityMap)
+	if err != nil {
+		t.Fatalf("Failed to read visibility map: %v", err)
+	}
+	if img.Bounds().Size() != image.Pt(48, 32) {
+		t.Errorf("Expected a 48x32 visibility map, got %v", img.Bounds().Size())
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// pipelineFlags holds the pipeline flags shared by the commands. The
+// settings come from the defaults, a preset or a config file, and flags
+// given on the command line override them.
+type pipelineFlags struct {
+	config     *string
+	preset     *string
+	working    *string
+	output     *string
+	align      *string
+	merge      *string
+	denoise    *string
+	strength   *float64
+	shadows    *float64
+	toneMapper *string
+	gamma      *float64
+	intensity  *float64
+	light      *float64
+	preserve   *string
+	exponent   *float64
+	saturation *float64
+	vibrance   *float64
+	quality    *int
+	hdr        *string
+	white      *float64
+	peak       *float64
+	bitDepth   *int
+	gainMap    *bool
+	wb         *string
+	kelvin     *float64
+	tint       *float64
+	grayPoint  *string
+	adaptation *string
+}
+
+// addPipelineFlags defines the flags of every pipeline stage on fs
+func addPipelineFlags(fs *flag.FlagSet) *pipelineFlags {
+	f := addSettingsFlags(fs)
+	f.addMergeFlags(fs)
+	f.addToneMapFlags(fs)
+	return f
+}
+
+// addSettingsFlags defines the config file, preset and color space flags on
+// fs. The stage flags are added with addMergeFlags, addDenoiseFlags and
+// addToneMapFlags.
+func addSettingsFlags(fs *flag.FlagSet) *pipelineFlags {
+	d := config.Default()
+	spaces := strings.Join(colorspace.Names, ", ")
+	return &pipelineFlags{
+		config:  fs.String("config", "", "Pipeline config file (.yaml, .toml or .json)"),
+		preset:  fs.String("preset", "", "Named preset ("+strings.Join(config.Presets(), ", ")+")"),
+		working: fs.String("working-space", d.Color.Working, "Color space to merge and tone map in ("+spaces+")"),
+		output:  fs.String("output-space", d.Color.Output, "Color space of the output image ("+spaces+")"),
+	}
+}
+
+// addMergeFlags defines the alignment and merge flags on fs
+func (f *pipelineFlags) addMergeFlags(fs *flag.FlagSet) {
+	d := config.Default()
+	f.align = fs.String("align", d.Alignment.Method, "Alignment method ("+strings.Join(align.Methods, ", ")+")")
+	f.merge = fs.String("merge", d.Merge.Method, "Merge method ("+strings.Join(processor.MergeMethods, ", ")+")")
+	f.addDenoiseFlags(fs)
+}
+
+// addDenoiseFlags defines the noise reduction flags on fs
+func (f *pipelineFlags) addDenoiseFlags(fs *flag.FlagSet) {
+	d := config.Default()
+	f.denoise = fs.String("denoise", d.Denoise.Method, "Reduce the noise of the radiance map with this method ("+strings.Join(processor.Denoisers, ", ")+")")
+	f.strength = fs.Float64("denoise-strength", d.Denoise.Strength, "Noise reduction strength at and above mid-tones (1 removes the estimated noise)")
+	f.shadows = fs.Float64("denoise-shadows", d.Denoise.Shadows, "Noise reduction strength in the shadows, 4 stops below mid-tones and darker")
+}
+
+// addToneMapFlags defines the tone mapping and output flags on fs
+func (f *pipelineFlags) addToneMapFlags(fs *flag.FlagSet) {
+	d := config.Default()
+	f.toneMapper = fs.String("tonemapper", d.ToneMap.Operator, "Tone mapping operator ("+strings.Join(processor.ToneMappers, ", ")+")")
+	f.gamma = fs.Float64("gamma", d.ToneMap.Gamma, "Gamma correction value")
+	f.intensity = fs.Float64("intensity", d.ToneMap.Intensity, "Intensity adjustment")
+	f.light = fs.Float64("light", d.ToneMap.Light, "Light adaptation (Reinhard05 only)")
+	f.preserve = fs.String("preserve-color", d.ToneMap.PreserveColor, "Map luminance alone and keep colors with this method ("+strings.Join(processor.ColorPreservations, ", ")+")")
+	f.exponent = fs.Float64("color-exponent", d.ToneMap.ColorExponent, "Saturation exponent of -preserve-color ratio")
+	f.saturation = fs.Float64("saturation", d.ToneMap.Saturation, "Saturation of the tone mapped colors (1 keeps it)")
+	f.vibrance = fs.Float64("vibrance", d.ToneMap.Vibrance, "Saturation boost of muted colors, from -1 to 1")
+	f.quality = fs.Int("quality", d.Output.Quality, "JPEG output quality (1-100)")
+	f.hdr = fs.String("hdr", d.HDR.Transfer, "Encode for HDR displays with this transfer function ("+strings.Join(processor.HDRTransfers, ", ")+") instead of tone mapping")
+	f.white = fs.Float64("reference-white", d.HDR.ReferenceWhite, "Luminance of diffuse white in cd/m² for -hdr")
+	f.peak = fs.Float64("peak-luminance", d.HDR.PeakLuminance, "Luminance highlights roll off to in cd/m² for -hdr")
+	f.bitDepth = fs.Int("bit-depth", d.HDR.BitDepth, "Bits per sample of TIFF output for -hdr (10 or 16)")
+	f.wb = fs.String("white-balance", d.WhiteBalance.Mode, "White balance before tone mapping ("+strings.Join(processor.WhiteBalances, ", ")+")")
+	f.kelvin = fs.Float64("temperature", d.WhiteBalance.Temperature, "Color temperature of the light in kelvins to correct (implies -white-balance temperature)")
+	f.tint = fs.Float64("tint", d.WhiteBalance.Tint, "Tint of the light to correct, positive for green (implies -white-balance temperature)")
+	f.grayPoint = fs.String("gray-point", "", "Pixel x,y of a neutral surface (implies -white-balance gray-point)")
+	f.adaptation = fs.String("adaptation", d.WhiteBalance.Adaptation, "Chromatic adaptation of the white balance ("+strings.Join(colorspace.Adaptations, ", ")+")")
+	f.gainMap = fs.Bool("gain-map", d.HDR.GainMap, "Embed a gain map in JPEG output for HDR displays (Ultra HDR)")
+}
+
+// resolve builds the configuration from the preset and config file, then
+// applies the flags that were set explicitly on fs. Flags that were not
+// defined on fs keep the values of the preset or config file.
+func (f *pipelineFlags) resolve(fs *flag.FlagSet) (config.Config, error) {
+	var cfg config.Config
+	var err error
+	switch {
+	case *f.config != "":
+		cfg, err = config.Load(*f.config, *f.preset)
+	case *f.preset != "":
+		cfg, err = config.Preset(*f.preset)
+	default:
+		cfg = config.Default()
+	}
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "working-space":
+			cfg.Color.Working = *f.working
+		case "output-space":
+			cfg.Color.Output = *f.output
+		case "align":
+			cfg.Alignment.Method = *f.align
+		case "merge":
+			cfg.Merge.Method = *f.merge
+		case "denoise":
+			cfg.Denoise.Method = *f.denoise
+		case "denoise-strength":
+			cfg.Denoise.Strength = *f.strength
+		case "denoise-shadows":
+			cfg.Denoise.Shadows = *f.shadows
+		case "tonemapper":
+			cfg.ToneMap.Operator = *f.toneMapper
+		case "gamma":
+			cfg.ToneMap.Gamma = *f.gamma
+		case "intensity":
+			cfg.ToneMap.Intensity = *f.intensity
+		case "light":
+			cfg.ToneMap.Light = *f.light
+		case "preserve-color":
+			cfg.ToneMap.PreserveColor = *f.preserve
+		case "color-exponent":
+			cfg.ToneMap.ColorExponent = *f.exponent
+		case "saturation":
+			cfg.ToneMap.Saturation = *f.saturation
+		case "vibrance":
+			cfg.ToneMap.Vibrance = *f.vibrance
+		case "quality":
+			cfg.Output.Quality = *f.quality
+		case "hdr":
+			cfg.HDR.Transfer = *f.hdr
+		case "reference-white":
+			cfg.HDR.ReferenceWhite = *f.white
+		case "peak-luminance":
+			cfg.HDR.PeakLuminance = *f.peak
+		case "bit-depth":
+			cfg.HDR.BitDepth = *f.bitDepth
+		case "gain-map":
+			cfg.HDR.GainMap = *f.gainMap
+		case "temperature", "tint":
+			cfg.WhiteBalance.Temperature, cfg.WhiteBalance.Tint = *f.kelvin, *f.tint
+			if !isSet(fs, "white-balance") {
+				cfg.WhiteBalance.Mode = "temperature"
+			}
+		case "gray-point":
+			if cfg.WhiteBalance.GrayPoint, err = parsePoint(*f.grayPoint); err != nil {
+				flagErr = fmt.Errorf("invalid -gray-point: %w", err)
+			}
+			if !isSet(fs, "white-balance") {
+				cfg.WhiteBalance.Mode = "gray-point"
+			}
+		case "white-balance":
+			cfg.WhiteBalance.Mode = *f.wb
+		case "adaptation":
+			cfg.WhiteBalance.Adaptation = *f.adaptation
+		}
+	})
+	if flagErr != nil {
+		return config.Config{}, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return config.Config{}, fmt.Errorf("invalid settings: %w", err)
+	}
+	return cfg, nil
+}
+
+// parsePoint parses a pixel given as "x,y"
+func parsePoint(s string) ([]int, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	if !ok {
+		return nil, fmt.Errorf("%q is not x,y", s)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(xs))
+	if err != nil {
+		return nil, err
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(ys))
+	if err != nil {
+		return nil, err
+	}
+	return []int{x, y}, nil
+}
+
+// isSet reports whether the flag name was given on the command line
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			set = true
+		}
+	})
+	return set
+}
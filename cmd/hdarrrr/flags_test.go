@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+)
+
+// resolveArgs parses args with the pipeline flags and resolves the config
+func resolveArgs(t *testing.T, args ...string) (*pipelineFlags, *flag.FlagSet) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	pf := addPipelineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return pf, fs
+}
+
+func TestPipelineFlagsResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.yaml")
+	content := "preset: natural\ntonemap:\n  gamma: 0.7\n  intensity: 1.2\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		args          []string
+		wantOperator  string
+		wantGamma     float64
+		wantIntensity float64
+		wantMerge     string
+	}{
+		{"defaults", nil, "drago03", 1, 1, "average"},
+		{"preset", []string{"-preset", "dramatic"}, "reinhard05", 1, 1.5, "average"},
+		{"preset with flag", []string{"-preset", "dramatic", "-intensity", "2"}, "reinhard05", 1, 2, "average"},
+		{"config file", []string{"-config", path}, "drago03", 0.7, 1.2, "average"},
+		// Flags override the file even when they are set to the default value
+		{"config with flags", []string{"-config", path, "-gamma", "1", "-merge", "average"}, "drago03", 1, 1.2, "average"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pf, fs := resolveArgs(t, tt.args...)
+			cfg, err := pf.resolve(fs)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cfg.ToneMap.Operator != tt.wantOperator || cfg.ToneMap.Gamma != tt.wantGamma ||
+				cfg.ToneMap.Intensity != tt.wantIntensity || cfg.Merge.Method != tt.wantMerge {
+				t.Errorf("Unexpected config: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestPipelineFlagsResolveErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-preset", "vivid"},
+		{"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+		{"-tonemapper", "mantiuk"},
+		{"-quality", "0"},
+		{"-output-space", "cmyk"},
+		{"-hdr", "dolby"},
+		{"-hdr", "pq", "-bit-depth", "12"},
+		{"-hdr", "pq", "-gain-map"},
+		{"-preserve-color", "lab"},
+		{"-vibrance", "1.5"},
+		{"-white-balance", "auto"},
+		{"-temperature", "500"},
+		{"-gray-point", "4"},
+		{"-gray-point", "4,y"},
+		{"-white-balance", "gray-world", "-adaptation", "xyz"},
+		{"-denoise", "median"},
+		{"-denoise", "bilateral", "-denoise-shadows", "-1"},
+	} {
+		pf, fs := resolveArgs(t, args...)
+		if _, err := pf.resolve(fs); err == nil {
+			t.Errorf("%v: expected error, got nil", args)
+		}
+	}
+}
+
+func TestPipelineFlagsColorSpaces(t *testing.T) {
+	pf, fs := resolveArgs(t, "-working-space", "acescg", "-output-space", "display-p3")
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Color.Working != "acescg" || cfg.Color.Output != "display-p3" {
+		t.Errorf("Unexpected color settings: %+v", cfg.Color)
+	}
+}
+
+func TestPipelineFlagsToneMapColor(t *testing.T) {
+	pf, fs := resolveArgs(t, "-preserve-color", "oklab", "-saturation", "1.1", "-vibrance", "0.4")
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := config.Default().ToneMap
+	want.PreserveColor, want.Saturation, want.Vibrance = "oklab", 1.1, 0.4
+	if cfg.ToneMap != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.ToneMap)
+	}
+}
+
+func TestPipelineFlagsWhiteBalance(t *testing.T) {
+	tests := []struct {
+		args []string
+		want config.WhiteBalance
+	}{
+		{nil, config.Default().WhiteBalance},
+		{[]string{"-temperature", "3200"}, config.WhiteBalance{Mode: "temperature", Temperature: 3200, Adaptation: "bradford"}},
+		{[]string{"-tint", "-10", "-adaptation", "cat02"}, config.WhiteBalance{Mode: "temperature", Temperature: 6500, Tint: -10, Adaptation: "cat02"}},
+		{[]string{"-gray-point", "12, 7"}, config.WhiteBalance{Mode: "gray-point", Temperature: 6500, GrayPoint: []int{12, 7}, Adaptation: "bradford"}},
+		{[]string{"-white-balance", "gray-world"}, config.WhiteBalance{Mode: "gray-world", Temperature: 6500, Adaptation: "bradford"}},
+		{[]string{"-white-balance", "white-patch", "-temperature", "4000"}, config.WhiteBalance{Mode: "white-patch", Temperature: 4000, Adaptation: "bradford"}},
+	}
+
+	for _, tt := range tests {
+		pf, fs := resolveArgs(t, tt.args...)
+		cfg, err := pf.resolve(fs)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+		if !reflect.DeepEqual(cfg.WhiteBalance, tt.want) {
+			t.Errorf("%v: expected %+v, got %+v", tt.args, tt.want, cfg.WhiteBalance)
+		}
+	}
+}
+
+func TestPipelineFlagsDenoise(t *testing.T) {
+	tests := []struct {
+		args []string
+		want config.Denoise
+	}{
+		{nil, config.Default().Denoise},
+		{[]string{"-denoise", "wavelet"}, config.Denoise{Method: "wavelet", Strength: 1, Shadows: 2}},
+		{[]string{"-denoise", "bilateral", "-denoise-strength", "0.5", "-denoise-shadows", "3"}, config.Denoise{Method: "bilateral", Strength: 0.5, Shadows: 3}},
+	}
+
+	for _, tt := range tests {
+		pf, fs := resolveArgs(t, tt.args...)
+		cfg, err := pf.resolve(fs)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+		if cfg.Denoise != tt.want {
+			t.Errorf("%v: expected %+v, got %+v", tt.args, tt.want, cfg.Denoise)
+		}
+	}
+}
+
+func TestPipelineFlagsHDR(t *testing.T) {
+	pf, fs := resolveArgs(t, "-hdr", "hlg", "-peak-luminance", "600", "-bit-depth", "10")
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.HDR.Transfer != "hlg" || cfg.HDR.PeakLuminance != 600 || cfg.HDR.BitDepth != 10 || cfg.HDR.ReferenceWhite != 203 {
+		t.Errorf("Unexpected HDR settings: %+v", cfg.HDR)
+	}
+	if !isSet(fs, "hdr") || isSet(fs, "reference-white") {
+		t.Error("Expected only the given flags to be set")
+	}
+}
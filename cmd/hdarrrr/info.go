@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/analysis"
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// Histogram sizes: characters of the sparkline printed by info, and pixels
+// of the charts written by -histograms
+const (
+	sparklineWidth = 32
+	chartWidth     = 512
+	chartHeight    = 160
+)
+
+// infoReport is the outcome of the info command, printed by -json
+type infoReport struct {
+	Images []imageInfo `json:"images"`
+	// Bracket analyzes the images merged as a bracket, with -bracket
+	Bracket *bracketInfo `json:"bracket,omitempty"`
+}
+
+// imageInfo describes an image
+type imageInfo struct {
+	Path     string `json:"path"`
+	Format   string `json:"format"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	BitDepth int    `json:"bit_depth"`
+	// ColorSpace is the space the image is tagged with, empty when it is
+	// untagged or a radiance map
+	ColorSpace string `json:"color_space,omitempty"`
+	// ColorSpaceError tells why a color space tag was not supported
+	ColorSpaceError string         `json:"color_space_error,omitempty"`
+	EXIF            *exifReport    `json:"exif,omitempty"`
+	Luminance       analysis.Stats `json:"luminance"`
+
+	metadata *imaging.Metadata
+}
+
+// bracketInfo analyzes a bracket: the merged radiance map and how much
+// each frame adds to it
+type bracketInfo struct {
+	Merge  string         `json:"merge"`
+	Merged analysis.Stats `json:"merged"`
+	// Frames lists the frames darkest first, as they were merged
+	Frames []frameInfo `json:"frames"`
+}
+
+// frameInfo is the contribution of a frame to the merge
+type frameInfo struct {
+	Path string `json:"path"`
+	analysis.Contribution
+}
+
+// runInfo implements the info command and returns the exit code
+func runInfo(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("info", "<image>...",
+		"Shows the dimensions, bit depth, EXIF exposure data, luminance histogram,\n"+
+			"dynamic range and clipping of PNG, JPEG and radiance (.hdr, .pfm) images.\n"+
+			"With -bracket, the images are also merged, and the dynamic range of the\n"+
+			"scene and the frames that contribute to the merge are reported.", stderr)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	histDir := fs.String("histograms", "", "Directory to write a histogram chart of each image to")
+	bracket := fs.Bool("bracket", false, "Merge the images as a bracket and analyze the result")
+	pf := addSettingsFlags(fs)
+	pf.addMergeFlags(fs)
+
+	if code, ok := parseCommand(fs, args, 1, false); !ok {
+		return code
+	}
+	var cfg config.Config
+	if *bracket {
+		if fs.NArg() < 2 {
+			fmt.Fprintln(stderr, "Error: -bracket needs at least two images")
+			return exitUsage
+		}
+		var err error
+		if cfg, err = pf.resolve(fs); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitUsage
+		}
+	}
+	if *histDir != "" {
+		if err := os.MkdirAll(*histDir, 0755); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+	}
+
+	code := exitOK
+	report := infoReport{Images: []imageInfo{}}
+	for _, path := range fs.Args() {
+		if err := ctx.Err(); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+		info, err := describeImage(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading %s: %v\n", path, err)
+			code = exitFailure
+			continue
+		}
+		if *histDir != "" {
+			if err := writeHistogram(*histDir, baseName(path), info.Luminance.Histogram); err != nil {
+				fmt.Fprintln(stderr, "Error:", err)
+				code = exitFailure
+			}
+		}
+		if !*asJSON {
+			if len(report.Images) > 0 {
+				fmt.Fprintln(stdout)
+			}
+			printInfo(stdout, info)
+		}
+		report.Images = append(report.Images, info)
+	}
+
+	if *bracket && code == exitOK {
+		b, err := analyzeBracket(ctx, cfg, fs.Args(), stderr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitCode(err)
+		}
+		if *histDir != "" {
+			if err := writeHistogram(*histDir, "merged", b.Merged.Histogram); err != nil {
+				fmt.Fprintln(stderr, "Error:", err)
+				code = exitFailure
+			}
+		}
+		if !*asJSON {
+			fmt.Fprintln(stdout)
+			printBracket(stdout, b)
+		}
+		report.Bracket = b
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+	}
+	return code
+}
+
+// describeImage reads the image at path and measures its luminance
+func describeImage(path string) (imageInfo, error) {
+	img, err := imaging.Decode(path)
+	if err != nil {
+		return imageInfo{}, err
+	}
+	b := img.Bounds()
+	info := imageInfo{
+		Path:      path,
+		Format:    strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), ".")),
+		Width:     b.Dx(),
+		Height:    b.Dy(),
+		BitDepth:  imaging.BitDepth(img),
+		Luminance: analysis.Analyze(img),
+	}
+
+	if !info.Luminance.Radiance {
+		switch s, err := imaging.ReadColorSpace(path); {
+		case err == nil:
+			info.ColorSpace = s.String()
+		case !errors.Is(err, imaging.ErrNoColorSpace):
+			info.ColorSpaceError = err.Error()
+		}
+	}
+	if m, err := imaging.ReadMetadata(path); err == nil {
+		info.metadata = &m
+		info.EXIF = newEXIFReport(m)
+	}
+	return info, nil
+}
+
+// printInfo prints the description of an image
+func printInfo(w io.Writer, info imageInfo) {
+	fmt.Fprintln(w, info.Path)
+	fmt.Fprintf(w, "  Format:        %s\n", info.Format)
+	fmt.Fprintf(w, "  Dimensions:    %d x %d\n", info.Width, info.Height)
+	if info.Luminance.Radiance {
+		fmt.Fprintf(w, "  Bit depth:     %d-bit float per channel\n", info.BitDepth)
+	} else {
+		fmt.Fprintf(w, "  Bit depth:     %d bits per channel\n", info.BitDepth)
+		switch {
+		case info.ColorSpace != "":
+			fmt.Fprintf(w, "  Color space:   %s\n", info.ColorSpace)
+		case info.ColorSpaceError != "":
+			fmt.Fprintf(w, "  Color space:   unsupported (assumed sRGB): %s\n", info.ColorSpaceError)
+		default:
+			fmt.Fprintln(w, "  Color space:   untagged (assumed sRGB)")
+		}
+	}
+
+	if info.metadata != nil {
+		printMetadata(w, *info.metadata)
+	} else {
+		fmt.Fprintln(w, "  EXIF:          none")
+	}
+	printLuminance(w, info.Luminance)
+}
+
+// printLuminance prints the luminance statistics of an image, and its
+// clipping when it is a display image
+func printLuminance(w io.Writer, s analysis.Stats) {
+	fmt.Fprintf(w, "  Luminance:     min %.4g, mean %.4g, max %.4g\n", s.Min, s.Mean, s.Max)
+	fmt.Fprintf(w, "  Dynamic range: %.1f EV\n", s.Stops)
+	if !s.Radiance {
+		fmt.Fprintf(w, "  Clipped:       %.2f%% shadows, %.2f%% highlights\n", 100*s.Shadows, 100*s.Highlights)
+	}
+	if s.Histogram.Log {
+		fmt.Fprintf(w, "  Histogram:     %.1f EV |%s| %.1f EV\n", s.Histogram.Min, s.Histogram.Sparkline(sparklineWidth), s.Histogram.Max)
+	} else {
+		fmt.Fprintf(w, "  Histogram:     |%s|\n", s.Histogram.Sparkline(sparklineWidth))
+	}
+}
+
+// printMetadata prints the EXIF fields that were found
+func printMetadata(w io.Writer, m imaging.Metadata) {
+	if !m.CaptureTime.IsZero() {
+		fmt.Fprintf(w, "  Captured:      %s\n", m.CaptureTime.Format("2006-01-02 15:04:05.000"))
+	}
+
+	var settings []string
+	if m.ExposureTime > 0 {
+		if m.ExposureTime < 1 {
+			settings = append(settings, fmt.Sprintf("1/%.0f s", 1/m.ExposureTime))
+		} else {
+			settings = append(settings, fmt.Sprintf("%g s", m.ExposureTime))
+		}
+	}
+	if m.FNumber > 0 {
+		settings = append(settings, fmt.Sprintf("f/%g", m.FNumber))
+	}
+	if m.ISO > 0 {
+		settings = append(settings, fmt.Sprintf("ISO %d", m.ISO))
+	}
+	if m.HasExposureBias {
+		settings = append(settings, fmt.Sprintf("%+.1f EV", m.ExposureBias))
+	}
+	if len(settings) > 0 {
+		fmt.Fprintf(w, "  Exposure:      %s\n", strings.Join(settings, ", "))
+	}
+}
+
+// analyzeBracket aligns and merges the exposures at paths, and measures the
+// merged radiance map and the contribution of each frame
+func analyzeBracket(ctx context.Context, cfg config.Config, paths []string, stderr io.Writer) (*bracketInfo, error) {
+	inputs := orderExposures(stderr, paths...)
+	p := processor.NewHDRProcessor(cfg.Options()...)
+	aligned, err := alignBracket(ctx, p, inputs, stderr)
+	if err != nil {
+		return nil, err
+	}
+	contributions, err := analysis.Contributions(aligned)
+	if err != nil {
+		return nil, fmt.Errorf("analyzing bracket: %w", err)
+	}
+	merged, err := mergeAligned(ctx, p, aligned)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bracketInfo{Merge: cfg.Merge.Method, Merged: analysis.Analyze(merged)}
+	for i, c := range contributions {
+		b.Frames = append(b.Frames, frameInfo{Path: inputs[i], Contribution: c})
+	}
+	return b, nil
+}
+
+// printBracket prints the analysis of a bracket and the frames worth
+// merging
+func printBracket(w io.Writer, b *bracketInfo) {
+	fmt.Fprintf(w, "Bracket (%s merge)\n", b.Merge)
+	printLuminance(w, b.Merged)
+	fmt.Fprintln(w, "  Best exposed:")
+	var keep []string
+	for _, f := range b.Frames {
+		note := ""
+		if f.Contributes {
+			keep = append(keep, filepath.Base(f.Path))
+		} else {
+			note = " (adds little, consider dropping it)"
+		}
+		fmt.Fprintf(w, "    %6.2f%%  %s%s\n", 100*f.Share, f.Path, note)
+	}
+	if len(keep) == 0 {
+		fmt.Fprintln(w, "  Recommended:   none, every frame is clipped or crushed")
+		return
+	}
+	fmt.Fprintf(w, "  Recommended:   %s\n", strings.Join(keep, ", "))
+}
+
+// writeHistogram writes the chart of h to dir as <name>_histogram.png
+func writeHistogram(dir, name string, h analysis.Histogram) error {
+	path := filepath.Join(dir, name+"_histogram.png")
+	if err := imaging.SaveImage(h.Chart(chartWidth, chartHeight), path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
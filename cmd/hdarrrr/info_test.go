@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunInfo(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	radiance := filepath.Join(dir, "scene.hdr")
+	writeRadiance(t, radiance)
+
+	var stdout, stderr bytes.Buffer
+	code := runInfo(context.Background(), []string{frames[0], radiance, filepath.Join(dir, "missing.png")}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("Expected exit code 1 for the missing file, got %d", code)
+	}
+
+	out := stdout.String()
+	for _, want := range []string{
+		"Dimensions:    8 x 8",
+		"Bit depth:     8 bits per channel",
+		"Bit depth:     32-bit float per channel",
+		"Color space:   untagged (assumed sRGB)",
+		"EXIF:          none",
+		"Dynamic range: 8.0 EV",
+		"Clipped:",
+		"Histogram:     |",
+		" EV |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(stderr.String(), "missing.png") {
+		t.Errorf("Expected the missing file to be reported, got %q", stderr.String())
+	}
+}
+
+func TestRunInfoBracket(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	charts := filepath.Join(dir, "charts")
+
+	var stdout, stderr bytes.Buffer
+	args := append([]string{"-bracket", "-merge", "average", "-histograms", charts}, frames...)
+	if code := runInfo(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{"Bracket (average merge)", "Best exposed:", "Recommended:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+	for _, name := range []string{"frame_a", "frame_b", "frame_c", "merged"} {
+		path := filepath.Join(charts, name+"_histogram.png")
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected histogram chart %s: %v", path, err)
+		}
+	}
+}
+
+func TestRunInfoJSON(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	var stdout, stderr bytes.Buffer
+	args := append([]string{"-json", "-bracket"}, frames...)
+	if code := runInfo(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var report infoReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, stdout.String())
+	}
+	if len(report.Images) != 3 {
+		t.Fatalf("Expected 3 images, got %d", len(report.Images))
+	}
+	if img := report.Images[0]; img.Width != 8 || img.Height != 8 || len(img.Luminance.Histogram.Counts) == 0 {
+		t.Errorf("Expected an 8x8 image with a histogram, got %+v", img)
+	}
+	if report.Bracket == nil || len(report.Bracket.Frames) != 3 {
+		t.Fatalf("Expected the contributions of 3 frames, got %+v", report.Bracket)
+	}
+	if !report.Bracket.Merged.Radiance || !report.Bracket.Merged.Histogram.Log {
+		t.Error("Expected the merged image to be analyzed as a radiance map")
+	}
+}
+
+func TestRunInfoUsage(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"one frame bracket", []string{"-bracket", frames[0]}, "at least two images"},
+		{"invalid merge", []string{"-bracket", "-merge", "median", frames[0], frames[1]}, "merge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runInfo(context.Background(), tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+			if !strings.Contains(stderr.String(), tt.want) {
+				t.Errorf("Expected %q in stderr, got %q", tt.want, stderr.String())
+			}
+		})
+	}
+}
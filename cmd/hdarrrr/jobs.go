@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+)
+
+// jobCommands lists the subcommands of the jobs command
+var jobCommands = []command{
+	{"submit", "Queue a bracket and print the job ID", runJobsSubmit},
+	{"status", "Show the state and progress of jobs", runJobsStatus},
+	{"result", "Copy the output of a finished job", runJobsResult},
+	{"cancel", "Cancel a queued or running job", runJobsCancel},
+	{"prune", "Delete finished jobs", runJobsPrune},
+	{"work", "Run the queued jobs", runJobsWork},
+}
+
+// runJobs implements the jobs command, dispatching to its subcommands
+func runJobs(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		w := stderr
+		if len(args) > 0 {
+			w = stdout
+		}
+		printJobsUsage(w)
+		if len(args) > 0 {
+			return exitOK
+		}
+		return exitUsage
+	}
+	for _, c := range jobCommands {
+		if c.name == args[0] {
+			return c.run(ctx, args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "Error: unknown jobs command %q\n\n", args[0])
+	printJobsUsage(stderr)
+	return exitUsage
+}
+
+// printJobsUsage lists the subcommands of the jobs command
+func printJobsUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hdarrrr jobs <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\nJobs are kept in a store on disk and run in the background by 'hdarrrr jobs work'")
+	fmt.Fprintln(w, "or by 'hdarrrr serve -jobs-dir', so they survive restarts.")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range jobCommands {
+		fmt.Fprintf(w, "  %-7s %s\n", c.name, c.summary)
+	}
+}
+
+// defaultJobStore returns the default directory of the job store
+func defaultJobStore() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "hdarrrr-jobs"
+	}
+	return filepath.Join(dir, "hdarrrr", "jobs")
+}
+
+// addStoreFlag defines the -store flag on fs
+func addStoreFlag(fs *flag.FlagSet) *string {
+	return fs.String("store", defaultJobStore(), "Directory of the job store")
+}
+
+// openManager opens the job store at dir. Errors are reported on stderr.
+func openManager(dir string, stderr io.Writer, opts ...jobs.Option) (*jobs.Manager, bool) {
+	store, err := jobs.OpenStore(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return nil, false
+	}
+	return jobs.NewManager(store, opts...), true
+}
+
+// jobExitCode maps a job API error to an exit code
+func jobExitCode(err error) int {
+	if errors.Is(err, jobs.ErrNotFound) {
+		return exitUsage
+	}
+	return exitFailure
+}
+
+// runJobsSubmit implements jobs submit
+func runJobsSubmit(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("jobs submit", "<image>...",
+		"Copies the exposures into the job store and queues a job merging them.\n"+
+			"The job ID is printed on stdout.", stderr)
+	store := addStoreFlag(fs)
+	format := fs.String("format", "jpg", "Output format (jpg, png, hdr or pfm)")
+	pf := addPipelineFlags(fs)
+
+	if code, ok := parseCommand(fs, args, 2, false); !ok {
+		return code
+	}
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	spec := jobs.Spec{Settings: cfg, Format: strings.ToLower(*format)}
+	if err := spec.Validate(); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+
+	m, ok := openManager(*store, stderr)
+	if !ok {
+		return exitFailure
+	}
+	j, err := m.Submit(spec, fs.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitInvalidInput
+	}
+	fmt.Fprintln(stdout, j.ID)
+	return exitOK
+}
+
+// runJobsStatus implements jobs status
+func runJobsStatus(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("jobs status", "[id]...",
+		"Shows the state and progress of the given jobs, or of all jobs.", stderr)
+	store := addStoreFlag(fs)
+	asJSON := fs.Bool("json", false, "Print the job records as JSON")
+
+	if code, ok := parseCommand(fs, args, 0, false); !ok {
+		return code
+	}
+	m, ok := openManager(*store, stderr)
+	if !ok {
+		return exitFailure
+	}
+
+	var list []*jobs.Job
+	if fs.NArg() == 0 {
+		var err error
+		if list, err = m.List(); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+	}
+	for _, id := range fs.Args() {
+		j, err := m.Get(id)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s: %v\n", id, err)
+			return jobExitCode(err)
+		}
+		list = append(list, j)
+	}
+
+	if *asJSON {
+		if list == nil {
+			list = []*jobs.Job{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(list); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tPROGRESS\tFORMAT\tCREATED\tDETAILS")
+	for _, j := range list {
+		details := j.Stage
+		switch j.State {
+		case jobs.StateFailed:
+			details = j.Error
+		case jobs.StateSucceeded:
+			details = j.Result
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.0f%%\t%s\t%s\t%s\n", j.ID, j.State, j.Progress*100, j.Format,
+			j.CreatedAt.Local().Format(time.DateTime), details)
+	}
+	tw.Flush()
+	return exitOK
+}
+
+// runJobsResult implements jobs result
+func runJobsResult(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("jobs result", "<id>", "Copies the output of a job that succeeded.", stderr)
+	store := addStoreFlag(fs)
+	output := fs.String("o", "", "Output file (default <id> with the extension of the job format)")
+
+	if code, ok := parseCommand(fs, args, 1, true); !ok {
+		return code
+	}
+	m, ok := openManager(*store, stderr)
+	if !ok {
+		return exitFailure
+	}
+	id := fs.Arg(0)
+	j, path, err := m.Result(id)
+	if errors.Is(err, jobs.ErrNotReady) {
+		fmt.Fprintf(stderr, "Error: job %s is %s\n", id, j.State)
+		return exitFailure
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return jobExitCode(err)
+	}
+
+	if *output == "" {
+		*output = id + filepath.Ext(path)
+	}
+	if err := copyResult(*output, path); err != nil {
+		fmt.Fprintln(stderr, "Error saving output image:", err)
+		return exitEncoding
+	}
+	for _, warning := range j.Warnings {
+		fmt.Fprintln(stderr, "Warning:", warning)
+	}
+	fmt.Fprintf(stdout, "Saved %s\n", *output)
+	return exitOK
+}
+
+// copyResult copies the file at src to dst
+func copyResult(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runJobsCancel implements jobs cancel
+func runJobsCancel(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("jobs cancel", "<id>...",
+		"Cancels queued or running jobs. Running jobs stop at the next check of\n"+
+			"the process running them.", stderr)
+	store := addStoreFlag(fs)
+
+	if code, ok := parseCommand(fs, args, 1, false); !ok {
+		return code
+	}
+	m, ok := openManager(*store, stderr)
+	if !ok {
+		return exitFailure
+	}
+	code := exitOK
+	for _, id := range fs.Args() {
+		if _, err := m.Cancel(id); err != nil {
+			fmt.Fprintf(stderr, "Error: %s: %v\n", id, err)
+			code = jobExitCode(err)
+			continue
+		}
+		fmt.Fprintf(stdout, "Canceled %s\n", id)
+	}
+	return code
+}
+
+// runJobsPrune implements jobs prune
+func runJobsPrune(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("jobs prune", "",
+		"Deletes the jobs that finished longer ago than -older-than, with their files.", stderr)
+	store := addStoreFlag(fs)
+	olderThan := fs.Duration("older-than", 0, "Keep the jobs that finished more recently, e.g. 24h")
+
+	if code, ok := parseCommand(fs, args, 0, true); !ok {
+		return code
+	}
+	if *olderThan < 0 {
+		fmt.Fprintln(stderr, "Error: -older-than must not be negative")
+		return exitUsage
+	}
+	m, ok := openManager(*store, stderr)
+	if !ok {
+		return exitFailure
+	}
+	n, err := m.Store().Prune(time.Now().Add(-*olderThan))
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Deleted %d jobs\n", n)
+	return exitOK
+}
+
+// runJobsWork implements jobs work
+func runJobsWork(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("jobs work", "",
+		"Runs the queued jobs of the store, then exits. With -watch it keeps\n"+
+			"running new jobs until interrupted; interrupted jobs return to the queue.\n"+
+			"A store should be worked by one process at a time.", stderr)
+	store := addStoreFlag(fs)
+	concurrency := fs.Int("concurrency", jobs.DefaultConcurrency, "Number of jobs run at once")
+	watch := fs.Bool("watch", false, "Keep running new jobs until interrupted")
+	retention := fs.Duration("retention", 0, "Delete finished jobs older than this, e.g. 168h (default keep them)")
+
+	if code, ok := parseCommand(fs, args, 0, true); !ok {
+		return code
+	}
+	if *concurrency < 1 || *retention < 0 {
+		fmt.Fprintln(stderr, "Error: -concurrency must be at least 1 and -retention not negative")
+		return exitUsage
+	}
+	m, ok := openManager(*store, stderr, jobs.WithConcurrency(*concurrency), jobs.WithRetention(*retention))
+	if !ok {
+		return exitFailure
+	}
+
+	run := m.RunPending
+	if *watch {
+		fmt.Fprintf(stdout, "Watching %s for jobs\n", m.Store().Dir())
+		run = m.Run
+	}
+	if err := run(ctx); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	if ctx.Err() != nil && !*watch {
+		fmt.Fprintln(stderr, "Error:", ctx.Err())
+		return exitFailure
+	}
+	return exitOK
+}
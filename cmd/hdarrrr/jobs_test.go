@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+)
+
+// runJobsCommand runs a jobs subcommand and returns its exit code and output
+func runJobsCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := runJobs(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunJobs(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	store := filepath.Join(dir, "store")
+
+	code, stdout, stderr := runJobsCommand(append([]string{"submit", "-store", store, "-format", "png", "-preset", "natural"}, frames...)...)
+	if code != 0 {
+		t.Fatalf("submit: exit code %d: %s", code, stderr)
+	}
+	id := strings.TrimSpace(stdout)
+
+	code, stdout, _ = runJobsCommand("status", "-store", store)
+	if code != 0 || !strings.Contains(stdout, id) || !strings.Contains(stdout, "queued") {
+		t.Errorf("Expected the queued job in the status, got %d:\n%s", code, stdout)
+	}
+	if code, _, _ = runJobsCommand("result", "-store", store, id); code != 1 {
+		t.Errorf("Expected exit code 1 for the result of a queued job, got %d", code)
+	}
+
+	if code, _, stderr = runJobsCommand("work", "-store", store); code != 0 {
+		t.Fatalf("work: exit code %d: %s", code, stderr)
+	}
+
+	code, stdout, _ = runJobsCommand("status", "-store", store, "-json", id)
+	var list []jobs.Job
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil || code != 0 {
+		t.Fatalf("Expected a JSON status, got %d %v", code, err)
+	}
+	if len(list) != 1 || list[0].State != jobs.StateSucceeded || list[0].Settings.Preset != "natural" {
+		t.Errorf("Unexpected job %+v", list)
+	}
+
+	output := filepath.Join(dir, "result.png")
+	if code, _, stderr = runJobsCommand("result", "-store", store, "-o", output, id); code != 0 {
+		t.Fatalf("result: exit code %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Error(err)
+	}
+
+	if code, _, _ = runJobsCommand("cancel", "-store", store, id); code != 1 {
+		t.Errorf("Expected exit code 1 canceling a finished job, got %d", code)
+	}
+	code, stdout, _ = runJobsCommand("prune", "-store", store)
+	if code != 0 || !strings.Contains(stdout, "Deleted 1 jobs") {
+		t.Errorf("Expected the job to be pruned, got %d: %s", code, stdout)
+	}
+}
+
+func TestRunJobsCancel(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	store := filepath.Join(dir, "store")
+
+	_, stdout, _ := runJobsCommand(append([]string{"submit", "-store", store}, frames...)...)
+	id := strings.TrimSpace(stdout)
+	if code, _, stderr := runJobsCommand("cancel", "-store", store, id); code != 0 {
+		t.Fatalf("cancel: exit code %d: %s", code, stderr)
+	}
+	runJobsCommand("work", "-store", store)
+
+	_, stdout, _ = runJobsCommand("status", "-store", store, id)
+	if !strings.Contains(stdout, "canceled") {
+		t.Errorf("Expected a canceled job, got:\n%s", stdout)
+	}
+}
+
+func TestRunJobsErrors(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	store := filepath.Join(dir, "store")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"no command", nil, 2},
+		{"help", []string{"-h"}, 0},
+		{"unknown command", []string{"squash"}, 2},
+		{"single image", []string{"submit", "-store", store, frames[0]}, 2},
+		{"unknown format", append([]string{"submit", "-store", store, "-format", "gif"}, frames...), 2},
+		{"invalid settings", append([]string{"submit", "-store", store, "-tonemapper", "aces"}, frames...), 2},
+		{"missing image", []string{"submit", "-store", store, frames[0], filepath.Join(dir, "missing.png")}, 3},
+		{"unknown job", []string{"status", "-store", store, "0123456789abcdef"}, 2},
+		{"result of unknown job", []string{"result", "-store", store, "0123456789abcdef"}, 2},
+		{"bad concurrency", []string{"work", "-store", store, "-concurrency", "0"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _, stderr := runJobsCommand(tt.args...); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr)
+			}
+		})
+	}
+}
@@ -1,140 +1,197 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"image"
-	"log"
+	"io"
+	"math"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 
-	"github.com/harperreed/hdarrrr/internal/processor"
-	"github.com/harperreed/hdarrrr/pkg/align"
 	"github.com/harperreed/hdarrrr/pkg/imaging"
-	"github.com/mdouchement/hdr"
-	"github.com/mdouchement/hdr/hdrcolor"
 )
 
-// convertToHDR converts a regular image to HDR format
-func convertToHDR(img image.Image) hdr.Image {
-	bounds := img.Bounds()
-	hdrImg := hdr.NewRGB(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			hdrImg.Set(x, y, hdrcolor.RGB{
-				R: float64(r) / 0xffff,
-				G: float64(g) / 0xffff,
-				B: float64(b) / 0xffff,
-			})
-		}
-	}
+// Exit codes of the commands
+const (
+	exitOK      = 0
+	exitFailure = 1
+	// exitUsage reports invalid flags or arguments
+	exitUsage = 2
+	// exitInvalidInput reports input images that cannot be read or do not
+	// form a bracket
+	exitInvalidInput = 3
+	// exitAlignment reports that the frames could not be aligned
+	exitAlignment = 4
+	// exitEncoding reports that the output could not be written
+	exitEncoding = 5
+)
 
-	return hdrImg
+// command is a subcommand of the CLI
+type command struct {
+	name    string
+	summary string
+	// run executes the command with its arguments and returns the exit code
+	run func(ctx context.Context, args []string, stdout, stderr io.Writer) int
 }
 
-// convertToRegular converts an HDR image to regular format
-// func convertToRegular(img hdr.Image) image.Image {
-// 	bounds := img.Bounds()
-// 	regular := image.NewRGBA(bounds)
-
-// 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-// 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-// 			r, g, b, _ := img.HDRAt(x, y).HDRRGBA()
-// 			regular.Set(x, y, color.RGBA{
-// 				R: uint8(r * 255),
-// 				G: uint8(g * 255),
-// 				B: uint8(b * 255),
-// 				A: 255,
-// 			})
-// 		}
-// 	}
-
-// 	return regular
-// }
+// commands lists the subcommands in the order of the help text
+var commands = []command{
+	{"process", "Merge and tone map a bracket into a displayable image", runProcess},
+	{"merge", "Merge a bracket into a radiance map (.hdr or .pfm)", runMerge},
+	{"tonemap", "Tone map a radiance map into a displayable image", runToneMap},
+	{"align", "Align the frames of a bracket and write them out", runAlign},
+	{"info", "Show dimensions, bit depth, EXIF data and dynamic range of images", runInfo},
+	{"compare", "Lay out images or tone mappers side by side", runCompare},
+	{"sweep", "Render a grid of tone mapping settings on a contact sheet", runSweep},
+	{"tune", "Tune the tone mapping of an image in a local web page", runTune},
+	{"batch", "Merge every bracket found in a directory", runBatch},
+	{"watch", "Merge brackets as they appear in a directory", runWatch},
+	{"serve", "Run an HTTP server exposing the pipeline", runServe},
+	{"jobs", "Queue brackets and run them in the background", runJobs},
+}
 
 func main() {
-	// Define command line flags
-	img1Path := flag.String("low", "", "Path to low exposure image (required)")
-	img2Path := flag.String("mid", "", "Path to mid exposure image (required)")
-	img3Path := flag.String("high", "", "Path to high exposure image (required)")
-	outputPath := flag.String("output", "hdr_output.jpg", "Path for output HDR image")
-	tonemapperFlag := flag.String("tonemapper", "drago03", "Tone mapping operator (reinhard05, drago03)")
-	gammaFlag := flag.Float64("gamma", 1.0, "Gamma correction value")
-	intensityFlag := flag.Float64("intensity", 1.0, "Intensity adjustment")
-	lightFlag := flag.Float64("light", 0.0, "Light adaptation (Reinhard05 only)")
-
-	// Parse command line arguments
-	flag.Parse()
-
-	// Validate required arguments
-	if *img1Path == "" || *img2Path == "" || *img3Path == "" {
-		fmt.Println("Error: All three exposure images are required")
-		fmt.Println("\nUsage:")
-		flag.PrintDefaults()
-		os.Exit(1)
+	// Cancel processing on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run dispatches args to a subcommand and returns the exit code. Arguments
+// starting with a flag run the process command, as the CLI did before it
+// had subcommands.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return exitUsage
 	}
 
-	// Load images
-	images, err := imaging.LoadImages(*img1Path, *img2Path, *img3Path)
-	if err != nil {
-		log.Fatal("Error loading images:", err)
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) > 1 {
+			if cmd, ok := findCommand(args[1]); ok {
+				return cmd.run(ctx, []string{"-h"}, stdout, stderr)
+			}
+		}
+		printUsage(stdout)
+		return exitOK
+	case strings.HasPrefix(name, "-"):
+		return runProcess(ctx, args, stdout, stderr)
 	}
 
-	// Convert regular images to HDR for alignment
-	hdrImages := make([]hdr.Image, len(images))
-	for i, img := range images {
-		hdrImages[i] = convertToHDR(img)
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "Error: unknown command %q\n\n", name)
+		printUsage(stderr)
+		return exitUsage
 	}
+	return cmd.run(ctx, args[1:], stdout, stderr)
+}
 
-	// Align HDR images
-	alignedImages, err := align.AlignImages(images) // Align regular images first
-	if err != nil {
-		log.Printf("Warning: Image alignment failed: %v", err)
-		log.Println("Proceeding with unaligned images...")
-		alignedImages = images
+// findCommand looks up a subcommand by name
+func findCommand(name string) (command, bool) {
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if i < 0 {
+		return command{}, false
 	}
+	return commands[i], true
+}
 
-	// Convert aligned images to HDR
-	alignedHDRImages := make([]hdr.Image, len(alignedImages))
-	for i, img := range alignedImages {
-		alignedHDRImages[i] = convertToHDR(img)
+// printUsage lists the subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hdarrrr <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintln(w, "\nRun 'hdarrrr help <command>' or 'hdarrrr <command> -h' for the flags of a command.")
+	fmt.Fprintln(w, "Flags given without a command run process, e.g. hdarrrr -low a.jpg -mid b.jpg -high c.jpg.")
+}
 
-	// Create HDR processor with configured parameters
-	hdrProc := processor.NewHDRProcessor().
-		WithToneMapper(*tonemapperFlag).
-		WithParams(map[string]float64{
-			"gamma":     *gammaFlag,
-			"intensity": *intensityFlag,
-			"light":     *lightFlag,
-		})
-
-	// Process HDR image
-	output, err := hdrProc.Process(alignedImages)
-	if err != nil {
-		log.Fatal("Error processing HDR:", err)
+// newFlagSet creates the flag set of a command. The usage text shows the
+// arguments and description above the flags.
+func newFlagSet(name, arguments, description string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: hdarrrr %s [flags] %s\n\n%s\n\nFlags:\n", name, arguments, description)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	// Create output directory if it doesn't exist
-	if dir := filepath.Dir(*outputPath); dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Fatal("Error creating output directory:", err)
+// parseCommand parses args and checks that at least minArgs arguments
+// remain, or exactly that many when exact is set. When the command should
+// stop it returns false with the exit code: 0 after -h, 2 on usage errors.
+func parseCommand(fs *flag.FlagSet, args []string, minArgs int, exact bool) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
 		}
+		return exitUsage, false
+	}
+	if fs.NArg() < minArgs || (exact && fs.NArg() != minArgs) {
+		fs.Usage()
+		return exitUsage, false
 	}
+	return exitOK, true
+}
+
+// orderExposures sorts the exposures from darkest to brightest using their
+// EXIF data or, failing that, their brightness. The given order is kept if
+// the images cannot be read.
+func orderExposures(stderr io.Writer, paths ...string) []string {
+	return imaging.Paths(orderFrames(&warnings{w: stderr}, imaging.ReadFrames(paths)))
+}
 
-	// Save the result
-	if err := imaging.SaveImage(output, *outputPath); err != nil {
-		log.Fatal("Error saving output image:", err)
+// orderFrames is orderExposures for frames whose EXIF data has been read.
+// It sorts frames in place and returns them.
+func orderFrames(ws *warnings, frames []imaging.Frame) []imaging.Frame {
+	paths := imaging.Paths(frames)
+	if err := imaging.OrderByExposure(frames); err != nil {
+		ws.add("could not order exposures: %v", err)
+		return frames
+	}
+
+	if ordered := imaging.Paths(frames); !slices.Equal(ordered, paths) {
+		fmt.Fprintf(ws.w, "Ordered exposures from darkest to brightest: %s\n", strings.Join(ordered, ", "))
+	}
+	return frames
+}
+
+// sizeUnits maps size suffixes to their number of bytes
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a byte count such as "1048576", "512MB" or "2GiB"
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	scale := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			scale = unit.bytes
+			break
+		}
 	}
 
-	fmt.Printf("HDR image successfully saved to %s\n", *outputPath)
-	fmt.Printf("Processing parameters:\n")
-	fmt.Printf("- Tone mapper: %s\n", *tonemapperFlag)
-	fmt.Printf("- Gamma: %.2f\n", *gammaFlag)
-	fmt.Printf("- Intensity: %.2f\n", *intensityFlag)
-	if *tonemapperFlag == "reinhard05" {
-		fmt.Printf("- Light adaptation: %.2f\n", *lightFlag)
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size %q", s)
 	}
+	return int64(n * scale), nil
 }
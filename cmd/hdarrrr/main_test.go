@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -202,3 +206,110 @@ func TestHDRProcessing(t *testing.T) {
 		})
 	}
 }
+
+func TestProgressBar(t *testing.T) {
+	var buf bytes.Buffer
+	bar := newProgressBar(&buf)
+
+	bar.Report("merge", 0.5)
+	bar.Report("merge", 0.501) // same percentage, no redraw
+	bar.Report("tonemap", 1)
+	bar.Finish()
+
+	out := buf.String()
+	if strings.Count(out, "merge") != 1 {
+		t.Errorf("Expected a single merge redraw, got %q", out)
+	}
+	if !strings.Contains(out, " 50%") || !strings.Contains(out, "100%") {
+		t.Errorf("Expected 50%% and 100%% in output, got %q", out)
+	}
+	if !strings.HasSuffix(out, "\n") {
+		t.Errorf("Expected output to end with a newline, got %q", out)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input       string
+		want        int64
+		expectError bool
+	}{
+		{"1048576", 1 << 20, false},
+		{"512MB", 512e6, false},
+		{"2GiB", 2 << 30, false},
+		{"1.5 gib", 3 << 29, false},
+		{"64k", 64 << 10, false},
+		{"100B", 100, false},
+		{"", 0, true},
+		{"-1GB", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %d", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// bracketPaths writes a bracket of three frames to dir and returns their paths
+func bracketPaths(t *testing.T, dir string) []string {
+	t.Helper()
+	writeBracketFiles(t, dir, 3)
+	return []string{
+		filepath.Join(dir, "frame_a.png"),
+		filepath.Join(dir, "frame_b.png"),
+		filepath.Join(dir, "frame_c.png"),
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"no arguments", nil, 2, "", "Commands:"},
+		{"help", []string{"help"}, 0, "Commands:", ""},
+		{"help command", []string{"help", "merge"}, 0, "", "Usage: hdarrrr merge"},
+		{"command help", []string{"tonemap", "-h"}, 0, "", "Usage: hdarrrr tonemap"},
+		{"unknown command", []string{"squash"}, 2, "", `unknown command "squash"`},
+		{"flags without command", []string{
+			"-low", frames[0], "-mid", frames[1], "-high", frames[2],
+			"-progress=false", "-output", filepath.Join(dir, "legacy.png"),
+		}, 0, "HDR image successfully saved", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("Expected stdout to contain %q, got:\n%s", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("Expected stderr to contain %q, got:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/mdouchement/hdr"
+)
+
+// runMerge implements the merge command and returns the exit code
+func runMerge(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("merge", "<image> <image>...",
+		"Aligns and merges a bracket of exposures into a radiance map, without tone\n"+
+			"mapping it. The map is written as Radiance RGBE (.hdr) or Portable Float\n"+
+			"Map (.pfm) and can be tone mapped later with the tonemap command.", stderr)
+	outputPath := fs.String("output", "merged.hdr", "Path for the radiance map (.hdr or .pfm)")
+	pf := addSettingsFlags(fs)
+	pf.addMergeFlags(fs)
+	progressFlag := fs.Bool("progress", true, "Show a progress bar on stderr")
+
+	if code, ok := parseCommand(fs, args, 2, false); !ok {
+		return code
+	}
+	if !imaging.IsRadiance(*outputPath) {
+		fmt.Fprintf(stderr, "Error: -output must be a .hdr or .pfm file, got %s\n", *outputPath)
+		return exitUsage
+	}
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+
+	inputs := orderExposures(stderr, fs.Args()...)
+
+	opts := cfg.Options()
+	bar := newProgressBar(stderr)
+	if *progressFlag {
+		opts = append(opts, processor.WithProgress(bar))
+	}
+	p := processor.NewHDRProcessor(opts...)
+
+	err = mergeFiles(ctx, p, *outputPath, inputs, stderr)
+	bar.Finish()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitCode(err)
+	}
+
+	fmt.Fprintf(stdout, "Radiance map saved to %s\n", *outputPath)
+	fmt.Fprintf(stdout, "- Alignment: %s\n", cfg.Alignment.Method)
+	fmt.Fprintf(stdout, "- Merge: %s\n", cfg.Merge.Method)
+	printDenoiseSummary(stdout, cfg)
+	return exitOK
+}
+
+// mergeFiles merges the exposures at inputs and saves the radiance map to
+// output
+func mergeFiles(ctx context.Context, p *processor.HDRProcessor, output string, inputs []string, stderr io.Writer) error {
+	merged, err := mergeBracket(ctx, p, inputs, stderr)
+	if err != nil {
+		return err
+	}
+	if err := p.SaveContext(ctx, merged, output); err != nil {
+		return fmt.Errorf("saving radiance map: %w", err)
+	}
+	return nil
+}
+
+// mergeBracket loads, aligns and merges the exposures at inputs. Alignment
+// failures are reported on stderr and the unaligned images are merged.
+func mergeBracket(ctx context.Context, p *processor.HDRProcessor, inputs []string, stderr io.Writer) (hdr.Image, error) {
+	aligned, err := alignBracket(ctx, p, inputs, stderr)
+	if err != nil {
+		return nil, err
+	}
+	return mergeAligned(ctx, p, aligned)
+}
+
+// alignBracket loads and aligns the exposures at inputs. Alignment failures
+// are reported on stderr and the unaligned images are returned.
+func alignBracket(ctx context.Context, p *processor.HDRProcessor, inputs []string, stderr io.Writer) ([]image.Image, error) {
+	images, err := p.LoadContext(ctx, inputs...)
+	if err != nil {
+		return nil, fmt.Errorf("loading images: %w", err)
+	}
+
+	aligned, err := p.AlignContext(ctx, images)
+	if err != nil {
+		fmt.Fprintf(stderr, "Warning: Image alignment failed: %v\n", err)
+		aligned = images
+	}
+	return aligned, nil
+}
+
+// loadRadiance loads the radiance map at path and denoises it
+func loadRadiance(ctx context.Context, p *processor.HDRProcessor, path string) (hdr.Image, error) {
+	m, err := imaging.LoadRadiance(path)
+	if err != nil {
+		return nil, &processor.InputError{Err: err}
+	}
+	merged, err := p.DenoiseContext(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("processing HDR: %w", err)
+	}
+	return merged, nil
+}
+
+// mergeAligned linearizes, merges and denoises aligned exposures
+func mergeAligned(ctx context.Context, p *processor.HDRProcessor, aligned []image.Image) (hdr.Image, error) {
+	linear, err := p.LinearizeContext(ctx, aligned)
+	if err != nil {
+		return nil, fmt.Errorf("processing HDR: %w", err)
+	}
+	merged, err := p.MergeContext(ctx, linear)
+	if err != nil {
+		return nil, fmt.Errorf("processing HDR: %w", err)
+	}
+	if merged, err = p.DenoiseContext(ctx, merged); err != nil {
+		return nil, fmt.Errorf("processing HDR: %w", err)
+	}
+	return merged, nil
+}
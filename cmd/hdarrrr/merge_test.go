@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+func TestRunMerge(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	tests := []struct {
+		name     string
+		output   string
+		args     []string
+		wantCode int
+	}{
+		{"rgbe", "merged.hdr", frames, 0},
+		{"pfm", "merged.pfm", append([]string{"-merge", "average"}, frames...), 0},
+		{"ldr output", "merged.png", frames, 2},
+		{"single frame", "single.hdr", frames[:1], 2},
+		{"missing frame", "missing.hdr", []string{frames[0], filepath.Join(dir, "missing.png")}, 3},
+		{"bad merge method", "bad.hdr", append([]string{"-merge", "median"}, frames...), 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, tt.output)
+			args := append([]string{"-progress=false", "-output", output}, tt.args...)
+
+			var stdout, stderr bytes.Buffer
+			code := runMerge(context.Background(), args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			if code != 0 {
+				return
+			}
+
+			merged, err := imaging.LoadRadiance(output)
+			if err != nil {
+				t.Fatalf("Failed to load radiance map: %v", err)
+			}
+			if merged.Bounds().Dx() != 8 || merged.Bounds().Dy() != 8 {
+				t.Errorf("Expected an 8x8 radiance map, got %v", merged.Bounds())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+)
+
+// runProcess implements the process command and returns the exit code
+func runProcess(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("process", "[<image>...]",
+		"Merges a bracket of exposures and tone maps the result. The exposures are\n"+
+			"given with -low, -mid and -high or as arguments, in any order.\n\n"+
+			"Besides 0 for success, 1 for other failures and 2 for invalid flags, the\n"+
+			"exit code is 3 when the inputs cannot be read or differ in size, 4 when\n"+
+			"alignment fails with -strict-align and 5 when the output cannot be written.", stderr)
+	img1Path := fs.String("low", "", "Path to low exposure image")
+	img2Path := fs.String("mid", "", "Path to mid exposure image")
+	img3Path := fs.String("high", "", "Path to high exposure image")
+	outputPath := fs.String("output", "hdr_output.jpg", "Path for output HDR image")
+	pf := addPipelineFlags(fs)
+	progressFlag := fs.Bool("progress", true, "Show a progress bar on stderr")
+	streamFlag := fs.Bool("stream", false, "Process band by band to bound memory use (skips alignment)")
+	budgetFlag := fs.String("memory-budget", "1GiB", "Memory budget for -stream, e.g. 512MB or 2GiB")
+	strictAlign := fs.Bool("strict-align", false, "Fail instead of merging unaligned images when alignment fails")
+	jsonFlag := fs.Bool("json", false, "Print a JSON report on stdout instead of the summary")
+	debugFlag := fs.Bool("debug-outputs", false, "Write false color, exposure contribution, merge weight and clipping images next to the output")
+
+	if code, ok := parseCommand(fs, args, 0, false); !ok {
+		return code
+	}
+
+	var inputs []string
+	for _, path := range []string{*img1Path, *img2Path, *img3Path} {
+		if path != "" {
+			inputs = append(inputs, path)
+		}
+	}
+	inputs = append(inputs, fs.Args()...)
+	if len(inputs) < 2 {
+		fmt.Fprintln(stderr, "Error: at least two exposure images are required")
+		fs.Usage()
+		return exitUsage
+	}
+
+	if *debugFlag && *streamFlag {
+		fmt.Fprintln(stderr, "Error: -debug-outputs cannot be used with -stream")
+		return exitUsage
+	}
+
+	budget, err := parseSize(*budgetFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: invalid -memory-budget:", err)
+		return exitUsage
+	}
+
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	if cfg.Denoise.Method != "" && *streamFlag {
+		fmt.Fprintln(stderr, "Error: -denoise cannot be used with -stream")
+		return exitUsage
+	}
+	// HDR output cannot be saved as JPEG
+	if cfg.HDR.Transfer != "" && !isSet(fs, "output") {
+		*outputPath = "hdr_output.png"
+	}
+
+	rep := newReport(cfg, *outputPath)
+	ws := &warnings{w: stderr}
+	bar := newProgressBar(stderr)
+	started := time.Now()
+	// finish reports the outcome on stdout and returns the exit code. The
+	// stage is left empty for errors that already name it.
+	finish := func(code int, stage string, err error) int {
+		bar.Finish()
+		rep.TotalSeconds = time.Since(started).Seconds()
+		rep.Warnings = append(rep.Warnings, ws.list...)
+		rep.ExitCode = code
+		if err != nil {
+			rep.Error = err.Error()
+			if stage != "" {
+				rep.Error = stage + ": " + rep.Error
+			}
+			fmt.Fprintf(stderr, "Error %s\n", rep.Error)
+		}
+		if *jsonFlag {
+			if err := rep.write(stdout); err != nil {
+				fmt.Fprintln(stderr, "Error writing report:", err)
+			}
+		} else if code == exitOK {
+			printSummary(stdout, *outputPath, cfg)
+			if len(rep.DebugOutputs) > 0 {
+				fmt.Fprintf(stdout, "- Debug outputs: %s\n", strings.Join(rep.DebugOutputs, ", "))
+			}
+		}
+		return code
+	}
+
+	// The exposures may be given in any order; the pipeline expects them
+	// from darkest to brightest
+	start := time.Now()
+	frames := imaging.ReadFrames(inputs)
+	if rep.Inputs, err = describeInputs(frames); err != nil {
+		return finish(exitInvalidInput, "reading images", err)
+	}
+	inputs = imaging.Paths(orderFrames(ws, frames))
+	rep.sortInputs(inputs)
+	rep.timed("inspect", start)
+
+	opts := append(cfg.Options(), processor.WithMemoryBudget(budget))
+	if *progressFlag {
+		opts = append(opts, processor.WithProgress(bar))
+	}
+
+	// Create HDR processor with configured parameters
+	hdrProc := processor.NewHDRProcessor(opts...)
+
+	if *streamFlag {
+		start = time.Now()
+		err := hdrProc.RunStreamingContext(ctx, *outputPath, inputs...)
+		rep.timed(progress.StageStream, start)
+		if err != nil {
+			return finish(exitCode(err), "processing HDR", err)
+		}
+		return finish(exitOK, "", nil)
+	}
+
+	res, err := hdrProc.RunPipeline(ctx, processor.PipelineOptions{StrictAlignment: *strictAlign}, inputs...)
+	for _, t := range res.Timings {
+		rep.Timings = append(rep.Timings, stageTiming{t.Stage, t.Duration.Seconds()})
+	}
+	if res.AlignErr != nil {
+		ws.add("Image alignment failed: %v", res.AlignErr)
+		fmt.Fprintln(stderr, "Proceeding with unaligned images...")
+	}
+	if err != nil {
+		code := exitCode(err)
+		var stageErr *processor.StageError
+		if errors.As(err, &stageErr) && stageErr.Stage == progress.StageAlign && ctx.Err() == nil {
+			code = exitAlignment
+		}
+		return finish(code, "", err)
+	}
+	if res.AlignErr == nil {
+		rep.Alignment.Aligned = true
+		rep.setOffsets(res.Offsets)
+	}
+
+	// Save the result
+	start = time.Now()
+	err = hdrProc.SaveContext(ctx, res.Result, *outputPath)
+	rep.timed(progress.StageSave, start)
+	if err != nil {
+		return finish(exitCode(err), "saving output image", err)
+	}
+
+	if *debugFlag {
+		start = time.Now()
+		diag, err := hdrProc.DiagnoseContext(ctx, res.Linear, res.Merged)
+		if err == nil {
+			rep.DebugOutputs, err = diag.Save(*outputPath)
+		}
+		rep.timed(progress.StageDebug, start)
+		if err != nil {
+			return finish(exitCode(err), "writing debug outputs", err)
+		}
+	}
+	return finish(exitOK, "", nil)
+}
+
+// printSummary reports where the result was saved and the parameters used
+func printSummary(w io.Writer, outputPath string, cfg config.Config) {
+	fmt.Fprintf(w, "HDR image successfully saved to %s\n", outputPath)
+	fmt.Fprintf(w, "Processing parameters:\n")
+	if cfg.Preset != "" {
+		fmt.Fprintf(w, "- Preset: %s\n", cfg.Preset)
+	}
+	fmt.Fprintf(w, "- Alignment: %s\n", cfg.Alignment.Method)
+	fmt.Fprintf(w, "- Merge: %s\n", cfg.Merge.Method)
+	printDenoiseSummary(w, cfg)
+	printToneMapSummary(w, cfg)
+}
+
+// printDenoiseSummary reports the noise reduction settings, if any
+func printDenoiseSummary(w io.Writer, cfg config.Config) {
+	if d := cfg.Denoise; d.Method != "" {
+		fmt.Fprintf(w, "- Denoise: %s, strength %.2f (shadows %.2f)\n", d.Method, d.Strength, d.Shadows)
+	}
+}
+
+// printToneMapSummary reports the white balance and the tone mapping
+// parameters, or the HDR output settings
+func printToneMapSummary(w io.Writer, cfg config.Config) {
+	switch wb := cfg.WhiteBalance; wb.Mode {
+	case "":
+	case "temperature":
+		fmt.Fprintf(w, "- White balance: %gK, tint %g (%s)\n", wb.Temperature, wb.Tint, wb.Adaptation)
+	case "gray-point":
+		fmt.Fprintf(w, "- White balance: gray point %d,%d (%s)\n", wb.GrayPoint[0], wb.GrayPoint[1], wb.Adaptation)
+	default:
+		fmt.Fprintf(w, "- White balance: %s (%s)\n", wb.Mode, wb.Adaptation)
+	}
+	if cfg.HDR.Transfer != "" {
+		fmt.Fprintf(w, "- HDR output: %s, reference white %g cd/m², peak %g cd/m²\n",
+			strings.ToUpper(cfg.HDR.Transfer), cfg.HDR.ReferenceWhite, cfg.HDR.PeakLuminance)
+		return
+	}
+	fmt.Fprintf(w, "- Tone mapper: %s\n", cfg.ToneMap.Operator)
+	if cfg.HDR.GainMap {
+		fmt.Fprintf(w, "- Gain map: up to %g cd/m²\n", cfg.HDR.PeakLuminance)
+	}
+	fmt.Fprintf(w, "- Gamma: %.2f\n", cfg.ToneMap.Gamma)
+	fmt.Fprintf(w, "- Intensity: %.2f\n", cfg.ToneMap.Intensity)
+	if cfg.ToneMap.Operator == "reinhard05" {
+		fmt.Fprintf(w, "- Light adaptation: %.2f\n", cfg.ToneMap.Light)
+	}
+	if cfg.ToneMap.PreserveColor != "" {
+		fmt.Fprintf(w, "- Color preservation: %s\n", cfg.ToneMap.PreserveColor)
+	}
+	if d := config.Default().ToneMap; cfg.ToneMap.Saturation != d.Saturation || cfg.ToneMap.Vibrance != d.Vibrance {
+		fmt.Fprintf(w, "- Saturation: %.2f, vibrance %.2f\n", cfg.ToneMap.Saturation, cfg.ToneMap.Vibrance)
+	}
+}
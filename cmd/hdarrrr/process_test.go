@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunProcess(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	large := filepath.Join(dir, "large.png")
+	writeShiftedFrame(t, large, image.Point{}, 1)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"positional inputs", []string{frames[2], frames[0], frames[1]}, 0},
+		{"streaming", []string{"-stream", "-memory-budget", "1MB", frames[0], frames[1]}, 0},
+		{"single input", []string{frames[0]}, 2},
+		{"bad budget", []string{"-memory-budget", "lots", frames[0], frames[1]}, 2},
+		{"debug outputs streaming", []string{"-stream", "-debug-outputs", frames[0], frames[1]}, 2},
+		{"denoised", []string{"-denoise", "wavelet", frames[0], frames[1]}, 0},
+		{"denoise streaming", []string{"-stream", "-denoise", "bilateral", frames[0], frames[1]}, 2},
+		{"bad preset", []string{"-preset", "vivid", frames[0], frames[1]}, 2},
+		{"missing input", []string{frames[0], filepath.Join(dir, "missing.png")}, 3},
+		{"different sizes", []string{frames[0], large}, 3},
+		{"unwritable output", []string{"-output", filepath.Join(frames[0], "out.png"), frames[0], frames[1]}, 5},
+		{"unwritable streaming output", []string{"-stream", "-output", filepath.Join(frames[0], "out.png"), frames[0], frames[1]}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".png")
+			args := append([]string{"-progress=false", "-output", output}, tt.args...)
+
+			var stdout, stderr bytes.Buffer
+			code := runProcess(context.Background(), args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			if _, err := os.Stat(output); (err == nil) != (tt.wantCode == 0) {
+				t.Errorf("Unexpected output file state: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunProcessJSON(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	output := filepath.Join(dir, "out.png")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-progress=false", "-json", "-align", "mtb", "-output", output, frames[2], frames[0], frames[1]}
+	if code := runProcess(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+
+	var rep report
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatalf("Expected a JSON report, got %q: %v", stdout.String(), err)
+	}
+	if rep.Output != output || rep.ExitCode != 0 || rep.Error != "" {
+		t.Errorf("Unexpected outcome: %+v", rep)
+	}
+	if rep.Settings.Alignment.Method != "mtb" || rep.Settings.ToneMap.Operator != "drago03" {
+		t.Errorf("Unexpected settings: %+v", rep.Settings)
+	}
+	// The frames are reported from darkest to brightest
+	if len(rep.Inputs) != 3 || rep.Inputs[0].Path != frames[2] || rep.Inputs[2].Path != frames[0] {
+		t.Fatalf("Unexpected inputs: %+v", rep.Inputs)
+	}
+	if in := rep.Inputs[0]; in.Width != 8 || in.Height != 8 || in.BitDepth != 8 || in.Format != ".png" {
+		t.Errorf("Unexpected input properties: %+v", in)
+	}
+	if !rep.Alignment.Aligned || len(rep.Alignment.Offsets) != 3 {
+		t.Errorf("Expected an offset for every frame, got %+v", rep.Alignment)
+	}
+
+	var stages []string
+	for _, timing := range rep.Timings {
+		stages = append(stages, timing.Stage)
+	}
+	if got := strings.Join(stages, ","); got != "inspect,load,align,linearize,merge,tonemap,save" {
+		t.Errorf("Unexpected stages %s", got)
+	}
+}
+
+func TestRunProcessDebugOutputs(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	output := filepath.Join(dir, "out.jpg")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-progress=false", "-json", "-debug-outputs", "-output", output, frames[0], frames[1], frames[2]}
+	if code := runProcess(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+
+	var rep report
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatalf("Expected a JSON report, got %q: %v", stdout.String(), err)
+	}
+	// False color, contribution and clipping maps, and a weight map per frame
+	if len(rep.DebugOutputs) != 6 {
+		t.Fatalf("Expected 6 debug outputs, got %v", rep.DebugOutputs)
+	}
+	for _, path := range rep.DebugOutputs {
+		if !strings.HasPrefix(path, filepath.Join(dir, "out_")) {
+			t.Errorf("Expected %s next to the output", path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected debug output %s: %v", path, err)
+		}
+	}
+	if last := rep.Timings[len(rep.Timings)-1].Stage; last != "debug" {
+		t.Errorf("Expected the debug stage to run last, got %s", last)
+	}
+}
+
+func TestRunProcessJSONFailure(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-progress=false", "-json", "-output", filepath.Join(dir, "out.png"), frames[0], filepath.Join(dir, "missing.png")}
+	code := runProcess(context.Background(), args, &stdout, &stderr)
+
+	var rep report
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatalf("Expected a JSON report, got %q: %v", stdout.String(), err)
+	}
+	if code != 3 || rep.ExitCode != 3 || !strings.Contains(rep.Error, "missing.png") {
+		t.Errorf("Expected exit code 3 and the missing file in the report, got %d and %+v", code, rep)
+	}
+}
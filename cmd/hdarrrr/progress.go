@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const progressBarWidth = 30
+
+// progressBar renders pipeline progress as a text bar, one line per stage
+type progressBar struct {
+	mu      sync.Mutex
+	w       io.Writer
+	stage   string
+	percent int
+}
+
+// newProgressBar creates a progress bar writing to w
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w, percent: -1}
+}
+
+// Report implements progress.Reporter
+func (b *progressBar) Report(stage string, fraction float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	percent := int(fraction * 100)
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	if stage != b.stage {
+		if b.stage != "" {
+			fmt.Fprintln(b.w)
+		}
+		b.stage = stage
+		b.percent = -1
+	}
+
+	// Only redraw when the displayed value changes
+	if percent == b.percent {
+		return
+	}
+	b.percent = percent
+
+	filled := percent * progressBarWidth / 100
+	fmt.Fprintf(b.w, "\r%-10s [%s%s] %3d%%",
+		stage,
+		strings.Repeat("#", filled),
+		strings.Repeat("-", progressBarWidth-filled),
+		percent,
+	)
+}
+
+// Finish terminates the current progress line
+func (b *progressBar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stage != "" {
+		fmt.Fprintln(b.w)
+		b.stage = ""
+	}
+}
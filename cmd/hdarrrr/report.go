@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// report is the outcome of the process command, printed by -json
+type report struct {
+	Inputs    []inputReport   `json:"inputs"`
+	Output    string          `json:"output"`
+	Settings  config.Config   `json:"settings"`
+	Alignment alignmentReport `json:"alignment"`
+	// Timings lists the stages that ran, in order
+	Timings      []stageTiming `json:"timings"`
+	TotalSeconds float64       `json:"total_seconds"`
+	// DebugOutputs lists the diagnostic images written with -debug-outputs
+	DebugOutputs []string `json:"debug_outputs,omitempty"`
+	Warnings     []string `json:"warnings"`
+	ExitCode     int      `json:"exit_code"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// inputReport describes an input image
+type inputReport struct {
+	Path     string `json:"path"`
+	Format   string `json:"format"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	BitDepth int    `json:"bit_depth"`
+	// EXIF holds the capture settings, when the file has them
+	EXIF *exifReport `json:"exif,omitempty"`
+}
+
+// exifReport holds the EXIF capture settings of an input image
+type exifReport struct {
+	CaptureTime  *time.Time `json:"capture_time,omitempty"`
+	ExposureTime float64    `json:"exposure_time,omitempty"`
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	ExposureBias *float64   `json:"exposure_bias,omitempty"`
+}
+
+// alignmentReport tells whether the frames were aligned and how far each
+// one was moved, when the alignment method reports it
+type alignmentReport struct {
+	Aligned bool           `json:"aligned"`
+	Offsets []offsetReport `json:"offsets,omitempty"`
+}
+
+// offsetReport is the translation applied to a frame, in pixels
+type offsetReport struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// stageTiming is the wall time spent in a stage of the pipeline
+type stageTiming struct {
+	Stage   string  `json:"stage"`
+	Seconds float64 `json:"seconds"`
+}
+
+// newReport starts the report of a run with the given settings
+func newReport(cfg config.Config, output string) *report {
+	return &report{Output: output, Settings: cfg, Inputs: []inputReport{}, Warnings: []string{}}
+}
+
+// timed records the time since start for stage
+func (r *report) timed(stage string, start time.Time) {
+	r.Timings = append(r.Timings, stageTiming{stage, time.Since(start).Seconds()})
+}
+
+// sortInputs puts the inputs in the order of paths
+func (r *report) sortInputs(paths []string) {
+	byPath := make(map[string]inputReport, len(r.Inputs))
+	for _, in := range r.Inputs {
+		byPath[in.Path] = in
+	}
+	for i, path := range paths {
+		r.Inputs[i] = byPath[path]
+	}
+}
+
+// setOffsets records the alignment offsets of the frames
+func (r *report) setOffsets(offsets []image.Point) {
+	r.Alignment.Offsets = nil
+	for _, o := range offsets {
+		r.Alignment.Offsets = append(r.Alignment.Offsets, offsetReport{o.X, o.Y})
+	}
+}
+
+// write prints r as indented JSON
+func (r *report) write(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// describeInputs reads the header and EXIF data of every frame. It fails
+// when a frame cannot be read or the frames differ in size.
+func describeInputs(frames []imaging.Frame) ([]inputReport, error) {
+	inputs := make([]inputReport, len(frames))
+	for i, f := range frames {
+		props, err := imaging.ReadImageProperties(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Path, err)
+		}
+		inputs[i] = inputReport{
+			Path:     f.Path,
+			Format:   props.Format,
+			Width:    props.Width,
+			Height:   props.Height,
+			BitDepth: props.ColorDepth,
+		}
+		if i > 0 && (props.Width != inputs[0].Width || props.Height != inputs[0].Height) {
+			return nil, fmt.Errorf("%s is %dx%d but %s is %dx%d", f.Path, props.Width, props.Height,
+				inputs[0].Path, inputs[0].Width, inputs[0].Height)
+		}
+		if f.HasMetadata {
+			inputs[i].EXIF = newEXIFReport(f.Metadata)
+		}
+	}
+	return inputs, nil
+}
+
+// newEXIFReport keeps the fields of m that were found in the file
+func newEXIFReport(m imaging.Metadata) *exifReport {
+	e := &exifReport{ExposureTime: m.ExposureTime, FNumber: m.FNumber, ISO: m.ISO}
+	if !m.CaptureTime.IsZero() {
+		e.CaptureTime = &m.CaptureTime
+	}
+	if m.HasExposureBias {
+		e.ExposureBias = &m.ExposureBias
+	}
+	return e
+}
+
+// warnings prints warnings to stderr and keeps them for the JSON report
+type warnings struct {
+	w    io.Writer
+	list []string
+}
+
+// add prints and records a warning
+func (ws *warnings) add(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	ws.list = append(ws.list, msg)
+	fmt.Fprintln(ws.w, "Warning:", msg)
+}
+
+// exitCode returns the exit code for an error of the processor: invalid
+// input, encoding failure or any other failure
+func exitCode(err error) int {
+	var inputErr *processor.InputError
+	var encodeErr *processor.EncodeError
+	switch {
+	case errors.As(err, &inputErr):
+		return exitInvalidInput
+	case errors.As(err, &encodeErr):
+		return exitEncoding
+	default:
+		return exitFailure
+	}
+}
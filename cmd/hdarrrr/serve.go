@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/harperreed/hdarrrr/pkg/server"
+)
+
+// shutdownTimeout is how long running jobs may take to finish once the
+// server is asked to stop
+const shutdownTimeout = time.Minute
+
+// runServe implements the serve command and returns the exit code
+func runServe(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("serve", "",
+		"Runs an HTTP server exposing the pipeline. POST a multipart/form-data bracket\n"+
+			"to /v1/process with one \"images\" part per exposure, an optional \"params\"\n"+
+			"part holding settings in the JSON form of a config file, and an optional\n"+
+			"\"format\" field (jpg, png, hdr or pfm). GET /health reports the state of\n"+
+			"the queue and GET /metrics serves Prometheus metrics.\n\n"+
+			"With -jobs-dir, brackets POSTed to /v1/jobs are queued in a job store and\n"+
+			"processed in the background; GET /v1/jobs/{id} reports their progress and\n"+
+			"GET /v1/jobs/{id}/result returns the output.", stderr)
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	concurrency := fs.Int("concurrency", server.DefaultConcurrency, "Number of jobs processed at once")
+	queue := fs.Int("queue", server.DefaultQueueSize, "Number of requests that may wait for a slot before being rejected")
+	maxRequest := fs.String("max-request-size", "256MiB", "Largest accepted request, e.g. 64MB or 1GiB")
+	maxPixels := fs.Int64("max-pixels", server.DefaultMaxPixels, "Largest accepted image in pixels, width × height (0 for no limit)")
+	workers := fs.Int("workers", 0, "Worker goroutines per job (default the CPUs shared between the jobs)")
+	tempDir := fs.String("temp-dir", "", "Directory for uploads being processed (default the system temporary directory)")
+	jobsDir := fs.String("jobs-dir", "", "Job store serving the asynchronous /v1/jobs API (default disabled)")
+	retention := fs.Duration("retention", 0, "Delete finished jobs older than this, e.g. 168h (default keep them)")
+
+	if code, ok := parseCommand(fs, args, 0, true); !ok {
+		return code
+	}
+	if *concurrency < 1 || *queue < 0 || *maxPixels < 0 || *retention < 0 {
+		fmt.Fprintln(stderr, "Error: -concurrency must be at least 1, -queue and -max-pixels at least 0 and -retention not negative")
+		return exitUsage
+	}
+	limit, err := parseSize(*maxRequest)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: invalid -max-request-size:", err)
+		return exitUsage
+	}
+
+	opts := []server.Option{
+		server.WithConcurrency(*concurrency),
+		server.WithQueueSize(*queue),
+		server.WithMaxRequestBytes(limit),
+		server.WithMaxPixels(*maxPixels),
+		server.WithTempDir(*tempDir),
+	}
+	jobOpts := []jobs.Option{jobs.WithConcurrency(*concurrency), jobs.WithRetention(*retention)}
+	if *workers > 0 {
+		opts = append(opts, server.WithProcessorOptions(processor.WithWorkers(*workers)))
+		jobOpts = append(jobOpts, jobs.WithProcessorOptions(processor.WithWorkers(*workers)))
+	}
+
+	var m *jobs.Manager
+	if *jobsDir != "" {
+		var ok bool
+		if m, ok = openManager(*jobsDir, stderr, jobOpts...); !ok {
+			return exitFailure
+		}
+		opts = append(opts, server.WithJobs(m))
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	srv := &http.Server{
+		Handler:           server.New(opts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(listener) }()
+	// The job manager runs until the server has stopped; the jobs it was
+	// running then return to the queue
+	managerCtx, stopManager := context.WithCancel(context.Background())
+	defer stopManager()
+	managerDone := make(chan error, 1)
+	if m != nil {
+		go func() { managerDone <- m.Run(managerCtx) }()
+	}
+	fmt.Fprintf(stdout, "Listening on http://%s\n", listener.Addr())
+
+	select {
+	case err = <-done:
+	case err = <-managerDone:
+		srv.Close()
+		// The manager has already stopped
+		m = nil
+	case <-ctx.Done():
+		fmt.Fprintln(stdout, "Shutting down, waiting for running jobs...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	if m != nil {
+		stopManager()
+		if merr := <-managerDone; err == nil {
+			err = merr
+		}
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	return exitOK
+}
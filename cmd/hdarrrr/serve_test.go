@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+)
+
+// startServe runs the serve command with args until ctx is done. It
+// returns the URL of the server and a channel receiving the exit code.
+func startServe(t *testing.T, ctx context.Context, args ...string) (string, <-chan int) {
+	t.Helper()
+	out, w := io.Pipe()
+
+	codes := make(chan int, 1)
+	go func() {
+		var stderr bytes.Buffer
+		codes <- runServe(ctx, append([]string{"-addr", "127.0.0.1:0"}, args...), w, &stderr)
+		w.Close()
+	}()
+
+	lines := bufio.NewScanner(out)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "Listening on ") {
+		t.Fatalf("Expected the listening address, got %q", lines.Text())
+	}
+	go io.Copy(io.Discard, out)
+	return strings.TrimPrefix(lines.Text(), "Listening on "), codes
+}
+
+func TestRunServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, codes := startServe(t, ctx)
+
+	resp, err := http.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 from /health, got %d", resp.StatusCode)
+	}
+
+	cancel()
+	if code := <-codes; code != 0 {
+		t.Errorf("Expected exit code 0 after shutdown, got %d", code)
+	}
+}
+
+func TestRunServeJobs(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	// A job queued from the CLI is run by the server
+	_, stdout, _ := runJobsCommand(append([]string{"submit", "-store", store}, bracketPaths(t, dir)...)...)
+	id := strings.TrimSpace(stdout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, codes := startServe(t, ctx, "-jobs-dir", store)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(url + "/v1/jobs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var j jobs.Job
+		json.NewDecoder(resp.Body).Decode(&j)
+		resp.Body.Close()
+		if j.State == jobs.StateSucceeded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the job to succeed, got %+v", j)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cancel()
+	if code := <-codes; code != 0 {
+		t.Errorf("Expected exit code 0 after shutdown, got %d", code)
+	}
+}
+
+func TestRunServeUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"argument", []string{"extra"}},
+		{"bad concurrency", []string{"-concurrency", "0"}},
+		{"bad size", []string{"-max-request-size", "huge"}},
+		{"bad pixels", []string{"-max-pixels", "-1"}},
+		{"bad retention", []string{"-retention", "-1h"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runServe(context.Background(), tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+		})
+	}
+}
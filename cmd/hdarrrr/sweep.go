@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/mdouchement/hdr"
+)
+
+// sweepManifest describes a contact sheet written by the sweep command
+type sweepManifest struct {
+	// Inputs are the bracket or radiance map the variants were rendered from
+	Inputs       []string       `json:"inputs"`
+	ContactSheet string         `json:"contact_sheet"`
+	Columns      int            `json:"columns"`
+	Variants     []sweepVariant `json:"variants"`
+}
+
+// sweepVariant is one cell of the contact sheet
+type sweepVariant struct {
+	Index int    `json:"index"`
+	Label string `json:"label"`
+	Row   int    `json:"row"`
+	// Column is the 0-based position of the cell in its row
+	Column int `json:"column"`
+	processor.ToneMapSettings
+	// File is the full-size image, when -variants-dir is set
+	File string `json:"file,omitempty"`
+}
+
+// runSweep implements the sweep command and returns the exit code
+func runSweep(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("sweep", "<radiance map | image image...>",
+		"Renders one radiance map, or a bracket merged once, with every combination\n"+
+			"of the tone mapping values given, and lays the variants out on a labeled\n"+
+			"contact sheet. A JSON manifest lists the settings of every cell.", stderr)
+	outputPath := fs.String("output", "sweep.jpg", "Path for the contact sheet")
+	manifestPath := fs.String("manifest", "", "Path for the JSON manifest (default <output>.json)")
+	toneMappers := fs.String("tonemappers", strings.Join(processor.ToneMappers, ","), "Comma-separated tone mappers")
+	gammas := fs.String("gammas", "0.8,1,1.2", "Comma-separated gamma values")
+	intensities := fs.String("intensities", "1", "Comma-separated intensity values")
+	lights := fs.String("lights", "0", "Comma-separated light adaptation values (Reinhard05 only)")
+	columns := fs.Int("columns", 0, "Number of columns (default the number of gamma values)")
+	thumbSize := fs.Int("thumb-size", 320, "Largest width or height of a variant on the sheet, in pixels")
+	variantsDir := fs.String("variants-dir", "", "Also save every variant at full size to this directory")
+	pf := addSettingsFlags(fs)
+	pf.addMergeFlags(fs)
+	progressFlag := fs.Bool("progress", true, "Show a progress bar on stderr")
+
+	if code, ok := parseCommand(fs, args, 1, false); !ok {
+		return code
+	}
+	grid, err := parseSweepGrid(*toneMappers, *gammas, *intensities, *lights)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	if *thumbSize < 16 {
+		fmt.Fprintln(stderr, "Error: -thumb-size must be at least 16")
+		return exitUsage
+	}
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	if *columns <= 0 {
+		*columns = len(grid.Gammas)
+	}
+	if *manifestPath == "" {
+		*manifestPath = strings.TrimSuffix(*outputPath, filepath.Ext(*outputPath)) + ".json"
+	}
+
+	opts := cfg.Options()
+	bar := newProgressBar(stderr)
+	if *progressFlag {
+		opts = append(opts, processor.WithProgress(bar))
+	}
+	p := processor.NewHDRProcessor(opts...)
+
+	inputs := fs.Args()
+	var merged hdr.Image
+	switch {
+	case len(inputs) == 1 && imaging.IsRadiance(inputs[0]):
+		merged, err = loadRadiance(ctx, p, inputs[0])
+	case len(inputs) < 2:
+		bar.Finish()
+		fmt.Fprintln(stderr, "Error: sweep needs a radiance map or a bracket of at least two images")
+		return exitUsage
+	default:
+		inputs = orderExposures(stderr, inputs...)
+		merged, err = mergeBracket(ctx, p, inputs, stderr)
+	}
+	if err != nil {
+		bar.Finish()
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitCode(err)
+	}
+
+	settings := grid.Settings()
+	variants, err := p.ToneMapSweep(ctx, merged, settings)
+	bar.Finish()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error tone mapping:", err)
+		return exitFailure
+	}
+
+	manifest := sweepManifest{Inputs: inputs, ContactSheet: *outputPath, Columns: *columns}
+	thumbs := make([]image.Image, len(variants))
+	labels := make([]string, len(variants))
+	for i, s := range settings {
+		labels[i] = sweepLabel(s)
+		thumbs[i] = imaging.Thumbnail(variants[i], *thumbSize, *thumbSize)
+		v := sweepVariant{Index: i + 1, Label: labels[i], Row: i / *columns, Column: i % *columns, ToneMapSettings: s}
+		if *variantsDir != "" {
+			v.File = filepath.Join(*variantsDir, fmt.Sprintf("%03d_%s%s", i+1, strings.NewReplacer(" ", "_", "=", "").Replace(labels[i]), filepath.Ext(*outputPath)))
+			if err := p.SaveContext(ctx, variants[i], v.File); err != nil {
+				fmt.Fprintln(stderr, "Error saving variant:", err)
+				return exitCode(err)
+			}
+		}
+		manifest.Variants = append(manifest.Variants, v)
+	}
+
+	sheet, err := imaging.LabeledMontage(thumbs, labels, *columns, 4, color.Black)
+	if err == nil {
+		err = p.SaveContext(ctx, sheet, *outputPath)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error saving contact sheet:", err)
+		return exitCode(err)
+	}
+	if err := writeManifest(*manifestPath, manifest); err != nil {
+		fmt.Fprintln(stderr, "Error saving manifest:", err)
+		return exitEncoding
+	}
+
+	fmt.Fprintf(stdout, "Contact sheet of %d variants saved to %s\n", len(variants), *outputPath)
+	fmt.Fprintf(stdout, "Manifest saved to %s\n", *manifestPath)
+	return exitOK
+}
+
+// parseSweepGrid parses the comma-separated values of the sweep flags
+func parseSweepGrid(toneMappers, gammas, intensities, lights string) (processor.SweepGrid, error) {
+	var grid processor.SweepGrid
+	for _, op := range strings.Split(toneMappers, ",") {
+		op = strings.TrimSpace(op)
+		if !slices.Contains(processor.ToneMappers, op) {
+			return grid, fmt.Errorf("unsupported tone mapper %q (%s)", op, strings.Join(processor.ToneMappers, ", "))
+		}
+		grid.Operators = append(grid.Operators, op)
+	}
+
+	lists := []struct {
+		name  string
+		value string
+		dst   *[]float64
+	}{
+		{"-gammas", gammas, &grid.Gammas},
+		{"-intensities", intensities, &grid.Intensities},
+		{"-lights", lights, &grid.Lights},
+	}
+	for _, l := range lists {
+		values, err := parseFloats(l.value)
+		if err != nil {
+			return grid, fmt.Errorf("invalid %s: %w", l.name, err)
+		}
+		*l.dst = values
+	}
+	return grid, nil
+}
+
+// parseFloats parses a comma-separated list of numbers
+func parseFloats(s string) ([]float64, error) {
+	var values []float64
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%q is not a number", strings.TrimSpace(field))
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// sweepLabel describes settings in the caption of a cell
+func sweepLabel(s processor.ToneMapSettings) string {
+	label := fmt.Sprintf("%s g=%.2f i=%.2f", s.Operator, s.Gamma, s.Intensity)
+	if s.Operator == "reinhard05" {
+		label += fmt.Sprintf(" l=%.2f", s.Light)
+	}
+	return label
+}
+
+// writeManifest saves m as indented JSON
+func writeManifest(path string, m sweepManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+func TestRunSweep(t *testing.T) {
+	dir := t.TempDir()
+	radiance := filepath.Join(dir, "scene.hdr")
+	writeRadiance(t, radiance)
+	output := filepath.Join(dir, "sheet.png")
+
+	var stdout, stderr bytes.Buffer
+	code := runSweep(context.Background(), []string{
+		"-progress=false", "-output", output, "-variants-dir", filepath.Join(dir, "variants"),
+		"-gammas", "0.8, 1.2", "-lights", "0,0.5", radiance,
+	}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "sheet.json"))
+	if err != nil {
+		t.Fatalf("Expected a manifest next to the sheet: %v", err)
+	}
+	var manifest sweepManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest: %v", err)
+	}
+
+	// Two reinhard05 variants per gamma, one drago03 variant per gamma
+	if len(manifest.Variants) != 6 || manifest.Columns != 2 {
+		t.Fatalf("Expected 6 variants on 2 columns, got %d on %d", len(manifest.Variants), manifest.Columns)
+	}
+	last := manifest.Variants[5]
+	if last.Operator != "drago03" || last.Gamma != 1.2 || last.Row != 2 || last.Column != 1 {
+		t.Errorf("Unexpected last variant: %+v", last)
+	}
+	if manifest.Variants[1].Light != 0.5 || manifest.Variants[1].Label != "reinhard05 g=0.80 i=1.00 l=0.50" {
+		t.Errorf("Unexpected second variant: %+v", manifest.Variants[1])
+	}
+	for _, v := range manifest.Variants {
+		if _, err := os.Stat(v.File); err != nil {
+			t.Errorf("Expected variant file %s: %v", v.File, err)
+		}
+	}
+
+	sheet, err := imaging.Decode(output)
+	if err != nil {
+		t.Fatalf("Failed to read the contact sheet: %v", err)
+	}
+	// 6x4 cells with captions, 2 columns and 3 rows, 4 pixels apart
+	if want := image.Pt(2*6+4, 3*(4+21)+2*4); sheet.Bounds().Size() != want {
+		t.Errorf("Expected a %v sheet, got %v", want, sheet.Bounds().Size())
+	}
+}
+
+func TestRunSweepBracket(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+	output := filepath.Join(dir, "sheet.jpg")
+
+	var stdout, stderr bytes.Buffer
+	code := runSweep(context.Background(), append([]string{
+		"-progress=false", "-output", output, "-manifest", filepath.Join(dir, "out", "m.json"),
+		"-tonemappers", "drago03", "-gammas", "1", "-intensities", "0.5,1,2",
+	}, frames...), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", "m.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest sweepManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Variants) != 3 || len(manifest.Inputs) != 3 || manifest.Variants[2].Intensity != 2 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+}
+
+func TestRunSweepUsage(t *testing.T) {
+	dir := t.TempDir()
+	frames := bracketPaths(t, dir)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no input", nil},
+		{"single image", frames[:1]},
+		{"bad operator", []string{"-tonemappers", "aces", frames[0], frames[1]}},
+		{"bad gamma", []string{"-gammas", "1,bright", frames[0], frames[1]}},
+		{"tiny thumbnails", []string{"-thumb-size", "4", frames[0], frames[1]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runSweep(context.Background(), tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// runToneMap implements the tonemap command and returns the exit code
+func runToneMap(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("tonemap", "<radiance map>",
+		"Tone maps a radiance map (.hdr or .pfm), such as one written by the merge\n"+
+			"command, into a PNG or JPEG image, or encodes it for HDR displays with -hdr.\n"+
+			"With -denoise, the noise of the radiance map is reduced first.", stderr)
+	outputPath := fs.String("output", "", "Path for the output image (default <input>.jpg)")
+	pf := addSettingsFlags(fs)
+	pf.addDenoiseFlags(fs)
+	pf.addToneMapFlags(fs)
+	progressFlag := fs.Bool("progress", true, "Show a progress bar on stderr")
+
+	if code, ok := parseCommand(fs, args, 1, true); !ok {
+		return code
+	}
+	input := fs.Arg(0)
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	if *outputPath == "" {
+		ext := ".jpg"
+		if cfg.HDR.Transfer != "" {
+			ext = ".png"
+		}
+		*outputPath = strings.TrimSuffix(input, filepath.Ext(input)) + ext
+	}
+
+	merged, err := imaging.LoadRadiance(input)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error loading radiance map:", err)
+		return exitInvalidInput
+	}
+
+	opts := cfg.Options()
+	bar := newProgressBar(stderr)
+	if *progressFlag {
+		opts = append(opts, processor.WithProgress(bar))
+	}
+	p := processor.NewHDRProcessor(opts...)
+
+	denoised, err := p.DenoiseContext(ctx, merged)
+	var result image.Image
+	if err == nil {
+		result, err = p.ToneMapContext(ctx, denoised)
+	}
+	if err == nil {
+		err = p.SaveContext(ctx, result, *outputPath)
+	}
+	bar.Finish()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitCode(err)
+	}
+
+	fmt.Fprintf(stdout, "Tone mapped image saved to %s\n", *outputPath)
+	printDenoiseSummary(stdout, cfg)
+	printToneMapSummary(stdout, cfg)
+	return exitOK
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// writeRadiance writes a small radiance map with a wide range of values
+func writeRadiance(t *testing.T, path string) {
+	t.Helper()
+	m := hdr.NewRGB(image.Rect(0, 0, 6, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			v := float64(uint(1)<<(x+y)) / 16
+			m.SetRGB(x, y, hdrcolor.RGB{R: v, G: v, B: v})
+		}
+	}
+	if err := imaging.SaveRadiance(m, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunToneMap(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "scene.hdr")
+	writeRadiance(t, input)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantOutput string
+	}{
+		{"default output", []string{input}, 0, "scene.jpg"},
+		{"reinhard05", []string{"-tonemapper", "reinhard05", "-output", filepath.Join(dir, "r.png"), input}, 0, "r.png"},
+		{"preset", []string{"-preset", "dramatic", "-output", filepath.Join(dir, "d.png"), input}, 0, "d.png"},
+		{"hdr default output", []string{"-hdr", "pq", input}, 0, "scene.png"},
+		{"hdr tiff", []string{"-hdr", "hlg", "-bit-depth", "10", "-output", filepath.Join(dir, "h.tif"), input}, 0, ""},
+		{"white balance", []string{"-white-balance", "gray-world", "-adaptation", "cat02", "-output", filepath.Join(dir, "w.png"), input}, 0, "w.png"},
+		{"gray point outside", []string{"-gray-point", "10,10", input}, 1, ""},
+		{"gain map", []string{"-gain-map", "-output", filepath.Join(dir, "g.jpg"), input}, 0, "g.jpg"},
+		{"denoise", []string{"-denoise", "bilateral", "-output", filepath.Join(dir, "n.png"), input}, 0, "n.png"},
+		{"unknown denoiser", []string{"-denoise", "median", input}, 2, ""},
+		{"hdr jpeg", []string{"-hdr", "pq", "-output", filepath.Join(dir, "h.jpg"), input}, 5, ""},
+		{"hdr white above peak", []string{"-hdr", "pq", "-reference-white", "2000", input}, 2, ""},
+		{"not a radiance map", []string{filepath.Join(dir, "scene.jpg")}, 3, ""},
+		{"radiance output", []string{"-output", filepath.Join(dir, "out.hdr"), input}, 5, ""},
+		{"unknown operator", []string{"-tonemapper", "aces", input}, 2, ""},
+		{"two inputs", []string{input, input}, 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runToneMap(context.Background(), append([]string{"-progress=false"}, tt.args...), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			if tt.wantOutput == "" {
+				return
+			}
+
+			img, err := imaging.Decode(filepath.Join(dir, tt.wantOutput))
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			if img.Bounds().Dx() != 6 || img.Bounds().Dy() != 4 {
+				t.Errorf("Expected a 6x4 image, got %v", img.Bounds())
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out.hdr")); err == nil {
+		t.Error("Expected no file for the rejected radiance output")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "h.tif")); err != nil {
+		t.Errorf("Expected a TIFF output: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/harperreed/hdarrrr/pkg/tuner"
+	"github.com/mdouchement/hdr"
+)
+
+// runTune implements the tune command and returns the exit code
+func runTune(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("tune", "<radiance map | image image...>",
+		"Opens a local web page for tuning the tone mapping of one radiance map, or\n"+
+			"of a bracket merged once. Previews follow the sliders, and the settings\n"+
+			"can be downloaded as a config file or copied as a command line.", stderr)
+	addr := fs.String("addr", "127.0.0.1:8090", "Address to listen on")
+	previewSize := fs.Int("preview-size", tuner.DefaultPreviewSize, "Longest edge of the previews, in pixels")
+	pf := addPipelineFlags(fs)
+
+	if code, ok := parseCommand(fs, args, 1, false); !ok {
+		return code
+	}
+	if *previewSize < 16 {
+		fmt.Fprintln(stderr, "Error: -preview-size must be at least 16")
+		return exitUsage
+	}
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+
+	inputs := fs.Args()
+	var merged hdr.Image
+	switch {
+	case len(inputs) == 1 && imaging.IsRadiance(inputs[0]):
+		merged, err = loadRadiance(ctx, processor.NewHDRProcessor(cfg.Options()...), inputs[0])
+	case len(inputs) < 2:
+		fmt.Fprintln(stderr, "Error: tune needs a radiance map or a bracket of at least two images")
+		return exitUsage
+	default:
+		inputs = orderExposures(stderr, inputs...)
+		merged, err = mergeBracket(ctx, processor.NewHDRProcessor(cfg.Options()...), inputs, stderr)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitCode(err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	srv := &http.Server{
+		Handler:           tuner.New(merged, inputs, cfg, tuner.WithPreviewSize(*previewSize)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(listener) }()
+	fmt.Fprintf(stdout, "Tuning on http://%s\n", listener.Addr())
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	return exitOK
+}
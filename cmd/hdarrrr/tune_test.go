@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunTune(t *testing.T) {
+	dir := t.TempDir()
+	writeBracketFiles(t, dir, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, w := io.Pipe()
+	codes := make(chan int, 1)
+	var stderr bytes.Buffer
+	go func() {
+		codes <- runTune(ctx, append([]string{"-addr", "127.0.0.1:0", "-tonemapper", "reinhard05"}, bracketPaths(t, dir)...), w, &stderr)
+		w.Close()
+	}()
+	lines := bufio.NewScanner(out)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "Tuning on ") {
+		t.Fatalf("Expected the listening address, got %q (stderr: %s)", lines.Text(), stderr.String())
+	}
+	go io.Copy(io.Discard, out)
+	url := strings.TrimPrefix(lines.Text(), "Tuning on ")
+
+	resp, err := http.Get(url + "/api/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exp map[string]string
+	json.NewDecoder(resp.Body).Decode(&exp)
+	resp.Body.Close()
+	if !strings.Contains(exp["command"], "hdarrrr process -tonemapper reinhard05 ") {
+		t.Errorf("Expected the command to merge the bracket with the flags given, got %q", exp["command"])
+	}
+
+	cancel()
+	if code := <-codes; code != 0 {
+		t.Errorf("Expected exit code 0 after shutdown, got %d", code)
+	}
+}
+
+func TestRunTuneUsage(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"missing input", nil, 2},
+		{"single image", []string{filepath.Join(dir, "a.png")}, 2},
+		{"small preview", []string{"-preview-size", "4", filepath.Join(dir, "a.hdr")}, 2},
+		{"missing radiance map", []string{filepath.Join(dir, "a.hdr")}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runTune(context.Background(), tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+		})
+	}
+}
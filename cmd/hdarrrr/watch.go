@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// watchStateFile is the default name of the watch state file in the output
+// directory
+const watchStateFile = ".hdarrrr-watch.json"
+
+// runWatch implements the watch command and returns the exit code
+func runWatch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("watch", "<dir>",
+		"Watches dir for new exposures, e.g. from a camera tether, and merges every\n"+
+			"bracket once no file has changed for -settle. Handled brackets are recorded\n"+
+			"in a state file, so a restarted watcher only merges the brackets added since.", stderr)
+	outputDir := fs.String("output-dir", "", "Directory for the results (default <dir>/hdr)")
+	group := fs.String("group", groupAuto, "Bracket grouping: auto (capture time, exposure sequence and similarity), time, exposure or size")
+	size := fs.Int("size", 3, "Frames per bracket for -group size; incomplete brackets wait for their last frames")
+	gap := fs.Duration("gap", 2*time.Second, "Largest time between frames of a bracket for -group time and auto")
+	settle := fs.Duration("settle", 3*time.Second, "Time without file changes before new frames are grouped")
+	name := fs.String("name", "{{.First}}_hdr.jpg", "Output file name template (fields: .Index, .First, .Last, .Count)")
+	statePath := fs.String("state", "", "State file (default <output-dir>/"+watchStateFile+")")
+	once := fs.Bool("once", false, "Merge the brackets already in dir and exit instead of watching")
+	pf := addPipelineFlags(fs)
+
+	if code, ok := parseCommand(fs, args, 1, true); !ok {
+		return code
+	}
+	dir := fs.Arg(0)
+	cfg, err := pf.resolve(fs)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+	if *settle <= 0 {
+		fmt.Fprintln(stderr, "Error: -settle must be positive")
+		return exitUsage
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(*name)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: invalid -name template:", err)
+		return exitUsage
+	}
+	if *outputDir == "" {
+		*outputDir = filepath.Join(dir, "hdr")
+	}
+	if sameDir(dir, *outputDir) {
+		fmt.Fprintln(stderr, "Error: -output-dir must differ from the watched directory")
+		return exitUsage
+	}
+	if *statePath == "" {
+		*statePath = filepath.Join(*outputDir, watchStateFile)
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(stderr, "Error: %s is not a directory\n", dir)
+		return exitInvalidInput
+	}
+	if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitEncoding
+	}
+	state, err := loadWatchState(*statePath)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error reading watch state:", err)
+		return exitFailure
+	}
+
+	w := &folderWatcher{
+		dir:       dir,
+		outputDir: *outputDir,
+		group:     *group,
+		size:      *size,
+		gap:       *gap,
+		tmpl:      tmpl,
+		opts:      cfg.Options(),
+		state:     state,
+		lone:      map[string]bool{},
+		stdout:    stdout,
+		ws:        &warnings{w: stderr},
+	}
+
+	if *once {
+		if err := w.flush(ctx); err != nil {
+			fmt.Fprintln(stderr, "Error:", err)
+			return exitFailure
+		}
+		return w.summary()
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	defer fsw.Close()
+	if err := fsw.Add(dir); err != nil {
+		fmt.Fprintf(stderr, "Error watching %s: %v\n", dir, err)
+		return exitFailure
+	}
+
+	fmt.Fprintf(stdout, "Watching %s, saving brackets to %s\n", dir, *outputDir)
+	if err := w.watch(ctx, fsw.Events, fsw.Errors, *settle); err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitFailure
+	}
+	w.summary()
+	return exitOK
+}
+
+// folderWatcher merges the brackets appearing in a directory
+type folderWatcher struct {
+	dir       string
+	outputDir string
+	group     string
+	size      int
+	gap       time.Duration
+	tmpl      *template.Template
+	opts      []processor.Option
+	state     *watchState
+	// lone holds the frames found outside any bracket, which are grouped
+	// again with the frames arriving later
+	lone   map[string]bool
+	stdout io.Writer
+	ws     *warnings
+	// merged and failed count the brackets handled in this run
+	merged, failed int
+}
+
+// watch merges new brackets as the events of the directory come in, until
+// ctx is done. Frames are grouped once settle has passed without events,
+// and once at the start for the frames added while nobody was watching.
+func (w *folderWatcher) watch(ctx context.Context, events <-chan fsnotify.Event, errs <-chan error, settle time.Duration) error {
+	timer := time.NewTimer(settle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return errors.New("file watcher stopped")
+			}
+			if isFrameFile(ev.Name) {
+				timer.Reset(settle)
+			}
+		case err, ok := <-errs:
+			if ok {
+				w.ws.add("watching %s: %v", w.dir, err)
+			}
+		case <-timer.C:
+			if err := w.flush(ctx); err != nil {
+				w.ws.add("%v", err)
+			}
+		}
+	}
+}
+
+// flush groups the frames of the directory that belong to no recorded
+// bracket and merges the complete brackets. Frames left alone are not
+// recorded, as the rest of their bracket may still be on its way.
+func (w *folderWatcher) flush(ctx context.Context) error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	var fresh []string
+	for _, e := range entries {
+		path := filepath.Join(w.dir, e.Name())
+		if e.Type().IsRegular() && isFrameFile(path) && !w.state.isHandled(path) {
+			fresh = append(fresh, path)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	groups, err := groupFrames(ctx, fresh, w.group, w.size, w.gap)
+	if err != nil {
+		return fmt.Errorf("grouping brackets: %w", err)
+	}
+	for _, g := range groups {
+		if ctx.Err() != nil {
+			return nil
+		}
+		switch {
+		case w.group == groupSize && len(g) < w.size:
+			// The rest of the bracket has not arrived yet
+		case len(g) < 2:
+			if !w.lone[g[0].Path] {
+				w.lone[g[0].Path] = true
+				fmt.Fprintf(w.stdout, "skip %s: not part of a bracket yet\n", g[0].Path)
+			}
+		default:
+			if err := w.merge(ctx, imaging.Paths(g)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// merge processes a bracket and records it. Only failures to record the
+// bracket are returned; a bracket interrupted by ctx is left for the next
+// run.
+func (w *folderWatcher) merge(ctx context.Context, inputs []string) error {
+	var buf bytes.Buffer
+	err := w.tmpl.Execute(&buf, batchName{
+		Index: w.merged + w.failed + 1,
+		First: baseName(inputs[0]),
+		Last:  baseName(inputs[len(inputs)-1]),
+		Count: len(inputs),
+	})
+	output := filepath.Join(w.outputDir, buf.String())
+	if err == nil {
+		var warnings []string
+		warnings, err = jobs.Run(ctx, processor.NewHDRProcessor(w.opts...), inputs, output)
+		if ctx.Err() != nil {
+			return nil
+		}
+		for _, warning := range warnings {
+			w.ws.add("%s: %s", output, warning)
+		}
+	}
+
+	b := watchedBracket{Inputs: inputs, Time: time.Now()}
+	if err != nil {
+		w.failed++
+		b.Error = err.Error()
+		fmt.Fprintf(w.stdout, "FAIL %s: %v\n", strings.Join(inputs, ", "), err)
+	} else {
+		w.merged++
+		b.Output = output
+		fmt.Fprintf(w.stdout, "ok   %s (%d frames)\n", output, len(inputs))
+	}
+	return w.state.add(b)
+}
+
+// summary prints the counts of merged and failed brackets and returns the
+// exit code
+func (w *folderWatcher) summary() int {
+	fmt.Fprintf(w.stdout, "Merged %d brackets, %d failed\n", w.merged, w.failed)
+	if w.failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// isFrameFile reports whether path names an image the watcher groups.
+// Hidden files, such as those tether software writes before renaming them,
+// are ignored.
+func isFrameFile(path string) bool {
+	name := filepath.Base(path)
+	return !strings.HasPrefix(name, ".") && imaging.SupportedFormats[strings.ToLower(filepath.Ext(name))]
+}
+
+// sameDir reports whether a and b name the same directory
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
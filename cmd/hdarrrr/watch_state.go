@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchState records the brackets handled by the watch command, so that a
+// restarted watcher skips them
+type watchState struct {
+	path     string
+	Brackets []watchedBracket `json:"brackets"`
+	// handled holds the file names of every frame in Brackets
+	handled map[string]bool
+}
+
+// watchedBracket is a bracket handled by the watch command
+type watchedBracket struct {
+	// Inputs are the file names of the frames, darkest first
+	Inputs []string  `json:"inputs"`
+	Output string    `json:"output,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// loadWatchState reads the state file at path. A missing file is an empty
+// state.
+func loadWatchState(path string) (*watchState, error) {
+	s := &watchState{path: path, handled: map[string]bool{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, b := range s.Brackets {
+		for _, name := range b.Inputs {
+			s.handled[name] = true
+		}
+	}
+	return s, nil
+}
+
+// isHandled reports whether the frame at path belongs to a recorded bracket
+func (s *watchState) isHandled(path string) bool {
+	return s.handled[filepath.Base(path)]
+}
+
+// add records a bracket and saves the state file
+func (s *watchState) add(b watchedBracket) error {
+	for i, name := range b.Inputs {
+		b.Inputs[i] = filepath.Base(name)
+		s.handled[b.Inputs[i]] = true
+	}
+	s.Brackets = append(s.Brackets, b)
+	return s.save()
+}
+
+// save atomically replaces the state file
+func (s *watchState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".watch-")
+	if err != nil {
+		return fmt.Errorf("saving watch state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("saving watch state: %w", err)
+	}
+	return nil
+}
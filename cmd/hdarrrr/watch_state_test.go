@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), watchStateFile)
+	s, err := loadWatchState(path)
+	if err != nil {
+		t.Fatalf("Expected an empty state for a missing file, got %v", err)
+	}
+	if s.isHandled("shoot/a.jpg") {
+		t.Error("Expected no handled frame")
+	}
+
+	if err := s.add(watchedBracket{Inputs: []string{"shoot/a.jpg", "shoot/b.jpg"}, Output: "out/a_hdr.jpg", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := loadWatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range []string{"shoot/a.jpg", "elsewhere/b.jpg"} {
+		if !reloaded.isHandled(frame) {
+			t.Errorf("Expected %s to be handled", frame)
+		}
+	}
+	if reloaded.isHandled("shoot/c.jpg") || len(reloaded.Brackets) != 1 {
+		t.Errorf("Unexpected state %+v", reloaded.Brackets)
+	}
+}
+
+func TestWatchStateCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), watchStateFile)
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := loadWatchState(path); err == nil {
+		t.Error("Expected an error for a corrupt state file")
+	}
+}
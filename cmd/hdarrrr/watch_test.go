@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestRunWatchOnce(t *testing.T) {
+	dir := t.TempDir()
+	writeBracketFiles(t, dir, 7)
+	args := []string{"-once", "-group", "size", "-size", "3", "-name", "{{.First}}-{{.Last}}.png", dir}
+
+	var stdout, stderr bytes.Buffer
+	if code := runWatch(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+	for _, name := range []string{"frame_c-frame_a.png", "frame_f-frame_d.png"} {
+		if _, err := os.Stat(filepath.Join(dir, "hdr", name)); err != nil {
+			t.Errorf("Expected output %s: %v", name, err)
+		}
+	}
+	if !strings.Contains(stdout.String(), "Merged 2 brackets, 0 failed") {
+		t.Errorf("Unexpected summary:\n%s", stdout.String())
+	}
+
+	// A restarted watcher only merges the bracket completed since
+	writeBracketFiles(t, dir, 9)
+	stdout.Reset()
+	if code := runWatch(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "Merged 1 brackets") || !strings.Contains(out, "frame_i-frame_g.png") {
+		t.Errorf("Expected only the new bracket to be merged:\n%s", out)
+	}
+
+	state, err := loadWatchState(filepath.Join(dir, "hdr", watchStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Brackets) != 3 {
+		t.Errorf("Expected 3 brackets in the state file, got %d", len(state.Brackets))
+	}
+}
+
+func TestRunWatchSkipsSingleFrames(t *testing.T) {
+	dir := t.TempDir()
+	writeBracketFiles(t, dir, 1)
+
+	var stdout, stderr bytes.Buffer
+	if code := runWatch(context.Background(), []string{"-once", "-group", "exposure", dir}, &stdout, &stderr); code == 0 {
+		// Frames without EXIF data cannot be grouped by exposure
+		t.Fatalf("Expected a grouping failure, got 0:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runWatch(context.Background(), []string{"-once", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "not part of a bracket") {
+		t.Errorf("Expected the single frame to be skipped:\n%s", stdout.String())
+	}
+	state, err := loadWatchState(filepath.Join(dir, "hdr", watchStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Brackets) != 0 {
+		t.Errorf("Expected the single frame to be left out of the state file, got %+v", state.Brackets)
+	}
+}
+
+func TestWatchFlushLateFrames(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadWatchState(filepath.Join(t.TempDir(), watchStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	w := &folderWatcher{
+		dir:       dir,
+		outputDir: t.TempDir(),
+		group:     groupAuto,
+		gap:       2 * time.Second,
+		tmpl:      template.Must(template.New("name").Parse("{{.First}}.png")),
+		state:     state,
+		lone:      map[string]bool{},
+		stdout:    &stdout,
+		ws:        &warnings{w: &stderr},
+	}
+
+	// The first frame of the bracket settles before the others arrive
+	writeBracketFiles(t, dir, 1)
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w.merged != 0 || len(state.Brackets) != 0 {
+		t.Fatalf("Expected the lone frame to wait, got %d merged and %+v", w.merged, state.Brackets)
+	}
+
+	writeBracketFiles(t, dir, 3)
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w.merged != 1 || len(state.Brackets) != 1 || len(state.Brackets[0].Inputs) != 3 {
+		t.Errorf("Expected one bracket of 3 frames, got %+v (stderr: %s)", state.Brackets, stderr.String())
+	}
+
+	// Skipped frames are reported once
+	if n := strings.Count(stdout.String(), "skip"); n != 1 {
+		t.Errorf("Expected one skip message, got %d:\n%s", n, stdout.String())
+	}
+}
+
+func TestRunWatch(t *testing.T) {
+	dir := t.TempDir()
+	output := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	codes := make(chan int, 1)
+	go func() {
+		codes <- runWatch(ctx, []string{
+			"-settle", "50ms", "-group", "size", "-size", "3",
+			"-output-dir", output, "-name", "{{.Index}}.png", dir,
+		}, &stdout, &stderr)
+	}()
+
+	// Frames arriving after the watcher started are merged
+	time.Sleep(100 * time.Millisecond)
+	writeBracketFiles(t, dir, 3)
+	result := filepath.Join(output, "1.png")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(result); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the bracket to be merged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cancel()
+	if code := <-codes; code != 0 {
+		t.Errorf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Merged 1 brackets, 0 failed") {
+		t.Errorf("Unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestRunWatchUsage(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"missing dir", nil, 2},
+		{"bad settle", []string{"-settle", "0s", dir}, 2},
+		{"bad template", []string{"-name", "{{.Index", dir}, 2},
+		{"output in watched dir", []string{"-output-dir", dir, dir}, 2},
+		{"not a directory", []string{filepath.Join(dir, "missing")}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runWatch(context.Background(), tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+		})
+	}
+}
+
+func TestIsFrameFile(t *testing.T) {
+	for path, want := range map[string]bool{
+		"dir/IMG_0001.JPG": true,
+		"dir/frame.png":    true,
+		"dir/.frame.png":   false,
+		"dir/frame.cr2":    false,
+		"dir/notes.txt":    false,
+	} {
+		if got := isFrameFile(path); got != want {
+			t.Errorf("isFrameFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -3,12 +3,16 @@ module github.com/harperreed/hdarrrr
 go 1.23.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/mdouchement/hdr v0.2.4
 	github.com/mdouchement/tiff v0.0.0-20231118214351-fe2945891af6
+	golang.org/x/image v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
-	golang.org/x/image v0.14.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	gonum.org/v1/gonum v0.12.0 // indirect
 )
@@ -0,0 +1,105 @@
+// Package parallel runs per-tile image work on a bounded pool of goroutines.
+package parallel
+
+import (
+	"context"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// DefaultTileSize is the edge length, in pixels, of the tiles produced by Split
+// when no size is given
+const DefaultTileSize = 256
+
+// Workers returns n if it is positive, or the number of usable CPUs otherwise
+func Workers(n int) int {
+	if n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Split divides bounds into size×size tiles in row-major order. Tiles on the
+// right and bottom edges may be smaller. A non-positive size uses DefaultTileSize.
+func Split(bounds image.Rectangle, size int) []image.Rectangle {
+	if size <= 0 {
+		size = DefaultTileSize
+	}
+
+	var tiles []image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += size {
+		for x := bounds.Min.X; x < bounds.Max.X; x += size {
+			tiles = append(tiles, image.Rect(x, y, x+size, y+size).Intersect(bounds))
+		}
+	}
+	return tiles
+}
+
+// SplitRows divides bounds into full-width strips of at most rows rows
+func SplitRows(bounds image.Rectangle, rows int) []image.Rectangle {
+	if rows <= 0 {
+		rows = 1
+	}
+
+	var strips []image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += rows {
+		strips = append(strips, image.Rect(bounds.Min.X, y, bounds.Max.X, y+rows).Intersect(bounds))
+	}
+	return strips
+}
+
+// Run calls fn for every tile on at most workers goroutines. The index of the
+// tile in tiles is passed to fn so callers can store per-tile results without
+// locking. report, if not nil, is called after each tile with the number of
+// completed tiles; calls to report are serialized.
+//
+// Run stops handing out tiles once ctx is canceled and returns ctx.Err().
+func Run(ctx context.Context, tiles []image.Rectangle, workers int, fn func(i int, r image.Rectangle), report func(done, total int)) error {
+	workers = Workers(workers)
+	if workers > len(tiles) {
+		workers = len(tiles)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	next := make(chan int)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i, tiles[i])
+
+				if report != nil {
+					mu.Lock()
+					done++
+					report(done, len(tiles))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := range tiles {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		case next <- i:
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
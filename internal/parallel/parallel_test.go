@@ -0,0 +1,86 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	bounds := image.Rect(0, 0, 10, 5)
+	tiles := Split(bounds, 4)
+
+	if len(tiles) != 6 {
+		t.Fatalf("Expected 6 tiles, got %d", len(tiles))
+	}
+
+	area := 0
+	for _, tile := range tiles {
+		if !tile.In(bounds) {
+			t.Errorf("Tile %v outside bounds %v", tile, bounds)
+		}
+		area += tile.Dx() * tile.Dy()
+	}
+	if area != 50 {
+		t.Errorf("Expected tiles to cover 50 pixels, got %d", area)
+	}
+}
+
+func TestSplitRows(t *testing.T) {
+	strips := SplitRows(image.Rect(2, 3, 12, 10), 3)
+
+	if len(strips) != 3 {
+		t.Fatalf("Expected 3 strips, got %d", len(strips))
+	}
+	if strips[2] != image.Rect(2, 9, 12, 10) {
+		t.Errorf("Unexpected last strip %v", strips[2])
+	}
+}
+
+func TestRun(t *testing.T) {
+	tiles := Split(image.Rect(0, 0, 100, 100), 10)
+	seen := make([]int32, len(tiles))
+	var reports int32
+
+	err := Run(context.Background(), tiles, 4, func(i int, r image.Rectangle) {
+		atomic.AddInt32(&seen[i], 1)
+	}, func(done, total int) {
+		atomic.AddInt32(&reports, 1)
+		if done > total {
+			t.Errorf("Reported %d done out of %d", done, total)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i, n := range seen {
+		if n != 1 {
+			t.Errorf("Tile %d processed %d times", i, n)
+		}
+	}
+	if int(reports) != len(tiles) {
+		t.Errorf("Expected %d reports, got %d", len(tiles), reports)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tiles := Split(image.Rect(0, 0, 100, 100), 10)
+
+	var processed int32
+	err := Run(ctx, tiles, 1, func(i int, r image.Rectangle) {
+		if atomic.AddInt32(&processed, 1) == 5 {
+			cancel()
+		}
+	}, nil)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if int(processed) == len(tiles) {
+		t.Error("Expected cancellation to stop before all tiles were processed")
+	}
+}
@@ -0,0 +1,316 @@
+// Package synthetic renders HDR scenes of known radiance and simulates a
+// camera shooting exposure brackets of them, so tests and benchmarks can
+// compare merges and alignments with the scene they came from.
+//
+// A Scene gives the radiance at any point, so frames can be rendered with
+// sub-pixel shifts and rotations. A Camera maps the radiance of each frame
+// through its exposure time and response curve, adds noise, clips and
+// quantizes it like a real sensor and JPEG pipeline would.
+package synthetic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// Scene is an HDR scene of known radiance
+type Scene struct {
+	Width  int
+	Height int
+	// Radiance returns the linear RGB radiance at a point of the scene, in
+	// pixels from its top left corner
+	Radiance func(x, y float64) [3]float64
+}
+
+// Render returns the ground-truth radiance map of the scene, sampled at
+// the pixel centers
+func (s Scene) Render() *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, s.Width, s.Height))
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			c := s.Radiance(float64(x)+0.5, float64(y)+0.5)
+			m.SetRGB(x, y, hdrcolor.RGB{R: c[0], G: c[1], B: c[2]})
+		}
+	}
+	return m
+}
+
+// Window returns a textured interior lit by a bright window in its upper
+// right, spanning about four decades of radiance. The texture has no
+// repeating pattern, so aligners find a single match.
+func Window(width, height int) Scene {
+	w, h := float64(width), float64(height)
+	return Scene{Width: width, Height: height, Radiance: func(x, y float64) [3]float64 {
+		// Warm, dim walls with a textured falloff away from the window
+		base := 0.02 + 0.3*texture(x/8, y/8)*(0.3+0.7*x/w)
+		c := [3]float64{base, 0.85 * base, 0.7 * base}
+		if x > 0.6*w && x < 0.9*w && y > 0.15*h && y < 0.55*h {
+			// A cool sky with a few bright clouds
+			sky := 20 + 60*texture(x/12+100, y/12)
+			c = [3]float64{0.8 * sky, 0.9 * sky, sky}
+		}
+		return c
+	}}
+}
+
+// Gradient returns a horizontal ramp of gray radiance rising over the given
+// number of decades from 0.01, with a faint texture for aligners
+func Gradient(width, height int, decades float64) Scene {
+	return Scene{Width: width, Height: height, Radiance: func(x, y float64) [3]float64 {
+		v := 0.01 * math.Pow(10, decades*x/float64(width)) * (0.9 + 0.2*texture(x/6, y/6))
+		return [3]float64{v, v, v}
+	}}
+}
+
+// texture is smooth value noise in [0, 1): hashed values on the integer
+// lattice, blended bilinearly
+func texture(x, y float64) float64 {
+	cx, cy := math.Floor(x), math.Floor(y)
+	fx, fy := x-cx, y-cy
+	ix, iy := int(cx), int(cy)
+	top := lattice(ix, iy)*(1-fx) + lattice(ix+1, iy)*fx
+	bottom := lattice(ix, iy+1)*(1-fx) + lattice(ix+1, iy+1)*fx
+	return top*(1-fy) + bottom*fy
+}
+
+// lattice hashes a grid point to a value in [0, 1)
+func lattice(x, y int) float64 {
+	h := uint32(x)*374761393 + uint32(y)*668265263
+	h = (h ^ h>>13) * 1274126177
+	return float64(h>>8&0xffff) / 0x10000
+}
+
+// Object is a disc of uniform radiance moving across the scene between
+// frames, like a person walking through a bracket
+type Object struct {
+	// Start is the center of the disc in the first frame, and Velocity its
+	// displacement per frame, in pixels
+	Start    [2]float64
+	Velocity [2]float64
+	Radius   float64
+	Radiance [3]float64
+}
+
+// Motion is the displacement of the camera for a frame: the scene point p
+// appears at p - Shift, rotated by Rotation radians about the center of
+// the frame
+type Motion struct {
+	Shift    [2]float64
+	Rotation float64
+}
+
+// Response curves of cameras, mapping exposure to a pixel value in [0, 1]
+var (
+	// Linear is the response of a raw sensor
+	Linear = func(e float64) float64 { return e }
+	// SRGB is the response of a camera producing sRGB JPEGs
+	SRGB = colorspace.SRGBCurve.Encode
+)
+
+// Gamma returns a response curve of a plain power law
+func Gamma(gamma float64) func(e float64) float64 {
+	return func(e float64) float64 { return math.Pow(e, 1/gamma) }
+}
+
+// Camera simulates shooting a bracket of exposures
+type Camera struct {
+	// Exposures holds the exposure time of each frame, relative to the
+	// scene radiance: a radiance of 1/t fills the sensor at time t
+	Exposures []float64
+	// Response maps exposure to a pixel value before clipping; nil is SRGB
+	Response func(e float64) float64
+	// Noise is the standard deviation of the noise added to the exposure
+	// of each pixel: a read noise floor plus shot noise growing with the
+	// square root of the exposure
+	Noise float64
+	// Seed seeds the noise, so brackets are reproducible
+	Seed int64
+	// Motions holds the camera motion of each frame, or is nil for a
+	// camera on a tripod
+	Motions []Motion
+	// Objects move across the scene between frames
+	Objects []Object
+	// BitDepth is 8 or 16 bits per channel; 0 is 8
+	BitDepth int
+	// Samples is the number of samples per pixel along each axis, to
+	// antialias edges; 0 is 2
+	Samples int
+}
+
+// Bracket is a simulated exposure bracket with its ground truth
+type Bracket struct {
+	Frames    []image.Image
+	Exposures []float64
+	Motions   []Motion
+	// Truth is the radiance map of the scene without camera or object
+	// motion, noise or clipping
+	Truth *hdr.RGB
+}
+
+// Shoot renders the scene through the camera, one frame per exposure
+func (c Camera) Shoot(s Scene) (*Bracket, error) {
+	if len(c.Exposures) == 0 {
+		return nil, fmt.Errorf("no exposures")
+	}
+	if c.Motions != nil && len(c.Motions) != len(c.Exposures) {
+		return nil, fmt.Errorf("got %d motions for %d exposures", len(c.Motions), len(c.Exposures))
+	}
+	bits := c.BitDepth
+	if bits == 0 {
+		bits = 8
+	}
+	if bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("unsupported bit depth: %d", bits)
+	}
+	response := c.Response
+	if response == nil {
+		response = SRGB
+	}
+	samples := c.Samples
+	if samples <= 0 {
+		samples = 2
+	}
+
+	rng := rand.New(rand.NewSource(c.Seed))
+	b := &Bracket{Exposures: c.Exposures, Motions: c.Motions, Truth: s.Render()}
+	for i, t := range c.Exposures {
+		if t <= 0 {
+			return nil, fmt.Errorf("invalid exposure time: %v", t)
+		}
+		var m Motion
+		if c.Motions != nil {
+			m = c.Motions[i]
+		}
+		radiance := c.radiance(s, m, i, samples)
+		frame := newFrame(s.Width, s.Height, bits)
+		for y := 0; y < s.Height; y++ {
+			for x := 0; x < s.Width; x++ {
+				var v [3]float64
+				for ch, l := range radiance[y*s.Width+x] {
+					e := l * t
+					if c.Noise > 0 {
+						e += rng.NormFloat64() * c.Noise * (1 + math.Sqrt(max(e, 0)))
+					}
+					v[ch] = min(max(response(max(e, 0)), 0), 1)
+				}
+				frame.set(x, y, v)
+			}
+		}
+		b.Frames = append(b.Frames, frame.img)
+	}
+	return b, nil
+}
+
+// radiance returns the radiance of every pixel of frame i, seen through
+// the camera motion m, with the objects at their position in that frame
+func (c Camera) radiance(s Scene, m Motion, i, samples int) [][3]float64 {
+	cx, cy := float64(s.Width)/2, float64(s.Height)/2
+	sin, cos := math.Sincos(m.Rotation)
+	out := make([][3]float64, s.Width*s.Height)
+	n := float64(samples * samples)
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			var sum [3]float64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					// Undo the rotation about the center, then the shift
+					px := float64(x) + (float64(sx)+0.5)/float64(samples) - cx
+					py := float64(y) + (float64(sy)+0.5)/float64(samples) - cy
+					qx := cos*px + sin*py + cx + m.Shift[0]
+					qy := -sin*px + cos*py + cy + m.Shift[1]
+					l := c.objectRadiance(qx, qy, i)
+					if l == nil {
+						v := s.Radiance(qx, qy)
+						l = &v
+					}
+					for ch := range sum {
+						sum[ch] += l[ch]
+					}
+				}
+			}
+			for ch := range sum {
+				sum[ch] /= n
+			}
+			out[y*s.Width+x] = sum
+		}
+	}
+	return out
+}
+
+// objectRadiance returns the radiance of the topmost object covering the
+// scene point x, y in frame i, or nil
+func (c Camera) objectRadiance(x, y float64, i int) *[3]float64 {
+	for j := len(c.Objects) - 1; j >= 0; j-- {
+		o := &c.Objects[j]
+		dx := x - (o.Start[0] + float64(i)*o.Velocity[0])
+		dy := y - (o.Start[1] + float64(i)*o.Velocity[1])
+		if dx*dx+dy*dy <= o.Radius*o.Radius {
+			return &o.Radiance
+		}
+	}
+	return nil
+}
+
+// Offset returns the offset an aligner should find for frame i against
+// frame ref, rounded to whole pixels, ignoring rotation
+func (b *Bracket) Offset(i, ref int) image.Point {
+	if b.Motions == nil {
+		return image.Point{}
+	}
+	dx := b.Motions[ref].Shift[0] - b.Motions[i].Shift[0]
+	dy := b.Motions[ref].Shift[1] - b.Motions[i].Shift[1]
+	return image.Pt(int(math.Round(dx)), int(math.Round(dy)))
+}
+
+// Save writes the frames to dir as PNG files named frame_<n>.png and
+// returns their paths, darkest exposure first as shot
+func (b *Bracket) Save(dir string) ([]string, error) {
+	paths := make([]string, len(b.Frames))
+	for i, img := range b.Frames {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("frame_%d.png", i+1))
+		f, err := os.Create(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		err = png.Encode(f, img)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("saving %s: %w", paths[i], err)
+		}
+	}
+	return paths, nil
+}
+
+// frame is a frame being rendered at a bit depth
+type frame struct {
+	img image.Image
+	set func(x, y int, v [3]float64)
+}
+
+func newFrame(width, height, bits int) frame {
+	r := image.Rect(0, 0, width, height)
+	if bits == 16 {
+		img := image.NewRGBA64(r)
+		q := func(v float64) uint16 { return uint16(math.Round(v * 0xffff)) }
+		return frame{img, func(x, y int, v [3]float64) {
+			img.SetRGBA64(x, y, color.RGBA64{R: q(v[0]), G: q(v[1]), B: q(v[2]), A: 0xffff})
+		}}
+	}
+	img := image.NewRGBA(r)
+	q := func(v float64) uint8 { return uint8(math.Round(v * 0xff)) }
+	return frame{img, func(x, y int, v [3]float64) {
+		img.SetRGBA(x, y, color.RGBA{R: q(v[0]), G: q(v[1]), B: q(v[2]), A: 0xff})
+	}}
+}
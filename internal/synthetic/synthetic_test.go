@@ -0,0 +1,198 @@
+package synthetic
+
+import (
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// gray returns the red channel of a frame pixel in [0, 1]
+func gray(img image.Image, x, y int) float64 {
+	r, _, _, _ := img.At(x, y).RGBA()
+	return float64(r) / 0xffff
+}
+
+func TestSceneRender(t *testing.T) {
+	s := Window(80, 60)
+	m := s.Render()
+	if m.Bounds() != image.Rect(0, 0, 80, 60) {
+		t.Fatalf("Expected 80x60 bounds, got %v", m.Bounds())
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 80; x++ {
+			r, _, _, _ := m.HDRAt(x, y).HDRRGBA()
+			if want := s.Radiance(float64(x)+0.5, float64(y)+0.5)[0]; math.Abs(r-want) > 1e-5*want {
+				t.Fatalf("Pixel %d,%d: expected %v, got %v", x, y, want, r)
+			}
+			lo, hi = min(lo, r), max(hi, r)
+		}
+	}
+	if hi/lo < 1000 {
+		t.Errorf("Expected the window scene to span three decades, got %v to %v", lo, hi)
+	}
+}
+
+func TestShootExposures(t *testing.T) {
+	s := Gradient(64, 8, 4)
+	b, err := Camera{Exposures: []float64{0.25, 1, 4}, Response: Linear, Samples: 1}.Shoot(s)
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+	if len(b.Frames) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(b.Frames))
+	}
+	if _, ok := b.Frames[0].(*image.RGBA); !ok {
+		t.Errorf("Expected 8-bit frames by default, got %T", b.Frames[0])
+	}
+
+	for i, exposure := range b.Exposures {
+		for _, x := range []int{5, 30, 60} {
+			truth, _, _, _ := b.Truth.HDRAt(x, 4).HDRRGBA()
+			want := math.Min(truth*exposure, 1)
+			if got := gray(b.Frames[i], x, 4); math.Abs(got-want) > 1.0/255 {
+				t.Errorf("Frame %d at x=%d: expected %.4f, got %.4f", i, x, want, got)
+			}
+		}
+	}
+	if gray(b.Frames[2], 63, 4) != 1 {
+		t.Error("Expected the longest exposure to clip the highlights")
+	}
+
+	deep, err := Camera{Exposures: []float64{1}, BitDepth: 16}.Shoot(s)
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+	if _, ok := deep.Frames[0].(*image.RGBA64); !ok {
+		t.Errorf("Expected a 16-bit frame, got %T", deep.Frames[0])
+	}
+}
+
+func TestShootNoise(t *testing.T) {
+	s := Gradient(32, 32, 2)
+	shoot := func(seed int64) image.Image {
+		b, err := Camera{Exposures: []float64{2}, Noise: 0.02, Seed: seed}.Shoot(s)
+		if err != nil {
+			t.Fatalf("Shoot failed: %v", err)
+		}
+		return b.Frames[0]
+	}
+	a, again, other := shoot(1), shoot(1), shoot(2)
+	same, differ := true, false
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			same = same && gray(a, x, y) == gray(again, x, y)
+			differ = differ || gray(a, x, y) != gray(other, x, y)
+		}
+	}
+	if !same || !differ {
+		t.Errorf("Expected noise to depend on the seed alone, got same %v, differ %v", same, differ)
+	}
+}
+
+func TestShootMotion(t *testing.T) {
+	s := Gradient(64, 16, 2)
+	cam := Camera{
+		Exposures: []float64{1, 1, 1},
+		Response:  Linear,
+		Samples:   1,
+		Motions:   []Motion{{Shift: [2]float64{3, 1}}, {}, {Shift: [2]float64{-2.4, 0}, Rotation: 0.01}},
+	}
+	b, err := cam.Shoot(s)
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+	// Scene point p appears at p - shift
+	for x := 10; x < 50; x += 7 {
+		if got, want := gray(b.Frames[0], x-3, 7), gray(b.Frames[1], x, 8); got != want {
+			t.Errorf("x=%d: expected the shifted frame to show %.4f, got %.4f", x, want, got)
+		}
+	}
+
+	tests := []struct {
+		i, ref int
+		want   image.Point
+	}{
+		{0, 1, image.Pt(-3, -1)},
+		{2, 1, image.Pt(2, 0)},
+		{0, 2, image.Pt(-5, -1)},
+		{1, 1, image.Point{}},
+	}
+	for _, tt := range tests {
+		if got := b.Offset(tt.i, tt.ref); got != tt.want {
+			t.Errorf("Offset(%d, %d): expected %v, got %v", tt.i, tt.ref, tt.want, got)
+		}
+	}
+}
+
+func TestShootObjects(t *testing.T) {
+	s := Gradient(64, 32, 2)
+	cam := Camera{
+		Exposures: []float64{1, 1, 1},
+		Response:  Linear,
+		Objects:   []Object{{Start: [2]float64{10, 16}, Velocity: [2]float64{20, 0}, Radius: 4, Radiance: [3]float64{0.5, 0, 0}}},
+	}
+	b, err := cam.Shoot(s)
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+	for i, x := range []int{10, 30, 50} {
+		r, g, _, _ := b.Frames[i].At(x, 16).RGBA()
+		if math.Abs(float64(r)/0xffff-0.5) > 1.0/255 || g != 0 {
+			t.Errorf("Frame %d: expected the object at x=%d, got %v", i, x, b.Frames[i].At(x, 16))
+		}
+		if _, g, _, _ := b.Frames[i].At(10+20*((i+1)%3), 16).RGBA(); g == 0 {
+			t.Errorf("Frame %d: expected the background where the object is not", i)
+		}
+	}
+}
+
+func TestShootErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cam     Camera
+		wantErr string
+	}{
+		{"no exposures", Camera{}, "no exposures"},
+		{"bad exposure", Camera{Exposures: []float64{1, 0}}, "invalid exposure time"},
+		{"motions", Camera{Exposures: []float64{1, 2}, Motions: []Motion{{}}}, "1 motions for 2 exposures"},
+		{"bit depth", Camera{Exposures: []float64{1}, BitDepth: 12}, "unsupported bit depth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cam.Shoot(Gradient(8, 8, 1))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBracketSave(t *testing.T) {
+	b, err := Camera{Exposures: []float64{0.5, 2}}.Shoot(Window(16, 12))
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+	paths, err := b.Save(t.TempDir())
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for i, path := range paths {
+		if !strings.HasSuffix(path, []string{"frame_1.png", "frame_2.png"}[i]) {
+			t.Errorf("Unexpected path %s", path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil || img.Bounds().Size() != image.Pt(16, 12) {
+			t.Errorf("Expected a 16x12 PNG, got %v, %v", img, err)
+		}
+	}
+}
@@ -2,9 +2,12 @@
 package align
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"image"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
 )
 
 // Aligner defines the interface for image alignment implementations
@@ -12,6 +15,20 @@ type Aligner interface {
 	Align(images []image.Image) ([]image.Image, error)
 }
 
+// ContextAligner is an Aligner that can be canceled and reports progress
+// through the Reporter carried by the context
+type ContextAligner interface {
+	Aligner
+	AlignContext(ctx context.Context, images []image.Image) ([]image.Image, error)
+}
+
+// OffsetAligner is an Aligner that corrects translations and can report the
+// offset it finds for every image (see MTBAligner.Offsets)
+type OffsetAligner interface {
+	Aligner
+	Offsets(ctx context.Context, images []image.Image) ([]image.Point, error)
+}
+
 // BasicAligner provides simple dimension validation
 type BasicAligner struct{}
 
@@ -24,25 +41,102 @@ func NewBasicAligner() *BasicAligner {
 // This implementation ensures images are the same size but does not perform
 // any pixel-level alignment.
 func (a *BasicAligner) Align(images []image.Image) ([]image.Image, error) {
+	return a.AlignContext(context.Background(), images)
+}
+
+// AlignContext is Align with cancellation and progress reporting
+func (a *BasicAligner) AlignContext(ctx context.Context, images []image.Image) ([]image.Image, error) {
 	if len(images) < 2 {
 		return nil, errors.New("at least two images are required for alignment")
 	}
 
 	baseBounds := images[0].Bounds()
 	for i, img := range images[1:] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		if img == nil {
 			return nil, fmt.Errorf("image %d is nil", i+1)
 		}
 		if img.Bounds() != baseBounds {
 			return nil, fmt.Errorf("image %d has different dimensions than the base image", i+1)
 		}
+		progress.Step(ctx, progress.StageAlign, i+1, len(images)-1)
 	}
 
 	return images, nil
 }
 
+// NopAligner returns the images unchanged, for exposures that are already
+// registered
+type NopAligner struct{}
+
+// Align returns images as they are
+func (NopAligner) Align(images []image.Image) ([]image.Image, error) {
+	return images, nil
+}
+
+// Methods lists the alignment methods accepted by New
+var Methods = []string{"basic", "mtb", "none"}
+
+// New returns the aligner for a method name (see Methods)
+func New(method string) (Aligner, error) {
+	switch method {
+	case "basic":
+		return NewBasicAligner(), nil
+	case "mtb":
+		return NewMTBAligner(), nil
+	case "none":
+		return NopAligner{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported alignment method: %s", method)
+	}
+}
+
 // AlignImages is a convenience function that uses the BasicAligner
 func AlignImages(images []image.Image) ([]image.Image, error) {
 	aligner := NewBasicAligner()
 	return aligner.Align(images)
 }
+
+// AlignImagesContext is AlignImages with cancellation and progress reporting
+func AlignImagesContext(ctx context.Context, images []image.Image) ([]image.Image, error) {
+	return AlignContext(ctx, NewBasicAligner(), images)
+}
+
+// AlignContext runs aligner with ctx, falling back to Align for aligners
+// that do not implement ContextAligner
+func AlignContext(ctx context.Context, aligner Aligner, images []image.Image) ([]image.Image, error) {
+	if ca, ok := aligner.(ContextAligner); ok {
+		return ca.AlignContext(ctx, images)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	aligned, err := aligner.Align(images)
+	if err != nil {
+		return nil, err
+	}
+	progress.FromContext(ctx).Report(progress.StageAlign, 1)
+	return aligned, nil
+}
+
+// AlignOffsets is AlignContext that also returns the offset of every image.
+// The offsets are nil when aligner does not implement OffsetAligner.
+func AlignOffsets(ctx context.Context, aligner Aligner, images []image.Image) ([]image.Image, []image.Point, error) {
+	oa, ok := aligner.(OffsetAligner)
+	if !ok {
+		aligned, err := AlignContext(ctx, aligner, images)
+		return aligned, nil, err
+	}
+
+	offsets, err := oa.Offsets(ctx, images)
+	if err != nil {
+		return nil, nil, err
+	}
+	aligned := make([]image.Image, len(images))
+	for i, img := range images {
+		aligned[i] = Translate(img, offsets[i])
+	}
+	return aligned, offsets, nil
+}
@@ -1,8 +1,11 @@
 package align
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/color"
+	"slices"
 	"testing"
 )
 
@@ -172,3 +175,96 @@ func TestBasicAligner(t *testing.T) {
 		})
 	}
 }
+
+func TestAlignImagesContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := AlignImagesContext(ctx, []image.Image{
+		createTestImage(10, 10),
+		createTestImage(10, 10),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// plainAligner implements only the Aligner interface
+type plainAligner struct{ calls int }
+
+func (a *plainAligner) Align(images []image.Image) ([]image.Image, error) {
+	a.calls++
+	return images, nil
+}
+
+func TestAlignContextFallback(t *testing.T) {
+	aligner := &plainAligner{}
+	images := []image.Image{createTestImage(10, 10), createTestImage(10, 10)}
+
+	if _, err := AlignContext(context.Background(), aligner, images); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if aligner.calls != 1 {
+		t.Errorf("Expected Align to be called once, got %d", aligner.calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AlignContext(ctx, aligner, images); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAlignOffsets(t *testing.T) {
+	images := []image.Image{
+		shiftedExposure(96, 64, image.Pt(3, 1), 0.5),
+		shiftedExposure(96, 64, image.Point{}, 1),
+	}
+
+	tests := []struct {
+		name        string
+		aligner     Aligner
+		wantOffsets []image.Point
+	}{
+		{"mtb", NewMTBAligner(), []image.Point{{-3, -1}, {0, 0}}},
+		{"basic", NewBasicAligner(), nil},
+		{"none", NopAligner{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aligned, offsets, err := AlignOffsets(context.Background(), tt.aligner, images)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(aligned) != len(images) {
+				t.Fatalf("Expected %d images, got %d", len(images), len(aligned))
+			}
+			if !slices.Equal(offsets, tt.wantOffsets) {
+				t.Errorf("Expected offsets %v, got %v", tt.wantOffsets, offsets)
+			}
+		})
+	}
+
+	if _, _, err := AlignOffsets(context.Background(), NewMTBAligner(), images[:1]); err == nil {
+		t.Error("Expected error for a single image, got nil")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, method := range Methods {
+		if _, err := New(method); err != nil {
+			t.Errorf("New(%q): unexpected error: %v", method, err)
+		}
+	}
+	if _, err := New("invalid"); err == nil {
+		t.Error("Expected error for unknown method, got nil")
+	}
+
+	// The no-op aligner accepts mismatched images
+	aligner, _ := New("none")
+	images := []image.Image{createTestImage(10, 10), createTestImage(5, 5)}
+	if aligned, err := aligner.Align(images); err != nil || len(aligned) != 2 {
+		t.Errorf("Expected images unchanged, got %v, %v", aligned, err)
+	}
+}
@@ -0,0 +1,270 @@
+package align
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// DefaultMaxShift is the largest offset, in pixels, searched by MTBAligner
+const DefaultMaxShift = 64
+
+// mtbNoise is the distance from the median, in 8-bit gray levels, under
+// which pixels are left out of the comparison as they flip with noise
+const mtbNoise = 4
+
+// mtbMinSize is the smallest side of the coarsest pyramid level
+const mtbMinSize = 16
+
+// MTBAligner registers exposures with Ward's median threshold bitmaps. Each
+// image is thresholded at its median gray level, which gives the same bitmap
+// whatever the exposure, and the bitmaps are matched from a coarse to a fine
+// resolution. Only translations are corrected.
+type MTBAligner struct {
+	// MaxShift bounds the offsets searched, in pixels
+	MaxShift int
+	// Reference is the index of the image the others are aligned to, or -1
+	// for the middle exposure
+	Reference int
+}
+
+// NewMTBAligner creates an MTBAligner that aligns to the middle exposure
+func NewMTBAligner() *MTBAligner {
+	return &MTBAligner{MaxShift: DefaultMaxShift, Reference: -1}
+}
+
+// Align shifts every image onto the reference image
+func (a *MTBAligner) Align(images []image.Image) ([]image.Image, error) {
+	return a.AlignContext(context.Background(), images)
+}
+
+// AlignContext is Align with cancellation and progress reporting
+func (a *MTBAligner) AlignContext(ctx context.Context, images []image.Image) ([]image.Image, error) {
+	offsets, err := a.Offsets(ctx, images)
+	if err != nil {
+		return nil, err
+	}
+
+	aligned := make([]image.Image, len(images))
+	for i, img := range images {
+		aligned[i] = Translate(img, offsets[i])
+	}
+	return aligned, nil
+}
+
+// Offsets returns, for every image, where the content of the reference image
+// lies in it: pixel (x, y) of the reference matches pixel (x, y) + offset.
+// The offset of the reference image is zero.
+func (a *MTBAligner) Offsets(ctx context.Context, images []image.Image) ([]image.Point, error) {
+	if err := checkImages(images); err != nil {
+		return nil, err
+	}
+	ref := a.Reference
+	if ref < 0 {
+		ref = len(images) / 2
+	}
+	if ref >= len(images) {
+		return nil, fmt.Errorf("reference image %d out of range", ref+1)
+	}
+
+	levels := bits.Len(uint(max(a.MaxShift, 0)))
+	refPyramid := newPyramid(grayOf(images[ref]), levels)
+
+	offsets := make([]image.Point, len(images))
+	for i, img := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if i != ref {
+			offsets[i] = matchPyramids(refPyramid, newPyramid(grayOf(img), levels), a.MaxShift)
+		}
+		progress.Step(ctx, progress.StageAlign, i+1, len(images))
+	}
+	return offsets, nil
+}
+
+// checkImages rejects fewer than two images, nil images and mismatched sizes
+func checkImages(images []image.Image) error {
+	if len(images) < 2 {
+		return errors.New("at least two images are required for alignment")
+	}
+	for i, img := range images {
+		if img == nil {
+			return fmt.Errorf("image %d is nil", i+1)
+		}
+		if img.Bounds().Size() != images[0].Bounds().Size() {
+			return fmt.Errorf("image %d has different dimensions than the base image", i+1)
+		}
+	}
+	return nil
+}
+
+// bitmap holds the threshold and exclusion bitmaps of one pyramid level
+type bitmap struct {
+	w, h int
+	// threshold is set where the pixel is brighter than the median
+	threshold []bool
+	// exclude is set where the pixel is too close to the median to be trusted
+	exclude []bool
+}
+
+// newPyramid builds up to levels bitmaps of gray, from full resolution down,
+// stopping early when the image gets too small
+func newPyramid(gray *image.Gray, levels int) []bitmap {
+	pyramid := []bitmap{newBitmap(gray)}
+	for len(pyramid) < levels {
+		b := gray.Bounds()
+		if b.Dx()/2 < mtbMinSize || b.Dy()/2 < mtbMinSize {
+			break
+		}
+		gray = halve(gray)
+		pyramid = append(pyramid, newBitmap(gray))
+	}
+	return pyramid
+}
+
+// newBitmap thresholds gray at its median
+func newBitmap(gray *image.Gray) bitmap {
+	b := gray.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var hist [256]int
+	for y := 0; y < h; y++ {
+		for _, v := range gray.Pix[y*gray.Stride : y*gray.Stride+w] {
+			hist[v]++
+		}
+	}
+	median, count := 0, 0
+	for ; median < 255; median++ {
+		if count += hist[median]; count*2 >= w*h {
+			break
+		}
+	}
+
+	bm := bitmap{w: w, h: h, threshold: make([]bool, w*h), exclude: make([]bool, w*h)}
+	for y := 0; y < h; y++ {
+		for x, v := range gray.Pix[y*gray.Stride : y*gray.Stride+w] {
+			bm.threshold[y*w+x] = int(v) > median
+			bm.exclude[y*w+x] = int(v) >= median-mtbNoise && int(v) <= median+mtbNoise
+		}
+	}
+	return bm
+}
+
+// matchPyramids finds the offset of img relative to ref, refining it from
+// the coarsest level shared by both pyramids down to full resolution
+func matchPyramids(ref, img []bitmap, maxShift int) image.Point {
+	var offset image.Point
+	for level := min(len(ref), len(img)) - 1; level >= 0; level-- {
+		offset = offset.Mul(2)
+		best, bestErr := offset, -1
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				p := offset.Add(image.Pt(dx, dy))
+				if e := difference(ref[level], img[level], p); bestErr < 0 || e < bestErr {
+					best, bestErr = p, e
+				}
+			}
+		}
+		offset = best
+	}
+
+	offset.X = max(-maxShift, min(maxShift, offset.X))
+	offset.Y = max(-maxShift, min(maxShift, offset.Y))
+	return offset
+}
+
+// difference counts the trusted pixels of ref whose threshold differs from
+// the pixel of img at offset
+func difference(ref, img bitmap, offset image.Point) int {
+	n := 0
+	for y := max(0, -offset.Y); y < min(ref.h, img.h-offset.Y); y++ {
+		r := y * ref.w
+		i := (y+offset.Y)*img.w + offset.X
+		for x := max(0, -offset.X); x < min(ref.w, img.w-offset.X); x++ {
+			if ref.threshold[r+x] != img.threshold[i+x] && !ref.exclude[r+x] && !img.exclude[i+x] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// halve downsamples gray by two with a box filter
+func halve(gray *image.Gray) *image.Gray {
+	b := gray.Bounds()
+	w, h := b.Dx()/2, b.Dy()/2
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		top := gray.Pix[2*y*gray.Stride:]
+		bottom := gray.Pix[(2*y+1)*gray.Stride:]
+		for x := 0; x < w; x++ {
+			sum := int(top[2*x]) + int(top[2*x+1]) + int(bottom[2*x]) + int(bottom[2*x+1])
+			out.Pix[y*out.Stride+x] = uint8((sum + 2) / 4)
+		}
+	}
+	return out
+}
+
+// grayOf converts img to 8-bit gray levels with origin (0, 0). HDR channels
+// are clamped to [0, 1].
+func grayOf(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+
+	if m, ok := img.(*hdr.RGB); ok {
+		for y := 0; y < b.Dy(); y++ {
+			row := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < b.Dx(); x++ {
+				l := 0.2126*row[3*x] + 0.7152*row[3*x+1] + 0.0722*row[3*x+2]
+				gray.Pix[y*gray.Stride+x] = uint8(max(0, min(1, l))*255 + 0.5)
+			}
+		}
+		return gray
+	}
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			gray.Pix[y*gray.Stride+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return gray
+}
+
+// Translate returns img moved by -offset, so that pixel (x, y) of the result
+// is pixel (x, y) + offset of img. Pixels moved in from outside the image
+// repeat its edge.
+func Translate(img image.Image, offset image.Point) image.Image {
+	if offset == (image.Point{}) {
+		return img
+	}
+	b := img.Bounds()
+	clampX := func(x int) int { return max(b.Min.X, min(b.Max.X-1, x)) }
+	clampY := func(y int) int { return max(b.Min.Y, min(b.Max.Y-1, y)) }
+
+	if m, ok := img.(*hdr.RGB); ok {
+		out := hdr.NewRGB(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			dst := out.Pix[out.PixOffset(b.Min.X, y):]
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := m.PixOffset(clampX(x+offset.X), clampY(y+offset.Y))
+				copy(dst[3*(x-b.Min.X):3*(x-b.Min.X)+3], m.Pix[i:i+3])
+			}
+		}
+		return out
+	}
+
+	out := image.NewRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.Set(x, y, img.At(clampX(x+offset.X), clampY(y+offset.Y)))
+		}
+	}
+	return out
+}
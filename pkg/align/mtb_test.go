@@ -0,0 +1,167 @@
+package align
+
+import (
+	"context"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/internal/synthetic"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// sceneRadiance is a textured, non-repeating radiance pattern: hashed
+// values on a coarse grid, blended bilinearly
+func sceneRadiance(x, y int) float64 {
+	const cell = 6
+	cx, cy := floorDiv(x, cell), floorDiv(y, cell)
+	fx := float64(x-cx*cell) / cell
+	fy := float64(y-cy*cell) / cell
+	top := lattice(cx, cy)*(1-fx) + lattice(cx+1, cy)*fx
+	bottom := lattice(cx, cy+1)*(1-fx) + lattice(cx+1, cy+1)*fx
+	return 0.05 + 0.9*(top*(1-fy)+bottom*fy)
+}
+
+// lattice hashes a grid point to a value in [0, 1)
+func lattice(x, y int) float64 {
+	h := uint32(x)*374761393 + uint32(y)*668265263
+	h = (h ^ h>>13) * 1274126177
+	return float64(h>>8&0xffff) / 0x10000
+}
+
+// floorDiv divides rounding towards negative infinity
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((b - 1 - a) / b)
+	}
+	return a / b
+}
+
+// shiftedExposure renders the scene seen through a camera moved by shift,
+// so that scene point p appears at p - shift, at the given exposure
+func shiftedExposure(width, height int, shift image.Point, exposure float64) *hdr.RGB {
+	img := hdr.NewRGB(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := math.Min(1, sceneRadiance(x+shift.X, y+shift.Y)*exposure)
+			img.SetRGB(x, y, hdrcolor.RGB{R: v, G: v, B: v})
+		}
+	}
+	return img
+}
+
+func TestMTBAlignerOffsets(t *testing.T) {
+	// The pyramid of a 256x192 image covers offsets up to 15 pixels
+	shifts := []image.Point{{-7, 3}, {0, 0}, {12, -5}}
+	images := []image.Image{
+		shiftedExposure(256, 192, shifts[0], 0.4),
+		shiftedExposure(256, 192, shifts[1], 1),
+		shiftedExposure(256, 192, shifts[2], 1.5),
+	}
+
+	offsets, err := NewMTBAligner().Offsets(context.Background(), images)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Reference point p lies at p - shift in the other images
+	for i, shift := range shifts {
+		if want := shift.Mul(-1); offsets[i] != want {
+			t.Errorf("Image %d: expected offset %v, got %v", i, want, offsets[i])
+		}
+	}
+}
+
+func TestMTBAlignerSyntheticBracket(t *testing.T) {
+	// A noisy hand-held bracket with sub-pixel shifts and a slight rotation
+	cam := synthetic.Camera{
+		Exposures: []float64{0.05, 0.5, 5},
+		Noise:     0.005,
+		Seed:      1,
+		Motions: []synthetic.Motion{
+			{Shift: [2]float64{-4.2, 2.8}},
+			{},
+			{Shift: [2]float64{6.1, -3.3}, Rotation: 0.002},
+		},
+	}
+	b, err := cam.Shoot(synthetic.Window(256, 192))
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+
+	offsets, err := NewMTBAligner().Offsets(context.Background(), b.Frames)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := range b.Frames {
+		want := b.Offset(i, 1)
+		if d := offsets[i].Sub(want); max(abs(d.X), abs(d.Y)) > 1 {
+			t.Errorf("Image %d: expected offset %v within a pixel, got %v", i, want, offsets[i])
+		}
+	}
+}
+
+// abs returns the absolute value of v
+func abs(v int) int {
+	return max(v, -v)
+}
+
+func TestMTBAlignerAlign(t *testing.T) {
+	ref := shiftedExposure(96, 64, image.Point{}, 1)
+	moved := shiftedExposure(96, 64, image.Pt(4, -2), 1)
+
+	aligned, err := NewMTBAligner().Align([]image.Image{moved, ref})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if aligned[1] != ref {
+		t.Error("Expected the reference image to be returned unchanged")
+	}
+
+	// Away from the repeated edges the aligned image matches the reference
+	got := aligned[0].(hdr.Image)
+	for y := 8; y < 56; y++ {
+		for x := 8; x < 88; x++ {
+			g, _, _, _ := got.HDRAt(x, y).HDRRGBA()
+			w, _, _, _ := ref.HDRAt(x, y).HDRRGBA()
+			if math.Abs(g-w) > 1e-6 {
+				t.Fatalf("Pixel (%d, %d): expected %f, got %f", x, y, w, g)
+			}
+		}
+	}
+}
+
+func TestMTBAlignerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		images []image.Image
+	}{
+		{"single image", []image.Image{createTestImage(32, 32)}},
+		{"different sizes", []image.Image{createTestImage(32, 32), createTestImage(16, 16)}},
+		{"nil image", []image.Image{createTestImage(32, 32), nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMTBAligner().Align(tt.images); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 1))
+	img.Pix = []uint8{10, 20, 30}
+
+	got := Translate(img, image.Pt(1, 0))
+	for x, want := range []uint32{20, 30, 30} {
+		if r, _, _, _ := got.At(x, 0).RGBA(); r>>8 != want {
+			t.Errorf("Pixel %d: expected %d, got %d", x, want, r>>8)
+		}
+	}
+	if Translate(img, image.Point{}) != image.Image(img) {
+		t.Error("Expected a zero offset to return the image unchanged")
+	}
+}
@@ -0,0 +1,219 @@
+// Package analysis measures the luminance of exposures and radiance maps
+// before choosing pipeline settings: histograms, clipped highlights and
+// crushed shadows, dynamic range, and how much each frame of a bracket
+// contributes to the merge.
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// Clipping thresholds of 8-bit and 16-bit images, as fractions of full scale
+const (
+	ClipShadow    = 1.0 / 255
+	ClipHighlight = 254.0 / 255
+)
+
+// Histogram bins of display images, over values in [0, 1], and of radiance
+// maps, over EV
+const (
+	valueBins = 256
+	evBins    = 64
+)
+
+// MinShare is the fraction of pixels a frame must expose best for
+// Contributions to recommend merging it
+const MinShare = 0.01
+
+// Stats summarizes the luminance of an image
+type Stats struct {
+	// Radiance reports a radiance map rather than a display image
+	Radiance bool `json:"radiance"`
+	// Min is the smallest positive luminance, Max the largest and Mean
+	// the average over every pixel
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+	// Stops is the dynamic range between Min and Max in EV
+	Stops float64 `json:"stops"`
+	// Shadows and Highlights are the fractions of pixels of display images
+	// crushed to black (every channel) or clipped to white (any channel)
+	Shadows    float64   `json:"crushed_shadows"`
+	Highlights float64   `json:"clipped_highlights"`
+	Histogram  Histogram `json:"histogram"`
+}
+
+// Histogram counts the pixels of an image by luminance
+type Histogram struct {
+	// Log reports bins spread evenly over log2 luminance, as for radiance
+	// maps, rather than over values in [0, 1]
+	Log bool `json:"log"`
+	// Min and Max are the bounds of the bins, in EV when Log is set.
+	// Black pixels are not counted in log histograms.
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Counts []int   `json:"counts"`
+}
+
+// Analyze measures the luminance of img. Radiance maps (hdr.Image) get a
+// log histogram; display images a histogram of their values and clipping
+// counts.
+func Analyze(img image.Image) Stats {
+	m, radiance := pixels(img)
+	lums := make([]float64, 0, len(m.Pix)/3)
+	s := Stats{Radiance: radiance, Min: math.Inf(1)}
+	var sum float64
+	var shadows, highlights int
+
+	for i := 0; i < len(m.Pix); i += 3 {
+		r, g, b := float64(m.Pix[i]), float64(m.Pix[i+1]), float64(m.Pix[i+2])
+		l := luminance(r, g, b)
+		lums = append(lums, l)
+		sum += l
+		s.Max = max(s.Max, l)
+		if l > 0 {
+			s.Min = min(s.Min, l)
+		}
+		if !radiance {
+			if max(r, g, b) <= ClipShadow {
+				shadows++
+			}
+			if max(r, g, b) >= ClipHighlight {
+				highlights++
+			}
+		}
+	}
+
+	if math.IsInf(s.Min, 1) {
+		s.Min = 0
+	}
+	if s.Min > 0 {
+		s.Stops = math.Log2(s.Max / s.Min)
+	}
+	if n := float64(len(lums)); n > 0 {
+		s.Mean = sum / n
+		s.Shadows = float64(shadows) / n
+		s.Highlights = float64(highlights) / n
+	}
+	s.Histogram = histogram(lums, radiance, s.Min, s.Max)
+	return s
+}
+
+// histogram bins the luminance values, over EV between lo and hi for
+// radiance maps
+func histogram(lums []float64, radiance bool, lo, hi float64) Histogram {
+	if !radiance {
+		h := Histogram{Min: 0, Max: 1, Counts: make([]int, valueBins)}
+		for _, l := range lums {
+			h.Counts[min(int(max(l, 0)*valueBins), valueBins-1)]++
+		}
+		return h
+	}
+
+	h := Histogram{Log: true, Counts: make([]int, evBins)}
+	if lo <= 0 {
+		return h
+	}
+	h.Min, h.Max = math.Log2(lo), math.Log2(hi)
+	span := max(h.Max-h.Min, 1e-9)
+	for _, l := range lums {
+		if l > 0 {
+			h.Counts[min(int((math.Log2(l)-h.Min)/span*evBins), evBins-1)]++
+		}
+	}
+	return h
+}
+
+// Contribution tells how much a frame of a bracket adds to the merge
+type Contribution struct {
+	// Share is the fraction of pixels the frame exposes best: closest to
+	// the middle of its range without clipping
+	Share float64 `json:"share"`
+	// Contributes recommends merging the frame: it exposes at least
+	// MinShare of the pixels best
+	Contributes bool `json:"contributes"`
+}
+
+// Contributions finds which frames of an aligned bracket expose each pixel
+// best. The frames are display images, or HDR images with channels in
+// [0, 1] like those the processor loads. Pixels clipped or crushed in every
+// frame count for none, and of frames exposing a pixel equally well, the
+// first counts.
+func Contributions(frames []image.Image) ([]Contribution, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("no frames")
+	}
+	b := frames[0].Bounds()
+	for i, f := range frames {
+		if f.Bounds().Size() != b.Size() {
+			return nil, fmt.Errorf("frame %d is %v, not %v like the first", i+1, f.Bounds().Size(), b.Size())
+		}
+	}
+
+	values := make([]*hdr.RGB, len(frames))
+	for i, f := range frames {
+		values[i], _ = pixels(f)
+	}
+	counts := make([]int, len(frames))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			best, weight := -1, 0.0
+			for i, v := range values {
+				j := v.PixOffset(v.Rect.Min.X+x, v.Rect.Min.Y+y)
+				r, g, bl := float64(v.Pix[j]), float64(v.Pix[j+1]), float64(v.Pix[j+2])
+				if max(r, g, bl) >= ClipHighlight || max(r, g, bl) <= ClipShadow {
+					continue
+				}
+				// Mid-tones weigh most, falling off steeply toward the extremes
+				t := 2*luminance(r, g, bl) - 1
+				if w := 1 - math.Pow(t, 12); w > weight {
+					best, weight = i, w
+				}
+			}
+			if best >= 0 {
+				counts[best]++
+			}
+		}
+	}
+
+	n := float64(b.Dx() * b.Dy())
+	contributions := make([]Contribution, len(frames))
+	for i, c := range counts {
+		share := float64(c) / n
+		contributions[i] = Contribution{Share: share, Contributes: share >= MinShare}
+	}
+	return contributions, nil
+}
+
+// pixels returns the RGB buffer of img, with the linear values of radiance
+// maps or the values in [0, 1] of display images, and whether img is a
+// radiance map
+func pixels(img image.Image) (*hdr.RGB, bool) {
+	switch m := img.(type) {
+	case *hdr.RGB:
+		return m, true
+	case hdr.Image:
+		b := m.Bounds()
+		out := hdr.NewRGB(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := m.HDRAt(x, y).HDRRGBA()
+				out.SetRGB(x, y, hdrcolor.RGB{R: r, G: g, B: bl})
+			}
+		}
+		return out, true
+	}
+	return imaging.ConvertToHDR(img), false
+}
+
+// luminance returns the Rec. 709 luminance of a color
+func luminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/internal/synthetic"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// grays returns a 2x2 display image of the given gray levels
+func grays(levels ...uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i, v := range levels {
+		img.SetRGBA(i%2, i/2, color.RGBA{v, v, v, 0xff})
+	}
+	return img
+}
+
+func TestAnalyzeDisplayImage(t *testing.T) {
+	s := Analyze(grays(0, 0x40, 0x80, 0xff))
+
+	if s.Radiance || s.Histogram.Log {
+		t.Error("Expected a display image with a linear histogram")
+	}
+	if math.Abs(s.Min-0x40/255.0) > 1e-6 || math.Abs(s.Max-1) > 1e-6 {
+		t.Errorf("Expected luminance range [%f, 1], got [%f, %f]", 0x40/255.0, s.Min, s.Max)
+	}
+	if want := (0x40 + 0x80 + 0xff) / 255.0 / 4; math.Abs(s.Mean-want) > 1e-6 {
+		t.Errorf("Expected mean %f, got %f", want, s.Mean)
+	}
+	if want := math.Log2(255.0 / 0x40); math.Abs(s.Stops-want) > 1e-6 {
+		t.Errorf("Expected %f stops, got %f", want, s.Stops)
+	}
+	if s.Shadows != 0.25 || s.Highlights != 0.25 {
+		t.Errorf("Expected 25%% crushed shadows and clipped highlights, got %f and %f", s.Shadows, s.Highlights)
+	}
+
+	h := s.Histogram
+	if len(h.Counts) != valueBins || h.Min != 0 || h.Max != 1 {
+		t.Fatalf("Expected %d bins over [0, 1], got %d over [%f, %f]", valueBins, len(h.Counts), h.Min, h.Max)
+	}
+	for _, bin := range []int{0, 0x40, 0x80, 0xff} {
+		if h.Counts[bin] != 1 {
+			t.Errorf("Expected one pixel in bin %d, got %d", bin, h.Counts[bin])
+		}
+	}
+}
+
+func TestAnalyzeRadianceMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels []float64
+		stops  float64
+	}{
+		{"eight stops", []float64{0, 0.01, 0.1, 2.56}, 8},
+		{"flat", []float64{0.5, 0.5, 0.5, 0.5}, 0},
+		{"black", []float64{0, 0, 0, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := hdr.NewRGB(image.Rect(0, 0, 2, 2))
+			for i, v := range tt.levels {
+				m.SetRGB(i%2, i/2, hdrcolor.RGB{R: v, G: v, B: v})
+			}
+			s := Analyze(m)
+			if !s.Radiance || !s.Histogram.Log {
+				t.Error("Expected a radiance map with a log histogram")
+			}
+			if math.Abs(s.Stops-tt.stops) > 1e-6 {
+				t.Errorf("Expected %v stops, got %f", tt.stops, s.Stops)
+			}
+			if s.Shadows != 0 || s.Highlights != 0 {
+				t.Errorf("Expected no clipping counts for radiance maps, got %f and %f", s.Shadows, s.Highlights)
+			}
+
+			// Black pixels fall outside log histograms
+			positive := 0
+			for _, v := range tt.levels {
+				if v > 0 {
+					positive++
+				}
+			}
+			total := 0
+			for _, c := range s.Histogram.Counts {
+				total += c
+			}
+			if total != positive {
+				t.Errorf("Expected %d pixels in the histogram, got %d", positive, total)
+			}
+		})
+	}
+}
+
+func TestContributions(t *testing.T) {
+	// The window needs the short exposure and the walls the middle one; the
+	// long exposure is clipped everywhere and the last frame repeats the
+	// middle one
+	b, err := synthetic.Camera{Exposures: []float64{0.01, 1, 1000}}.Shoot(synthetic.Window(48, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames loaded by the processor are HDR images with channels in [0, 1]
+	frames := append(b.Frames, imaging.ConvertToHDR(b.Frames[1]))
+
+	got, err := Contributions(frames)
+	if err != nil {
+		t.Fatalf("Contributions failed: %v", err)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("Expected %d contributions, got %d", len(frames), len(got))
+	}
+	for i, want := range []bool{true, true, false, false} {
+		if got[i].Contributes != want {
+			t.Errorf("Frame %d: expected contributes %v, got %v (share %.3f)", i+1, want, got[i].Contributes, got[i].Share)
+		}
+	}
+	if got[3].Share != 0 {
+		t.Errorf("Expected the duplicate frame to expose no pixel best, got %.3f", got[3].Share)
+	}
+	sum := 0.0
+	for _, c := range got {
+		sum += c.Share
+	}
+	if sum > 1+1e-9 {
+		t.Errorf("Expected shares to sum to at most 1, got %f", sum)
+	}
+}
+
+func TestContributionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []image.Image
+	}{
+		{"no frames", nil},
+		{"sizes differ", []image.Image{grays(), image.NewRGBA(image.Rect(0, 0, 3, 2))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Contributions(tt.frames); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package analysis
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// Chart colors
+var (
+	chartBackground = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	chartBar        = color.RGBA{0xd0, 0xd0, 0xd0, 0xff}
+	// chartClipped marks the columns of clipped shadows and highlights
+	chartClipped = color.RGBA{0xe0, 0x40, 0x30, 0xff}
+)
+
+// sparks are the bar characters of Sparkline, from empty to full
+var sparks = []rune(" ▁▂▃▄▅▆▇█")
+
+// Chart draws the histogram as a bar chart of the given size. The darkest
+// and brightest columns of display image histograms, where clipped pixels
+// fall, are red.
+func (h Histogram) Chart(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+	counts := h.resample(width)
+	peak := 0
+	for _, c := range counts {
+		peak = max(peak, c)
+	}
+	if peak == 0 {
+		return img
+	}
+	for x, c := range counts {
+		bar := chartBar
+		if !h.Log && (x == 0 || x == width-1) {
+			bar = chartClipped
+		}
+		top := height - (c*height+peak-1)/peak
+		for y := top; y < height; y++ {
+			img.SetRGBA(x, y, bar)
+		}
+	}
+	return img
+}
+
+// Sparkline draws the histogram as a line of width bar characters
+func (h Histogram) Sparkline(width int) string {
+	counts := h.resample(width)
+	peak := 0
+	for _, c := range counts {
+		peak = max(peak, c)
+	}
+	var sb strings.Builder
+	for _, c := range counts {
+		level := 0
+		if peak > 0 {
+			level = (c*(len(sparks)-1) + peak - 1) / peak
+		}
+		sb.WriteRune(sparks[level])
+	}
+	return sb.String()
+}
+
+// resample sums or spreads the bins over n columns
+func (h Histogram) resample(n int) []int {
+	out := make([]int, n)
+	bins := len(h.Counts)
+	if bins == 0 || n <= 0 {
+		return out
+	}
+	if bins >= n {
+		for i, c := range h.Counts {
+			out[i*n/bins] += c
+		}
+		return out
+	}
+	for x := range out {
+		out[x] = h.Counts[x*bins/n]
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package analysis
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChart(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       Histogram
+		clipped bool
+	}{
+		{"values", Histogram{Max: 1, Counts: []int{4, 0, 2, 1}}, true},
+		{"log", Histogram{Log: true, Min: -4, Max: 4, Counts: []int{4, 0, 2, 1}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := tt.h.Chart(8, 10)
+			if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 10 {
+				t.Fatalf("Expected an 8x10 chart, got %v", b)
+			}
+			// The fullest bin reaches the top; the empty bin stays background
+			if got := img.RGBAAt(1, 0); got == chartBackground {
+				t.Error("Expected the fullest bin to fill its column")
+			}
+			if got := img.RGBAAt(2, 9); got != chartBackground {
+				t.Errorf("Expected the empty bin to be background, got %v", got)
+			}
+			if got := img.RGBAAt(4, 6); got != chartBar {
+				t.Errorf("Expected a half-full bin to be drawn, got %v", got)
+			}
+			if got := img.RGBAAt(0, 9) == chartClipped; got != tt.clipped {
+				t.Errorf("Expected clipped column %v, got %v", tt.clipped, got)
+			}
+		})
+	}
+}
+
+func TestChartEmpty(t *testing.T) {
+	img := Histogram{Counts: make([]int, 4)}.Chart(4, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if img.RGBAAt(x, y) != chartBackground {
+				t.Fatalf("Expected an empty chart, got %v at %d,%d", img.RGBAAt(x, y), x, y)
+			}
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int
+		width  int
+		want   string
+	}{
+		{"one per bin", []int{0, 1, 8, 4}, 4, " ▁█▄"},
+		{"summed", []int{1, 1, 0, 4}, 2, "▄█"},
+		{"spread", []int{0, 2}, 4, "  ██"},
+		{"empty", []int{0, 0}, 3, "   "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Histogram{Counts: tt.counts}.Sparkline(tt.width)
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if n := utf8.RuneCountInString(got); n != tt.width {
+				t.Errorf("Expected %d characters, got %d", tt.width, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package colorspace
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Adaptations lists the chromatic adaptation transforms of WhiteBalance
+var Adaptations = []string{"bradford", "cat02"}
+
+// cat02Cone is the CIECAM02 matrix from XYZ to sharpened cone responses
+var cat02Cone = mat3{
+	{0.7328, 0.4296, -0.1624},
+	{-0.7036, 1.6975, 0.0061},
+	{0.0030, 0.0136, 0.9834},
+}
+
+// Range of color temperatures of Planckian, in kelvins
+const (
+	MinTemperature = 1667
+	MaxTemperature = 25000
+)
+
+// adapt returns the von Kries matrix adapting XYZ colors seen under white
+// point from to white point to, scaling the responses of the cone matrix
+func adapt(cone mat3, from, to vec3) mat3 {
+	src, dst := cone.apply(from), cone.apply(to)
+	var scale mat3
+	for i := range 3 {
+		scale[i][i] = dst[i] / src[i]
+	}
+	return cone.inverse().mul(scale).mul(cone)
+}
+
+// Planckian returns the chromaticity of a light of color temperature kelvin,
+// between MinTemperature and MaxTemperature. Tint moves it off the
+// Planckian locus on the scale of common raw converters: one unit is
+// 1/3000 of the CIE 1960 uv distance, and positive values are greener.
+func Planckian(kelvin, tint float64) (x, y float64) {
+	kelvin = min(max(kelvin, MinTemperature), MaxTemperature)
+	u, v := planckianUV(kelvin)
+	if tint == 0 {
+		return uvToXY(u, v)
+	}
+	// The normal to the locus, pointing towards green
+	u0, v0 := planckianUV(max(kelvin-1, MinTemperature))
+	u1, v1 := planckianUV(min(kelvin+1, MaxTemperature))
+	nu, nv := v0-v1, u1-u0
+	if nv < 0 {
+		nu, nv = -nu, -nv
+	}
+	d := tint / 3000 / math.Hypot(nu, nv)
+	return uvToXY(u+nu*d, v+nv*d)
+}
+
+// planckianUV returns the CIE 1960 uv chromaticity of a black body, with the
+// cubic approximation of Kim et al.
+func planckianUV(t float64) (u, v float64) {
+	var x float64
+	if t <= 4000 {
+		x = -0.2661239e9/(t*t*t) - 0.2343589e6/(t*t) + 0.8776956e3/t + 0.179910
+	} else {
+		x = -3.0258469e9/(t*t*t) + 2.1070379e6/(t*t) + 0.2226347e3/t + 0.240390
+	}
+	var y float64
+	switch {
+	case t <= 2222:
+		y = -1.1063814*x*x*x - 1.34811020*x*x + 2.18555832*x - 0.20219683
+	case t <= 4000:
+		y = -0.9549476*x*x*x - 1.37418593*x*x + 2.09137015*x - 0.16748867
+	default:
+		y = 3.0817580*x*x*x - 5.87338670*x*x + 3.75112997*x - 0.37001483
+	}
+	d := -2*x + 12*y + 3
+	return 4 * x / d, 6 * y / d
+}
+
+// uvToXY converts a CIE 1960 uv chromaticity to xy
+func uvToXY(u, v float64) (x, y float64) {
+	d := 2*u - 8*v + 4
+	return 3 * u / d, 2 * v / d
+}
+
+// toXYZ returns the matrix from linear RGB of s to XYZ under its own white
+func (s *Space) toXYZ() mat3 {
+	return bradford(pcsWhite, s.white).mul(s.toPCS)
+}
+
+// Chromaticity returns the xy chromaticity of a linear color of s. It
+// returns zeros for black.
+func (s *Space) Chromaticity(r, g, b float64) (x, y float64) {
+	c := s.toXYZ().apply(vec3{r, g, b})
+	sum := c[0] + c[1] + c[2]
+	if sum <= 0 {
+		return 0, 0
+	}
+	return c[0] / sum, c[1] / sum
+}
+
+// WhiteBalance returns the transform of s that makes colors lit by a light
+// of chromaticity x, y look as if lit by the white point of s, with one of
+// Adaptations. It keeps the luminance of neutral colors.
+func (s *Space) WhiteBalance(x, y float64, adaptation string) (*Transform, error) {
+	var cone mat3
+	switch adaptation {
+	case "bradford":
+		cone = bradfordCone
+	case "cat02":
+		cone = cat02Cone
+	default:
+		return nil, fmt.Errorf("unknown chromatic adaptation %q (%s)", adaptation, strings.Join(Adaptations, ", "))
+	}
+	if x <= 0 || y <= 0 || x+y >= 1 {
+		return nil, fmt.Errorf("invalid illuminant chromaticity (%g, %g)", x, y)
+	}
+	m := s.toXYZ()
+	return &Transform{
+		src: s,
+		dst: s,
+		m:   m.inverse().mul(adapt(cone, xy{x, y}.xyz(), s.white)).mul(m),
+	}, nil
+}
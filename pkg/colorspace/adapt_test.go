@@ -0,0 +1,99 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlanckian(t *testing.T) {
+	tests := []struct {
+		kelvin, x, y float64
+	}{
+		{2856, 0.4476, 0.4074},
+		{5000, 0.3451, 0.3516},
+		{6504, 0.3135, 0.3236},
+		{100, 0.5646, 0.4029}, // clamped to MinTemperature
+	}
+
+	for _, tt := range tests {
+		x, y := Planckian(tt.kelvin, 0)
+		if math.Abs(x-tt.x) > 2e-3 || math.Abs(y-tt.y) > 2e-3 {
+			t.Errorf("Planckian(%v, 0) = (%.4f, %.4f), want (%.4f, %.4f)", tt.kelvin, x, y, tt.x, tt.y)
+		}
+	}
+}
+
+func TestPlanckianTint(t *testing.T) {
+	x0, y0 := Planckian(5000, 0)
+	x1, y1 := Planckian(5000, 30)
+	if y1 <= y0 {
+		t.Errorf("Expected a positive tint to be greener, got y %.4f from %.4f", y1, y0)
+	}
+	u0, v0 := xyToUV(x0, y0)
+	u1, v1 := xyToUV(x1, y1)
+	if d := math.Hypot(u1-u0, v1-v0); math.Abs(d-0.01) > 1e-6 {
+		t.Errorf("Expected a uv distance of 0.01, got %v", d)
+	}
+}
+
+// xyToUV converts a chromaticity to CIE 1960 uv
+func xyToUV(x, y float64) (u, v float64) {
+	d := -2*x + 12*y + 3
+	return 4 * x / d, 6 * y / d
+}
+
+func TestWhiteBalance(t *testing.T) {
+	// A gray surface under a warm light
+	light := [3]float64{1, 0.8, 0.5}
+	for _, s := range builtin {
+		for _, adaptation := range Adaptations {
+			x, y := s.Chromaticity(light[0], light[1], light[2])
+			wb, err := s.WhiteBalance(x, y, adaptation)
+			if err != nil {
+				t.Fatalf("%s, %s: unexpected error: %v", s, adaptation, err)
+			}
+			r, g, b := wb.ConvertLinear(light[0]/2, light[1]/2, light[2]/2)
+			if math.Abs(r-g) > 1e-6 || math.Abs(g-b) > 1e-6 {
+				t.Errorf("%s, %s: expected a neutral color, got (%.4f, %.4f, %.4f)", s, adaptation, r, g, b)
+			}
+			m := s.toXYZ()
+			want := m.apply(vec3{light[0] / 2, light[1] / 2, light[2] / 2})[1]
+			if got := m.apply(vec3{r, g, b})[1]; math.Abs(got-want) > 1e-6 {
+				t.Errorf("%s, %s: expected luminance %.4f, got %.4f", s, adaptation, want, got)
+			}
+		}
+	}
+
+	// The white point of the space leaves colors unchanged
+	x, y := SRGB.Chromaticity(1, 1, 1)
+	if math.Abs(x-d65.x) > 1e-4 || math.Abs(y-d65.y) > 1e-4 {
+		t.Errorf("Expected the chromaticity of sRGB white to be D65, got (%.4f, %.4f)", x, y)
+	}
+	wb, err := SRGB.WhiteBalance(x, y, "cat02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b := wb.ConvertLinear(0.2, 0.5, 0.9); math.Abs(r-0.2)+math.Abs(g-0.5)+math.Abs(b-0.9) > 1e-6 {
+		t.Errorf("Expected colors under the white point to be unchanged, got (%.4f, %.4f, %.4f)", r, g, b)
+	}
+}
+
+func TestWhiteBalanceErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		x, y       float64
+		adaptation string
+	}{
+		{"unknown adaptation", 0.3, 0.3, "von-kries"},
+		{"black", 0, 0, "bradford"},
+		{"outside the diagram", 0.6, 0.5, "bradford"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SRGB.WhiteBalance(tt.x, tt.y, tt.adaptation); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Package colorspace describes RGB color spaces by their primaries, white
+// point and transfer curve, converts colors between them, and reads and
+// writes the ICC profiles that tag images with them.
+//
+// Conversions go through the D50 XYZ connection space of ICC profiles, with
+// white points adapted by the Bradford transform, so white maps to white
+// (the relative colorimetric intent). Colors outside the destination gamut
+// are clipped. WhiteBalance uses the same adaptation, or CAT02, to correct
+// colors lit by other illuminants.
+package colorspace
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Space is an RGB color space
+type Space struct {
+	// Name identifies a built-in space (see Names). It is empty for spaces
+	// read from other ICC profiles.
+	Name string
+	// Description is the human-readable name embedded in ICC profiles
+	Description string
+	// toPCS converts linear RGB to XYZ adapted to the D50 white point
+	toPCS mat3
+	// white is the XYZ of the white point before adaptation
+	white vec3
+	curve Curve
+}
+
+// xy is a CIE 1931 chromaticity
+type xy struct{ x, y float64 }
+
+// d65 and d60 are the white points of the built-in spaces
+var (
+	d65 = xy{0.3127, 0.3290}
+	d60 = xy{0.32168, 0.33767}
+)
+
+// pcsWhite is the D50 white point of the ICC connection space
+var pcsWhite = vec3{0.9642, 1, 0.8249}
+
+// Built-in color spaces
+var (
+	// SRGB is sRGB (IEC 61966-2-1): Rec.709 primaries, D65 white and the
+	// sRGB curve. It is assumed for untagged images.
+	SRGB = newSpace("srgb", "sRGB", xy{0.64, 0.33}, xy{0.30, 0.60}, xy{0.15, 0.06}, d65, SRGBCurve)
+	// DisplayP3 has the DCI-P3 primaries with D65 white and the sRGB curve
+	DisplayP3 = newSpace("display-p3", "Display P3", xy{0.680, 0.320}, xy{0.265, 0.690}, xy{0.150, 0.060}, d65, SRGBCurve)
+	// Rec2020 has the ITU-R BT.2020 primaries and the BT.709 curve
+	Rec2020 = newSpace("rec2020", "Rec. ITU-R BT.2020", xy{0.708, 0.292}, xy{0.170, 0.797}, xy{0.131, 0.046}, d65, Rec709Curve)
+	// ACEScg has the ACES AP1 primaries with the ACES white and is linear
+	ACEScg = newSpace("acescg", "ACEScg", xy{0.713, 0.293}, xy{0.165, 0.830}, xy{0.128, 0.044}, d60, LinearCurve)
+	// AdobeRGB is Adobe RGB (1998), common on cameras
+	AdobeRGB = newSpace("adobe-rgb", "Adobe RGB (1998)", xy{0.64, 0.33}, xy{0.21, 0.71}, xy{0.15, 0.06}, d65, Curve{G: 563.0 / 256, A: 1})
+)
+
+// builtin lists the built-in spaces in the order of Names
+var builtin = []*Space{SRGB, DisplayP3, Rec2020, ACEScg, AdobeRGB}
+
+// Names lists the built-in color spaces
+var Names = []string{"srgb", "display-p3", "rec2020", "acescg", "adobe-rgb"}
+
+// Lookup returns the built-in space with the given name
+func Lookup(name string) (*Space, error) {
+	for _, s := range builtin {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown color space %q (%s)", name, strings.Join(Names, ", "))
+}
+
+// newSpace builds a space from the chromaticities of its primaries and white
+// point
+func newSpace(name, description string, r, g, b, white xy, curve Curve) *Space {
+	// The columns of the RGB to XYZ matrix are the XYZ of the primaries,
+	// scaled so that RGB white maps to the white point
+	p := mat3{
+		{r.x / r.y, g.x / g.y, b.x / b.y},
+		{1, 1, 1},
+		{(1 - r.x - r.y) / r.y, (1 - g.x - g.y) / g.y, (1 - b.x - b.y) / b.y},
+	}
+	w := white.xyz()
+	s := p.inverse().apply(w)
+	for i := range p {
+		for j := range p[i] {
+			p[i][j] *= s[j]
+		}
+	}
+	return &Space{
+		Name:        name,
+		Description: description,
+		toPCS:       bradford(w, pcsWhite).mul(p),
+		white:       w,
+		curve:       curve,
+	}
+}
+
+// String returns the name of s, or its description for spaces without one
+func (s *Space) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Description
+}
+
+// Curve returns the transfer curve of s
+func (s *Space) Curve() Curve {
+	return s.curve
+}
+
+// WithCurve returns a space with the primaries and white point of s and the
+// transfer curve c. It has no name.
+func (s *Space) WithCurve(c Curve) *Space {
+	w := *s
+	w.Name = ""
+	w.curve = c
+	return &w
+}
+
+// Equal reports whether s and o convert colors the same way
+func (s *Space) Equal(o *Space) bool {
+	if s == o {
+		return true
+	}
+	for i := range s.toPCS {
+		for j := range s.toPCS[i] {
+			if math.Abs(s.toPCS[i][j]-o.toPCS[i][j]) > 2e-3 {
+				return false
+			}
+		}
+	}
+	return s.curve.equal(o.curve)
+}
+
+// xyz returns the XYZ of the chromaticity at Y = 1
+func (c xy) xyz() vec3 {
+	return vec3{c.x / c.y, 1, (1 - c.x - c.y) / c.y}
+}
+
+// bradfordCone is the Bradford matrix from XYZ to cone responses
+var bradfordCone = mat3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// bradford returns the matrix adapting XYZ colors seen under white point
+// from to white point to
+func bradford(from, to vec3) mat3 {
+	return adapt(bradfordCone, from, to)
+}
+
+// vec3 is a color or white point with three components
+type vec3 [3]float64
+
+// mat3 is a 3x3 matrix, indexed by row then column
+type mat3 [3][3]float64
+
+// apply returns m × v
+func (m mat3) apply(v vec3) vec3 {
+	return vec3{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+// mul returns m × o
+func (m mat3) mul(o mat3) mat3 {
+	var r mat3
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				r[i][j] += m[i][k] * o[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// inverse returns the inverse of m, which must not be singular
+func (m mat3) inverse() mat3 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return mat3{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}
+}
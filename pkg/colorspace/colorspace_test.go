@@ -0,0 +1,64 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, name := range Names {
+		s, err := Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", name, err)
+		}
+		if s.Name != name || s.String() != name {
+			t.Errorf("Lookup(%q) returned %q", name, s.Name)
+		}
+	}
+	if _, err := Lookup("cmyk"); err == nil {
+		t.Error("Expected an error for an unknown space")
+	}
+}
+
+func TestSpaceWhite(t *testing.T) {
+	// RGB white of every space maps to the D50 white of the connection space
+	for _, s := range builtin {
+		w := s.toPCS.apply(vec3{1, 1, 1})
+		for i := range w {
+			if math.Abs(w[i]-pcsWhite[i]) > 1e-4 {
+				t.Errorf("%s: white maps to %v, want %v", s.Name, w, pcsWhite)
+				break
+			}
+		}
+	}
+}
+
+func TestSRGBColorants(t *testing.T) {
+	// The D50-adapted colorants of the sRGB profiles in common use
+	want := mat3{
+		{0.4361, 0.3851, 0.1431},
+		{0.2225, 0.7169, 0.0606},
+		{0.0139, 0.0971, 0.7141},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(SRGB.toPCS[i][j]-want[i][j]) > 1e-3 {
+				t.Fatalf("Expected colorants %v, got %v", want, SRGB.toPCS)
+			}
+		}
+	}
+}
+
+func TestSpaceEqual(t *testing.T) {
+	if !SRGB.Equal(SRGB) || SRGB.Equal(DisplayP3) || SRGB.Equal(AdobeRGB) {
+		t.Error("Expected spaces to equal only themselves")
+	}
+	// Same primaries, different curve
+	linear := SRGB.WithCurve(LinearCurve)
+	if SRGB.Equal(linear) || linear.Name != "" {
+		t.Errorf("Expected a new unnamed space with a different curve, got %q", linear.Name)
+	}
+	if !SRGB.Equal(linear.WithCurve(SRGBCurve)) {
+		t.Error("Expected the sRGB curve to give sRGB back")
+	}
+}
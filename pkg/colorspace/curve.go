@@ -0,0 +1,111 @@
+package colorspace
+
+import (
+	"math"
+	"slices"
+)
+
+// Curve is a transfer curve mapping encoded values to linear light, in the
+// parametric form of ICC profiles:
+//
+//	linear = (A·v + B)^G  for v ≥ D
+//	linear = C·v          for v < D
+//
+// Curves read from profiles as a table of samples interpolate the table
+// instead.
+type Curve struct {
+	G, A, B, C, D float64
+	// table holds evenly spaced samples of the curve over [0, 1]
+	table []float64
+}
+
+// Built-in curves
+var (
+	// LinearCurve leaves values unchanged
+	LinearCurve = Curve{G: 1, A: 1}
+	// SRGBCurve is the sRGB curve of IEC 61966-2-1
+	SRGBCurve = Curve{G: 2.4, A: 1 / 1.055, B: 0.055 / 1.055, C: 1 / 12.92, D: 0.04045}
+	// Rec709Curve is the inverse of the ITU-R BT.709 camera curve, also used
+	// by BT.2020
+	Rec709Curve = Curve{G: 1 / 0.45, A: 1 / 1.099, B: 0.099 / 1.099, C: 1 / 4.5, D: 0.081}
+)
+
+// Decode maps an encoded value to linear light. Negative values are
+// mirrored.
+func (c Curve) Decode(v float64) float64 {
+	if v < 0 {
+		return -c.Decode(-v)
+	}
+	if c.table != nil {
+		return interpolate(c.table, min(v, 1))
+	}
+	if v < c.D {
+		return c.C * v
+	}
+	return math.Pow(c.A*v+c.B, c.G)
+}
+
+// Encode maps linear light to an encoded value; it is the inverse of
+// Decode. Negative values are mirrored.
+func (c Curve) Encode(l float64) float64 {
+	if l < 0 {
+		return -c.Encode(-l)
+	}
+	if c.table != nil {
+		return invert(c.table, l)
+	}
+	if l < c.C*c.D {
+		return l / c.C
+	}
+	return (math.Pow(l, 1/c.G) - c.B) / c.A
+}
+
+// DecodeTable returns the decoded value of every 16-bit encoded value, to
+// decode whole images without evaluating the curve per channel
+func (c Curve) DecodeTable() []float32 {
+	table := make([]float32, 0x10000)
+	for i := range table {
+		table[i] = float32(c.Decode(float64(i) / 0xffff))
+	}
+	return table
+}
+
+// equal reports whether c and o map values alike
+func (c Curve) equal(o Curve) bool {
+	for i := 0; i <= 16; i++ {
+		v := float64(i) / 16
+		if math.Abs(c.Decode(v)-o.Decode(v)) > 2e-3 {
+			return false
+		}
+	}
+	return true
+}
+
+// interpolate returns the value at v in [0, 1] of the evenly spaced samples
+func interpolate(table []float64, v float64) float64 {
+	if len(table) == 1 {
+		return table[0]
+	}
+	pos := v * float64(len(table)-1)
+	i := min(int(pos), len(table)-2)
+	f := pos - float64(i)
+	return table[i]*(1-f) + table[i+1]*f
+}
+
+// invert returns the position in [0, 1] where the increasing samples reach l
+func invert(table []float64, l float64) float64 {
+	n := len(table)
+	if n < 2 || l <= table[0] {
+		return 0
+	}
+	if l >= table[n-1] {
+		return 1
+	}
+	i, _ := slices.BinarySearch(table, l)
+	lo, hi := table[i-1], table[i]
+	f := 0.0
+	if hi > lo {
+		f = (l - lo) / (hi - lo)
+	}
+	return (float64(i-1) + f) / float64(n-1)
+}
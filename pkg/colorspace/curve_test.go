@@ -0,0 +1,59 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+// sampled returns c as a table of n samples
+func sampled(c Curve, n int) Curve {
+	table := make([]float64, n)
+	for i := range table {
+		table[i] = c.Decode(float64(i) / float64(n-1))
+	}
+	return Curve{table: table}
+}
+
+func TestCurveRoundTrip(t *testing.T) {
+	curves := map[string]Curve{
+		"linear":  LinearCurve,
+		"srgb":    SRGBCurve,
+		"rec709":  Rec709Curve,
+		"gamma22": AdobeRGB.Curve(),
+		"table":   sampled(SRGBCurve, 1024),
+	}
+
+	for name, c := range curves {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i <= 100; i++ {
+				v := float64(i) / 100
+				if got := c.Encode(c.Decode(v)); math.Abs(got-v) > 1e-6 {
+					t.Errorf("Encode(Decode(%v)) = %v", v, got)
+				}
+				if d := c.Decode(-v); d != -c.Decode(v) {
+					t.Errorf("Decode(%v) = %v, want the mirror of %v", -v, d, c.Decode(v))
+				}
+			}
+		})
+	}
+}
+
+func TestSRGBCurve(t *testing.T) {
+	tests := []struct {
+		encoded, linear float64
+	}{
+		{0, 0},
+		{0.02, 0.02 / 12.92},
+		{0.5, 0.21404},
+		{1, 1},
+	}
+
+	for _, tt := range tests {
+		if got := SRGBCurve.Decode(tt.encoded); math.Abs(got-tt.linear) > 1e-5 {
+			t.Errorf("Decode(%v) = %v, want %v", tt.encoded, got, tt.linear)
+		}
+	}
+	if !SRGBCurve.equal(sampled(SRGBCurve, 256)) || SRGBCurve.equal(LinearCurve) {
+		t.Error("Expected curves to compare by their values")
+	}
+}
@@ -0,0 +1,326 @@
+package colorspace
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// ErrUnsupportedProfile is returned by ParseICC for profiles that do not
+// describe an RGB space with primaries and transfer curves, such as CMYK,
+// grayscale or lookup-table profiles
+var ErrUnsupportedProfile = errors.New("unsupported ICC profile")
+
+// iccHeaderSize is the size of the ICC profile header, before the tag table
+const iccHeaderSize = 128
+
+// iccTag is a tag of an ICC profile
+type iccTag struct {
+	sig  string
+	data []byte
+}
+
+// ICC returns an ICC v4 display profile describing s, for embedding in
+// images
+func (s *Space) ICC() []byte {
+	trc := s.curve.iccData()
+	white := s.white
+	if white == (vec3{}) {
+		white = pcsWhite
+	}
+	tags := []iccTag{
+		{"desc", iccText(s.Description)},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(pcsWhite)},
+		{"chad", iccMatrix(bradford(white, pcsWhite))},
+		{"rXYZ", iccXYZ(vec3{s.toPCS[0][0], s.toPCS[1][0], s.toPCS[2][0]})},
+		{"gXYZ", iccXYZ(vec3{s.toPCS[0][1], s.toPCS[1][1], s.toPCS[2][1]})},
+		{"bXYZ", iccXYZ(vec3{s.toPCS[0][2], s.toPCS[1][2], s.toPCS[2][2]})},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	// Tags with the same data share it
+	var body bytes.Buffer
+	table := make([]byte, 4+12*len(tags))
+	binary.BigEndian.PutUint32(table, uint32(len(tags)))
+	offsets := map[string]int{}
+	start := iccHeaderSize + len(table)
+	for i, tag := range tags {
+		off, ok := offsets[string(tag.data)]
+		if !ok {
+			off = start + body.Len()
+			offsets[string(tag.data)] = off
+			body.Write(tag.data)
+			for body.Len()%4 != 0 {
+				body.WriteByte(0)
+			}
+		}
+		entry := table[4+12*i:]
+		copy(entry, tag.sig)
+		binary.BigEndian.PutUint32(entry[4:], uint32(off))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tag.data)))
+	}
+
+	header := make([]byte, iccHeaderSize)
+	size := iccHeaderSize + len(table) + body.Len()
+	binary.BigEndian.PutUint32(header[0:], uint32(size))
+	binary.BigEndian.PutUint32(header[8:], 0x04300000)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	// A fixed creation date keeps the output reproducible
+	for i, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], iccXYZ(pcsWhite)[8:])
+
+	profile := make([]byte, 0, size)
+	profile = append(profile, header...)
+	profile = append(profile, table...)
+	profile = append(profile, body.Bytes()...)
+	// The profile ID is the MD5 of the profile with the flags, rendering
+	// intent and ID fields zeroed, which they already are
+	id := md5.Sum(profile)
+	copy(profile[84:], id[:])
+	return profile
+}
+
+// iccData encodes c as a parametric or sampled curve
+func (c Curve) iccData() []byte {
+	if c.table != nil {
+		data := make([]byte, 12+2*len(c.table))
+		copy(data, "curv")
+		binary.BigEndian.PutUint32(data[8:], uint32(len(c.table)))
+		for i, v := range c.table {
+			binary.BigEndian.PutUint16(data[12+2*i:], uint16(math.Round(min(max(v, 0), 1)*0xffff)))
+		}
+		return data
+	}
+
+	params := []float64{c.G}
+	kind := uint16(0)
+	if c.A != 1 || c.B != 0 || c.C != 0 || c.D != 0 {
+		params = []float64{c.G, c.A, c.B, c.C, c.D}
+		kind = 3
+	}
+	data := make([]byte, 12+4*len(params))
+	copy(data, "para")
+	binary.BigEndian.PutUint16(data[8:], kind)
+	for i, v := range params {
+		binary.BigEndian.PutUint32(data[12+4*i:], uint32(s15Fixed16(v)))
+	}
+	return data
+}
+
+// iccText encodes s as a multiLocalizedUnicodeType in US English
+func iccText(s string) []byte {
+	text := utf16.Encode([]rune(s))
+	data := make([]byte, 28+2*len(text))
+	copy(data, "mluc")
+	binary.BigEndian.PutUint32(data[8:], 1)
+	binary.BigEndian.PutUint32(data[12:], 12)
+	copy(data[16:], "enUS")
+	binary.BigEndian.PutUint32(data[20:], uint32(2*len(text)))
+	binary.BigEndian.PutUint32(data[24:], 28)
+	for i, u := range text {
+		binary.BigEndian.PutUint16(data[28+2*i:], u)
+	}
+	return data
+}
+
+// iccXYZ encodes v as an XYZType
+func iccXYZ(v vec3) []byte {
+	data := make([]byte, 20)
+	copy(data, "XYZ ")
+	for i, c := range v {
+		binary.BigEndian.PutUint32(data[8+4*i:], uint32(s15Fixed16(c)))
+	}
+	return data
+}
+
+// iccMatrix encodes m as an s15Fixed16ArrayType, row by row
+func iccMatrix(m mat3) []byte {
+	data := make([]byte, 44)
+	copy(data, "sf32")
+	for i := range 3 {
+		for j := range 3 {
+			binary.BigEndian.PutUint32(data[8+4*(3*i+j):], uint32(s15Fixed16(m[i][j])))
+		}
+	}
+	return data
+}
+
+// s15Fixed16 converts v to the signed 15.16 fixed-point numbers of ICC
+// profiles
+func s15Fixed16(v float64) int32 {
+	return int32(math.Round(v * 65536))
+}
+
+// ParseICC reads the color space of an RGB matrix profile. Profiles of the
+// built-in spaces return them; others return a space without a name. Only
+// the red transfer curve is read, as RGB profiles use the same curve for
+// every channel.
+func ParseICC(profile []byte) (*Space, error) {
+	if len(profile) < iccHeaderSize+4 || string(profile[36:40]) != "acsp" {
+		return nil, errors.New("invalid ICC profile")
+	}
+	if cs, pcs := string(profile[16:20]), string(profile[20:24]); cs != "RGB " || pcs != "XYZ " {
+		return nil, fmt.Errorf("%w: %q data with %q connection space", ErrUnsupportedProfile, cs, pcs)
+	}
+	if size := binary.BigEndian.Uint32(profile); size < uint32(len(profile)) {
+		profile = profile[:size]
+	}
+
+	tags := map[string][]byte{}
+	n := int(binary.BigEndian.Uint32(profile[iccHeaderSize:]))
+	for i := 0; i < n; i++ {
+		start := iccHeaderSize + 4 + 12*i
+		if start+12 > len(profile) {
+			return nil, errors.New("invalid ICC profile: truncated tag table")
+		}
+		entry := profile[start:]
+		off, size := uint64(binary.BigEndian.Uint32(entry[4:])), uint64(binary.BigEndian.Uint32(entry[8:]))
+		if off+size > uint64(len(profile)) || size < 8 {
+			return nil, fmt.Errorf("invalid ICC profile: tag %q out of range", entry[:4])
+		}
+		tags[string(entry[:4])] = profile[off : off+size]
+	}
+
+	s := &Space{white: pcsWhite}
+	for col, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		v, err := readXYZ(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUnsupportedProfile, sig, err)
+		}
+		for row := range 3 {
+			s.toPCS[row][col] = v[row]
+		}
+	}
+	curve, err := readCurve(tags["rTRC"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: rTRC: %v", ErrUnsupportedProfile, err)
+	}
+	s.curve = curve
+	if m, err := readMatrix(tags["chad"]); err == nil {
+		s.white = m.inverse().apply(pcsWhite)
+	}
+	s.Description = readText(tags["desc"])
+
+	for _, b := range builtin {
+		if b.Equal(s) {
+			return b, nil
+		}
+	}
+	return s, nil
+}
+
+// readXYZ decodes the first value of an XYZType
+func readXYZ(data []byte) (vec3, error) {
+	if len(data) < 20 || string(data[:4]) != "XYZ " {
+		return vec3{}, errors.New("missing or invalid XYZ tag")
+	}
+	var v vec3
+	for i := range v {
+		v[i] = fixed(data[8+4*i:])
+	}
+	return v, nil
+}
+
+// readMatrix decodes a 3x3 s15Fixed16ArrayType
+func readMatrix(data []byte) (mat3, error) {
+	if len(data) < 44 || string(data[:4]) != "sf32" {
+		return mat3{}, errors.New("missing or invalid matrix tag")
+	}
+	var m mat3
+	for i := range 3 {
+		for j := range 3 {
+			m[i][j] = fixed(data[8+4*(3*i+j):])
+		}
+	}
+	return m, nil
+}
+
+// readCurve decodes a curveType or parametricCurveType
+func readCurve(data []byte) (Curve, error) {
+	if len(data) < 12 {
+		return Curve{}, errors.New("missing or invalid curve tag")
+	}
+	switch string(data[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < 12+2*n {
+			return Curve{}, errors.New("truncated curve")
+		}
+		switch n {
+		case 0:
+			return LinearCurve, nil
+		case 1:
+			return Curve{G: float64(binary.BigEndian.Uint16(data[12:])) / 256, A: 1}, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 0xffff
+		}
+		return Curve{table: table}, nil
+	case "para":
+		counts := map[uint16]int{0: 1, 1: 3, 3: 5}
+		kind := binary.BigEndian.Uint16(data[8:])
+		count, ok := counts[kind]
+		if !ok {
+			return Curve{}, fmt.Errorf("parametric curve type %d", kind)
+		}
+		if len(data) < 12+4*count {
+			return Curve{}, errors.New("truncated curve")
+		}
+		p := make([]float64, 5)
+		for i := 0; i < count; i++ {
+			p[i] = fixed(data[12+4*i:])
+		}
+		switch kind {
+		case 0:
+			return Curve{G: p[0], A: 1}, nil
+		case 1:
+			// (a·v + b)^g above -b/a and zero below
+			return Curve{G: p[0], A: p[1], B: p[2], D: -p[2] / p[1]}, nil
+		default:
+			return Curve{G: p[0], A: p[1], B: p[2], C: p[3], D: p[4]}, nil
+		}
+	}
+	return Curve{}, fmt.Errorf("curve type %q", data[:4])
+}
+
+// readText decodes the first string of a textDescriptionType (v2) or
+// multiLocalizedUnicodeType (v4)
+func readText(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "desc":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < 12+n {
+			return ""
+		}
+		return string(bytes.TrimRight(data[12:12+n], "\x00"))
+	case len(data) >= 28 && string(data[:4]) == "mluc":
+		n, off := int(binary.BigEndian.Uint32(data[20:])), int(binary.BigEndian.Uint32(data[24:]))
+		if off+n > len(data) {
+			return ""
+		}
+		text := make([]uint16, n/2)
+		for i := range text {
+			text[i] = binary.BigEndian.Uint16(data[off+2*i:])
+		}
+		return string(utf16.Decode(text))
+	}
+	return ""
+}
+
+// fixed decodes a signed 15.16 fixed-point number
+func fixed(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
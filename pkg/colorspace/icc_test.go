@@ -0,0 +1,80 @@
+package colorspace
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestICCRoundTrip(t *testing.T) {
+	for _, s := range builtin {
+		t.Run(s.Name, func(t *testing.T) {
+			profile := s.ICC()
+			if size := binary.BigEndian.Uint32(profile); int(size) != len(profile) || len(profile)%4 != 0 {
+				t.Errorf("Header size %d for a %d-byte profile", size, len(profile))
+			}
+			unsigned := bytes.Clone(profile)
+			clear(unsigned[84:100])
+			if id := md5.Sum(unsigned); !bytes.Equal(id[:], profile[84:100]) {
+				t.Error("Expected the profile ID to be the MD5 of the profile")
+			}
+
+			parsed, err := ParseICC(profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed != s {
+				t.Errorf("Expected the built-in %s space, got %q", s.Name, parsed.Description)
+			}
+		})
+	}
+}
+
+func TestParseICCCustom(t *testing.T) {
+	custom := &Space{Description: "Wide gamma 1.8", toPCS: AdobeRGB.toPCS, white: AdobeRGB.white, curve: Curve{G: 1.8, A: 1}}
+	parsed, err := ParseICC(custom.ICC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != "" || parsed.String() != "Wide gamma 1.8" || !parsed.Equal(custom) {
+		t.Errorf("Expected an unnamed space equal to the original, got %+v", parsed)
+	}
+
+	// Profiles sampling a known curve in a table, like the common v2 sRGB
+	// profiles, are recognized
+	tabled := &Space{Description: "sRGB IEC61966-2.1", toPCS: SRGB.toPCS, white: SRGB.white, curve: sampled(SRGBCurve, 1024)}
+	if parsed, err := ParseICC(tabled.ICC()); err != nil || parsed != SRGB {
+		t.Errorf("Expected the sRGB space, got %v, %v", parsed, err)
+	}
+}
+
+func TestParseICCErrors(t *testing.T) {
+	cmyk := SRGB.ICC()
+	copy(cmyk[16:], "CMYK")
+	truncated := SRGB.ICC()[:200]
+
+	tests := []struct {
+		name        string
+		profile     []byte
+		unsupported bool
+	}{
+		{"empty", nil, false},
+		{"not a profile", bytes.Repeat([]byte{1}, 200), false},
+		{"cmyk", cmyk, true},
+		{"truncated", truncated, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseICC(tt.profile)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if errors.Is(err, ErrUnsupportedProfile) != tt.unsupported {
+				t.Errorf("Unexpected error %v", err)
+			}
+		})
+	}
+}
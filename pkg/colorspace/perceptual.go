@@ -0,0 +1,108 @@
+package colorspace
+
+import "math"
+
+// oklabLMS is the Oklab matrix from D65 XYZ to cone responses
+var oklabLMS = mat3{
+	{0.8189330101, 0.3618667424, -0.1288597137},
+	{0.0329845436, 0.9293118715, 0.0361456387},
+	{0.0482003018, 0.2643662691, 0.6338517070},
+}
+
+// oklabLab is the Oklab matrix from compressed cone responses to Lab
+var oklabLab = mat3{
+	{0.2104542553, 0.7936177850, -0.0040720468},
+	{1.9779984951, -2.4285922050, 0.4505937099},
+	{0.0259040371, 0.7827717662, -0.8086757660},
+}
+
+// oklabFromLab is the inverse of oklabLab
+var oklabFromLab = oklabLab.inverse()
+
+// Oklab converts linear colors of a space to and from Björn Ottosson's
+// Oklab perceptual space, where L is the lightness and a, b the opponent
+// colors
+type Oklab struct {
+	toLMS, fromLMS mat3
+}
+
+// NewOklab returns the Oklab conversion of the linear colors of s
+func NewOklab(s *Space) *Oklab {
+	toLMS := oklabLMS.mul(bradford(pcsWhite, d65.xyz())).mul(s.toPCS)
+	return &Oklab{toLMS: toLMS, fromLMS: toLMS.inverse()}
+}
+
+// Lab returns the Oklab coordinates of a linear color
+func (o *Oklab) Lab(r, g, b float64) (l, a, bb float64) {
+	lms := o.toLMS.apply(vec3{r, g, b})
+	for i := range lms {
+		lms[i] = math.Cbrt(lms[i])
+	}
+	lab := oklabLab.apply(lms)
+	return lab[0], lab[1], lab[2]
+}
+
+// RGB returns the linear color of Oklab coordinates
+func (o *Oklab) RGB(l, a, b float64) (r, g, bb float64) {
+	lms := oklabFromLab.apply(vec3{l, a, b})
+	for i := range lms {
+		lms[i] = lms[i] * lms[i] * lms[i]
+	}
+	rgb := o.fromLMS.apply(lms)
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// ictcpLMS is the ITU-R BT.2100 matrix from BT.2020 RGB to cone responses
+var ictcpLMS = mat3{
+	{1688.0 / 4096, 2146.0 / 4096, 262.0 / 4096},
+	{683.0 / 4096, 2951.0 / 4096, 462.0 / 4096},
+	{99.0 / 4096, 309.0 / 4096, 3688.0 / 4096},
+}
+
+// ictcpITP is the ITU-R BT.2100 matrix from PQ encoded cone responses to
+// ICtCp
+var ictcpITP = mat3{
+	{0.5, 0.5, 0},
+	{6610.0 / 4096, -13613.0 / 4096, 7003.0 / 4096},
+	{17933.0 / 4096, -17390.0 / 4096, -543.0 / 4096},
+}
+
+// ictcpFromITP is the inverse of ictcpITP
+var ictcpFromITP = ictcpITP.inverse()
+
+// ICtCp converts linear colors of a space to and from the ITU-R BT.2100
+// ICtCp space of PQ signals, where I is the intensity and Ct, Cp the
+// blue-yellow and red-green chroma
+type ICtCp struct {
+	toLMS, fromLMS mat3
+	// nits is the luminance of linear 1 in cd/m²
+	nits float64
+}
+
+// NewICtCp returns the ICtCp conversion of the linear colors of s, with
+// linear 1 shown at nits cd/m²
+func NewICtCp(s *Space, nits float64) *ICtCp {
+	toLMS := ictcpLMS.mul(Rec2020.toPCS.inverse()).mul(s.toPCS)
+	return &ICtCp{toLMS: toLMS, fromLMS: toLMS.inverse(), nits: nits}
+}
+
+// ITP returns the ICtCp coordinates of a linear color. Negative cone
+// responses, from colors outside the BT.2020 gamut, are clipped.
+func (c *ICtCp) ITP(r, g, b float64) (i, ct, cp float64) {
+	lms := c.toLMS.apply(vec3{r, g, b})
+	for j := range lms {
+		lms[j] = PQEncode(lms[j] * c.nits)
+	}
+	itp := ictcpITP.apply(lms)
+	return itp[0], itp[1], itp[2]
+}
+
+// RGB returns the linear color of ICtCp coordinates
+func (c *ICtCp) RGB(i, ct, cp float64) (r, g, b float64) {
+	lms := ictcpFromITP.apply(vec3{i, ct, cp})
+	for j := range lms {
+		lms[j] = PQDecode(lms[j]) / c.nits
+	}
+	rgb := c.fromLMS.apply(lms)
+	return rgb[0], rgb[1], rgb[2]
+}
@@ -0,0 +1,56 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOklab(t *testing.T) {
+	o := NewOklab(SRGB)
+	tests := []struct {
+		rgb, lab [3]float64
+	}{
+		{[3]float64{1, 1, 1}, [3]float64{1, 0, 0}},
+		{[3]float64{1, 0, 0}, [3]float64{0.6280, 0.2249, 0.1258}},
+		{[3]float64{0, 1, 0}, [3]float64{0.8664, -0.2339, 0.1795}},
+		{[3]float64{0, 0, 1}, [3]float64{0.4520, -0.0325, -0.3115}},
+	}
+
+	for _, tt := range tests {
+		l, a, b := o.Lab(tt.rgb[0], tt.rgb[1], tt.rgb[2])
+		if math.Abs(l-tt.lab[0]) > 2e-3 || math.Abs(a-tt.lab[1]) > 2e-3 || math.Abs(b-tt.lab[2]) > 2e-3 {
+			t.Errorf("Lab(%v) = (%.4f, %.4f, %.4f), want %v", tt.rgb, l, a, b, tt.lab)
+		}
+	}
+}
+
+func TestICtCp(t *testing.T) {
+	c := NewICtCp(Rec2020, 100)
+	i, ct, cp := c.ITP(1, 1, 1)
+	if math.Abs(i-PQEncode(100)) > 1e-6 || math.Abs(ct) > 1e-6 || math.Abs(cp) > 1e-6 {
+		t.Errorf("Expected white at 100 cd/m² to be (%.4f, 0, 0), got (%.4f, %.4f, %.4f)", PQEncode(100), i, ct, cp)
+	}
+	if _, ct, cp := c.ITP(0, 0, 1); ct <= 0 || cp >= 0.1 {
+		t.Errorf("Expected blue to have a positive Ct, got (%.4f, %.4f)", ct, cp)
+	}
+	if _, _, cp := c.ITP(1, 0, 0); cp <= 0 {
+		t.Errorf("Expected red to have a positive Cp, got %.4f", cp)
+	}
+}
+
+func TestPerceptualRoundTrip(t *testing.T) {
+	colors := [][3]float64{{0.2, 0.5, 0.9}, {1, 0.1, 0.05}, {0.01, 0.01, 0.01}, {4, 3, 2}}
+	for _, s := range builtin {
+		o, c := NewOklab(s), NewICtCp(s, 203)
+		for _, v := range colors {
+			r, g, b := o.RGB(o.Lab(v[0], v[1], v[2]))
+			if math.Abs(r-v[0])+math.Abs(g-v[1])+math.Abs(b-v[2]) > 1e-6 {
+				t.Errorf("%s: Oklab round trip of %v gave (%.6f, %.6f, %.6f)", s, v, r, g, b)
+			}
+			r, g, b = c.RGB(c.ITP(v[0], v[1], v[2]))
+			if math.Abs(r-v[0])+math.Abs(g-v[1])+math.Abs(b-v[2]) > 1e-4 {
+				t.Errorf("%s: ICtCp round trip of %v gave (%.6f, %.6f, %.6f)", s, v, r, g, b)
+			}
+		}
+	}
+}
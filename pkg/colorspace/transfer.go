@@ -0,0 +1,76 @@
+package colorspace
+
+import "math"
+
+// Constants of the SMPTE ST 2084 (PQ) curve
+const (
+	pqM1 = 2610.0 / 16384
+	pqM2 = 2523.0 / 4096 * 128
+	pqC1 = 3424.0 / 4096
+	pqC2 = 2413.0 / 4096 * 32
+	pqC3 = 2392.0 / 4096 * 32
+)
+
+// PQPeak is the luminance, in cd/m², of the largest PQ signal
+const PQPeak = 10000
+
+// PQEncode maps an absolute luminance in cd/m² to a SMPTE ST 2084 signal in
+// [0, 1]
+func PQEncode(nits float64) float64 {
+	y := min(max(nits/PQPeak, 0), 1)
+	p := math.Pow(y, pqM1)
+	return math.Pow((pqC1+pqC2*p)/(1+pqC3*p), pqM2)
+}
+
+// PQDecode is the inverse of PQEncode
+func PQDecode(v float64) float64 {
+	p := math.Pow(min(max(v, 0), 1), 1/pqM2)
+	return PQPeak * math.Pow(max(p-pqC1, 0)/(pqC2-pqC3*p), 1/pqM1)
+}
+
+// Constants of the ITU-R BT.2100 HLG curve
+const (
+	hlgA = 0.17883277
+	hlgB = 1 - 4*hlgA
+	hlgC = 0.55991073 // 0.5 - a·ln(4a)
+)
+
+// HLGEncode maps normalized scene light in [0, 1] to an HLG signal in [0, 1]
+// (the BT.2100 OETF)
+func HLGEncode(e float64) float64 {
+	e = min(max(e, 0), 1)
+	if e <= 1.0/12 {
+		return math.Sqrt(3 * e)
+	}
+	return hlgA*math.Log(12*e-hlgB) + hlgC
+}
+
+// HLGDecode is the inverse of HLGEncode
+func HLGDecode(v float64) float64 {
+	v = min(max(v, 0), 1)
+	if v <= 0.5 {
+		return v * v / 3
+	}
+	return (math.Exp((v-hlgC)/hlgA) + hlgB) / 12
+}
+
+// HLGSystemGamma returns the gamma of the BT.2100 HLG OOTF for a display of
+// the given peak luminance in cd/m²
+func HLGSystemGamma(peak float64) float64 {
+	return 1.2 + 0.42*math.Log10(peak/1000)
+}
+
+// Luminance returns the relative luminance Y of a linear color of s
+func (s *Space) Luminance(r, g, b float64) float64 {
+	return s.toPCS[1][0]*r + s.toPCS[1][1]*g + s.toPCS[1][2]*b
+}
+
+// ConvertLinear converts a linear source color to linear light in the
+// destination space, without clipping
+func (t *Transform) ConvertLinear(r, g, b float64) (float64, float64, float64) {
+	if t.identity {
+		return r, g, b
+	}
+	o := t.m.apply(vec3{r, g, b})
+	return o[0], o[1], o[2]
+}
@@ -0,0 +1,73 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPQ(t *testing.T) {
+	tests := []struct {
+		nits, signal float64
+	}{
+		{0, 0},
+		{100, 0.5081},
+		{203, 0.5807},
+		{1000, 0.7518},
+		{10000, 1},
+	}
+
+	for _, tt := range tests {
+		got := PQEncode(tt.nits)
+		if math.Abs(got-tt.signal) > 1e-3 {
+			t.Errorf("PQEncode(%v) = %.4f, want %.4f", tt.nits, got, tt.signal)
+		}
+		if back := PQDecode(got); math.Abs(back-tt.nits) > 1e-6*PQPeak {
+			t.Errorf("PQDecode(%.4f) = %v, want %v", got, back, tt.nits)
+		}
+	}
+	if PQEncode(20000) != PQEncode(PQPeak) || PQEncode(-1) != PQEncode(0) {
+		t.Error("Expected PQEncode to clamp luminance outside [0, 10000]")
+	}
+}
+
+func TestHLG(t *testing.T) {
+	tests := []struct {
+		e, signal float64
+	}{
+		{0, 0},
+		{1.0 / 12, 0.5},
+		{0.2, 0.6939},
+		{1, 1},
+	}
+
+	for _, tt := range tests {
+		got := HLGEncode(tt.e)
+		if math.Abs(got-tt.signal) > 1e-3 {
+			t.Errorf("HLGEncode(%v) = %.4f, want %.4f", tt.e, got, tt.signal)
+		}
+		if back := HLGDecode(got); math.Abs(back-tt.e) > 1e-6 {
+			t.Errorf("HLGDecode(%.4f) = %v, want %v", got, back, tt.e)
+		}
+	}
+	if g := HLGSystemGamma(1000); math.Abs(g-1.2) > 1e-9 {
+		t.Errorf("Expected system gamma 1.2 at 1000 cd/m², got %v", g)
+	}
+}
+
+func TestConvertLinear(t *testing.T) {
+	tr := NewTransform(SRGB, Rec2020)
+	r, g, b := tr.ConvertLinear(1, 1, 1)
+	for _, c := range []float64{r, g, b} {
+		if math.Abs(c-1) > 1e-3 {
+			t.Errorf("Expected white to stay white, got %v %v %v", r, g, b)
+		}
+	}
+	// Pure sRGB red lies inside Rec.2020, with the BT.2087 coefficients
+	r, g, b = tr.ConvertLinear(1, 0, 0)
+	if math.Abs(r-0.6274) > 2e-3 || math.Abs(g-0.0691) > 2e-3 || math.Abs(b-0.0164) > 2e-3 {
+		t.Errorf("Unexpected Rec.2020 red %v %v %v", r, g, b)
+	}
+	if y := Rec2020.Luminance(1, 1, 1); math.Abs(y-1) > 1e-6 {
+		t.Errorf("Expected white luminance 1, got %v", y)
+	}
+}
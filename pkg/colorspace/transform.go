@@ -0,0 +1,122 @@
+package colorspace
+
+import (
+	"context"
+	"image"
+	"sync"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/mdouchement/hdr"
+)
+
+// convertRows is the height of the strips converted concurrently
+const convertRows = 64
+
+// Transform converts encoded colors from one space to another
+type Transform struct {
+	src, dst *Space
+	// m converts linear source RGB to linear destination RGB
+	m        mat3
+	identity bool
+
+	// decode caches the decoded source curve for every 16-bit value
+	decodeOnce sync.Once
+	decode     []float64
+}
+
+// NewTransform returns the transform from src to dst
+func NewTransform(src, dst *Space) *Transform {
+	return &Transform{
+		src:      src,
+		dst:      dst,
+		m:        dst.toPCS.inverse().mul(src.toPCS),
+		identity: src.Equal(dst),
+	}
+}
+
+// Identity reports whether the transform leaves colors unchanged
+func (t *Transform) Identity() bool {
+	return t.identity
+}
+
+// Convert converts an encoded source color. Channels that fall below zero
+// in the destination space are clipped to zero; values above 1, as in
+// radiance maps, are kept.
+func (t *Transform) Convert(r, g, b float64) (float64, float64, float64) {
+	if t.identity {
+		return r, g, b
+	}
+	c := t.src.curve
+	return t.convertLinear(vec3{c.Decode(r), c.Decode(g), c.Decode(b)})
+}
+
+// convertLinear converts a decoded source color and encodes it
+func (t *Transform) convertLinear(v vec3) (float64, float64, float64) {
+	o := t.m.apply(v)
+	c := t.dst.curve
+	return c.Encode(max(o[0], 0)), c.Encode(max(o[1], 0)), c.Encode(max(o[2], 0))
+}
+
+// ConvertImage converts a regular image to a 16-bit image in the destination
+// space using up to workers goroutines (all CPUs if workers <= 0).
+// Cancellation is checked between strips of rows.
+func (t *Transform) ConvertImage(ctx context.Context, img image.Image, workers int) (*image.RGBA64, error) {
+	t.decodeOnce.Do(func() {
+		t.decode = make([]float64, 0x10000)
+		for i := range t.decode {
+			t.decode[i] = t.src.curve.Decode(float64(i) / 0xffff)
+		}
+	})
+
+	bounds := img.Bounds()
+	out := image.NewRGBA64(bounds)
+	err := parallel.Run(ctx, parallel.SplitRows(bounds, convertRows), workers, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			o := out.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb, _ := img.At(x, y).RGBA()
+				nr, ng, nb := cr, cg, cb
+				if !t.identity {
+					fr, fg, fb := t.convertLinear(vec3{t.decode[cr], t.decode[cg], t.decode[cb]})
+					nr, ng, nb = quantize(fr), quantize(fg), quantize(fb)
+				}
+				pix := out.Pix[o : o+8]
+				pix[0], pix[1] = uint8(nr>>8), uint8(nr)
+				pix[2], pix[3] = uint8(ng>>8), uint8(ng)
+				pix[4], pix[5] = uint8(nb>>8), uint8(nb)
+				pix[6], pix[7] = 0xff, 0xff
+				o += 8
+			}
+		}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ConvertRGB converts a radiance map in place
+func (t *Transform) ConvertRGB(m *hdr.RGB) {
+	if t.identity {
+		return
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 3 {
+			r, g, b := t.Convert(float64(row[i]), float64(row[i+1]), float64(row[i+2]))
+			row[i], row[i+1], row[i+2] = float32(r), float32(g), float32(b)
+		}
+	}
+}
+
+// quantize maps a channel in [0, 1] to 16 bits, clamping values outside
+func quantize(v float64) uint32 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 0xffff
+	}
+	return uint32(v*0xffff + 0.5)
+}
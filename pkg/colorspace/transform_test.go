@@ -0,0 +1,97 @@
+package colorspace
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+func TestTransformConvert(t *testing.T) {
+	if !NewTransform(SRGB, SRGB).Identity() || NewTransform(SRGB, DisplayP3).Identity() {
+		t.Fatal("Expected only transforms between equal spaces to be identities")
+	}
+
+	for _, dst := range builtin {
+		toDst, back := NewTransform(SRGB, dst), NewTransform(dst, SRGB)
+
+		// Neutrals stay neutral and in-gamut colors survive a round trip
+		for _, c := range [][3]float64{{0, 0, 0}, {1, 1, 1}, {0.5, 0.5, 0.5}, {0.8, 0.4, 0.2}, {0.1, 0.6, 0.9}} {
+			r, g, b := toDst.Convert(c[0], c[1], c[2])
+			if c[0] == c[1] && (math.Abs(r-g) > 1e-3 || math.Abs(g-b) > 1e-3) {
+				t.Errorf("%s: gray %v converted to %v %v %v", dst.Name, c, r, g, b)
+			}
+			r, g, b = back.Convert(r, g, b)
+			if math.Abs(r-c[0]) > 1e-6 || math.Abs(g-c[1]) > 1e-6 || math.Abs(b-c[2]) > 1e-6 {
+				t.Errorf("%s: %v round-tripped to %v %v %v", dst.Name, c, r, g, b)
+			}
+		}
+	}
+
+	// Display P3 red lies outside sRGB: green and blue clip to zero and red
+	// exceeds 1
+	r, g, b := NewTransform(DisplayP3, SRGB).Convert(1, 0, 0)
+	if r <= 1 || g != 0 || b != 0 {
+		t.Errorf("Expected P3 red to fall outside sRGB, got %v %v %v", r, g, b)
+	}
+}
+
+func TestTransformConvertImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.NRGBA{128, 128, 128, 255})
+	img.Set(2, 1, color.NRGBA{30, 200, 90, 255})
+
+	same, err := NewTransform(SRGB, SRGB).ConvertImage(context.Background(), img, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p3, err := NewTransform(SRGB, DisplayP3).ConvertImage(context.Background(), img, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := NewTransform(DisplayP3, SRGB).ConvertImage(context.Background(), p3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			want := color.RGBA64Model.Convert(img.At(x, y)).(color.RGBA64)
+			if got := same.RGBA64At(x, y); got != want {
+				t.Errorf("Identity changed (%d, %d) from %v to %v", x, y, want, got)
+			}
+			got := back.RGBA64At(x, y)
+			for _, d := range []int{int(got.R) - int(want.R), int(got.G) - int(want.G), int(got.B) - int(want.B)} {
+				if d < -2 || d > 2 {
+					t.Errorf("Round trip changed (%d, %d) from %v to %v", x, y, want, got)
+					break
+				}
+			}
+		}
+	}
+	if c := p3.RGBA64At(0, 0); c.R == 0xffff || c.G == 0 {
+		t.Errorf("Expected sRGB red to be desaturated in Display P3, got %v", c)
+	}
+}
+
+func TestTransformConvertRGB(t *testing.T) {
+	m := hdr.NewRGB(image.Rect(0, 0, 2, 1))
+	m.SetRGB(0, 0, hdrcolor.RGB{R: 4, G: 4, B: 4})
+	m.SetRGB(1, 0, hdrcolor.RGB{R: 0.5, G: 0.2, B: 0.1})
+
+	NewTransform(SRGB, ACEScg).ConvertRGB(m)
+	NewTransform(ACEScg, SRGB).ConvertRGB(m)
+	for x, want := range []hdrcolor.RGB{{R: 4, G: 4, B: 4}, {R: 0.5, G: 0.2, B: 0.1}} {
+		got := m.RGBAt(x, 0)
+		if math.Abs(got.R-want.R) > 1e-4 || math.Abs(got.G-want.G) > 1e-4 || math.Abs(got.B-want.B) > 1e-4 {
+			t.Errorf("Pixel %d round-tripped to %v, want %v", x, got, want)
+		}
+	}
+}
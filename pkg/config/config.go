@@ -0,0 +1,397 @@
+// Package config describes the HDR pipeline settings in a file and provides
+// named presets for common kinds of shots.
+//
+// Config files may be written in YAML, TOML or JSON; the format is chosen by
+// the file extension. A file may name a preset to start from and override
+// any of its values:
+//
+//	preset: interior
+//	tonemap:
+//	  gamma: 0.8
+//	output:
+//	  quality: 90
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of every pipeline stage
+type Config struct {
+	// Preset names the preset the file starts from
+	Preset    string    `json:"preset,omitempty" yaml:"preset,omitempty" toml:"preset,omitempty"`
+	Alignment Alignment `json:"alignment" yaml:"alignment" toml:"alignment"`
+	Merge     Merge     `json:"merge" yaml:"merge" toml:"merge"`
+	Denoise   Denoise   `json:"denoise" yaml:"denoise" toml:"denoise"`
+	ToneMap   ToneMap   `json:"tonemap" yaml:"tonemap" toml:"tonemap"`
+	// WhiteBalance is applied to the radiance map before tone mapping
+	WhiteBalance WhiteBalance `json:"white_balance" yaml:"white_balance" toml:"white_balance"`
+	Color        Color        `json:"color" yaml:"color" toml:"color"`
+	HDR          HDR          `json:"hdr" yaml:"hdr" toml:"hdr"`
+	Output       Output       `json:"output" yaml:"output" toml:"output"`
+}
+
+// Alignment configures the Align stage
+type Alignment struct {
+	// Method is one of align.Methods
+	Method string `json:"method" yaml:"method" toml:"method"`
+}
+
+// Merge configures the Merge stage
+type Merge struct {
+	// Method is one of processor.MergeMethods
+	Method string `json:"method" yaml:"method" toml:"method"`
+	// FrameWeights scales each exposure, from darkest to brightest
+	FrameWeights []float64 `json:"frame_weights,omitempty" yaml:"frame_weights,omitempty" toml:"frame_weights,omitempty"`
+}
+
+// Denoise configures the Denoise stage
+type Denoise struct {
+	// Method is one of processor.Denoisers, or empty to leave the radiance
+	// map as merged
+	Method string `json:"method" yaml:"method" toml:"method"`
+	// Strength scales the noise removed at and above the log-average
+	// luminance; 1 removes the noise estimated in the radiance map
+	Strength float64 `json:"strength" yaml:"strength" toml:"strength"`
+	// Shadows scales the noise removed 4 stops below the log-average
+	// luminance and darker
+	Shadows float64 `json:"shadows" yaml:"shadows" toml:"shadows"`
+}
+
+// ToneMap configures the ToneMap stage
+type ToneMap struct {
+	// Operator is one of processor.ToneMappers
+	Operator  string  `json:"operator" yaml:"operator" toml:"operator"`
+	Gamma     float64 `json:"gamma" yaml:"gamma" toml:"gamma"`
+	Intensity float64 `json:"intensity" yaml:"intensity" toml:"intensity"`
+	Light     float64 `json:"light" yaml:"light" toml:"light"`
+	// PreserveColor is one of processor.ColorPreservations to map luminance
+	// alone and keep colors, or empty to let the operator map each channel
+	PreserveColor string `json:"preserve_color" yaml:"preserve_color" toml:"preserve_color"`
+	// ColorExponent scales the saturation of the ratio color preservation
+	ColorExponent float64 `json:"color_exponent" yaml:"color_exponent" toml:"color_exponent"`
+	// Saturation scales the saturation of the mapped colors; 1 keeps it
+	Saturation float64 `json:"saturation" yaml:"saturation" toml:"saturation"`
+	// Vibrance, from -1 to 1, saturates muted colors more than saturated
+	// ones; 0 keeps them
+	Vibrance float64 `json:"vibrance" yaml:"vibrance" toml:"vibrance"`
+}
+
+// WhiteBalance configures the white balance of the radiance map
+type WhiteBalance struct {
+	// Mode is one of processor.WhiteBalances, or empty to leave colors
+	// unchanged
+	Mode string `json:"mode" yaml:"mode" toml:"mode"`
+	// Temperature is the color temperature of the light in kelvins, for the
+	// temperature mode
+	Temperature float64 `json:"temperature" yaml:"temperature" toml:"temperature"`
+	// Tint moves the light towards green when positive, magenta when
+	// negative, for the temperature mode
+	Tint float64 `json:"tint" yaml:"tint" toml:"tint"`
+	// GrayPoint is the x and y of a neutral pixel, for the gray-point mode
+	GrayPoint []int `json:"gray_point,omitempty" yaml:"gray_point,omitempty" toml:"gray_point,omitempty"`
+	// Adaptation is one of colorspace.Adaptations
+	Adaptation string `json:"adaptation" yaml:"adaptation" toml:"adaptation"`
+}
+
+// Color configures color management
+type Color struct {
+	// Working is the space the exposures are merged and tone mapped in, one
+	// of colorspace.Names
+	Working string `json:"working" yaml:"working" toml:"working"`
+	// Output is the space of the saved images, one of colorspace.Names
+	Output string `json:"output" yaml:"output" toml:"output"`
+}
+
+// HDR configures output for HDR displays
+type HDR struct {
+	// Transfer is one of processor.HDRTransfers to encode the result for HDR
+	// displays instead of tone mapping it, or empty
+	Transfer string `json:"transfer" yaml:"transfer" toml:"transfer"`
+	// ReferenceWhite is the luminance of diffuse white in cd/m²
+	ReferenceWhite float64 `json:"reference_white" yaml:"reference_white" toml:"reference_white"`
+	// PeakLuminance is the luminance highlights roll off to in cd/m²
+	PeakLuminance float64 `json:"peak_luminance" yaml:"peak_luminance" toml:"peak_luminance"`
+	// BitDepth is the bits per sample of TIFF output, 10 or 16
+	BitDepth int `json:"bit_depth" yaml:"bit_depth" toml:"bit_depth"`
+	// GainMap embeds a gain map in JPEG output so that HDR displays can
+	// restore the highlights of the tone mapped image
+	GainMap bool `json:"gain_map" yaml:"gain_map" toml:"gain_map"`
+}
+
+// Output configures how the result is encoded
+type Output struct {
+	// Quality is the JPEG quality, from 1 to 100
+	Quality int `json:"quality" yaml:"quality" toml:"quality"`
+	// Compression is the PNG compression: default, none, fast or best
+	Compression string `json:"compression" yaml:"compression" toml:"compression"`
+}
+
+// compressionLevels maps the Output.Compression names to PNG levels
+var compressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// Default returns the settings the CLI uses without a config file or preset
+func Default() Config {
+	return Config{
+		Alignment: Alignment{Method: "basic"},
+		Merge:     Merge{Method: "average"},
+		Denoise:   Denoise{Strength: processor.DefaultDenoiseStrength, Shadows: processor.DefaultDenoiseShadows},
+		ToneMap:   ToneMap{Operator: "drago03", Gamma: 1, Intensity: 1, Light: 0, ColorExponent: 1, Saturation: 1},
+		WhiteBalance: WhiteBalance{
+			Temperature: processor.DefaultTemperature,
+			Adaptation:  "bradford",
+		},
+		Color: Color{Working: "srgb", Output: "srgb"},
+		HDR: HDR{
+			ReferenceWhite: processor.DefaultReferenceWhite,
+			PeakLuminance:  processor.DefaultPeakLuminance,
+			BitDepth:       processor.DefaultBitDepth,
+		},
+		Output: Output{Quality: 95, Compression: "default"},
+	}
+}
+
+// Load reads the config file at path. The file starts from the preset it
+// names, or from Default if it names none. A non-empty preset replaces the
+// one named in the file.
+func Load(path, preset string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	cfg, err := Parse(data, format, preset)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes a config in format (yaml, yml, toml or json). See Load for
+// how presets apply.
+func Parse(data []byte, format, preset string) (Config, error) {
+	// Find the preset named in the file before decoding over it
+	var named struct {
+		Preset string `json:"preset" yaml:"preset" toml:"preset"`
+	}
+	if err := decode(data, format, &named, false); err != nil {
+		return Config{}, err
+	}
+	if preset == "" {
+		preset = named.Preset
+	}
+
+	cfg := Default()
+	if preset != "" {
+		var err error
+		if cfg, err = Preset(preset); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := decode(data, format, &cfg, true); err != nil {
+		return Config{}, err
+	}
+	cfg.Preset = preset
+	return cfg, cfg.Validate()
+}
+
+// decode unmarshals data into v, rejecting unknown keys when strict is set
+func decode(data []byte, format string, v any, strict bool) error {
+	switch format {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		return dec.Decode(v)
+	case "yaml", "yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(strict)
+		if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case "toml":
+		md, err := toml.Decode(string(data), v)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); strict && len(undecoded) > 0 {
+			return fmt.Errorf("unknown field %q", undecoded[0].String())
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported config format %q (yaml, toml, json)", format)
+	}
+}
+
+// Validate checks that every setting has a supported value
+func (c Config) Validate() error {
+	if !slices.Contains(align.Methods, c.Alignment.Method) {
+		return fmt.Errorf("alignment.method: unsupported value %q (%s)", c.Alignment.Method, strings.Join(align.Methods, ", "))
+	}
+	if !slices.Contains(processor.MergeMethods, c.Merge.Method) {
+		return fmt.Errorf("merge.method: unsupported value %q (%s)", c.Merge.Method, strings.Join(processor.MergeMethods, ", "))
+	}
+	for _, w := range c.Merge.FrameWeights {
+		if w < 0 {
+			return fmt.Errorf("merge.frame_weights: negative weight %v", w)
+		}
+	}
+	if err := c.Denoise.validate(); err != nil {
+		return err
+	}
+	if !slices.Contains(processor.ToneMappers, c.ToneMap.Operator) {
+		return fmt.Errorf("tonemap.operator: unsupported value %q (%s)", c.ToneMap.Operator, strings.Join(processor.ToneMappers, ", "))
+	}
+	if c.ToneMap.PreserveColor != "" && !slices.Contains(processor.ColorPreservations, c.ToneMap.PreserveColor) {
+		return fmt.Errorf("tonemap.preserve_color: unsupported value %q (%s)", c.ToneMap.PreserveColor, strings.Join(processor.ColorPreservations, ", "))
+	}
+	if c.ToneMap.ColorExponent <= 0 {
+		return fmt.Errorf("tonemap.color_exponent: %v is not positive", c.ToneMap.ColorExponent)
+	}
+	if c.ToneMap.Saturation < 0 {
+		return fmt.Errorf("tonemap.saturation: %v is negative", c.ToneMap.Saturation)
+	}
+	if c.ToneMap.Vibrance < -1 || c.ToneMap.Vibrance > 1 {
+		return fmt.Errorf("tonemap.vibrance: %v is not between -1 and 1", c.ToneMap.Vibrance)
+	}
+	if err := c.WhiteBalance.validate(); err != nil {
+		return err
+	}
+	if !slices.Contains(colorspace.Names, c.Color.Working) {
+		return fmt.Errorf("color.working: unsupported value %q (%s)", c.Color.Working, strings.Join(colorspace.Names, ", "))
+	}
+	if !slices.Contains(colorspace.Names, c.Color.Output) {
+		return fmt.Errorf("color.output: unsupported value %q (%s)", c.Color.Output, strings.Join(colorspace.Names, ", "))
+	}
+	if c.HDR.Transfer != "" && !slices.Contains(processor.HDRTransfers, c.HDR.Transfer) {
+		return fmt.Errorf("hdr.transfer: unsupported value %q (%s)", c.HDR.Transfer, strings.Join(processor.HDRTransfers, ", "))
+	}
+	if c.HDR.ReferenceWhite <= 0 {
+		return fmt.Errorf("hdr.reference_white: %v is not positive", c.HDR.ReferenceWhite)
+	}
+	if c.HDR.PeakLuminance < c.HDR.ReferenceWhite || c.HDR.PeakLuminance > colorspace.PQPeak {
+		return fmt.Errorf("hdr.peak_luminance: %v is not between the reference white and %d", c.HDR.PeakLuminance, colorspace.PQPeak)
+	}
+	if c.HDR.BitDepth != 10 && c.HDR.BitDepth != 16 {
+		return fmt.Errorf("hdr.bit_depth: unsupported value %d (10, 16)", c.HDR.BitDepth)
+	}
+	if c.HDR.GainMap && c.HDR.Transfer != "" {
+		return fmt.Errorf("hdr.gain_map: cannot be combined with hdr.transfer %q", c.HDR.Transfer)
+	}
+	if c.Output.Quality < 1 || c.Output.Quality > 100 {
+		return fmt.Errorf("output.quality: %d is not between 1 and 100", c.Output.Quality)
+	}
+	if _, ok := compressionLevels[c.Output.Compression]; !ok {
+		return fmt.Errorf("output.compression: unsupported value %q (default, none, fast, best)", c.Output.Compression)
+	}
+	return nil
+}
+
+// validate checks the noise reduction settings
+func (d Denoise) validate() error {
+	if d.Method != "" && !slices.Contains(processor.Denoisers, d.Method) {
+		return fmt.Errorf("denoise.method: unsupported value %q (%s)", d.Method, strings.Join(processor.Denoisers, ", "))
+	}
+	if d.Strength < 0 {
+		return fmt.Errorf("denoise.strength: %v is negative", d.Strength)
+	}
+	if d.Shadows < 0 {
+		return fmt.Errorf("denoise.shadows: %v is negative", d.Shadows)
+	}
+	return nil
+}
+
+// validate checks the white balance settings
+func (w WhiteBalance) validate() error {
+	if w.Mode != "" && !slices.Contains(processor.WhiteBalances, w.Mode) {
+		return fmt.Errorf("white_balance.mode: unsupported value %q (%s)", w.Mode, strings.Join(processor.WhiteBalances, ", "))
+	}
+	if w.Temperature < colorspace.MinTemperature || w.Temperature > colorspace.MaxTemperature {
+		return fmt.Errorf("white_balance.temperature: %v is not between %d and %d", w.Temperature, colorspace.MinTemperature, colorspace.MaxTemperature)
+	}
+	if w.GrayPoint != nil && (len(w.GrayPoint) != 2 || w.GrayPoint[0] < 0 || w.GrayPoint[1] < 0) {
+		return fmt.Errorf("white_balance.gray_point: %v is not a pixel [x, y]", w.GrayPoint)
+	}
+	if w.Mode == "gray-point" && w.GrayPoint == nil {
+		return errors.New("white_balance.gray_point: required by the gray-point mode")
+	}
+	if !slices.Contains(colorspace.Adaptations, w.Adaptation) {
+		return fmt.Errorf("white_balance.adaptation: unsupported value %q (%s)", w.Adaptation, strings.Join(colorspace.Adaptations, ", "))
+	}
+	return nil
+}
+
+// options returns the processor options of the white balance
+func (w WhiteBalance) options() []processor.Option {
+	opts := []processor.Option{processor.WithAdaptation(w.Adaptation)}
+	switch w.Mode {
+	case "temperature":
+		return append(opts, processor.WithTemperature(w.Temperature, w.Tint))
+	case "gray-point":
+		return append(opts, processor.WithGrayPoint(w.GrayPoint[0], w.GrayPoint[1]))
+	}
+	return append(opts, processor.WithWhiteBalance(w.Mode))
+}
+
+// Options returns the processor options for c, which must be valid
+func (c Config) Options() []processor.Option {
+	aligner, err := align.New(c.Alignment.Method)
+	if err != nil {
+		aligner = align.NewBasicAligner()
+	}
+
+	// Lookup only fails for invalid configs; nil keeps sRGB
+	working, _ := colorspace.Lookup(c.Color.Working)
+	output, _ := colorspace.Lookup(c.Color.Output)
+
+	opts := []processor.Option{
+		processor.WithAligner(aligner),
+		processor.WithMergeMethod(c.Merge.Method),
+		processor.WithFrameWeights(c.Merge.FrameWeights),
+		processor.WithDenoise(c.Denoise.Method, c.Denoise.Strength, c.Denoise.Shadows),
+		processor.WithToneMapper(c.ToneMap.Operator),
+		processor.WithGamma(c.ToneMap.Gamma),
+		processor.WithIntensity(c.ToneMap.Intensity),
+		processor.WithLight(c.ToneMap.Light),
+		processor.WithColorPreservation(c.ToneMap.PreserveColor, c.ToneMap.ColorExponent),
+		processor.WithSaturation(c.ToneMap.Saturation),
+		processor.WithVibrance(c.ToneMap.Vibrance),
+		processor.WithWorkingSpace(working),
+		processor.WithOutputSpace(output),
+		processor.WithHDROutput(c.HDR.Transfer),
+		processor.WithReferenceWhite(c.HDR.ReferenceWhite),
+		processor.WithPeakLuminance(c.HDR.PeakLuminance),
+		processor.WithBitDepth(c.HDR.BitDepth),
+		processor.WithGainMap(c.HDR.GainMap),
+		processor.WithEncodeOptions(imaging.EncodeOptions{
+			Quality:     c.Output.Quality,
+			Compression: compressionLevels[c.Output.Compression],
+		}),
+	}
+	return append(opts, c.WhiteBalance.options()...)
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// writeConfig writes a config file named name to a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFormats(t *testing.T) {
+	want := Default()
+	want.Preset = "interior"
+	want.Merge = Merge{Method: "average", FrameWeights: []float64{1, 2, 1}}
+	want.Denoise = Denoise{Method: "wavelet", Strength: 1, Shadows: 3}
+	want.ToneMap = toneMap("drago03", 0.8, 1, 0)
+	want.Output.Quality = 90
+	want.WhiteBalance.Mode = "gray-point"
+	want.WhiteBalance.GrayPoint = []int{10, 20}
+
+	files := map[string]string{
+		"pipeline.yaml": `
+preset: interior
+merge:
+  frame_weights: [1, 2, 1]
+denoise:
+  method: wavelet
+  shadows: 3
+tonemap:
+  gamma: 0.8
+white_balance:
+  mode: gray-point
+  gray_point: [10, 20]
+output:
+  quality: 90
+`,
+		"pipeline.toml": `
+preset = "interior"
+
+[merge]
+frame_weights = [1.0, 2.0, 1.0]
+
+[denoise]
+method = "wavelet"
+shadows = 3.0
+
+[tonemap]
+gamma = 0.8
+
+[white_balance]
+mode = "gray-point"
+gray_point = [10, 20]
+
+[output]
+quality = 90
+`,
+		"pipeline.json": `{
+  "preset": "interior",
+  "merge": {"frame_weights": [1, 2, 1]},
+  "denoise": {"method": "wavelet", "shadows": 3},
+  "tonemap": {"gamma": 0.8},
+  "white_balance": {"mode": "gray-point", "gray_point": [10, 20]},
+  "output": {"quality": 90}
+}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			got, err := Load(writeConfig(t, name, content), "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestLoadPresetOverride(t *testing.T) {
+	path := writeConfig(t, "pipeline.yml", "preset: interior\noutput:\n  quality: 80\n")
+
+	got, err := Load(path, "dramatic")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Preset != "dramatic" || got.ToneMap.Operator != "reinhard05" {
+		t.Errorf("Expected the dramatic preset, got %+v", got)
+	}
+	if got.Output.Quality != 80 {
+		t.Errorf("Expected file values on top of the preset, got quality %d", got.Output.Quality)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"unknown yaml field", "c.yaml", "tonemap:\n  gama: 1\n", "gama"},
+		{"unknown toml field", "c.toml", "[tonemap]\ngama = 1\n", "gama"},
+		{"unknown json field", "c.json", `{"tonemap": {"gama": 1}}`, "gama"},
+		{"unknown preset", "c.yaml", "preset: vivid\n", "unknown preset"},
+		{"invalid operator", "c.yaml", "tonemap:\n  operator: mantiuk\n", "tonemap.operator"},
+		{"invalid quality", "c.json", `{"output": {"quality": 101}}`, "output.quality"},
+		{"invalid compression", "c.toml", "[output]\ncompression = \"max\"\n", "output.compression"},
+		{"invalid alignment", "c.yaml", "alignment:\n  method: sift\n", "alignment.method"},
+		{"invalid color preservation", "c.yaml", "tonemap:\n  preserve_color: lab\n", "tonemap.preserve_color"},
+		{"invalid color exponent", "c.toml", "[tonemap]\ncolor_exponent = 0.0\n", "tonemap.color_exponent"},
+		{"negative saturation", "c.json", `{"tonemap": {"saturation": -0.5}}`, "tonemap.saturation"},
+		{"invalid vibrance", "c.yaml", "tonemap:\n  vibrance: 2\n", "tonemap.vibrance"},
+		{"invalid white balance", "c.yaml", "white_balance:\n  mode: auto\n", "white_balance.mode"},
+		{"invalid temperature", "c.toml", "[white_balance]\ntemperature = 500.0\n", "white_balance.temperature"},
+		{"missing gray point", "c.json", `{"white_balance": {"mode": "gray-point"}}`, "white_balance.gray_point"},
+		{"invalid gray point", "c.yaml", "white_balance:\n  gray_point: [1, 2, 3]\n", "white_balance.gray_point"},
+		{"invalid adaptation", "c.yaml", "white_balance:\n  adaptation: xyz\n", "white_balance.adaptation"},
+		{"invalid working space", "c.yaml", "color:\n  working: prophoto\n", "color.working"},
+		{"invalid output space", "c.toml", "[color]\noutput = \"cmyk\"\n", "color.output"},
+		{"invalid hdr transfer", "c.yaml", "hdr:\n  transfer: dolby\n", "hdr.transfer"},
+		{"invalid peak", "c.json", `{"hdr": {"peak_luminance": 100}}`, "hdr.peak_luminance"},
+		{"invalid bit depth", "c.toml", "[hdr]\nbit_depth = 12\n", "hdr.bit_depth"},
+		{"gain map with transfer", "c.yaml", "hdr:\n  transfer: pq\n  gain_map: true\n", "hdr.gain_map"},
+		{"negative weight", "c.yaml", "merge:\n  frame_weights: [1, -1]\n", "negative weight"},
+		{"invalid denoiser", "c.yaml", "denoise:\n  method: median\n", "denoise.method"},
+		{"negative denoise strength", "c.toml", "[denoise]\nstrength = -1.0\n", "denoise.strength"},
+		{"negative denoise shadows", "c.json", `{"denoise": {"shadows": -1}}`, "denoise.shadows"},
+		{"unsupported format", "c.ini", "gamma=1\n", "unsupported config format"},
+		{"malformed", "c.json", `{"tonemap": `, "c.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.file, tt.content), "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	cfg, err := Preset("dramatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := processor.NewHDRProcessor(cfg.Options()...)
+	if p.ToneMapper() != "reinhard05" || p.MergeMethod() != "average" {
+		t.Errorf("Unexpected processor settings: %s, %s", p.ToneMapper(), p.MergeMethod())
+	}
+	if p.Param("intensity") != cfg.ToneMap.Intensity || p.Param("light") != cfg.ToneMap.Light {
+		t.Errorf("Expected tone mapping parameters from the preset, got intensity %v, light %v",
+			p.Param("intensity"), p.Param("light"))
+	}
+}
+
+func TestOptionsToneMapColor(t *testing.T) {
+	cfg := Default()
+	cfg.ToneMap.PreserveColor = "ictcp"
+	cfg.ToneMap.Saturation = 1.2
+	cfg.ToneMap.Vibrance = -0.3
+
+	p := processor.NewHDRProcessor(cfg.Options()...)
+	if p.ColorPreservation() != "ictcp" || p.Param("saturation") != 1.2 || p.Param("vibrance") != -0.3 {
+		t.Errorf("Unexpected color settings: %q, saturation %v, vibrance %v",
+			p.ColorPreservation(), p.Param("saturation"), p.Param("vibrance"))
+	}
+}
+
+func TestOptionsColorSpaces(t *testing.T) {
+	cfg := Default()
+	cfg.Color = Color{Working: "acescg", Output: "display-p3"}
+
+	p := processor.NewHDRProcessor(cfg.Options()...)
+	if p.WorkingSpace() != colorspace.ACEScg || p.OutputSpace() != colorspace.DisplayP3 {
+		t.Errorf("Expected acescg and display-p3, got %v and %v", p.WorkingSpace(), p.OutputSpace())
+	}
+}
+
+func TestOptionsHDR(t *testing.T) {
+	cfg := Default()
+	if p := processor.NewHDRProcessor(cfg.Options()...); p.HDROutput() != "" {
+		t.Errorf("Expected SDR output by default, got %q", p.HDROutput())
+	}
+	cfg.HDR.Transfer = "hlg"
+	if p := processor.NewHDRProcessor(cfg.Options()...); p.HDROutput() != "hlg" {
+		t.Errorf("Expected HLG output, got %q", p.HDROutput())
+	}
+	cfg.HDR = Default().HDR
+	cfg.HDR.GainMap = true
+	if p := processor.NewHDRProcessor(cfg.Options()...); !p.GainMap() {
+		t.Error("Expected a gain map")
+	}
+}
+
+func TestOptionsDenoise(t *testing.T) {
+	cfg := Default()
+	if p := processor.NewHDRProcessor(cfg.Options()...); p.Denoiser() != "" {
+		t.Errorf("Expected no denoising by default, got %q", p.Denoiser())
+	}
+	cfg.Denoise.Method = "wavelet"
+	if p := processor.NewHDRProcessor(cfg.Options()...); p.Denoiser() != "wavelet" {
+		t.Errorf("Expected the wavelet denoiser, got %q", p.Denoiser())
+	}
+}
+
+func TestOptionsWhiteBalance(t *testing.T) {
+	tests := []struct {
+		wb   WhiteBalance
+		want string
+	}{
+		{Default().WhiteBalance, ""},
+		{WhiteBalance{Mode: "temperature", Temperature: 3200, Adaptation: "cat02"}, "temperature"},
+		{WhiteBalance{Mode: "gray-point", Temperature: 6500, GrayPoint: []int{3, 4}, Adaptation: "bradford"}, "gray-point"},
+		{WhiteBalance{Mode: "white-patch", Temperature: 6500, Adaptation: "bradford"}, "white-patch"},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.WhiteBalance = tt.wb
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tt.wb, err)
+		}
+		if p := processor.NewHDRProcessor(cfg.Options()...); p.WhiteBalance() != tt.want {
+			t.Errorf("%+v: expected white balance %q, got %q", tt.wb, tt.want, p.WhiteBalance())
+		}
+	}
+}
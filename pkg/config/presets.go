@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// presets holds the built-in named configurations
+var presets = map[string]func() Config{
+	// natural keeps contrast close to a single well-exposed frame
+	"natural": func() Config {
+		c := Default()
+		c.ToneMap = toneMap("drago03", 0.85, 1, 0)
+		return c
+	},
+	// dramatic uses local adaptation for strong, punchy local contrast
+	"dramatic": func() Config {
+		c := Default()
+		c.ToneMap = toneMap("reinhard05", 1, 1.5, 0.3)
+		return c
+	},
+	// interior lifts shadows so dim rooms read well next to bright windows
+	"interior": func() Config {
+		c := Default()
+		c.ToneMap = toneMap("drago03", 0.7, 1, 0)
+		return c
+	},
+	// real-estate is interior with brighter shadows and smaller JPEGs for
+	// listing sites
+	"real-estate": func() Config {
+		c := Default()
+		c.ToneMap = toneMap("drago03", 0.65, 1, 0)
+		c.Output.Quality = 90
+		return c
+	},
+}
+
+// toneMap returns the default tone mapping settings with the given operator
+// and parameters
+func toneMap(operator string, gamma, intensity, light float64) ToneMap {
+	t := Default().ToneMap
+	t.Operator, t.Gamma, t.Intensity, t.Light = operator, gamma, intensity, light
+	return t
+}
+
+// Presets returns the names of the built-in presets, sorted
+func Presets() []string {
+	return slices.Sorted(maps.Keys(presets))
+}
+
+// Preset returns the built-in preset with the given name
+func Preset(name string) (Config, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown preset %q (%s)", name, strings.Join(Presets(), ", "))
+	}
+	c := preset()
+	c.Preset = name
+	return c, nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import "testing"
+
+func TestPresets(t *testing.T) {
+	names := Presets()
+	for _, want := range []string{"natural", "dramatic", "interior", "real-estate"} {
+		found := false
+		for _, name := range names {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("Expected preset %q in %v", want, names)
+		}
+	}
+
+	for _, name := range names {
+		cfg, err := Preset(name)
+		if err != nil {
+			t.Fatalf("Preset(%q): unexpected error: %v", name, err)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Preset %q is invalid: %v", name, err)
+		}
+		if cfg.Preset != name {
+			t.Errorf("Expected preset name %q, got %q", name, cfg.Preset)
+		}
+	}
+
+	if _, err := Preset("vivid"); err == nil {
+		t.Error("Expected error for unknown preset, got nil")
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default config is invalid: %v", err)
+	}
+}
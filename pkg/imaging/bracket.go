@@ -0,0 +1,136 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Frame is an image file together with its capture metadata
+type Frame struct {
+	Path     string
+	Metadata Metadata
+	// HasMetadata reports whether EXIF metadata could be read
+	HasMetadata bool
+	// Brightness is the mean luminance of the image in [0, 1]. It is only
+	// set once the image has been measured by DetectBrackets or
+	// OrderByExposure.
+	Brightness float64
+
+	// thumbnail holds downscaled luminance values used to compare frames
+	thumbnail []float64
+}
+
+// ReadFrames reads the metadata of every path. Files without readable EXIF
+// data are returned with HasMetadata unset.
+func ReadFrames(paths []string) []Frame {
+	frames := make([]Frame, len(paths))
+	for i, path := range paths {
+		m, err := ReadMetadata(path)
+		frames[i] = Frame{Path: path, Metadata: m, HasMetadata: err == nil}
+	}
+	return frames
+}
+
+// Paths returns the paths of frames
+func Paths(frames []Frame) []string {
+	paths := make([]string, len(frames))
+	for i, f := range frames {
+		paths[i] = f.Path
+	}
+	return paths
+}
+
+// GroupBySize splits frames, in order, into brackets of size frames. The last
+// bracket is shorter if len(frames) is not a multiple of size.
+func GroupBySize(frames []Frame, size int) ([][]Frame, error) {
+	if size < 2 {
+		return nil, fmt.Errorf("bracket size must be at least 2, got %d", size)
+	}
+
+	var groups [][]Frame
+	for start := 0; start < len(frames); start += size {
+		groups = append(groups, frames[start:min(start+size, len(frames))])
+	}
+	return groups, nil
+}
+
+// GroupByTime sorts frames by capture time and starts a new bracket whenever
+// more than gap elapses between consecutive frames
+func GroupByTime(frames []Frame, gap time.Duration) ([][]Frame, error) {
+	for _, f := range frames {
+		if !f.HasMetadata || f.Metadata.CaptureTime.IsZero() {
+			return nil, fmt.Errorf("%s: no capture time", f.Path)
+		}
+	}
+
+	sorted := append([]Frame(nil), frames...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Metadata.CaptureTime.Before(sorted[j].Metadata.CaptureTime)
+	})
+
+	var groups [][]Frame
+	for i, f := range sorted {
+		if i == 0 || f.Metadata.CaptureTime.Sub(sorted[i-1].Metadata.CaptureTime) > gap {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], f)
+	}
+	return groups, nil
+}
+
+// GroupByExposureSequence walks frames in order and starts a new bracket
+// whenever the exposure of the first frame of the current bracket comes
+// around again, as cameras repeat the same exposure sequence for every
+// bracket
+func GroupByExposureSequence(frames []Frame) ([][]Frame, error) {
+	var groups [][]Frame
+	for _, f := range frames {
+		if !hasExposure(f) {
+			return nil, fmt.Errorf("%s: no exposure information", f.Path)
+		}
+		if len(groups) == 0 || sameExposure(groups[len(groups)-1][0], f) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], f)
+	}
+	if len(groups) == 0 {
+		return nil, errors.New("no frames to group")
+	}
+	return groups, nil
+}
+
+// hasExposure reports whether the frame records its exposure settings or
+// exposure bias
+func hasExposure(f Frame) bool {
+	return f.HasMetadata && (f.Metadata.Exposure() > 0 || f.Metadata.HasExposureBias)
+}
+
+// sameExposure reports whether a and b were taken at the same exposure. They
+// differ when either their exposure settings or their exposure biases
+// differ, so a manual bracket shot with a bias of 0 throughout is told apart
+// by its shutter speeds and an automatic one by its biases. Frames without
+// any exposure information in common are never the same.
+func sameExposure(a, b Frame) bool {
+	if !a.HasMetadata || !b.HasMetadata {
+		return false
+	}
+	ma, mb := a.Metadata, b.Metadata
+
+	compared := false
+	if ea, eb := ma.Exposure(), mb.Exposure(); ea > 0 && eb > 0 {
+		if math.Abs(math.Log2(ea/eb)) >= 1e-3 {
+			return false
+		}
+		compared = true
+	}
+	if ma.HasExposureBias && mb.HasExposureBias {
+		if math.Abs(ma.ExposureBias-mb.ExposureBias) >= 1e-3 {
+			return false
+		}
+		compared = true
+	}
+	return compared
+}
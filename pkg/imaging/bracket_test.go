@@ -0,0 +1,143 @@
+package imaging
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testFrames builds frames named by index with capture times offset from a
+// base time and the given exposure biases
+func testFrames(offsets []time.Duration, biases []float64) []Frame {
+	base := time.Date(2024, 5, 17, 14, 0, 0, 0, time.UTC)
+	frames := make([]Frame, len(offsets))
+	for i := range offsets {
+		frames[i] = Frame{
+			Path:        string(rune('a' + i)),
+			HasMetadata: true,
+			Metadata: Metadata{
+				CaptureTime:     base.Add(offsets[i]),
+				ExposureBias:    biases[i],
+				HasExposureBias: true,
+			},
+		}
+	}
+	return frames
+}
+
+// groupPaths returns the paths of each group
+func groupPaths(groups [][]Frame) [][]string {
+	out := make([][]string, len(groups))
+	for i, g := range groups {
+		out[i] = Paths(g)
+	}
+	return out
+}
+
+func TestGroupBySize(t *testing.T) {
+	frames := testFrames(make([]time.Duration, 7), make([]float64, 7))
+
+	groups, err := GroupBySize(frames, 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g"}}
+	if got := groupPaths(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if _, err := GroupBySize(frames, 1); err == nil {
+		t.Error("Expected error for size 1, got nil")
+	}
+}
+
+func TestGroupByTime(t *testing.T) {
+	s := time.Second
+	frames := testFrames(
+		[]time.Duration{0, s / 2, s, 20 * s, 21 * s, 22 * s, 5 * s},
+		make([]float64, 7),
+	)
+
+	groups, err := GroupByTime(frames, 2*s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]string{{"a", "b", "c"}, {"g"}, {"d", "e", "f"}}
+	if got := groupPaths(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	frames[1].HasMetadata = false
+	if _, err := GroupByTime(frames, 2*s); err == nil {
+		t.Error("Expected error for frame without capture time, got nil")
+	}
+}
+
+func TestGroupByExposureSequence(t *testing.T) {
+	frames := testFrames(make([]time.Duration, 8), []float64{0, -2, 2, 0, -2, 2, 0, -2})
+
+	groups, err := GroupByExposureSequence(frames)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g", "h"}}
+	if got := groupPaths(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Without a bias the exposure settings are used
+	frames[4].Metadata = Metadata{ExposureTime: 1.0 / 60}
+	if _, err := GroupByExposureSequence(frames); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	frames[4].HasMetadata = false
+	if _, err := GroupByExposureSequence(frames); err == nil {
+		t.Error("Expected error for frame without metadata, got nil")
+	}
+}
+
+func TestGroupByExposureSequenceManual(t *testing.T) {
+	// A manual bracket records a bias of 0 on every frame, so only the
+	// shutter speeds repeat
+	frames := testFrames(make([]time.Duration, 6), make([]float64, 6))
+	for i, shutter := range []float64{1.0 / 60, 1.0 / 250, 1.0 / 15, 1.0 / 60, 1.0 / 250, 1.0 / 15} {
+		frames[i].Metadata.ExposureTime = shutter
+		frames[i].Metadata.FNumber = 8
+		frames[i].Metadata.ISO = 100
+	}
+
+	groups, err := GroupByExposureSequence(frames)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]string{{"a", "b", "c"}, {"d", "e", "f"}}
+	if got := groupPaths(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestSameExposure(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Metadata
+		want bool
+	}{
+		{"same settings", Metadata{ExposureTime: 0.01, FNumber: 8}, Metadata{ExposureTime: 0.01, FNumber: 8}, true},
+		{"different shutter", Metadata{ExposureTime: 0.01}, Metadata{ExposureTime: 0.04}, false},
+		{"equivalent settings", Metadata{ExposureTime: 0.01, ISO: 200}, Metadata{ExposureTime: 0.02, ISO: 100}, true},
+		{"same bias", Metadata{HasExposureBias: true}, Metadata{HasExposureBias: true}, true},
+		{"different bias", Metadata{HasExposureBias: true}, Metadata{ExposureBias: -2, HasExposureBias: true}, false},
+		{"zero bias, different shutter", Metadata{ExposureTime: 0.01, HasExposureBias: true}, Metadata{ExposureTime: 0.04, HasExposureBias: true}, false},
+		{"same shutter, different bias", Metadata{ExposureTime: 0.01, HasExposureBias: true}, Metadata{ExposureTime: 0.01, ExposureBias: 1, HasExposureBias: true}, false},
+		{"nothing in common", Metadata{ExposureTime: 0.01}, Metadata{HasExposureBias: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Frame{HasMetadata: true, Metadata: tt.a}
+			b := Frame{HasMetadata: true, Metadata: tt.b}
+			if got := sameExposure(a, b); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
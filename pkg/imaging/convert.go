@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"context"
+	"image"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+)
+
+// convertRows is the height of the strips converted concurrently
+const convertRows = 64
+
+// ConvertToHDR converts a regular image to an HDR image with channels in [0, 1]
+func ConvertToHDR(img image.Image) *hdr.RGB {
+	hdrImg, _ := convertToHDR(context.Background(), img, 0, nil, nil)
+	return hdrImg
+}
+
+// ConvertToHDRContext converts a regular image to an HDR image with channels
+// in [0, 1] using up to workers goroutines (all CPUs if workers <= 0).
+// Cancellation is checked between strips of rows.
+func ConvertToHDRContext(ctx context.Context, img image.Image, workers int) (*hdr.RGB, error) {
+	return convertToHDR(ctx, img, workers, nil, nil)
+}
+
+// LinearizeContext converts an image encoded with the transfer curve c to an
+// HDR image in linear light, using up to workers goroutines (all CPUs if
+// workers <= 0). HDR images, such as those LoadImage returns, hold encoded
+// channels too and are decoded into a new image.
+func LinearizeContext(ctx context.Context, img image.Image, c colorspace.Curve, workers int) (*hdr.RGB, error) {
+	decode := c.DecodeTable()
+	src, ok := img.(hdr.Image)
+	if !ok {
+		return convertToHDR(ctx, img, workers, nil, decode)
+	}
+
+	// Channels in [0, 1] come from 16-bit images and are looked up
+	channel := func(v float64) float32 {
+		if v >= 0 && v <= 1 {
+			return decode[int(v*0xffff+0.5)]
+		}
+		return float32(c.Decode(v))
+	}
+	bounds := src.Bounds()
+	out := hdr.NewRGB(bounds)
+	rgb, _ := src.(*hdr.RGB)
+	err := parallel.Run(ctx, parallel.SplitRows(bounds, convertRows), workers, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := out.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				var cr, cg, cb float64
+				if rgb != nil {
+					j := rgb.PixOffset(x, y)
+					cr, cg, cb = float64(rgb.Pix[j]), float64(rgb.Pix[j+1]), float64(rgb.Pix[j+2])
+				} else {
+					cr, cg, cb, _ = src.HDRAt(x, y).HDRRGBA()
+				}
+				out.Pix[i], out.Pix[i+1], out.Pix[i+2] = channel(cr), channel(cg), channel(cb)
+				i += 3
+			}
+		}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// convertToHDR converts img strip by strip, writing straight into the float32
+// pixel buffer. Common image types are read through their concrete accessors
+// to avoid an interface call per pixel. Channels are looked up in decode,
+// when given, instead of scaled to [0, 1].
+func convertToHDR(ctx context.Context, img image.Image, workers int, report func(done, total int), decode []float32) (*hdr.RGB, error) {
+	bounds := img.Bounds()
+	hdrImg := hdr.NewRGB(bounds)
+
+	if src, ok := img.(*hdr.RGB); ok {
+		copy(hdrImg.Pix, src.Pix)
+		if report != nil {
+			report(1, 1)
+		}
+		return hdrImg, nil
+	}
+
+	strips := parallel.SplitRows(bounds, convertRows)
+	err := parallel.Run(ctx, strips, workers, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := hdrImg.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb := rgbAt(img, x, y)
+				if decode != nil {
+					hdrImg.Pix[i], hdrImg.Pix[i+1], hdrImg.Pix[i+2] = decode[cr], decode[cg], decode[cb]
+				} else {
+					hdrImg.Pix[i] = float32(cr) / 0xffff
+					hdrImg.Pix[i+1] = float32(cg) / 0xffff
+					hdrImg.Pix[i+2] = float32(cb) / 0xffff
+				}
+				i += 3
+			}
+		}
+	}, report)
+	if err != nil {
+		return nil, err
+	}
+
+	return hdrImg, nil
+}
+
+// rgbAt returns the 16-bit alpha-premultiplied channels of the pixel at (x, y)
+func rgbAt(img image.Image, x, y int) (r, g, b uint32) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		r, g, b, _ = m.YCbCrAt(x, y).RGBA()
+	case *image.RGBA:
+		r, g, b, _ = m.RGBAAt(x, y).RGBA()
+	case *image.NRGBA:
+		r, g, b, _ = m.NRGBAAt(x, y).RGBA()
+	case *image.RGBA64:
+		r, g, b, _ = m.RGBA64At(x, y).RGBA()
+	case *image.NRGBA64:
+		r, g, b, _ = m.NRGBA64At(x, y).RGBA()
+	case *image.Gray:
+		r, g, b, _ = m.GrayAt(x, y).RGBA()
+	case *image.Gray16:
+		r, g, b, _ = m.Gray16At(x, y).RGBA()
+	case *image.CMYK:
+		r, g, b, _ = m.CMYKAt(x, y).RGBA()
+	default:
+		r, g, b, _ = img.At(x, y).RGBA()
+	}
+	return r, g, b
+}
@@ -0,0 +1,100 @@
+package imaging
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+)
+
+func TestConvertToHDR(t *testing.T) {
+	rect := image.Rect(0, 0, 3, 2)
+
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = 90
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i] = 110
+		ycbcr.Cr[i] = 150
+	}
+
+	nrgba := image.NewNRGBA(rect)
+	gray16 := image.NewGray16(rect)
+	paletted := image.NewPaletted(rect, color.Palette{color.RGBA{R: 10, G: 20, B: 30, A: 255}})
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			nrgba.SetNRGBA(x, y, color.NRGBA{R: uint8(40 * x), G: 200, B: uint8(60 * y), A: 128})
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(1000 * (x + y))})
+		}
+	}
+
+	for _, img := range []image.Image{ycbcr, nrgba, gray16, paletted, createTestImage(3, 2, color.RGBA{R: 255, G: 64, A: 255})} {
+		hdrImg := ConvertToHDR(img)
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				hr, hg, hb, _ := hdrImg.HDRAt(x, y).HDRRGBA()
+				for _, c := range [][2]float64{
+					{float64(r) / 0xffff, hr},
+					{float64(g) / 0xffff, hg},
+					{float64(b) / 0xffff, hb},
+				} {
+					if math.Abs(c[0]-c[1]) > 1e-6 {
+						t.Errorf("%T pixel (%d, %d): expected %f, got %f", img, x, y, c[0], c[1])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConvertToHDRContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ConvertToHDRContext(ctx, createTestImage(8, 200, color.White), 2)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLinearizeContext(t *testing.T) {
+	radiance := hdr.NewRGB(image.Rect(0, 0, 1, 1))
+	radiance.Pix[0], radiance.Pix[1], radiance.Pix[2] = 0.5, 2, 0
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		curve colorspace.Curve
+		want  [3]float64
+	}{
+		{"srgb", createTestImage(1, 1, color.RGBA{R: 255, G: 128, A: 255}), colorspace.SRGBCurve, [3]float64{1, 0.2158605, 0}},
+		{"linear", createTestImage(1, 1, color.RGBA{R: 255, G: 128, A: 255}), colorspace.LinearCurve, [3]float64{1, 128.0 / 255, 0}},
+		// Channels above 1 are decoded along the curve
+		{"hdr", radiance, colorspace.SRGBCurve, [3]float64{0.2140411, colorspace.SRGBCurve.Decode(2), 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := LinearizeContext(context.Background(), tt.img, tt.curve, 1)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			r, g, b, _ := m.HDRAt(0, 0).HDRRGBA()
+			for c, got := range []float64{r, g, b} {
+				if math.Abs(got-tt.want[c]) > 1e-5 {
+					t.Errorf("Channel %d: expected %f, got %f", c, tt.want[c], got)
+				}
+			}
+		})
+	}
+	if radiance.Pix[0] != 0.5 {
+		t.Error("Expected the HDR input to be left unchanged")
+	}
+}
@@ -0,0 +1,228 @@
+package imaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// thumbnailSize is the edge length of the luminance thumbnails compared by
+// DetectBrackets
+const thumbnailSize = 32
+
+// BracketOptions controls how DetectBrackets splits frames into brackets
+type BracketOptions struct {
+	// MaxGap is the largest time between the captures of consecutive frames
+	// of a bracket. Zero disables the capture time check.
+	MaxGap time.Duration
+	// MinSimilarity is the smallest rank correlation, in [-1, 1], between the
+	// thumbnails of a frame and the first frame of its bracket. Values of
+	// -1 or below disable the similarity check.
+	MinSimilarity float64
+	// MaxSize limits the number of frames per bracket. Zero means no limit.
+	MaxSize int
+}
+
+// DefaultBracketOptions returns the options used by the CLI
+func DefaultBracketOptions() BracketOptions {
+	return BracketOptions{
+		MaxGap:        2 * time.Second,
+		MinSimilarity: 0.5,
+	}
+}
+
+// DetectBrackets groups a flat list of image files into brackets and orders
+// every bracket from darkest to brightest.
+//
+// Frames are visited in capture order (file order when a capture time is
+// missing). A frame starts a new bracket when it was captured more than
+// MaxGap after the previous one, when its exposure already occurs in the
+// current bracket (the camera started the sequence again), or when its
+// content does not resemble the first frame of the bracket. Similarity is
+// the rank correlation of small luminance thumbnails, which is insensitive
+// to the exposure differences within a bracket.
+func DetectBrackets(ctx context.Context, paths []string, opts BracketOptions) ([][]Frame, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no images to group")
+	}
+
+	frames := ReadFrames(paths)
+	for i := range frames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := frames[i].measure(); err != nil {
+			return nil, fmt.Errorf("%s: %w", frames[i].Path, err)
+		}
+	}
+
+	timed := true
+	for _, f := range frames {
+		timed = timed && f.HasMetadata && !f.Metadata.CaptureTime.IsZero()
+	}
+	if timed {
+		sort.SliceStable(frames, func(i, j int) bool {
+			return frames[i].Metadata.CaptureTime.Before(frames[j].Metadata.CaptureTime)
+		})
+	}
+
+	var groups [][]Frame
+	for i, f := range frames {
+		if i == 0 || startsBracket(groups[len(groups)-1], f, opts) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], f)
+	}
+
+	for _, g := range groups {
+		if err := OrderByExposure(g); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// startsBracket reports whether f cannot join the bracket
+func startsBracket(bracket []Frame, f Frame, opts BracketOptions) bool {
+	if opts.MaxSize > 0 && len(bracket) >= opts.MaxSize {
+		return true
+	}
+
+	prev := bracket[len(bracket)-1]
+	if opts.MaxGap > 0 && prev.HasMetadata && f.HasMetadata &&
+		!prev.Metadata.CaptureTime.IsZero() && !f.Metadata.CaptureTime.IsZero() &&
+		f.Metadata.CaptureTime.Sub(prev.Metadata.CaptureTime) > opts.MaxGap {
+		return true
+	}
+
+	for _, b := range bracket {
+		if sameExposure(b, f) {
+			return true
+		}
+	}
+
+	if opts.MinSimilarity > -1 && bracket[0].thumbnail != nil && f.thumbnail != nil {
+		return rankCorrelation(bracket[0].thumbnail, f.thumbnail) < opts.MinSimilarity
+	}
+	return false
+}
+
+// OrderByExposure sorts a bracket from darkest to brightest. It uses the
+// EXIF exposure settings when every frame has them, then the exposure bias,
+// and otherwise the mean brightness of the images, decoding them if needed.
+func OrderByExposure(frames []Frame) error {
+	byExposure, byBias := true, true
+	for _, f := range frames {
+		byExposure = byExposure && f.HasMetadata && f.Metadata.Exposure() > 0
+		byBias = byBias && f.HasMetadata && f.Metadata.HasExposureBias
+	}
+
+	var key func(f Frame) float64
+	switch {
+	case byExposure:
+		key = func(f Frame) float64 { return f.Metadata.Exposure() }
+	case byBias:
+		key = func(f Frame) float64 { return f.Metadata.ExposureBias }
+	default:
+		for i := range frames {
+			if err := frames[i].measure(); err != nil {
+				return fmt.Errorf("%s: %w", frames[i].Path, err)
+			}
+		}
+		key = func(f Frame) float64 { return f.Brightness }
+	}
+
+	sort.SliceStable(frames, func(i, j int) bool {
+		return key(frames[i]) < key(frames[j])
+	})
+	return nil
+}
+
+// measure decodes the frame to compute its thumbnail and brightness, unless
+// this was already done
+func (f *Frame) measure() error {
+	if f.thumbnail != nil {
+		return nil
+	}
+
+	img, err := decodeImage(f.Path)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	thumb := make([]float64, thumbnailSize*thumbnailSize)
+	counts := make([]int, len(thumb))
+	// Sample a grid of at most 4 pixels per thumbnail cell along each axis
+	step := max(1, min(bounds.Dx(), bounds.Dy())/(4*thumbnailSize))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		ty := (y - bounds.Min.Y) * thumbnailSize / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			tx := (x - bounds.Min.X) * thumbnailSize / bounds.Dx()
+			r, g, b := rgbAt(img, x, y)
+			thumb[ty*thumbnailSize+tx] += (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+			counts[ty*thumbnailSize+tx]++
+		}
+	}
+
+	sum := 0.0
+	for i := range thumb {
+		if counts[i] > 0 {
+			thumb[i] /= float64(counts[i])
+		}
+		sum += thumb[i]
+	}
+	f.thumbnail = thumb
+	f.Brightness = sum / float64(len(thumb))
+	return nil
+}
+
+// rankCorrelation returns the Spearman rank correlation of a and b
+func rankCorrelation(a, b []float64) float64 {
+	ra, rb := ranks(a), ranks(b)
+	mean := float64(len(a)-1) / 2
+
+	var cov, va, vb float64
+	for i := range ra {
+		da, db := ra[i]-mean, rb[i]-mean
+		cov += da * db
+		va += da * da
+		vb += db * db
+	}
+	if va == 0 || vb == 0 {
+		// A flat image carries no structure to compare; only another flat
+		// image resembles it
+		if va == vb {
+			return 1
+		}
+		return 0
+	}
+	return cov / math.Sqrt(va*vb)
+}
+
+// ranks returns the 0-based rank of each value, giving tied values the mean
+// of their ranks so clipped areas do not create spurious order
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return values[order[i]] < values[order[j]] })
+
+	r := make([]float64, len(values))
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && values[order[end]] == values[order[start]] {
+			end++
+		}
+		rank := float64(start+end-1) / 2
+		for _, i := range order[start:end] {
+			r[i] = rank
+		}
+		start = end
+	}
+	return r
+}
@@ -0,0 +1,214 @@
+package imaging
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeScene writes an exposure of a synthetic scene. Scene "x" brightens
+// from left to right and scene "y" from top to bottom.
+func writeScene(t *testing.T, dir, name, scene string, exposure float64) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			base := float64(x) / 64
+			if scene == "y" {
+				base = float64(y) / 48
+			}
+			v := uint8(math.Min(255, 255*base*exposure))
+			img.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+
+	path := filepath.Join(dir, name+".png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// baseNames returns the file names, without directories, of each bracket
+func baseNames(groups [][]Frame) [][]string {
+	out := make([][]string, len(groups))
+	for i, g := range groups {
+		for _, f := range g {
+			out[i] = append(out[i], filepath.Base(f.Path))
+		}
+	}
+	return out
+}
+
+func TestDetectBracketsBySimilarity(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		writeScene(t, dir, "1", "x", 0.5),
+		writeScene(t, dir, "2", "x", 2),
+		writeScene(t, dir, "3", "x", 1),
+		writeScene(t, dir, "4", "y", 1),
+		writeScene(t, dir, "5", "y", 0.5),
+		writeScene(t, dir, "6", "y", 2),
+	}
+
+	groups, err := DetectBrackets(context.Background(), paths, DefaultBracketOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Each scene forms a bracket ordered from darkest to brightest
+	want := [][]string{{"1.png", "3.png", "2.png"}, {"5.png", "4.png", "6.png"}}
+	if got := baseNames(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestDetectBracketsByMetadata(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 17, 14, 0, 0, 0, time.UTC)
+	bias := func(ev int32) *[2]int32 { return &[2]int32{ev, 1} }
+
+	frames := []struct {
+		name   string
+		offset time.Duration
+		bias   int32
+	}{
+		// Two bursts of the same bias sequence, one second apart
+		{"a.jpg", 0, 0}, {"b.jpg", 300 * time.Millisecond, -2}, {"c.jpg", 600 * time.Millisecond, 2},
+		{"d.jpg", time.Second, 0}, {"e.jpg", 1300 * time.Millisecond, -2}, {"f.jpg", 1600 * time.Millisecond, 2},
+		// A lone frame taken much later
+		{"g.jpg", time.Minute, 0},
+	}
+
+	var paths []string
+	// Write the files in reverse so capture time, not file order, decides
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		path := filepath.Join(dir, f.name)
+		writeExifJPEG(t, path, 128, testExif{captureTime: base.Add(f.offset), bias: bias(f.bias)})
+		paths = append(paths, path)
+	}
+
+	groups, err := DetectBrackets(context.Background(), paths, DefaultBracketOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]string{{"b.jpg", "a.jpg", "c.jpg"}, {"e.jpg", "d.jpg", "f.jpg"}, {"g.jpg"}}
+	if got := baseNames(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestDetectBracketsManual(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 17, 14, 0, 0, 0, time.UTC)
+
+	// Two manual brackets: the bias reads 0 on every frame and only the
+	// shutter speed changes
+	shutters := [][2]uint32{{1, 60}, {1, 250}, {1, 15}, {1, 60}, {1, 250}, {1, 15}}
+	var paths []string
+	for i, shutter := range shutters {
+		path := filepath.Join(dir, string(rune('a'+i))+".jpg")
+		writeExifJPEG(t, path, 128, testExif{
+			captureTime:  base.Add(time.Duration(i) * 400 * time.Millisecond),
+			exposureTime: shutter,
+			fNumber:      [2]uint32{8, 1},
+			iso:          100,
+			bias:         &[2]int32{0, 1},
+		})
+		paths = append(paths, path)
+	}
+
+	groups, err := DetectBrackets(context.Background(), paths, DefaultBracketOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]string{{"b.jpg", "a.jpg", "c.jpg"}, {"e.jpg", "d.jpg", "f.jpg"}}
+	if got := baseNames(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestDetectBracketsMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, e := range []float64{0.25, 0.5, 1, 2} {
+		paths = append(paths, writeScene(t, dir, string(rune('a'+i)), "x", e))
+	}
+
+	opts := DefaultBracketOptions()
+	opts.MaxSize = 2
+	groups, err := DetectBrackets(context.Background(), paths, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Errorf("Expected 2 brackets, got %v", baseNames(groups))
+	}
+}
+
+func TestOrderByExposure(t *testing.T) {
+	dir := t.TempDir()
+	frames := []Frame{
+		{Path: writeScene(t, dir, "bright", "x", 2)},
+		{Path: writeScene(t, dir, "dark", "x", 0.5)},
+		{Path: writeScene(t, dir, "mid", "x", 1)},
+	}
+
+	if err := OrderByExposure(frames); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"dark.png", "mid.png", "bright.png"}
+	var got []string
+	for _, f := range frames {
+		got = append(got, filepath.Base(f.Path))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// EXIF exposure settings take precedence over the measured brightness
+	frames[0].HasMetadata, frames[0].Metadata = true, Metadata{ExposureTime: 1}
+	frames[1].HasMetadata, frames[1].Metadata = true, Metadata{ExposureTime: 0.5}
+	frames[2].HasMetadata, frames[2].Metadata = true, Metadata{ExposureTime: 0.25}
+	if err := OrderByExposure(frames); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := filepath.Base(frames[0].Path); got != "bright.png" {
+		t.Errorf("Expected bright.png first by exposure time, got %s", got)
+	}
+}
+
+func TestRankCorrelation(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	tests := []struct {
+		name string
+		b    []float64
+		want float64
+	}{
+		{"monotonic transform", []float64{1, 4, 9, 16, 25}, 1},
+		{"reversed", []float64{5, 4, 3, 2, 1}, -1},
+		{"flat", []float64{2, 2, 2, 2, 2}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankCorrelation(a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package imaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+// Transfer identifies the transfer function of an HDR display image by its
+// ITU-T H.273 code
+type Transfer uint8
+
+// Transfer functions of ITU-R BT.2100
+const (
+	// TransferPQ is SMPTE ST 2084, the perceptual quantizer
+	TransferPQ Transfer = 16
+	// TransferHLG is hybrid log-gamma (ARIB STD-B67)
+	TransferHLG Transfer = 18
+)
+
+// h273BT2020 is the H.273 code of the BT.2020 primaries
+const h273BT2020 = 9
+
+// DisplayFormats lists the extensions of the formats HDR display images can
+// be saved in
+var DisplayFormats = map[string]bool{
+	".png":  true,
+	".tif":  true,
+	".tiff": true,
+}
+
+// IsDisplayFormat reports whether filepath names a format HDR display images
+// can be saved in
+func IsDisplayFormat(filepath string) bool {
+	return DisplayFormats[strings.ToLower(path.Ext(filepath))]
+}
+
+// SaveDisplayImage saves an HDR display image, whose channels hold signal
+// values of transfer in BT.2020 primaries. PNG files are 16-bit with a cICP
+// chunk identifying the encoding; TIFF files have bits (10 or 16) per
+// sample and no tag for the transfer function.
+func SaveDisplayImage(img *image.RGBA64, outputPath string, transfer Transfer, bits int) error {
+	ext := strings.ToLower(path.Ext(outputPath))
+	if !DisplayFormats[ext] {
+		return errors.New("unsupported HDR output format: " + ext + ". Supported formats: PNG, TIFF")
+	}
+	if bits != 10 && bits != 16 {
+		return fmt.Errorf("unsupported bit depth %d (10, 16)", bits)
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if ext == ".png" {
+		// Opaque 16-bit images are written as 16-bit RGB
+		cicp := []byte{h273BT2020, byte(transfer), 0, 1}
+		return encodePNGChunks(file, opaque(img), png.DefaultCompression, pngChunk{"cICP", cicp})
+	}
+	w := bufio.NewWriter(file)
+	if err := writeTIFF(w, img, bits); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// opaque returns img, or a copy of it with every alpha value set to fully
+// opaque if it has transparent pixels
+func opaque(img *image.RGBA64) *image.RGBA64 {
+	if img.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	out := image.NewRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		dst := out.Pix[out.PixOffset(b.Min.X, y):]
+		copy(dst, src)
+		for o := 0; o < len(src); o += 8 {
+			dst[o+6], dst[o+7] = 0xff, 0xff
+		}
+	}
+	return out
+}
+
+// TIFF tags and field types
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanarConfig    = 284
+
+	tiffShort = 3
+	tiffLong  = 4
+)
+
+// writeTIFF writes img as an uncompressed little-endian RGB TIFF with bits
+// per sample. 10-bit samples are packed most significant bit first, and
+// every row starts on a byte boundary.
+func writeTIFF(w io.Writer, img *image.RGBA64, bits int) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	rowBytes := (width*3*bits + 7) / 8
+
+	type entry struct {
+		tag, typ uint16
+		value    uint32
+	}
+	const entries = 10
+	ifdSize := 2 + 12*entries + 4
+	bitsOffset := 8 + ifdSize
+	dataOffset := bitsOffset + 6
+	dataSize := int64(rowBytes) * int64(height)
+	if int64(dataOffset)+dataSize > math.MaxUint32 {
+		return fmt.Errorf("%dx%d image is too large for TIFF", width, height)
+	}
+
+	ifd := []entry{
+		{tiffImageWidth, tiffLong, uint32(width)},
+		{tiffImageLength, tiffLong, uint32(height)},
+		{tiffBitsPerSample, tiffShort, uint32(bitsOffset)},
+		{tiffCompression, tiffShort, 1},
+		{tiffPhotometric, tiffShort, 2},
+		{tiffStripOffsets, tiffLong, uint32(dataOffset)},
+		{tiffSamplesPerPixel, tiffShort, 3},
+		{tiffRowsPerStrip, tiffLong, uint32(height)},
+		{tiffStripByteCounts, tiffLong, uint32(dataSize)},
+		{tiffPlanarConfig, tiffShort, 1},
+	}
+
+	header := make([]byte, 0, dataOffset)
+	le := binary.LittleEndian
+	header = append(header, 'I', 'I', 42, 0)
+	header = le.AppendUint32(header, 8)
+	header = le.AppendUint16(header, entries)
+	for _, e := range ifd {
+		header = le.AppendUint16(header, e.tag)
+		header = le.AppendUint16(header, e.typ)
+		// BitsPerSample holds three values, stored after the directory
+		count := uint32(1)
+		if e.tag == tiffBitsPerSample {
+			count = 3
+		}
+		header = le.AppendUint32(header, count)
+		if e.typ == tiffShort && count == 1 {
+			header = le.AppendUint16(header, uint16(e.value))
+			header = le.AppendUint16(header, 0)
+		} else {
+			header = le.AppendUint32(header, e.value)
+		}
+	}
+	header = le.AppendUint32(header, 0)
+	for range 3 {
+		header = le.AppendUint16(header, uint16(bits))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]byte, rowBytes)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		if bits == 16 {
+			for i := 0; i < width*3; i++ {
+				p := pix[8*(i/3)+2*(i%3):]
+				row[2*i], row[2*i+1] = p[1], p[0]
+			}
+		} else {
+			packTenBits(row, pix)
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packTenBits packs the RGB channels of a row of 16-bit RGBA pixels into
+// 10-bit samples, most significant bit first
+func packTenBits(dst, pix []byte) {
+	clear(dst)
+	bit := 0
+	for i := 0; i < len(pix); i += 8 {
+		for c := 0; c < 6; c += 2 {
+			v := uint32(pix[i+c])<<8 | uint32(pix[i+c+1])
+			v = (v*1023 + 0x7fff) / 0xffff
+			// Spread the 10 bits over the two or three bytes they touch
+			shifted := v << (22 - bit%8)
+			dst[bit/8] |= byte(shifted >> 24)
+			dst[bit/8+1] |= byte(shifted >> 16)
+			if bit/8+2 < len(dst) {
+				dst[bit/8+2] |= byte(shifted >> 8)
+			}
+			bit += 10
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package imaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// displayTestImage returns an image whose channels span the 16-bit range
+func displayTestImage(width, height int) *image.RGBA64 {
+	img := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint16((x + y*width) * 0xffff / (width*height - 1))
+			img.SetRGBA64(x, y, color.RGBA64{v, 0xffff - v, v / 2, 0xffff})
+		}
+	}
+	return img
+}
+
+func TestSaveDisplayImagePNG(t *testing.T) {
+	img := displayTestImage(7, 5)
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := SaveDisplayImage(img, path, TransferHLG, 16); err != nil {
+		t.Fatalf("SaveDisplayImage failed: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var cicp []byte
+	err = pngChunks(bufio.NewReader(file), 4, func(typ string) bool { return typ == "cICP" }, func(_ string, data []byte) bool {
+		cicp = data
+		return false
+	})
+	if err != nil {
+		t.Fatalf("Failed to read chunks: %v", err)
+	}
+	if want := []byte{9, 18, 0, 1}; string(cicp) != string(want) {
+		t.Errorf("Expected cICP %v, got %v", want, cicp)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IHDR holds the bit depth and color type after the dimensions
+	if depth, colorType := data[24], data[25]; depth != 16 || colorType != 2 {
+		t.Errorf("Expected 16-bit RGB, got depth %d and color type %d", depth, colorType)
+	}
+	if _, err := LoadImage(path); err != nil {
+		t.Errorf("Failed to decode output: %v", err)
+	}
+}
+
+func TestSaveDisplayImageTIFF16(t *testing.T) {
+	img := displayTestImage(9, 4)
+	path := filepath.Join(t.TempDir(), "out.tif")
+	if err := SaveDisplayImage(img, path, TransferPQ, 16); err != nil {
+		t.Fatalf("SaveDisplayImage failed: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got, err := tiff.Decode(file)
+	if err != nil {
+		t.Fatalf("Failed to decode TIFF: %v", err)
+	}
+	if got.Bounds() != img.Bounds() {
+		t.Fatalf("Expected bounds %v, got %v", img.Bounds(), got.Bounds())
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 9; x++ {
+			if w, g := color.RGBA64Model.Convert(img.At(x, y)), color.RGBA64Model.Convert(got.At(x, y)); w != g {
+				t.Fatalf("Pixel (%d, %d): expected %v, got %v", x, y, w, g)
+			}
+		}
+	}
+}
+
+func TestSaveDisplayImageTIFF10(t *testing.T) {
+	img := displayTestImage(5, 3)
+	path := filepath.Join(t.TempDir(), "out.tiff")
+	if err := SaveDisplayImage(img, path, TransferPQ, 10); err != nil {
+		t.Fatalf("SaveDisplayImage failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[uint16]uint32{}
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	for i := 0; i < n; i++ {
+		e := data[int(ifd)+2+12*i:]
+		v := binary.LittleEndian.Uint32(e[8:])
+		if binary.LittleEndian.Uint16(e[2:]) == tiffShort && binary.LittleEndian.Uint32(e[4:]) == 1 {
+			v = uint32(binary.LittleEndian.Uint16(e[8:]))
+		}
+		tags[binary.LittleEndian.Uint16(e)] = v
+	}
+	if bits := binary.LittleEndian.Uint16(data[tags[tiffBitsPerSample]:]); bits != 10 {
+		t.Errorf("Expected 10 bits per sample, got %d", bits)
+	}
+	rowBytes := (5*3*10 + 7) / 8
+	if tags[tiffStripByteCounts] != uint32(3*rowBytes) {
+		t.Errorf("Expected %d bytes of pixels, got %d", 3*rowBytes, tags[tiffStripByteCounts])
+	}
+
+	// Unpack the samples and compare them with the 16-bit values
+	pix := data[tags[tiffStripOffsets]:]
+	for y := 0; y < 3; y++ {
+		row := pix[y*rowBytes:]
+		for i := 0; i < 15; i++ {
+			bit := 10 * i
+			v := (uint32(row[bit/8])<<16 | uint32(row[bit/8+1])<<8 | uint32(get(row, bit/8+2))) >> (14 - bit%8) & 0x3ff
+			r, g, b, _ := img.At(i/3, y).RGBA()
+			want := []uint32{r, g, b}[i%3]
+			if want = (want*1023 + 0x7fff) / 0xffff; v != want {
+				t.Fatalf("Sample %d of row %d: expected %d, got %d", i, y, want, v)
+			}
+		}
+	}
+}
+
+// get returns b[i], or zero past the end of b
+func get(b []byte, i int) byte {
+	if i < len(b) {
+		return b[i]
+	}
+	return 0
+}
+
+func TestSaveDisplayImageErrors(t *testing.T) {
+	img := displayTestImage(2, 2)
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		bits int
+	}{
+		{"out.jpg", 16},
+		{"out.tif", 12},
+	} {
+		if err := SaveDisplayImage(img, filepath.Join(dir, tt.name), TransferPQ, tt.bits); err == nil {
+			t.Errorf("%s with %d bits: expected error, got nil", tt.name, tt.bits)
+		}
+	}
+}
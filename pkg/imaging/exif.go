@@ -0,0 +1,364 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNoMetadata is returned by ReadMetadata when a file carries no EXIF data
+var ErrNoMetadata = errors.New("no EXIF metadata")
+
+// Metadata holds the EXIF capture settings relevant to bracketing and color
+// management. Fields missing from the file are left at their zero value.
+type Metadata struct {
+	// CaptureTime is DateTimeOriginal (or DateTime) including sub-seconds.
+	// EXIF times carry no zone, so they are returned as UTC.
+	CaptureTime time.Time
+	// ExposureTime is the shutter speed in seconds
+	ExposureTime float64
+	// FNumber is the aperture f-number
+	FNumber float64
+	// ISO is the ISO speed rating
+	ISO int
+	// ExposureBias is the exposure compensation in EV
+	ExposureBias float64
+	// HasExposureBias reports whether ExposureBias was present
+	HasExposureBias bool
+	// ColorSpace is the EXIF ColorSpace tag: 1 for sRGB, 0xffff for
+	// anything else
+	ColorSpace int
+	// Interoperability is the interoperability index: R98 for sRGB files,
+	// R03 for Adobe RGB files
+	Interoperability string
+}
+
+// Exposure returns the relative amount of light recorded with these
+// settings, proportional to exposure time × ISO / f-number². It returns 0
+// when the exposure time is unknown.
+func (m Metadata) Exposure() float64 {
+	if m.ExposureTime <= 0 {
+		return 0
+	}
+	e := m.ExposureTime
+	if m.FNumber > 0 {
+		e /= m.FNumber * m.FNumber
+	}
+	if m.ISO > 0 {
+		e *= float64(m.ISO) / 100
+	}
+	return e
+}
+
+// ReadMetadata reads the EXIF metadata of a JPEG or PNG file. It returns
+// ErrNoMetadata if the file has none.
+func ReadMetadata(filepath string) (Metadata, error) {
+	ext := strings.ToLower(path.Ext(filepath))
+	if !SupportedFormats[ext] {
+		return Metadata{}, errors.New("unsupported image format: " + ext + ". Supported formats: PNG, JPEG")
+	}
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer file.Close()
+
+	var tiff []byte
+	if ext == ".png" {
+		tiff, err = pngExif(bufio.NewReader(file))
+	} else {
+		tiff, err = jpegExif(bufio.NewReader(file))
+	}
+	if err != nil {
+		return Metadata{}, err
+	}
+	if tiff == nil {
+		return Metadata{}, ErrNoMetadata
+	}
+	return parseExif(tiff)
+}
+
+// jpegExif returns the TIFF structure of the Exif APP1 segment, or nil
+func jpegExif(r *bufio.Reader) ([]byte, error) {
+	var tiff []byte
+	err := jpegSegments(r, func(marker byte, segment []byte) bool {
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			tiff = segment[6:]
+			return false
+		}
+		return true
+	})
+	return tiff, err
+}
+
+// jpegSegments calls fn with the marker and data of every segment before
+// the image data, until fn returns false
+func jpegSegments(r *bufio.Reader, fn func(marker byte, segment []byte) bool) error {
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xff, 0xd8} {
+		return errors.New("jpeg: missing SOI marker")
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return err
+		}
+		if marker[0] != 0xff {
+			return errors.New("jpeg: invalid marker")
+		}
+		// Start of scan or end of image: no metadata follows
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil
+		}
+		// Fill bytes and markers without a length
+		if marker[1] == 0xff || marker[1] == 0x01 || (marker[1] >= 0xd0 && marker[1] <= 0xd7) {
+			continue
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return err
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return errors.New("jpeg: invalid segment length")
+		}
+		segment := make([]byte, n)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return err
+		}
+		if !fn(marker[1], segment) {
+			return nil
+		}
+	}
+}
+
+// maxExifBytes limits the eXIf chunk read from a PNG file. JPEG files cannot
+// hold more in their APP1 segment either.
+const maxExifBytes = 64 << 10
+
+// pngExif returns the contents of the eXIf chunk, or nil
+func pngExif(r *bufio.Reader) ([]byte, error) {
+	var data []byte
+	err := pngChunks(r, maxExifBytes, func(typ string) bool { return typ == "eXIf" }, func(_ string, chunk []byte) bool {
+		data = chunk
+		return false
+	})
+	return data, err
+}
+
+// pngChunks calls fn with the type and data of every chunk before the image
+// data that want accepts, until fn returns false. The data of other chunks
+// is skipped, and accepted chunks longer than limit bytes are an error, as
+// the length comes from the file.
+func pngChunks(r *bufio.Reader, limit uint32, want func(typ string) bool, fn func(typ string, data []byte) bool) error {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || string(sig) != string(pngSignature) {
+		return errors.New("png: invalid signature")
+	}
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(header[:4])
+		typ := string(header[4:])
+		if typ == "IDAT" || typ == "IEND" {
+			// Metadata must precede the image data
+			return nil
+		}
+		if want(typ) {
+			if n > limit {
+				return fmt.Errorf("png: %s chunk of %d bytes exceeds the limit of %d", typ, n, limit)
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			if !fn(typ, data) {
+				return nil
+			}
+			n = 0
+		}
+		// Skip the rest of the chunk and its CRC
+		if _, err := io.CopyN(io.Discard, r, int64(n)+4); err != nil {
+			return err
+		}
+	}
+}
+
+// EXIF tags read by parseExif
+const (
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagExposureTime       = 0x829a
+	tagFNumber            = 0x829d
+	tagISO                = 0x8827
+	tagDateTimeOriginal   = 0x9003
+	tagExposureBias       = 0x9204
+	tagSubSecTimeOriginal = 0x9291
+	tagColorSpace         = 0xa001
+	tagInteropIFD         = 0xa005
+	tagInteropIndex       = 0x0001
+)
+
+// exifTimeLayout is the layout of EXIF date and time strings
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// tiffEntry is an IFD entry whose value has been located in the TIFF data
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// parseExif extracts Metadata from a TIFF structure
+func parseExif(tiff []byte) (Metadata, error) {
+	if len(tiff) < 8 {
+		return Metadata{}, errors.New("exif: truncated header")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return Metadata{}, errors.New("exif: invalid byte order")
+	}
+
+	ifd0, err := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	if err != nil {
+		return Metadata{}, err
+	}
+	entries := ifd0
+	if e, ok := ifd0[tagExifIFD]; ok && len(e.value) >= 4 {
+		exif, err := readIFD(tiff, order, order.Uint32(e.value))
+		if err != nil {
+			return Metadata{}, err
+		}
+		for tag, e := range exif {
+			entries[tag] = e
+		}
+	}
+
+	var m Metadata
+	datetime, ok := entries[tagDateTimeOriginal]
+	if !ok {
+		datetime, ok = entries[tagDateTime]
+	}
+	if ok {
+		if t, err := time.Parse(exifTimeLayout, asciiValue(datetime)); err == nil {
+			if sub, ok := entries[tagSubSecTimeOriginal]; ok {
+				if frac, err := time.ParseDuration("0." + asciiValue(sub) + "s"); err == nil {
+					t = t.Add(frac)
+				}
+			}
+			m.CaptureTime = t
+		}
+	}
+	if e, ok := entries[tagExposureTime]; ok {
+		m.ExposureTime = rationalValue(e, order)
+	}
+	if e, ok := entries[tagFNumber]; ok {
+		m.FNumber = rationalValue(e, order)
+	}
+	if e, ok := entries[tagISO]; ok {
+		m.ISO = int(integerValue(e, order))
+	}
+	if e, ok := entries[tagExposureBias]; ok {
+		m.ExposureBias = rationalValue(e, order)
+		m.HasExposureBias = true
+	}
+	if e, ok := entries[tagColorSpace]; ok {
+		m.ColorSpace = int(integerValue(e, order))
+	}
+	if e, ok := entries[tagInteropIFD]; ok && len(e.value) >= 4 {
+		// A broken interoperability IFD does not spoil the rest
+		if interop, err := readIFD(tiff, order, order.Uint32(e.value)); err == nil {
+			if e, ok := interop[tagInteropIndex]; ok {
+				m.Interoperability = asciiValue(e)
+			}
+		}
+	}
+	return m, nil
+}
+
+// tiffTypeSizes is the size in bytes of each TIFF field type
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// readIFD reads the entries of the image file directory at offset
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, errors.New("exif: IFD offset out of range")
+	}
+	n := uint32(order.Uint16(tiff[offset:]))
+	if uint64(offset)+2+12*uint64(n) > uint64(len(tiff)) {
+		return nil, errors.New("exif: truncated IFD")
+	}
+
+	entries := make(map[uint16]tiffEntry, n)
+	for i := uint32(0); i < n; i++ {
+		raw := tiff[offset+2+12*i:]
+		tag, typ, count := order.Uint16(raw), order.Uint16(raw[2:]), order.Uint32(raw[4:])
+
+		size, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(count)
+		value := raw[8:12]
+		if total > 4 {
+			start := uint64(order.Uint32(raw[8:]))
+			if start+total > uint64(len(tiff)) {
+				return nil, fmt.Errorf("exif: tag %#x value out of range", tag)
+			}
+			value = tiff[start : start+total]
+		}
+		entries[tag] = tiffEntry{typ: typ, count: count, value: value[:total]}
+	}
+	return entries, nil
+}
+
+// asciiValue returns an ASCII value without its NUL terminator and padding
+func asciiValue(e tiffEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// rationalValue returns the first value of a RATIONAL or SRATIONAL entry
+func rationalValue(e tiffEntry, order binary.ByteOrder) float64 {
+	if len(e.value) < 8 {
+		return 0
+	}
+	num, den := order.Uint32(e.value), order.Uint32(e.value[4:])
+	if den == 0 {
+		return 0
+	}
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den))
+	}
+	return float64(num) / float64(den)
+}
+
+// integerValue returns the first value of a SHORT or LONG entry
+func integerValue(e tiffEntry, order binary.ByteOrder) uint32 {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(order.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return order.Uint32(e.value)
+	}
+	return 0
+}
@@ -0,0 +1,243 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testExif describes the EXIF fields written by encodeExif
+type testExif struct {
+	captureTime  time.Time
+	exposureTime [2]uint32
+	fNumber      [2]uint32
+	iso          uint16
+	bias         *[2]int32
+	colorSpace   uint16
+	// interop is the interoperability index, written in its own IFD
+	interop string
+}
+
+// encodeExif builds a big-endian TIFF structure with an IFD0 that points to
+// an Exif IFD holding the fields of e
+func encodeExif(e testExif) []byte {
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    []byte
+	}
+	be := binary.BigEndian
+	rational := func(v [2]uint32) []byte {
+		return be.AppendUint32(be.AppendUint32(nil, v[0]), v[1])
+	}
+
+	var exif []entry
+	if !e.captureTime.IsZero() {
+		s := append([]byte(e.captureTime.Format(exifTimeLayout)), 0)
+		exif = append(exif, entry{tagDateTimeOriginal, 2, uint32(len(s)), s})
+		if ms := e.captureTime.Nanosecond() / 1e6; ms > 0 {
+			sub := []byte{byte('0' + ms/100), byte('0' + ms/10%10), byte('0' + ms%10), 0}
+			exif = append(exif, entry{tagSubSecTimeOriginal, 2, 4, sub})
+		}
+	}
+	if e.exposureTime[1] != 0 {
+		exif = append(exif, entry{tagExposureTime, 5, 1, rational(e.exposureTime)})
+	}
+	if e.fNumber[1] != 0 {
+		exif = append(exif, entry{tagFNumber, 5, 1, rational(e.fNumber)})
+	}
+	if e.iso != 0 {
+		exif = append(exif, entry{tagISO, 3, 1, be.AppendUint16(nil, e.iso)})
+	}
+	if e.bias != nil {
+		exif = append(exif, entry{tagExposureBias, 10, 1, rational([2]uint32{uint32(e.bias[0]), uint32(e.bias[1])})})
+	}
+	if e.colorSpace != 0 {
+		exif = append(exif, entry{tagColorSpace, 3, 1, be.AppendUint16(nil, e.colorSpace)})
+	}
+	if e.interop != "" {
+		// Patched below with the offset of the interoperability IFD
+		exif = append(exif, entry{tagInteropIFD, 4, 1, nil})
+	}
+	sort.Slice(exif, func(i, j int) bool { return exif[i].tag < exif[j].tag })
+
+	// Header, IFD0 with a single Exif IFD pointer, the Exif IFD, its values,
+	// then the interoperability IFD
+	const ifd0 = 8
+	exifOffset := uint32(ifd0 + 2 + 12 + 4)
+	data := exifOffset + 2 + 12*uint32(len(exif)) + 4
+	interopOffset := data
+	for _, en := range exif {
+		if len(en.value) > 4 {
+			interopOffset += uint32(len(en.value))
+		}
+	}
+	for i := range exif {
+		if exif[i].tag == tagInteropIFD {
+			exif[i].value = be.AppendUint32(nil, interopOffset)
+		}
+	}
+
+	buf := []byte("MM\x00\x2a")
+	buf = be.AppendUint32(buf, ifd0)
+	buf = be.AppendUint16(buf, 1)
+	buf = be.AppendUint16(buf, tagExifIFD)
+	buf = be.AppendUint16(buf, 4)
+	buf = be.AppendUint32(buf, 1)
+	buf = be.AppendUint32(buf, exifOffset)
+	buf = be.AppendUint32(buf, 0)
+
+	var values []byte
+	buf = be.AppendUint16(buf, uint16(len(exif)))
+	for _, en := range exif {
+		buf = be.AppendUint16(buf, en.tag)
+		buf = be.AppendUint16(buf, en.typ)
+		buf = be.AppendUint32(buf, en.count)
+		if len(en.value) <= 4 {
+			buf = append(buf, append(en.value, make([]byte, 4-len(en.value))...)...)
+			continue
+		}
+		buf = be.AppendUint32(buf, data+uint32(len(values)))
+		values = append(values, en.value...)
+	}
+	buf = be.AppendUint32(buf, 0)
+	buf = append(buf, values...)
+
+	if e.interop != "" {
+		buf = be.AppendUint16(buf, 1)
+		buf = be.AppendUint16(buf, tagInteropIndex)
+		buf = be.AppendUint16(buf, 2)
+		buf = be.AppendUint32(buf, 4)
+		buf = append(buf, append([]byte(e.interop), 0)...)
+		buf = be.AppendUint32(buf, 0)
+	}
+	return buf
+}
+
+// writeExifJPEG writes a small JPEG with an Exif APP1 segment holding e
+func writeExifJPEG(t *testing.T, path string, value uint8, e testExif) {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, createTestImage(8, 8, color.Gray{Y: value}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := append([]byte("Exif\x00\x00"), encodeExif(e)...)
+	out := append([]byte{0xff, 0xd8, 0xff, 0xe1}, byte((len(segment)+2)>>8), byte(len(segment)+2))
+	out = append(out, segment...)
+	out = append(out, img.Bytes()[2:]...)
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeExifPNG writes a small PNG with an eXIf chunk holding e
+func writeExifPNG(t *testing.T, path string, e testExif) {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, createTestImage(8, 8, color.Gray{Y: 128})); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert the chunk right after IHDR (signature + 25 bytes)
+	data := encodeExif(e)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	raw := img.Bytes()
+	out := append(append(append([]byte(nil), raw[:33]...), chunk...), raw[33:]...)
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	captured := time.Date(2024, 5, 17, 14, 3, 21, 250e6, time.UTC)
+	e := testExif{
+		captureTime:  captured,
+		exposureTime: [2]uint32{1, 125},
+		fNumber:      [2]uint32{80, 10},
+		iso:          200,
+		bias:         &[2]int32{-2, 1},
+		colorSpace:   0xffff,
+		interop:      "R03",
+	}
+
+	jpegPath := filepath.Join(dir, "frame.jpg")
+	writeExifJPEG(t, jpegPath, 128, e)
+	pngPath := filepath.Join(dir, "frame.png")
+	writeExifPNG(t, pngPath, e)
+
+	for _, path := range []string{jpegPath, pngPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			m, err := ReadMetadata(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !m.CaptureTime.Equal(captured) {
+				t.Errorf("Expected capture time %v, got %v", captured, m.CaptureTime)
+			}
+			if m.ExposureTime != 1.0/125 || m.FNumber != 8 || m.ISO != 200 {
+				t.Errorf("Unexpected exposure settings: %+v", m)
+			}
+			if !m.HasExposureBias || m.ExposureBias != -2 {
+				t.Errorf("Expected exposure bias -2, got %v (present: %v)", m.ExposureBias, m.HasExposureBias)
+			}
+			if m.ColorSpace != 0xffff || m.Interoperability != "R03" {
+				t.Errorf("Expected an uncalibrated R03 color space, got %d %q", m.ColorSpace, m.Interoperability)
+			}
+			if want := 1.0 / 125 / 64 * 2; math.Abs(m.Exposure()-want) > 1e-12 {
+				t.Errorf("Expected exposure %g, got %g", want, m.Exposure())
+			}
+		})
+	}
+}
+
+func TestReadMetadataMissing(t *testing.T) {
+	for _, format := range []string{"png", "jpeg"} {
+		path, cleanup := createTestImageFile(t, format, color.Gray{Y: 10})
+		defer cleanup()
+
+		if _, err := ReadMetadata(path); !errors.Is(err, ErrNoMetadata) {
+			t.Errorf("%s: expected ErrNoMetadata, got %v", format, err)
+		}
+	}
+}
+
+func TestPNGChunksLimit(t *testing.T) {
+	// Chunk headers claiming ~4 GiB of data, with none following
+	chunk := func(typ string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, 0xfffffff0), typ...)
+	}
+	tests := []struct {
+		name  string
+		chunk []byte
+	}{
+		{"wanted", chunk("eXIf")},
+		{"skipped", chunk("tEXt")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(append([]byte(nil), pngSignature...), tt.chunk...)
+			if _, err := pngExif(bufio.NewReader(bytes.NewReader(data))); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
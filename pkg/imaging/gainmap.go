@@ -0,0 +1,406 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// ErrNoGainMap is returned by ReadGainMap for JPEG files without a gain map
+var ErrNoGainMap = errors.New("no gain map")
+
+// Namespaces and segment headers of gain map JPEG files
+const (
+	hdrgmNS   = "http://ns.adobe.com/hdr-gain-map/1.0/"
+	xmpHeader = "http://ns.adobe.com/xap/1.0/\x00"
+	mpfHeader = "MPF\x00"
+)
+
+// GainMapMetadata describes how a gain map turns the SDR base image into
+// an HDR rendition, following the Adobe gain map specification used by
+// Ultra HDR. Gains and capacities are in stops (log2 units).
+type GainMapMetadata struct {
+	// GainMapMin and GainMapMax are the gains of the darkest and brightest
+	// gain map values
+	GainMapMin, GainMapMax float64
+	// Gamma is applied to the normalized gains before quantizing them
+	Gamma float64
+	// OffsetSDR and OffsetHDR are added to linear values before taking
+	// their ratio, so black pixels get finite gains
+	OffsetSDR, OffsetHDR float64
+	// HDRCapacityMin and HDRCapacityMax bound the display headroom over
+	// which the gain map is phased in
+	HDRCapacityMin, HDRCapacityMax float64
+}
+
+// GainMapImage is an SDR image with a gain map that restores its HDR
+// rendition. Saved as JPEG, it is written as an Ultra HDR file; other
+// formats keep only the SDR image.
+type GainMapImage struct {
+	// Image is the SDR base image
+	image.Image
+	// GainMap holds the quantized gains, possibly at a lower resolution
+	GainMap  *image.Gray
+	Metadata GainMapMetadata
+}
+
+// encodeUltraHDR writes img as a JPEG with the gain map appended as a
+// second JPEG, described by XMP metadata and located by an MPF index
+func encodeUltraHDR(w io.Writer, img *GainMapImage, quality int, profile []byte) error {
+	var gainMap bytes.Buffer
+	gainMap.Write([]byte{0xff, 0xd8})
+	if err := writeSegment(&gainMap, 0xe1, []byte(xmpHeader+gainMapXMP(img.Metadata))); err != nil {
+		return err
+	}
+	if err := jpeg.Encode(&skipWriter{w: &gainMap, n: 2}, img.GainMap, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+
+	var base bytes.Buffer
+	if err := jpeg.Encode(&base, img.Image, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+
+	var head bytes.Buffer
+	head.Write([]byte{0xff, 0xd8})
+	if err := writeSegment(&head, 0xe1, []byte(xmpHeader+primaryXMP(gainMap.Len()))); err != nil {
+		return err
+	}
+	if err := writeICCSegments(&head, profile); err != nil {
+		return err
+	}
+	// Offsets in the MPF index count from its byte order mark, after the
+	// marker, length and MPF header
+	mpPos := head.Len() + 4 + len(mpfHeader)
+	primarySize := head.Len() + 4 + len(mpfHeader) + mpfSize + base.Len() - 2
+	mpf := mpfIndex(uint32(primarySize), uint32(gainMap.Len()), uint32(primarySize-mpPos))
+	if err := writeSegment(&head, 0xe2, append([]byte(mpfHeader), mpf...)); err != nil {
+		return err
+	}
+
+	for _, b := range [][]byte{head.Bytes(), base.Bytes()[2:], gainMap.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// primaryXMP returns the XMP packet of the primary image, listing the
+// images of the file
+func primaryXMP(gainMapSize int) string {
+	return `<x:xmpmeta xmlns:x="adobe:ns:meta/">` +
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:Container="http://ns.google.com/photos/1.0/container/" ` +
+		`xmlns:Item="http://ns.google.com/photos/1.0/container/item/" ` +
+		`xmlns:hdrgm="` + hdrgmNS + `" hdrgm:Version="1.0">` +
+		`<Container:Directory><rdf:Seq>` +
+		`<rdf:li rdf:parseType="Resource"><Container:Item Item:Semantic="Primary" Item:Mime="image/jpeg"/></rdf:li>` +
+		`<rdf:li rdf:parseType="Resource"><Container:Item Item:Semantic="GainMap" Item:Mime="image/jpeg" Item:Length="` +
+		strconv.Itoa(gainMapSize) + `"/></rdf:li>` +
+		`</rdf:Seq></Container:Directory></rdf:Description></rdf:RDF></x:xmpmeta>`
+}
+
+// gainMapXMP returns the XMP packet of the gain map image
+func gainMapXMP(m GainMapMetadata) string {
+	var b strings.Builder
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">`)
+	b.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	b.WriteString(`<rdf:Description xmlns:hdrgm="` + hdrgmNS + `" hdrgm:Version="1.0"`)
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"GainMapMin", m.GainMapMin},
+		{"GainMapMax", m.GainMapMax},
+		{"Gamma", m.Gamma},
+		{"OffsetSDR", m.OffsetSDR},
+		{"OffsetHDR", m.OffsetHDR},
+		{"HDRCapacityMin", m.HDRCapacityMin},
+		{"HDRCapacityMax", m.HDRCapacityMax},
+	} {
+		fmt.Fprintf(&b, ` hdrgm:%s="%s"`, f.name, strconv.FormatFloat(f.value, 'f', -1, 64))
+	}
+	b.WriteString(` hdrgm:BaseRenditionIsHDR="False"/></rdf:RDF></x:xmpmeta>`)
+	return b.String()
+}
+
+// mpfSize is the size of the MPF index written by mpfIndex: the TIFF
+// header, an IFD of three entries and two MP entries
+const mpfSize = 8 + 2 + 3*12 + 4 + 2*16
+
+// mpfIndex returns a big-endian MPF index of the primary image and the
+// gain map
+func mpfIndex(primarySize, gainMapSize, gainMapOffset uint32) []byte {
+	be := binary.BigEndian
+	b := make([]byte, 0, mpfSize)
+	b = append(b, 'M', 'M', 0, 42)
+	b = be.AppendUint32(b, 8)
+	b = be.AppendUint16(b, 3)
+	// MPFVersion, NumberOfImages and MPEntry
+	b = append(b, 0xb0, 0x00, 0, 7, 0, 0, 0, 4, '0', '1', '0', '0')
+	b = append(b, 0xb0, 0x01, 0, 4, 0, 0, 0, 1, 0, 0, 0, 2)
+	b = append(b, 0xb0, 0x02, 0, 7, 0, 0, 0, 32)
+	b = be.AppendUint32(b, 8+2+3*12+4)
+	b = be.AppendUint32(b, 0)
+	// The primary image is a baseline MP primary image at offset zero
+	b = be.AppendUint32(b, 0x20030000)
+	b = be.AppendUint32(b, primarySize)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, gainMapSize)
+	b = be.AppendUint32(b, gainMapOffset)
+	b = be.AppendUint32(b, 0)
+	return b
+}
+
+// ReadGainMap reads a JPEG file with a gain map, such as an Ultra HDR
+// file. It returns ErrNoGainMap for other JPEG files.
+func ReadGainMap(filepath string) (*GainMapImage, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	g, err := decodeGainMapJPEG(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath, err)
+	}
+	return g, nil
+}
+
+// decodeGainMapJPEG decodes a JPEG file with a gain map
+func decodeGainMapJPEG(data []byte) (*GainMapImage, error) {
+	start, size, err := findGainMap(data)
+	if err != nil {
+		return nil, err
+	}
+	gainData := data[start : start+size]
+
+	meta, err := readGainMapXMP(gainData)
+	if err != nil {
+		return nil, err
+	}
+	gain, err := jpeg.Decode(bytes.NewReader(gainData))
+	if err != nil {
+		return nil, fmt.Errorf("gain map: %w", err)
+	}
+	base, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	gray, ok := gain.(*image.Gray)
+	if !ok {
+		gray = image.NewGray(gain.Bounds())
+		draw.Draw(gray, gray.Bounds(), gain, gain.Bounds().Min, draw.Src)
+	}
+	return &GainMapImage{Image: base, GainMap: gray, Metadata: meta}, nil
+}
+
+// findGainMap locates the second image of the MPF index of data
+func findGainMap(data []byte) (start, size int, err error) {
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	var mpf []byte
+	mpPos := 0
+	err = jpegSegments(r, func(marker byte, segment []byte) bool {
+		if marker != 0xe2 || !bytes.HasPrefix(segment, []byte(mpfHeader)) {
+			return true
+		}
+		end := len(data) - br.Len() - r.Buffered()
+		mpPos = end - len(segment) + len(mpfHeader)
+		mpf = segment[len(mpfHeader):]
+		return false
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if mpf == nil {
+		return 0, 0, ErrNoGainMap
+	}
+
+	invalid := errors.New("invalid MPF index")
+	if len(mpf) < 8 {
+		return 0, 0, invalid
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(mpf[:2]) == "II" {
+		order = binary.LittleEndian
+	}
+	ifd := int(order.Uint32(mpf[4:]))
+	if ifd+2 > len(mpf) {
+		return 0, 0, invalid
+	}
+	var entries []byte
+	for i := 0; i < int(order.Uint16(mpf[ifd:])); i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(mpf) {
+			return 0, 0, invalid
+		}
+		if order.Uint16(mpf[e:]) == 0xb002 {
+			n, off := int(order.Uint32(mpf[e+4:])), int(order.Uint32(mpf[e+8:]))
+			if off+n > len(mpf) {
+				return 0, 0, invalid
+			}
+			entries = mpf[off : off+n]
+		}
+	}
+	if len(entries) < 32 {
+		return 0, 0, ErrNoGainMap
+	}
+	size = int(order.Uint32(entries[16+4:]))
+	start = mpPos + int(order.Uint32(entries[16+8:]))
+	if size <= 0 || start <= mpPos || start+size > len(data) {
+		return 0, 0, errors.New("gain map out of range")
+	}
+	return start, size, nil
+}
+
+// readGainMapXMP reads the hdrgm properties of the XMP packet of a gain
+// map image. Properties may be attributes or elements; per-channel values
+// are not supported.
+func readGainMapXMP(data []byte) (GainMapMetadata, error) {
+	var packet []byte
+	err := jpegSegments(bufio.NewReader(bytes.NewReader(data)), func(marker byte, segment []byte) bool {
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte(xmpHeader)) {
+			packet = segment[len(xmpHeader):]
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return GainMapMetadata{}, fmt.Errorf("gain map: %w", err)
+	}
+
+	props := map[string]string{}
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	var element string
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return GainMapMetadata{}, fmt.Errorf("gain map XMP: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			element = ""
+			if t.Name.Space == hdrgmNS {
+				element = t.Name.Local
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == hdrgmNS {
+					props[a.Name.Local] = a.Value
+				}
+			}
+		case xml.CharData:
+			if element != "" {
+				props[element] = strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			element = ""
+		}
+	}
+	if props["Version"] == "" {
+		return GainMapMetadata{}, ErrNoGainMap
+	}
+
+	m := GainMapMetadata{Gamma: 1, OffsetSDR: 1.0 / 64, OffsetHDR: 1.0 / 64}
+	for _, f := range []struct {
+		name     string
+		v        *float64
+		required bool
+	}{
+		{"GainMapMin", &m.GainMapMin, false},
+		{"GainMapMax", &m.GainMapMax, true},
+		{"Gamma", &m.Gamma, false},
+		{"OffsetSDR", &m.OffsetSDR, false},
+		{"OffsetHDR", &m.OffsetHDR, false},
+		{"HDRCapacityMin", &m.HDRCapacityMin, false},
+		{"HDRCapacityMax", &m.HDRCapacityMax, true},
+	} {
+		s, ok := props[f.name]
+		if !ok {
+			if f.required {
+				return GainMapMetadata{}, fmt.Errorf("gain map XMP: missing %s", f.name)
+			}
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return GainMapMetadata{}, fmt.Errorf("gain map XMP: %s: %w", f.name, err)
+		}
+		*f.v = v
+	}
+	if strings.EqualFold(props["BaseRenditionIsHDR"], "true") {
+		return GainMapMetadata{}, errors.New("gain map: HDR base renditions are not supported")
+	}
+	return m, nil
+}
+
+// HDR applies the gain map to the base image for a display with
+// displayBoost times the luminance of SDR white, following the gain map
+// specification. curve decodes the base image. The result is linear light
+// with SDR white at 1.
+func (g *GainMapImage) HDR(curve colorspace.Curve, displayBoost float64) *hdr.RGB {
+	m := g.Metadata
+	weight := 1.0
+	if m.HDRCapacityMax > m.HDRCapacityMin {
+		weight = (math.Log2(displayBoost) - m.HDRCapacityMin) / (m.HDRCapacityMax - m.HDRCapacityMin)
+		weight = min(max(weight, 0), 1)
+	}
+
+	bounds := g.Bounds()
+	gb := g.GainMap.Bounds()
+	sx := float64(gb.Dx()) / float64(bounds.Dx())
+	sy := float64(gb.Dy()) / float64(bounds.Dy())
+	out := hdr.NewRGB(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := sampleGray(g.GainMap, (float64(x-bounds.Min.X)+0.5)*sx-0.5, (float64(y-bounds.Min.Y)+0.5)*sy-0.5)
+			recovery := math.Pow(v, 1/m.Gamma)
+			gain := math.Exp2((m.GainMapMin*(1-recovery) + m.GainMapMax*recovery) * weight)
+
+			r, gr, b, _ := g.At(x, y).RGBA()
+			apply := func(c uint32) float64 {
+				return (curve.Decode(float64(c)/0xffff)+m.OffsetSDR)*gain - m.OffsetHDR
+			}
+			out.Set(x, y, hdrcolor.RGB{R: apply(r), G: apply(gr), B: apply(b)})
+		}
+	}
+	return out
+}
+
+// sampleGray bilinearly samples img at the pixel coordinates (x, y) relative
+// to its origin, returning a value in [0, 1]
+func sampleGray(img *image.Gray, x, y float64) float64 {
+	b := img.Bounds()
+	x = min(max(x, 0), float64(b.Dx()-1))
+	y = min(max(y, 0), float64(b.Dy()-1))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, b.Dx()-1), min(y0+1, b.Dy()-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+	at := func(px, py int) float64 {
+		return float64(img.GrayAt(b.Min.X+px, b.Min.Y+py).Y) / 0xff
+	}
+	top := at(x0, y0)*(1-fx) + at(x1, y0)*fx
+	bottom := at(x0, y1)*(1-fx) + at(x1, y1)*fx
+	return top*(1-fy) + bottom*fy
+}
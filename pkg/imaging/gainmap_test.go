@@ -0,0 +1,135 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+)
+
+// testGainMapImage returns a gray base image with a gain map of constant
+// value v at a quarter of its size
+func testGainMapImage(v uint8) *GainMapImage {
+	base := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			base.Set(x, y, color.RGBA{128, 128, 128, 255})
+		}
+	}
+	gain := image.NewGray(image.Rect(0, 0, 8, 6))
+	for i := range gain.Pix {
+		gain.Pix[i] = v
+	}
+	return &GainMapImage{
+		Image:   base,
+		GainMap: gain,
+		Metadata: GainMapMetadata{
+			GainMapMin: -0.5, GainMapMax: 2, Gamma: 1,
+			OffsetSDR: 1.0 / 64, OffsetHDR: 1.0 / 64,
+			HDRCapacityMin: 0, HDRCapacityMax: 2,
+		},
+	}
+}
+
+func TestSaveGainMapJPEG(t *testing.T) {
+	img := testGainMapImage(200)
+	path := filepath.Join(t.TempDir(), "ultra.jpg")
+	opts := DefaultEncodeOptions()
+	opts.Profile = colorspace.DisplayP3.ICC()
+	if err := SaveImageOptions(img, path, opts); err != nil {
+		t.Fatalf("SaveImageOptions failed: %v", err)
+	}
+
+	got, err := ReadGainMap(path)
+	if err != nil {
+		t.Fatalf("ReadGainMap failed: %v", err)
+	}
+	if got.Metadata != img.Metadata {
+		t.Errorf("Expected metadata %+v, got %+v", img.Metadata, got.Metadata)
+	}
+	if got.Bounds() != img.Bounds() || got.GainMap.Bounds() != img.GainMap.Bounds() {
+		t.Errorf("Unexpected sizes: base %v, gain map %v", got.Bounds(), got.GainMap.Bounds())
+	}
+	for _, v := range got.GainMap.Pix {
+		if v < 198 || v > 202 {
+			t.Fatalf("Expected gain map values near 200, got %d", v)
+		}
+	}
+
+	// The file remains a regular JPEG tagged with its profile
+	if s, err := ReadColorSpace(path); err != nil || s != colorspace.DisplayP3 {
+		t.Errorf("Expected a Display P3 profile, got %v, %v", s, err)
+	}
+	if _, err := LoadImage(path); err != nil {
+		t.Errorf("Failed to load the base image: %v", err)
+	}
+
+	// The MPF index locates the gain map right after the EOI of the
+	// primary image, at the end of the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, size, err := findGainMap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start+size != len(data) || data[start-2] != 0xff || data[start-1] != 0xd9 || data[start] != 0xff || data[start+1] != 0xd8 {
+		t.Errorf("Gain map at %d (%d bytes) does not follow the primary image in a %d-byte file", start, size, len(data))
+	}
+}
+
+func TestGainMapHDR(t *testing.T) {
+	img := testGainMapImage(255)
+	sdr := colorspace.SRGBCurve.Decode(128.0 / 255)
+	off := img.Metadata.OffsetSDR
+
+	tests := []struct {
+		name  string
+		boost float64
+		want  float64
+	}{
+		{"SDR display", 1, sdr},
+		{"full headroom", 4, (sdr+off)*4 - off},
+		{"beyond capacity", 16, (sdr+off)*4 - off},
+		{"half headroom", 2, (sdr+off)*2 - off},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := img.HDR(colorspace.SRGBCurve, tt.boost)
+			r, g, b, _ := m.HDRAt(5, 7).HDRRGBA()
+			for _, c := range []float64{r, g, b} {
+				if math.Abs(c-tt.want) > 1e-4 {
+					t.Errorf("Expected %.4f, got %.4f %.4f %.4f", tt.want, r, g, b)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestReadGainMapErrors(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.jpg")
+	if err := SaveImage(testGainMapImage(0).Image, plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGainMap(plain); !errors.Is(err, ErrNoGainMap) {
+		t.Errorf("Expected ErrNoGainMap for a plain JPEG, got %v", err)
+	}
+
+	// Other formats keep only the base image
+	png := filepath.Join(dir, "base.png")
+	if err := SaveImage(testGainMapImage(0), png); err != nil {
+		t.Fatalf("SaveImage failed: %v", err)
+	}
+	if _, err := ReadGainMap(png); err == nil {
+		t.Error("Expected an error for a PNG file")
+	}
+}
@@ -1,16 +1,18 @@
 package imaging
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
 	"os"
 	"path"
 	"strings"
 
-	"github.com/mdouchement/hdr"
-	"github.com/mdouchement/hdr/hdrcolor"
+	"github.com/harperreed/hdarrrr/pkg/progress"
 )
 
 // SupportedFormats contains the file extensions we support
@@ -40,6 +42,32 @@ func GetImageProperties(img image.Image, format string) ImageProperties {
 	}
 }
 
+// ReadImageProperties reads the properties of the PNG or JPEG file at
+// filepath from its header, without decoding the pixels
+func ReadImageProperties(filepath string) (ImageProperties, error) {
+	ext := strings.ToLower(path.Ext(filepath))
+	if !SupportedFormats[ext] {
+		return ImageProperties{}, errors.New("unsupported image format: " + ext + ". Supported formats: PNG, JPEG")
+	}
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		return ImageProperties{}, err
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(file))
+	if err != nil {
+		return ImageProperties{}, err
+	}
+	depth := 8
+	switch cfg.ColorModel {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		depth = 16
+	}
+	return ImageProperties{Width: cfg.Width, Height: cfg.Height, ColorDepth: depth, Format: ext}, nil
+}
+
 // ValidateImageProperties checks if two images have the same properties
 func ValidateImageProperties(baseProps, props ImageProperties) bool {
 	return baseProps.Width == props.Width &&
@@ -50,10 +78,19 @@ func ValidateImageProperties(baseProps, props ImageProperties) bool {
 
 // LoadImages loads multiple images from file paths
 func LoadImages(paths ...string) ([]image.Image, error) {
+	return LoadImagesContext(context.Background(), paths...)
+}
+
+// LoadImagesContext loads multiple images from file paths. It stops early
+// when ctx is canceled and reports progress to the Reporter carried by ctx.
+func LoadImagesContext(ctx context.Context, paths ...string) ([]image.Image, error) {
 	images := make([]image.Image, len(paths))
 
 	for i, path := range paths {
-		img, err := LoadImage(path)
+		img, err := loadImage(ctx, path, func(done, total int) {
+			fraction := (float64(i) + float64(done)/float64(total)) / float64(len(paths))
+			progress.FromContext(ctx).Report(progress.StageLoad, fraction)
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -76,6 +113,39 @@ func LoadImages(paths ...string) ([]image.Image, error) {
 
 // LoadImage loads a single image from a file path
 func LoadImage(filepath string) (image.Image, error) {
+	return LoadImageContext(context.Background(), filepath)
+}
+
+// LoadImageContext loads a single image from a file path. It stops early
+// when ctx is canceled and reports progress to the Reporter carried by ctx.
+func LoadImageContext(ctx context.Context, filepath string) (image.Image, error) {
+	return loadImage(ctx, filepath, func(done, total int) {
+		progress.Step(ctx, progress.StageLoad, done, total)
+	})
+}
+
+// loadImage decodes the file at filepath and converts it to HDR, calling
+// report as strips of rows are converted
+func loadImage(ctx context.Context, filepath string, report func(done, total int)) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	img, err := decodeImage(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to HDR format
+	hdrImg, err := convertToHDR(ctx, img, 0, report, nil)
+	if err != nil {
+		return nil, err
+	}
+	return hdrImg, nil
+}
+
+// decodeImage decodes the PNG or JPEG file at filepath without converting it
+func decodeImage(filepath string) (image.Image, error) {
 	ext := strings.ToLower(path.Ext(filepath))
 	if !SupportedFormats[ext] {
 		return nil, errors.New("unsupported image format: " + ext + ". Supported formats: PNG, JPEG")
@@ -87,24 +157,35 @@ func LoadImage(filepath string) (image.Image, error) {
 	}
 	defer file.Close()
 
-	var img image.Image
-	switch ext {
-	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(file)
-	case ".png":
-		img, err = png.Decode(file)
+	if ext == ".png" {
+		return png.Decode(bufio.NewReader(file))
 	}
+	return jpeg.Decode(bufio.NewReader(file))
+}
 
-	if err != nil {
-		return nil, err
-	}
+// EncodeOptions holds the encoder settings used when saving images
+type EncodeOptions struct {
+	// Quality is the JPEG quality, from 1 to 100
+	Quality int
+	// Compression is the PNG compression level
+	Compression png.CompressionLevel
+	// Profile is an ICC profile embedded in JPEG and PNG files, if not nil
+	Profile []byte
+}
 
-	// Convert to HDR format
-	return convertToHDR(img), nil
+// DefaultEncodeOptions returns the settings used by SaveImage
+func DefaultEncodeOptions() EncodeOptions {
+	return EncodeOptions{Quality: 95, Compression: png.DefaultCompression}
 }
 
 // SaveImage saves an image to a file path
 func SaveImage(img image.Image, outputPath string) error {
+	return SaveImageOptions(img, outputPath, DefaultEncodeOptions())
+}
+
+// SaveImageOptions saves an image to a file path with explicit encoder
+// settings. A GainMapImage saved as JPEG is written as an Ultra HDR file.
+func SaveImageOptions(img image.Image, outputPath string, opts EncodeOptions) error {
 	file, err := os.Create(outputPath)
 	if err != nil {
 		return err
@@ -112,31 +193,19 @@ func SaveImage(img image.Image, outputPath string) error {
 	defer file.Close()
 
 	ext := strings.ToLower(path.Ext(outputPath))
+	gm, withGainMap := img.(*GainMapImage)
+	switch {
+	case withGainMap && (ext == ".jpg" || ext == ".jpeg"):
+		return encodeUltraHDR(file, gm, opts.Quality, opts.Profile)
+	case withGainMap:
+		img = gm.Image
+	}
 	switch ext {
 	case ".jpg", ".jpeg":
-		return jpeg.Encode(file, img, &jpeg.Options{Quality: 95})
+		return encodeJPEG(file, img, opts.Quality, opts.Profile)
 	case ".png":
-		return png.Encode(file, img)
+		return encodePNG(file, img, opts.Compression, opts.Profile)
 	default:
 		return errors.New("unsupported output format: " + ext + ". Supported formats: PNG, JPEG")
 	}
 }
-
-// convertToHDR converts a regular image to HDR format
-func convertToHDR(img image.Image) hdr.Image {
-	bounds := img.Bounds()
-	hdrImg := hdr.NewRGB(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			hdrImg.Set(x, y, hdrcolor.RGB{
-				R: float64(r) / 0xffff,
-				G: float64(g) / 0xffff,
-				B: float64(b) / 0xffff,
-			})
-		}
-	}
-
-	return hdrImg
-}
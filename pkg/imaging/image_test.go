@@ -2,6 +2,8 @@ package imaging
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"image"
 	"image/color"
 	"image/jpeg"
@@ -9,6 +11,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
 )
 
 // createTestImage creates a test image with specified dimensions and color
@@ -196,6 +200,31 @@ func TestSaveImage(t *testing.T) {
 	}
 }
 
+func TestSaveImageOptions(t *testing.T) {
+	dir := t.TempDir()
+	src := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 31)
+	}
+
+	sizes := map[int]int64{}
+	for _, quality := range []int{20, 95} {
+		path := filepath.Join(dir, "out.jpg")
+		if err := SaveImageOptions(src, path, EncodeOptions{Quality: quality}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[quality] = info.Size()
+	}
+
+	if sizes[20] >= sizes[95] {
+		t.Errorf("Expected quality 20 to produce a smaller file than 95, got %d and %d bytes", sizes[20], sizes[95])
+	}
+}
+
 func TestLoadImagesWithDifferentProperties(t *testing.T) {
 	file1, cleanup1 := createTestImageFile(t, "png", color.RGBA{R: 255, A: 255})
 	defer cleanup1()
@@ -208,6 +237,38 @@ func TestLoadImagesWithDifferentProperties(t *testing.T) {
 	}
 }
 
+func TestReadImageProperties(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		img  image.Image
+		want ImageProperties
+	}{
+		{"rgba", image.NewRGBA(image.Rect(0, 0, 5, 3)), ImageProperties{5, 3, 8, ".png"}},
+		{"rgba64", image.NewRGBA64(image.Rect(0, 0, 2, 7)), ImageProperties{2, 7, 16, ".png"}},
+		{"gray16", image.NewGray16(image.Rect(0, 0, 4, 4)), ImageProperties{4, 4, 16, ".png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadImageProperties(writePNG(t, dir, tt.name, tt.img))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := ReadImageProperties(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("Expected error for a missing file, got nil")
+	}
+	if _, err := ReadImageProperties(filepath.Join(dir, "image.bmp")); err == nil {
+		t.Error("Expected error for an unsupported format, got nil")
+	}
+}
+
 func TestLoadImageUnsupportedFormat(t *testing.T) {
 	tmpFile, err := os.CreateTemp("", "test_*.bmp")
 	if err != nil {
@@ -232,3 +293,43 @@ func TestSaveImageUnsupportedFormat(t *testing.T) {
 		t.Error("Expected error due to unsupported format, got nil")
 	}
 }
+
+func TestLoadImagesContextCanceled(t *testing.T) {
+	file1, cleanup1 := createTestImageFile(t, "png", color.RGBA{R: 255, A: 255})
+	defer cleanup1()
+	file2, cleanup2 := createTestImageFile(t, "png", color.RGBA{G: 255, A: 255})
+	defer cleanup2()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := LoadImagesContext(ctx, file1, file2)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLoadImagesContextProgress(t *testing.T) {
+	file1, cleanup1 := createTestImageFile(t, "png", color.RGBA{R: 255, A: 255})
+	defer cleanup1()
+	file2, cleanup2 := createTestImageFile(t, "png", color.RGBA{G: 255, A: 255})
+	defer cleanup2()
+
+	var last float64
+	ctx := progress.NewContext(context.Background(), progress.ReporterFunc(func(stage string, fraction float64) {
+		if stage != progress.StageLoad {
+			t.Errorf("Expected stage %q, got %q", progress.StageLoad, stage)
+		}
+		if fraction < last {
+			t.Errorf("Progress went backwards: %.2f after %.2f", fraction, last)
+		}
+		last = fraction
+	}))
+
+	if _, err := LoadImagesContext(ctx, file1, file2); err != nil {
+		t.Fatalf("Failed to load images: %v", err)
+	}
+	if last != 1 {
+		t.Errorf("Expected final progress 1, got %.2f", last)
+	}
+}
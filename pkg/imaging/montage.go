@@ -0,0 +1,102 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// labelPadding is the space around the caption of a labeled cell, in pixels
+const labelPadding = 4
+
+// Montage lays out images on a grid of columns, left to right then top to
+// bottom, separated by gap pixels of background. Every cell is as large as
+// the largest image and smaller images are centered in their cell.
+func Montage(images []image.Image, columns, gap int, background color.Color) (*image.RGBA, error) {
+	return LabeledMontage(images, nil, columns, gap, background)
+}
+
+// LabeledMontage is Montage with a caption under every image. Captions are
+// drawn in a fixed 7x13 font, in black or white for contrast with the
+// background, and cut to the width of the cell. Nil labels draw no captions.
+func LabeledMontage(images []image.Image, labels []string, columns, gap int, background color.Color) (*image.RGBA, error) {
+	if len(images) == 0 {
+		return nil, errors.New("no images to lay out")
+	}
+	if labels != nil && len(labels) != len(images) {
+		return nil, errors.New("the number of labels does not match the number of images")
+	}
+	columns = max(1, min(columns, len(images)))
+	gap = max(0, gap)
+	rows := (len(images) + columns - 1) / columns
+
+	var cell image.Point
+	for _, img := range images {
+		size := img.Bounds().Size()
+		cell.X = max(cell.X, size.X)
+		cell.Y = max(cell.Y, size.Y)
+	}
+	imageHeight := cell.Y
+	face := basicfont.Face7x13
+	if labels != nil {
+		cell.Y += face.Height + 2*labelPadding
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, columns*cell.X+(columns-1)*gap, rows*cell.Y+(rows-1)*gap))
+	draw.Draw(out, out.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	drawer := font.Drawer{Dst: out, Src: image.NewUniform(labelColor(background)), Face: face}
+	for i, img := range images {
+		b := img.Bounds()
+		corner := image.Pt((i%columns)*(cell.X+gap), (i/columns)*(cell.Y+gap))
+		origin := corner.Add(image.Pt(cell.X, imageHeight).Sub(b.Size()).Div(2))
+		draw.Draw(out, image.Rectangle{Min: origin, Max: origin.Add(b.Size())}, img, b.Min, draw.Src)
+
+		if labels == nil {
+			continue
+		}
+		text := fitLabel(face, labels[i], cell.X-2*labelPadding)
+		width := drawer.MeasureString(text).Ceil()
+		drawer.Dot = fixed.P(corner.X+(cell.X-width)/2, corner.Y+imageHeight+labelPadding+face.Ascent)
+		drawer.DrawString(text)
+	}
+	return out, nil
+}
+
+// fitLabel shortens text until it fits in width pixels
+func fitLabel(face font.Face, text string, width int) string {
+	runes := []rune(text)
+	for len(runes) > 0 && font.MeasureString(face, string(runes)).Ceil() > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes)
+}
+
+// labelColor returns black or white, whichever stands out on background
+func labelColor(background color.Color) color.Color {
+	if color.GrayModel.Convert(background).(color.Gray).Y > 128 {
+		return color.Black
+	}
+	return color.White
+}
+
+// Thumbnail scales img down to fit within width x height pixels, keeping its
+// aspect ratio. Images that already fit are returned unchanged.
+func Thumbnail(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width && b.Dy() <= height {
+		return img
+	}
+
+	scale := min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	size := image.Pt(max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5)))
+	out := image.NewRGBA64(image.Rectangle{Max: size})
+	xdraw.CatmullRom.Scale(out, out.Bounds(), img, b, xdraw.Src, nil)
+	return out
+}
@@ -0,0 +1,130 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMontage(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	black := color.RGBA{A: 255}
+	images := []image.Image{
+		createTestImage(4, 4, red),
+		createTestImage(2, 2, green),
+		createTestImage(4, 4, blue),
+	}
+
+	tests := []struct {
+		name    string
+		columns int
+		size    image.Point
+		pixels  map[image.Point]color.RGBA
+	}{
+		{
+			name:    "single row",
+			columns: 3,
+			size:    image.Pt(14, 4),
+			pixels: map[image.Point]color.RGBA{
+				{0, 0}: red, {4, 0}: black, {5, 0}: black, {6, 0}: black, {6, 3}: black,
+				{6, 1}: green, {7, 2}: green, {10, 3}: blue,
+			},
+		},
+		{
+			name:    "two columns",
+			columns: 2,
+			size:    image.Pt(9, 9),
+			pixels: map[image.Point]color.RGBA{
+				{3, 3}: red, {7, 1}: green, {0, 5}: blue, {5, 5}: black,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Montage(images, tt.columns, 1, black)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got.Bounds().Size() != tt.size {
+				t.Fatalf("Expected size %v, got %v", tt.size, got.Bounds().Size())
+			}
+			for p, want := range tt.pixels {
+				if c := got.RGBAAt(p.X, p.Y); c != want {
+					t.Errorf("Pixel %v: expected %v, got %v", p, want, c)
+				}
+			}
+		})
+	}
+
+	if _, err := Montage(nil, 1, 0, black); err == nil {
+		t.Error("Expected error for no images, got nil")
+	}
+}
+
+func TestLabeledMontage(t *testing.T) {
+	black := color.RGBA{A: 255}
+	images := []image.Image{
+		createTestImage(40, 20, color.RGBA{R: 255, A: 255}),
+		createTestImage(40, 20, color.RGBA{G: 255, A: 255}),
+	}
+
+	got, err := LabeledMontage(images, []string{"first", "a caption far too long for the cell"}, 2, 2, black)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Each cell gains a caption strip under the image
+	if want := image.Pt(82, 20+13+2*labelPadding); got.Bounds().Size() != want {
+		t.Fatalf("Expected size %v, got %v", want, got.Bounds().Size())
+	}
+
+	// Captions are drawn in white below each image, within their cell
+	for cell := 0; cell < 2; cell++ {
+		lit := 0
+		for y := 20; y < got.Bounds().Dy(); y++ {
+			for x := cell * 42; x < cell*42+40; x++ {
+				if got.RGBAAt(x, y).G > 128 && got.RGBAAt(x, y).R > 128 {
+					lit++
+				}
+			}
+		}
+		if lit == 0 {
+			t.Errorf("Expected caption pixels in cell %d", cell)
+		}
+	}
+	if c := got.RGBAAt(41, 30); c != black {
+		t.Errorf("Expected the gap to stay clear, got %v", c)
+	}
+
+	if _, err := LabeledMontage(images, []string{"one"}, 2, 2, black); err == nil {
+		t.Error("Expected error for mismatched labels, got nil")
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	img := createTestImage(200, 100, color.RGBA{B: 255, A: 255})
+
+	tests := []struct {
+		name          string
+		width, height int
+		want          image.Point
+	}{
+		{"width bound", 50, 50, image.Pt(50, 25)},
+		{"height bound", 400, 20, image.Pt(40, 20)},
+		{"already fits", 300, 300, image.Pt(200, 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Thumbnail(img, tt.width, tt.height)
+			if got.Bounds().Size() != tt.want {
+				t.Errorf("Expected size %v, got %v", tt.want, got.Bounds().Size())
+			}
+			if _, _, b, _ := got.At(got.Bounds().Dx()/2, got.Bounds().Dy()/2).RGBA(); b>>8 != 255 {
+				t.Errorf("Expected the color to be kept, got blue %d", b>>8)
+			}
+		})
+	}
+}
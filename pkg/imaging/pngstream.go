@@ -0,0 +1,632 @@
+package imaging
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/mdouchement/hdr"
+)
+
+// PNG color types
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPaletted  = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// PNG row filters
+const (
+	filterNone = iota
+	filterSub
+	filterUp
+	filterAverage
+	filterPaeth
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// errInterlaced is returned for Adam7 interlaced files, which cannot be
+// decoded row by row
+var errInterlaced = errors.New("png: interlaced images cannot be streamed")
+
+// pngRowReader decodes a non-interlaced PNG one row at a time
+type pngRowReader struct {
+	path   string
+	file   *os.File
+	bounds image.Rectangle
+
+	depth     int
+	colorType int
+	palette   []color.Color
+	// trns is the transparent color key for gray and RGB images
+	trns []uint16
+
+	idat *idatReader
+	zr   io.ReadCloser
+	cur  []byte
+	prev []byte
+}
+
+// openPNGRows opens a PNG file and reads its header up to the image data
+func openPNGRows(filepath string) (*pngRowReader, error) {
+	r := &pngRowReader{path: filepath}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open (re)opens the file and positions the reader on the first row
+func (r *pngRowReader) open() error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(file)
+	if err := r.readHeader(br); err != nil {
+		file.Close()
+		return err
+	}
+
+	zr, err := zlib.NewReader(r.idat)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("png: %w", err)
+	}
+
+	rowBytes := (r.bounds.Dx()*r.bitsPerPixel() + 7) / 8
+	r.file = file
+	r.zr = zr
+	r.cur = make([]byte, 1+rowBytes)
+	r.prev = make([]byte, 1+rowBytes)
+	return nil
+}
+
+// readHeader reads the chunks before the first IDAT chunk
+func (r *pngRowReader) readHeader(br *bufio.Reader) error {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, sig); err != nil || string(sig) != string(pngSignature) {
+		return errors.New("png: invalid signature")
+	}
+
+	var palAlpha []byte
+	r.trns = nil
+	for {
+		length, typ, err := readChunkHeader(br)
+		if err != nil {
+			return err
+		}
+		if typ == "IDAT" {
+			r.idat = &idatReader{r: br, remaining: length, crc: crc32.NewIEEE()}
+			r.idat.crc.Write([]byte(typ))
+			break
+		}
+
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("png: reading %s chunk: %w", typ, err)
+		}
+		data = data[:length]
+
+		switch typ {
+		case "IHDR":
+			if len(data) != 13 {
+				return errors.New("png: invalid IHDR chunk")
+			}
+			width := int(binary.BigEndian.Uint32(data[0:4]))
+			height := int(binary.BigEndian.Uint32(data[4:8]))
+			r.bounds = image.Rect(0, 0, width, height)
+			r.depth = int(data[8])
+			r.colorType = int(data[9])
+			if data[12] != 0 {
+				return errInterlaced
+			}
+			if err := r.checkFormat(); err != nil {
+				return err
+			}
+		case "PLTE":
+			r.palette = make([]color.Color, 256)
+			for i := range r.palette {
+				r.palette[i] = color.RGBA{A: 0xff}
+			}
+			for i := 0; i+2 < len(data) && i/3 < 256; i += 3 {
+				r.palette[i/3] = color.RGBA{R: data[i], G: data[i+1], B: data[i+2], A: 0xff}
+			}
+		case "tRNS":
+			if r.colorType == pngPaletted {
+				palAlpha = data
+			} else {
+				for i := 0; i+1 < len(data); i += 2 {
+					r.trns = append(r.trns, binary.BigEndian.Uint16(data[i:]))
+				}
+			}
+		case "IEND":
+			return errors.New("png: no image data")
+		}
+	}
+
+	if r.depth == 0 {
+		return errors.New("png: missing IHDR chunk")
+	}
+	if r.colorType == pngPaletted {
+		if r.palette == nil {
+			return errors.New("png: missing palette")
+		}
+		for i, a := range palAlpha {
+			if i < len(r.palette) {
+				c := r.palette[i].(color.RGBA)
+				r.palette[i] = color.NRGBA{R: c.R, G: c.G, B: c.B, A: a}
+			}
+		}
+	}
+	return nil
+}
+
+// checkFormat validates the bit depth for the color type
+func (r *pngRowReader) checkFormat() error {
+	valid := false
+	switch r.colorType {
+	case pngGray:
+		valid = r.depth == 1 || r.depth == 2 || r.depth == 4 || r.depth == 8 || r.depth == 16
+	case pngPaletted:
+		valid = r.depth == 1 || r.depth == 2 || r.depth == 4 || r.depth == 8
+	case pngRGB, pngGrayAlpha, pngRGBA:
+		valid = r.depth == 8 || r.depth == 16
+	}
+	if !valid {
+		return fmt.Errorf("png: unsupported color type %d with bit depth %d", r.colorType, r.depth)
+	}
+	return nil
+}
+
+// channels returns the number of samples per pixel
+func (r *pngRowReader) channels() int {
+	switch r.colorType {
+	case pngRGB:
+		return 3
+	case pngGrayAlpha:
+		return 2
+	case pngRGBA:
+		return 4
+	default:
+		return 1
+	}
+}
+
+func (r *pngRowReader) bitsPerPixel() int {
+	return r.channels() * r.depth
+}
+
+func (r *pngRowReader) Bounds() image.Rectangle { return r.bounds }
+
+func (r *pngRowReader) ReadRows(dst *hdr.RGB) error {
+	bpp := (r.bitsPerPixel() + 7) / 8
+	for dy := 0; dy < dst.Bounds().Dy(); dy++ {
+		if _, err := io.ReadFull(r.zr, r.cur); err != nil {
+			return fmt.Errorf("png: reading row: %w", err)
+		}
+		if err := unfilter(r.cur[0], r.cur[1:], r.prev[1:], bpp); err != nil {
+			return err
+		}
+
+		i := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+dy)
+		for x := 0; x < r.bounds.Dx(); x++ {
+			cr, cg, cb := r.pixel(r.cur[1:], x)
+			dst.Pix[i] = float32(cr) / 0xffff
+			dst.Pix[i+1] = float32(cg) / 0xffff
+			dst.Pix[i+2] = float32(cb) / 0xffff
+			i += 3
+		}
+		r.cur, r.prev = r.prev, r.cur
+	}
+	return nil
+}
+
+// pixel returns the alpha-premultiplied 16-bit channels of pixel x in row,
+// matching the colors produced by image/png
+func (r *pngRowReader) pixel(row []byte, x int) (cr, cg, cb uint32) {
+	switch r.colorType {
+	case pngGray:
+		var v uint16
+		if r.depth == 16 {
+			v = binary.BigEndian.Uint16(row[2*x:])
+		} else {
+			v = uint16(r.sample(row, x))
+		}
+		if len(r.trns) > 0 && v == r.trns[0] {
+			return 0, 0, 0
+		}
+		if r.depth == 16 {
+			cr, cg, cb, _ = color.Gray16{Y: v}.RGBA()
+		} else {
+			// Scale low bit depths to the full 8-bit range
+			scale := uint16(0xff / (1<<r.depth - 1))
+			cr, cg, cb, _ = color.Gray{Y: uint8(v * scale)}.RGBA()
+		}
+	case pngPaletted:
+		cr, cg, cb, _ = r.palette[r.sample(row, x)].RGBA()
+	case pngRGB:
+		if r.depth == 16 {
+			p := row[6*x:]
+			c := color.RGBA64{
+				R: binary.BigEndian.Uint16(p), G: binary.BigEndian.Uint16(p[2:]), B: binary.BigEndian.Uint16(p[4:]), A: 0xffff,
+			}
+			if len(r.trns) == 3 && c.R == r.trns[0] && c.G == r.trns[1] && c.B == r.trns[2] {
+				return 0, 0, 0
+			}
+			cr, cg, cb, _ = c.RGBA()
+		} else {
+			p := row[3*x:]
+			if len(r.trns) == 3 && uint16(p[0]) == r.trns[0] && uint16(p[1]) == r.trns[1] && uint16(p[2]) == r.trns[2] {
+				return 0, 0, 0
+			}
+			cr, cg, cb, _ = color.RGBA{R: p[0], G: p[1], B: p[2], A: 0xff}.RGBA()
+		}
+	case pngGrayAlpha:
+		if r.depth == 16 {
+			p := row[4*x:]
+			v := binary.BigEndian.Uint16(p)
+			cr, cg, cb, _ = color.NRGBA64{R: v, G: v, B: v, A: binary.BigEndian.Uint16(p[2:])}.RGBA()
+		} else {
+			p := row[2*x:]
+			cr, cg, cb, _ = color.NRGBA{R: p[0], G: p[0], B: p[0], A: p[1]}.RGBA()
+		}
+	case pngRGBA:
+		if r.depth == 16 {
+			p := row[8*x:]
+			cr, cg, cb, _ = color.NRGBA64{
+				R: binary.BigEndian.Uint16(p), G: binary.BigEndian.Uint16(p[2:]),
+				B: binary.BigEndian.Uint16(p[4:]), A: binary.BigEndian.Uint16(p[6:]),
+			}.RGBA()
+		} else {
+			p := row[4*x:]
+			cr, cg, cb, _ = color.NRGBA{R: p[0], G: p[1], B: p[2], A: p[3]}.RGBA()
+		}
+	}
+	return cr, cg, cb
+}
+
+// sample returns the x-th sample of a row with a bit depth of at most 8
+func (r *pngRowReader) sample(row []byte, x int) uint8 {
+	if r.depth == 8 {
+		return row[x]
+	}
+	perByte := 8 / r.depth
+	shift := uint(8 - r.depth*(x%perByte+1))
+	return (row[x/perByte] >> shift) & (1<<r.depth - 1)
+}
+
+func (r *pngRowReader) Reset() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *pngRowReader) Buffered() int64 {
+	return int64(2 * len(r.cur))
+}
+
+func (r *pngRowReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	r.zr.Close()
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// readChunkHeader reads the length and type of the next chunk
+func readChunkHeader(r io.Reader) (uint32, string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", fmt.Errorf("png: reading chunk header: %w", err)
+	}
+	return binary.BigEndian.Uint32(header[:4]), string(header[4:]), nil
+}
+
+// idatReader concatenates the payloads of consecutive IDAT chunks,
+// verifying their checksums
+type idatReader struct {
+	r         *bufio.Reader
+	remaining uint32
+	crc       hash.Hash32
+	done      bool
+}
+
+func (d *idatReader) Read(p []byte) (int, error) {
+	for d.remaining == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		var sum [4]byte
+		if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+			return 0, err
+		}
+		if binary.BigEndian.Uint32(sum[:]) != d.crc.Sum32() {
+			return 0, errors.New("png: invalid checksum")
+		}
+
+		length, typ, err := readChunkHeader(d.r)
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			d.done = true
+			return 0, io.EOF
+		}
+		d.remaining = length
+		d.crc = crc32.NewIEEE()
+		d.crc.Write([]byte(typ))
+	}
+
+	if uint32(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.r.Read(p)
+	d.crc.Write(p[:n])
+	d.remaining -= uint32(n)
+	return n, err
+}
+
+// unfilter reverses the PNG filter of cur given the previous row
+func unfilter(filter byte, cur, prev []byte, bpp int) error {
+	switch filter {
+	case filterNone:
+	case filterSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case filterUp:
+		for i, p := range prev {
+			cur[i] += p
+		}
+	case filterAverage:
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i] / 2
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += uint8((int(cur[i-bpp]) + int(prev[i])) / 2)
+		}
+	case filterPaeth:
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i]
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += paeth(cur[i-bpp], prev[i], prev[i-bpp])
+		}
+	default:
+		return fmt.Errorf("png: invalid filter type %d", filter)
+	}
+	return nil
+}
+
+// paeth returns whichever of a (left), b (up) or c (up-left) is closest to a+b-c
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// pngRowWriter encodes a 16-bit RGB PNG one band at a time
+type pngRowWriter struct {
+	file   *os.File
+	bw     *bufio.Writer
+	bounds image.Rectangle
+	idat   *chunkWriter
+	zw     *zlib.Writer
+	cur    []byte
+	prev   []byte
+	// filtered holds the candidate filtered rows, indexed by filter type
+	filtered [5][]byte
+	y        int
+}
+
+func newPNGRowWriter(file *os.File, bounds image.Rectangle, opts EncodeOptions) (*pngRowWriter, error) {
+	bw := bufio.NewWriter(file)
+	w := &pngRowWriter{file: file, bw: bw, bounds: bounds, y: bounds.Min.Y}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(bounds.Dy()))
+	ihdr[8] = 16
+	ihdr[9] = pngRGB
+
+	if _, err := bw.Write(pngSignature); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := writeChunk(bw, "IHDR", ihdr); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if opts.Profile != nil {
+		if err := writeICCPChunk(bw, opts.Profile); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	rowBytes := 6 * bounds.Dx()
+	w.cur = make([]byte, rowBytes)
+	w.prev = make([]byte, rowBytes)
+	for i := range w.filtered {
+		w.filtered[i] = make([]byte, 1+rowBytes)
+		w.filtered[i][0] = byte(i)
+	}
+	w.idat = &chunkWriter{w: bw, typ: "IDAT"}
+	zw, err := zlib.NewWriterLevel(w.idat, zlibLevel(opts.Compression))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.zw = zw
+	return w, nil
+}
+
+// zlibLevel maps a PNG compression level to the zlib level used by image/png
+func zlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	default:
+		return zlib.DefaultCompression
+	}
+}
+
+func (w *pngRowWriter) WriteRows(band image.Image) error {
+	b := band.Bounds()
+	if b.Min.Y != w.y || b.Max.Y > w.bounds.Max.Y {
+		return fmt.Errorf("png: expected rows starting at %d, got %v", w.y, b)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := w.bounds.Min.X; x < w.bounds.Max.X; x++ {
+			cr, cg, cb, _ := band.At(x, y).RGBA()
+			p := w.cur[6*(x-w.bounds.Min.X):]
+			binary.BigEndian.PutUint16(p, uint16(cr))
+			binary.BigEndian.PutUint16(p[2:], uint16(cg))
+			binary.BigEndian.PutUint16(p[4:], uint16(cb))
+		}
+
+		if _, err := w.zw.Write(w.filterRow()); err != nil {
+			return err
+		}
+		w.cur, w.prev = w.prev, w.cur
+		w.y++
+	}
+	return nil
+}
+
+// filterRow filters the current row with each filter and returns the one with
+// the smallest sum of absolute differences, like image/png
+func (w *pngRowWriter) filterRow() []byte {
+	const bpp = 6
+	cur, prev := w.cur, w.prev
+
+	copy(w.filtered[filterNone][1:], cur)
+	for i := range cur {
+		var left, upLeft uint8
+		if i >= bpp {
+			left, upLeft = cur[i-bpp], prev[i-bpp]
+		}
+		w.filtered[filterSub][1+i] = cur[i] - left
+		w.filtered[filterUp][1+i] = cur[i] - prev[i]
+		w.filtered[filterAverage][1+i] = cur[i] - uint8((int(left)+int(prev[i]))/2)
+		w.filtered[filterPaeth][1+i] = cur[i] - paeth(left, prev[i], upLeft)
+	}
+
+	best, bestSum := 0, -1
+	for f, row := range w.filtered {
+		sum := 0
+		for _, v := range row[1:] {
+			sum += abs(int(int8(v)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = f, sum
+		}
+	}
+	return w.filtered[best]
+}
+
+func (w *pngRowWriter) Close() error {
+	err := w.zw.Close()
+	if err == nil {
+		err = w.idat.flush()
+	}
+	if err == nil && w.y != w.bounds.Max.Y {
+		err = fmt.Errorf("png: image closed after %d of %d rows", w.y-w.bounds.Min.Y, w.bounds.Dy())
+	}
+	if err == nil {
+		err = writeChunk(w.bw, "IEND", nil)
+	}
+	if err == nil {
+		err = w.bw.Flush()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// chunkWriter buffers data and writes it out as chunks of typ
+type chunkWriter struct {
+	w   io.Writer
+	typ string
+	buf []byte
+}
+
+// maxChunkSize is the payload size at which chunkWriter emits a chunk
+const maxChunkSize = 1 << 16
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= maxChunkSize {
+		if err := writeChunk(c.w, c.typ, c.buf[:maxChunkSize]); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[maxChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (c *chunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := writeChunk(c.w, c.typ, c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+// writeChunk writes a PNG chunk with its length and checksum
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+
+	for _, b := range [][]byte{header[:], data, sum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+)
+
+// ErrNoColorSpace is returned by ReadColorSpace when a file carries neither
+// an ICC profile nor an EXIF color space. Such files are usually sRGB.
+var ErrNoColorSpace = errors.New("no color space tag")
+
+// iccSegmentHeader starts the APP2 segments holding an ICC profile in JPEG
+// files
+var iccSegmentHeader = []byte("ICC_PROFILE\x00")
+
+// maxICCSegment is the largest part of a profile one APP2 segment holds
+const maxICCSegment = 0xffff - 2 - 14
+
+// maxICCBytes limits the ICC profiles read from PNG files to what the 255
+// APP2 segments of a JPEG file can hold
+const maxICCBytes = 255 * maxICCSegment
+
+// ReadColorSpace reads the color space a JPEG or PNG file is tagged with:
+// its embedded ICC profile, the PNG sRGB chunk, or the EXIF color space. It
+// returns ErrNoColorSpace if the file has none, and an error wrapping
+// colorspace.ErrUnsupportedProfile for profiles that are not RGB matrix
+// profiles.
+func ReadColorSpace(filepath string) (*colorspace.Space, error) {
+	ext := strings.ToLower(path.Ext(filepath))
+	if !SupportedFormats[ext] {
+		return nil, errors.New("unsupported image format: " + ext + ". Supported formats: PNG, JPEG")
+	}
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var profile []byte
+	if ext == ".png" {
+		var isSRGB bool
+		profile, isSRGB, err = pngProfile(bufio.NewReader(file))
+		if err == nil && profile == nil && isSRGB {
+			return colorspace.SRGB, nil
+		}
+	} else {
+		profile, err = jpegProfile(bufio.NewReader(file))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if profile != nil {
+		s, err := colorspace.ParseICC(profile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath, err)
+		}
+		return s, nil
+	}
+
+	m, err := ReadMetadata(filepath)
+	switch {
+	case errors.Is(err, ErrNoMetadata):
+		return nil, ErrNoColorSpace
+	case err != nil:
+		return nil, err
+	case m.ColorSpace == 1:
+		return colorspace.SRGB, nil
+	case m.ColorSpace == 0xffff && m.Interoperability == "R03":
+		return colorspace.AdobeRGB, nil
+	}
+	return nil, ErrNoColorSpace
+}
+
+// jpegProfile returns the ICC profile spread over the APP2 segments of a
+// JPEG file, or nil
+func jpegProfile(r *bufio.Reader) ([]byte, error) {
+	var parts [][]byte
+	err := jpegSegments(r, func(marker byte, segment []byte) bool {
+		if marker != 0xe2 || !bytes.HasPrefix(segment, iccSegmentHeader) || len(segment) < len(iccSegmentHeader)+2 {
+			return true
+		}
+		seq, count := int(segment[len(iccSegmentHeader)]), int(segment[len(iccSegmentHeader)+1])
+		if parts == nil {
+			parts = make([][]byte, count)
+		}
+		if seq >= 1 && seq <= len(parts) {
+			parts[seq-1] = segment[len(iccSegmentHeader)+2:]
+		}
+		return true
+	})
+	if err != nil || parts == nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		if p == nil {
+			return nil, errors.New("jpeg: incomplete ICC profile")
+		}
+	}
+	return bytes.Join(parts, nil), nil
+}
+
+// pngProfile returns the ICC profile of the iCCP chunk of a PNG file, or
+// nil, and whether the file has an sRGB chunk
+func pngProfile(r *bufio.Reader) (profile []byte, isSRGB bool, err error) {
+	want := func(typ string) bool { return typ == "iCCP" || typ == "sRGB" }
+	var chunkErr error
+	err = pngChunks(r, maxICCBytes, want, func(typ string, data []byte) bool {
+		if typ == "sRGB" {
+			isSRGB = true
+			return true
+		}
+		// Profile name, NUL, compression method, then the zlib stream
+		i := bytes.IndexByte(data, 0)
+		if i < 0 || i+2 > len(data) {
+			chunkErr = errors.New("png: invalid iCCP chunk")
+			return false
+		}
+		zr, zerr := zlib.NewReader(bytes.NewReader(data[i+2:]))
+		if zerr == nil {
+			profile, zerr = io.ReadAll(io.LimitReader(zr, maxICCBytes+1))
+		}
+		if zerr == nil && len(profile) > maxICCBytes {
+			zerr = errors.New("profile too large")
+		}
+		if zerr != nil {
+			chunkErr = fmt.Errorf("png: iCCP chunk: %w", zerr)
+		}
+		return false
+	})
+	if err == nil {
+		err = chunkErr
+	}
+	return profile, isSRGB, err
+}
+
+// encodeJPEG encodes img as a JPEG with profile, if not nil, in APP2
+// segments
+func encodeJPEG(w io.Writer, img image.Image, quality int, profile []byte) error {
+	if profile == nil {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	if _, err := w.Write([]byte{0xff, 0xd8}); err != nil {
+		return err
+	}
+	if err := writeICCSegments(w, profile); err != nil {
+		return err
+	}
+	// The encoder starts with its own SOI marker, which is already written
+	return jpeg.Encode(&skipWriter{w: w, n: 2}, img, &jpeg.Options{Quality: quality})
+}
+
+// writeICCSegments writes profile in as many APP2 segments as it needs
+func writeICCSegments(w io.Writer, profile []byte) error {
+	count := (len(profile) + maxICCSegment - 1) / maxICCSegment
+	for i := 0; i < count; i++ {
+		part := profile[i*maxICCSegment : min((i+1)*maxICCSegment, len(profile))]
+		data := append(append([]byte(nil), iccSegmentHeader...), byte(i+1), byte(count))
+		if err := writeSegment(w, 0xe2, append(data, part...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSegment writes a JPEG marker segment holding data
+func writeSegment(w io.Writer, marker byte, data []byte) error {
+	size := 2 + len(data)
+	if size > 0xffff {
+		return fmt.Errorf("jpeg: %d-byte segment is too large", len(data))
+	}
+	if _, err := w.Write([]byte{0xff, marker, byte(size >> 8), byte(size)}); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// encodePNG encodes img as a PNG with profile, if not nil, in an iCCP chunk
+func encodePNG(w io.Writer, img image.Image, level png.CompressionLevel, profile []byte) error {
+	if profile == nil {
+		return encodePNGChunks(w, img, level)
+	}
+	data, err := iccpData(profile)
+	if err != nil {
+		return err
+	}
+	return encodePNGChunks(w, img, level, pngChunk{"iCCP", data})
+}
+
+// pngChunk is an ancillary PNG chunk
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// encodePNGChunks encodes img as a PNG with chunks right after IHDR
+func encodePNGChunks(w io.Writer, img image.Image, level png.CompressionLevel, chunks ...pngChunk) error {
+	enc := png.Encoder{CompressionLevel: level}
+	if len(chunks) == 0 {
+		return enc.Encode(w, img)
+	}
+
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, img); err != nil {
+		return err
+	}
+	// IHDR ends after the signature and 25 bytes
+	raw := buf.Bytes()
+	if _, err := w.Write(raw[:33]); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err := writeChunk(w, c.typ, c.data); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(raw[33:])
+	return err
+}
+
+// writeICCPChunk writes a PNG iCCP chunk holding profile
+func writeICCPChunk(w io.Writer, profile []byte) error {
+	data, err := iccpData(profile)
+	if err != nil {
+		return err
+	}
+	return writeChunk(w, "iCCP", data)
+}
+
+// iccpData returns the data of an iCCP chunk holding profile
+func iccpData(profile []byte) ([]byte, error) {
+	var data bytes.Buffer
+	data.WriteString("ICC profile\x00\x00")
+	zw := zlib.NewWriter(&data)
+	zw.Write(profile)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// skipWriter drops the first n bytes written through it
+type skipWriter struct {
+	w io.Writer
+	n int
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	skip := min(s.n, len(p))
+	s.n -= skip
+	n, err := s.w.Write(p[skip:])
+	return n + skip, err
+}
@@ -0,0 +1,124 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+)
+
+func TestSaveImageProfile(t *testing.T) {
+	dir := t.TempDir()
+	img := createTestImage(16, 8, color.RGBA{R: 200, G: 120, B: 40, A: 255})
+	// Padding past the declared size spreads the profile over two JPEG
+	// segments without changing it
+	large := append(colorspace.DisplayP3.ICC(), make([]byte, 70000)...)
+
+	tests := []struct {
+		name    string
+		profile []byte
+		stream  bool
+	}{
+		{"p3.jpg", colorspace.DisplayP3.ICC(), false},
+		{"p3.png", colorspace.DisplayP3.ICC(), false},
+		{"large.jpg", large, false},
+		{"stream.jpg", colorspace.DisplayP3.ICC(), true},
+		{"stream.png", colorspace.DisplayP3.ICC(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			opts := DefaultEncodeOptions()
+			opts.Profile = tt.profile
+			if tt.stream {
+				w, err := CreateRowsOptions(path, img.Bounds(), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := w.WriteRows(img); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := SaveImageOptions(img, path, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := ReadColorSpace(path)
+			if err != nil || s != colorspace.DisplayP3 {
+				t.Errorf("Expected Display P3, got %v, %v", s, err)
+			}
+			decoded, err := LoadImage(path)
+			if err != nil {
+				t.Fatalf("Expected the tagged file to decode: %v", err)
+			}
+			if decoded.Bounds() != img.Bounds() {
+				t.Errorf("Expected bounds %v, got %v", img.Bounds(), decoded.Bounds())
+			}
+		})
+	}
+}
+
+func TestReadColorSpace(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, e testExif) string {
+		path := filepath.Join(dir, name)
+		writeExifJPEG(t, path, 128, e)
+		return path
+	}
+	untagged := filepath.Join(dir, "untagged.png")
+	if err := SaveImage(createTestImage(4, 4, color.White), untagged); err != nil {
+		t.Fatal(err)
+	}
+	srgbChunk := filepath.Join(dir, "srgb.png")
+	raw, _ := os.ReadFile(untagged)
+	var buf bytes.Buffer
+	buf.Write(raw[:33])
+	writeChunk(&buf, "sRGB", []byte{0})
+	buf.Write(raw[33:])
+	os.WriteFile(srgbChunk, buf.Bytes(), 0o644)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    *colorspace.Space
+		wantErr error
+	}{
+		{"exif srgb", write("srgb.jpg", testExif{colorSpace: 1}), colorspace.SRGB, nil},
+		{"exif adobe rgb", write("adobe.jpg", testExif{colorSpace: 0xffff, interop: "R03"}), colorspace.AdobeRGB, nil},
+		{"exif uncalibrated", write("other.jpg", testExif{colorSpace: 0xffff}), nil, ErrNoColorSpace},
+		{"exif without color space", write("plain.jpg", testExif{iso: 100}), nil, ErrNoColorSpace},
+		{"untagged png", untagged, nil, ErrNoColorSpace},
+		{"png srgb chunk", srgbChunk, colorspace.SRGB, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ReadColorSpace(tt.path)
+			if s != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, %v, got %v, %v", tt.want, tt.wantErr, s, err)
+			}
+		})
+	}
+}
+
+func TestReadColorSpaceUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmyk.jpg")
+	profile := colorspace.SRGB.ICC()
+	copy(profile[16:], "CMYK")
+	opts := DefaultEncodeOptions()
+	opts.Profile = profile
+	if err := SaveImageOptions(image.NewGray(image.Rect(0, 0, 4, 4)), path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadColorSpace(path); !errors.Is(err, colorspace.ErrUnsupportedProfile) {
+		t.Errorf("Expected an unsupported profile error, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/codec/pfm"
+	"github.com/mdouchement/hdr/codec/rgbe"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// RadianceFormats contains the file extensions of the radiance map formats:
+// Radiance RGBE (.hdr) and Portable Float Map (.pfm)
+var RadianceFormats = map[string]bool{
+	".hdr": true,
+	".pfm": true,
+}
+
+// IsRadiance reports whether path names a radiance map file
+func IsRadiance(filepath string) bool {
+	return RadianceFormats[strings.ToLower(path.Ext(filepath))]
+}
+
+// LoadRadiance reads the radiance map at filepath. The result always has
+// RGB channels, whatever the color space of the file.
+func LoadRadiance(filepath string) (*hdr.RGB, error) {
+	ext := strings.ToLower(path.Ext(filepath))
+	if !RadianceFormats[ext] {
+		return nil, errors.New("unsupported radiance format: " + ext + ". Supported formats: HDR, PFM")
+	}
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var img image.Image
+	if ext == ".hdr" {
+		img, err = rgbe.Decode(bufio.NewReader(file))
+	} else {
+		img, err = pfm.Decode(bufio.NewReader(file))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filepath, err)
+	}
+
+	m, ok := img.(hdr.Image)
+	if !ok {
+		return nil, fmt.Errorf("decoding %s: not a radiance map", filepath)
+	}
+	return toRGB(m), nil
+}
+
+// SaveRadiance writes img to outputPath as a Radiance RGBE (.hdr) or
+// Portable Float Map (.pfm) file
+func SaveRadiance(img hdr.Image, outputPath string) error {
+	ext := strings.ToLower(path.Ext(outputPath))
+	if !RadianceFormats[ext] {
+		return errors.New("unsupported radiance format: " + ext + ". Supported formats: HDR, PFM")
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if ext == ".hdr" {
+		err = rgbe.Encode(w, img)
+	} else {
+		err = pfm.Encode(w, img)
+	}
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Decode reads the image at filepath without converting it: PNG and JPEG
+// files keep their decoded type and radiance maps are returned as *hdr.RGB
+func Decode(filepath string) (image.Image, error) {
+	if IsRadiance(filepath) {
+		return LoadRadiance(filepath)
+	}
+	return decodeImage(filepath)
+}
+
+// BitDepth returns the number of bits per channel of img: 8 or 16 for
+// integer images and 32 for radiance maps
+func BitDepth(img image.Image) int {
+	switch img.(type) {
+	case *hdr.RGB, *hdr.XYZ:
+		return 32
+	case *hdr.RGB64, *hdr.XYZ64:
+		return 64
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return 16
+	default:
+		return 8
+	}
+}
+
+// toRGB returns m as a *hdr.RGB, converting other color spaces
+func toRGB(m hdr.Image) *hdr.RGB {
+	if rgb, ok := m.(*hdr.RGB); ok {
+		return rgb
+	}
+
+	bounds := m.Bounds()
+	rgb := hdr.NewRGB(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := m.HDRAt(x, y).HDRRGBA()
+			rgb.SetRGB(x, y, hdrcolor.RGB{R: r, G: g, B: b})
+		}
+	}
+	return rgb
+}
+
+// ShrinkRadiance scales m down to fit within maxSize pixels on its longest
+// edge, averaging the radiance of the pixels that fall into each output
+// pixel. Maps that already fit are returned unchanged, as a *hdr.RGB.
+func ShrinkRadiance(img hdr.Image, maxSize int) *hdr.RGB {
+	m := toRGB(img)
+	b := m.Bounds()
+	if maxSize <= 0 || (b.Dx() <= maxSize && b.Dy() <= maxSize) {
+		return m
+	}
+
+	scale := float64(maxSize) / float64(max(b.Dx(), b.Dy()))
+	w := max(1, int(float64(b.Dx())*scale+0.5))
+	h := max(1, int(float64(b.Dy())*scale+0.5))
+	out := hdr.NewRGB(image.Rect(0, 0, w, h))
+	for oy := 0; oy < h; oy++ {
+		y0, y1 := b.Min.Y+oy*b.Dy()/h, b.Min.Y+(oy+1)*b.Dy()/h
+		for ox := 0; ox < w; ox++ {
+			x0, x1 := b.Min.X+ox*b.Dx()/w, b.Min.X+(ox+1)*b.Dx()/w
+			var sum hdrcolor.RGB
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					c := m.RGBAt(x, y)
+					sum.R += c.R
+					sum.G += c.G
+					sum.B += c.B
+				}
+			}
+			n := float64((y1 - y0) * (x1 - x0))
+			out.SetRGB(ox, oy, hdrcolor.RGB{R: sum.R / n, G: sum.G / n, B: sum.B / n})
+		}
+	}
+	return out
+}
@@ -0,0 +1,136 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+func TestSaveLoadRadiance(t *testing.T) {
+	img := hdr.NewRGB(image.Rect(0, 0, 7, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			v := float64(x+1) * math.Pow(2, float64(y-2))
+			img.SetRGB(x, y, hdrcolor.RGB{R: v, G: v / 2, B: v / 4})
+		}
+	}
+
+	tests := []struct {
+		ext       string
+		tolerance float64
+	}{
+		// RGBE shares an 8-bit exponent between channels
+		{".hdr", 1.0 / 64},
+		{".pfm", 1e-6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "radiance"+tt.ext)
+			if err := SaveRadiance(img, path); err != nil {
+				t.Fatalf("Unexpected error saving: %v", err)
+			}
+			got, err := LoadRadiance(path)
+			if err != nil {
+				t.Fatalf("Unexpected error loading: %v", err)
+			}
+			if got.Bounds() != img.Bounds() {
+				t.Fatalf("Expected bounds %v, got %v", img.Bounds(), got.Bounds())
+			}
+
+			for y := 0; y < 5; y++ {
+				for x := 0; x < 7; x++ {
+					wr, wg, wb, _ := img.HDRAt(x, y).HDRRGBA()
+					gr, gg, gb, _ := got.HDRAt(x, y).HDRRGBA()
+					for _, c := range [][2]float64{{wr, gr}, {wg, gg}, {wb, gb}} {
+						if math.Abs(c[0]-c[1]) > tt.tolerance*c[0] {
+							t.Fatalf("Pixel (%d, %d): expected (%f, %f, %f), got (%f, %f, %f)", x, y, wr, wg, wb, gr, gg, gb)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRadianceUnsupportedFormat(t *testing.T) {
+	dir := t.TempDir()
+	if err := SaveRadiance(hdr.NewRGB(image.Rect(0, 0, 1, 1)), filepath.Join(dir, "out.exr")); err == nil {
+		t.Error("Expected error saving .exr, got nil")
+	}
+	if _, err := LoadRadiance(filepath.Join(dir, "in.png")); err == nil {
+		t.Error("Expected error loading .png, got nil")
+	}
+}
+
+func TestDecodeAndBitDepth(t *testing.T) {
+	dir := t.TempDir()
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	rgba64 := image.NewRGBA64(image.Rect(0, 0, 2, 2))
+	rgba64.Set(0, 0, color.RGBA64{R: 0x1234, G: 0x5678, B: 0x9abc, A: 0xffff})
+	radiance := hdr.NewRGB(image.Rect(0, 0, 2, 2))
+
+	tests := []struct {
+		name  string
+		path  string
+		depth int
+	}{
+		{"8-bit PNG", writePNG(t, dir, "rgba", rgba), 8},
+		{"16-bit PNG", writePNG(t, dir, "rgba64", rgba64), 16},
+		{"radiance", filepath.Join(dir, "radiance.hdr"), 32},
+	}
+	if err := SaveRadiance(radiance, tests[2].path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Decode(tt.path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := BitDepth(img); got != tt.depth {
+				t.Errorf("Expected %d bits, got %d", tt.depth, got)
+			}
+		})
+	}
+}
+
+func TestShrinkRadiance(t *testing.T) {
+	img := hdr.NewRGB(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			// Left half dim, right half a thousand times brighter
+			v := 0.01
+			if x >= 4 {
+				v = 10
+			}
+			img.SetRGB(x, y, hdrcolor.RGB{R: v, G: v, B: v})
+		}
+	}
+
+	if got := ShrinkRadiance(img, 8); got != img {
+		t.Error("Expected a map that fits to be returned unchanged")
+	}
+
+	got := ShrinkRadiance(img, 4)
+	if got.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Fatalf("Expected 4x2, got %v", got.Bounds())
+	}
+	for x, want := range []float64{0.01, 0.01, 10, 10} {
+		if c := got.RGBAt(x, 1); math.Abs(c.R-want) > 1e-5 {
+			t.Errorf("Pixel %d: expected %v, got %v", x, want, c.R)
+		}
+	}
+
+	// A single pixel averages the whole map
+	single := ShrinkRadiance(img, 1)
+	if c := single.RGBAt(0, 0); math.Abs(c.G-5.005) > 1e-5 {
+		t.Errorf("Expected the mean radiance 5.005, got %v", c.G)
+	}
+}
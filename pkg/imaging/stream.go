@@ -0,0 +1,260 @@
+package imaging
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mdouchement/hdr"
+)
+
+// RowReader reads an image from top to bottom in bands of rows, converting
+// them to HDR with channels in [0, 1]. It lets very large images be processed
+// without holding the whole frame in memory.
+type RowReader interface {
+	// Bounds returns the bounds of the whole image
+	Bounds() image.Rectangle
+	// ReadRows converts the next dst.Bounds().Dy() rows into dst, which
+	// must be as wide as the image
+	ReadRows(dst *hdr.RGB) error
+	// Reset rewinds the reader to the first row
+	Reset() error
+	// Buffered returns the number of bytes the reader keeps in memory
+	// regardless of the band height
+	Buffered() int64
+	Close() error
+}
+
+// RowWriter encodes an image from top to bottom in bands of rows
+type RowWriter interface {
+	// WriteRows encodes the next band of rows. Bands must be written in
+	// order and cover the image bounds given to CreateRows.
+	WriteRows(band image.Image) error
+	// Close flushes the encoder and closes the file
+	Close() error
+}
+
+// RowAlignment is the number of rows band heights must be a multiple of
+// for CreateRows writers (the height of a JPEG MCU row)
+const RowAlignment = 16
+
+// OpenRows opens the image at filepath for reading row by row. Non-interlaced
+// PNG files are decoded incrementally; other files are decoded up front and
+// converted band by band, keeping only the compact decoded image in memory.
+func OpenRows(filepath string) (RowReader, error) {
+	ext := strings.ToLower(path.Ext(filepath))
+	if !SupportedFormats[ext] {
+		return nil, errors.New("unsupported image format: " + ext + ". Supported formats: PNG, JPEG")
+	}
+
+	if ext == ".png" {
+		r, err := openPNGRows(filepath)
+		if err == nil {
+			return r, nil
+		}
+		if !errors.Is(err, errInterlaced) {
+			return nil, err
+		}
+	}
+
+	img, err := decodeImage(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &imageRowReader{img: img, y: img.Bounds().Min.Y}, nil
+}
+
+// imageRowReader converts the rows of a decoded image on demand
+type imageRowReader struct {
+	img image.Image
+	y   int
+}
+
+func (r *imageRowReader) Bounds() image.Rectangle { return r.img.Bounds() }
+
+func (r *imageRowReader) ReadRows(dst *hdr.RGB) error {
+	bounds := r.img.Bounds()
+	rows := dst.Bounds().Dy()
+	if r.y+rows > bounds.Max.Y {
+		return fmt.Errorf("reading rows %d-%d past the end of the image", r.y, r.y+rows)
+	}
+
+	for dy := 0; dy < rows; dy++ {
+		i := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+dy)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cr, cg, cb := rgbAt(r.img, x, r.y)
+			dst.Pix[i] = float32(cr) / 0xffff
+			dst.Pix[i+1] = float32(cg) / 0xffff
+			dst.Pix[i+2] = float32(cb) / 0xffff
+			i += 3
+		}
+		r.y++
+	}
+	return nil
+}
+
+func (r *imageRowReader) Reset() error {
+	r.y = r.img.Bounds().Min.Y
+	return nil
+}
+
+func (r *imageRowReader) Buffered() int64 {
+	return imageSize(r.img)
+}
+
+func (r *imageRowReader) Close() error { return nil }
+
+// imageSize estimates the bytes used by the pixels of a decoded image
+func imageSize(img image.Image) int64 {
+	pixels := int64(img.Bounds().Dx()) * int64(img.Bounds().Dy())
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return int64(len(m.Y) + len(m.Cb) + len(m.Cr))
+	case *image.Gray, *image.Paletted:
+		return pixels
+	case *image.Gray16:
+		return 2 * pixels
+	case *image.RGBA64, *image.NRGBA64:
+		return 8 * pixels
+	default:
+		return 4 * pixels
+	}
+}
+
+// CreateRows creates an image at filepath that is encoded band by band.
+// PNG files are written as 16-bit RGB; JPEG files use the same quality as
+// SaveImage.
+func CreateRows(filepath string, bounds image.Rectangle) (RowWriter, error) {
+	return CreateRowsOptions(filepath, bounds, DefaultEncodeOptions())
+}
+
+// CreateRowsOptions is CreateRows with explicit encoder settings
+func CreateRowsOptions(filepath string, bounds image.Rectangle, opts EncodeOptions) (RowWriter, error) {
+	ext := strings.ToLower(path.Ext(filepath))
+	switch ext {
+	case ".jpg", ".jpeg", ".png":
+	default:
+		return nil, errors.New("unsupported output format: " + ext + ". Supported formats: PNG, JPEG")
+	}
+
+	file, err := os.Create(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	if ext == ".png" {
+		return newPNGRowWriter(file, bounds, opts)
+	}
+	return newJPEGRowWriter(file, bounds, opts), nil
+}
+
+// jpegRowWriter feeds bands to the standard JPEG encoder, which runs in its
+// own goroutine and pulls rows through a bandImage
+type jpegRowWriter struct {
+	file *os.File
+	img  *bandImage
+	done chan error
+	err  error
+	// finished is set once the encoder goroutine has returned
+	finished bool
+}
+
+func newJPEGRowWriter(file *os.File, bounds image.Rectangle, opts EncodeOptions) *jpegRowWriter {
+	w := &jpegRowWriter{
+		file: file,
+		img: &bandImage{
+			bounds:  bounds,
+			bands:   make(chan image.Image),
+			release: make(chan struct{}, 1),
+		},
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		bw := bufio.NewWriter(file)
+		err := encodeJPEG(bw, w.img, opts.Quality, opts.Profile)
+		if err == nil {
+			err = bw.Flush()
+		}
+		w.done <- err
+	}()
+
+	return w
+}
+
+func (w *jpegRowWriter) WriteRows(band image.Image) error {
+	if w.finished {
+		if w.err != nil {
+			return w.err
+		}
+		return errors.New("jpeg: rows written past the end of the image")
+	}
+
+	select {
+	case w.img.bands <- band:
+	case w.err = <-w.done:
+		w.finished = true
+		return w.err
+	}
+
+	// Wait until the encoder moves past this band so the caller can reuse it
+	select {
+	case <-w.img.release:
+	case w.err = <-w.done:
+		w.finished = true
+	}
+	return w.err
+}
+
+func (w *jpegRowWriter) Close() error {
+	if !w.finished {
+		close(w.img.bands)
+		w.err = <-w.done
+		w.finished = true
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// bandImage is an image.Image whose rows arrive in bands through a channel.
+// It supports the top-to-bottom access pattern of the JPEG encoder.
+type bandImage struct {
+	bounds image.Rectangle
+	bands  chan image.Image
+	// release is signaled when the encoder no longer needs the current band
+	release chan struct{}
+	current image.Image
+	closed  bool
+}
+
+func (b *bandImage) ColorModel() color.Model { return color.RGBA64Model }
+
+func (b *bandImage) Bounds() image.Rectangle { return b.bounds }
+
+func (b *bandImage) At(x, y int) color.Color {
+	for !b.closed && (b.current == nil || y >= b.current.Bounds().Max.Y) {
+		if b.current != nil {
+			b.release <- struct{}{}
+		}
+		band, ok := <-b.bands
+		if !ok {
+			// The writer was closed early; fill the rest with black
+			b.closed = true
+			b.current = nil
+			break
+		}
+		b.current = band
+	}
+
+	if b.current == nil || !image.Pt(x, y).In(b.current.Bounds()) {
+		return color.RGBA64{A: 0xffff}
+	}
+	return b.current.At(x, y)
+}
@@ -0,0 +1,197 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdouchement/hdr"
+)
+
+// createPatternImages returns images of several PNG color types with varied pixels
+func createPatternImages(width, height int) map[string]image.Image {
+	rect := image.Rect(0, 0, width, height)
+	rgba := image.NewRGBA(rect)
+	nrgba := image.NewNRGBA(rect)
+	rgba64 := image.NewRGBA64(rect)
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	paletted := image.NewPaletted(rect, color.Palette{
+		color.RGBA{A: 0xff},
+		color.RGBA{R: 200, G: 100, B: 50, A: 0xff},
+		color.NRGBA{R: 10, G: 220, B: 90, A: 128},
+	})
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x*7 + y*13) % 256)
+			rgba.SetRGBA(x, y, color.RGBA{R: v, G: 255 - v, B: uint8(x), A: 0xff})
+			nrgba.SetNRGBA(x, y, color.NRGBA{R: v, G: uint8(y), B: 90, A: uint8(x * 20)})
+			rgba64.SetRGBA64(x, y, color.RGBA64{R: uint16(v) * 257, G: uint16(x * 1000), B: uint16(y * 999), A: 0xffff})
+			gray.SetGray(x, y, color.Gray{Y: v})
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(x*y) * 300})
+			paletted.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+
+	return map[string]image.Image{
+		"rgba": rgba, "nrgba": nrgba, "rgba64": rgba64,
+		"gray": gray, "gray16": gray16, "paletted": paletted,
+	}
+}
+
+// writePNG encodes img to a PNG file in dir
+func writePNG(t *testing.T, dir, name string, img image.Image) string {
+	t.Helper()
+	path := filepath.Join(dir, name+".png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readAllRows reads the image from r in bands of rows
+func readAllRows(t *testing.T, r RowReader, rows int) *hdr.RGB {
+	t.Helper()
+	bounds := r.Bounds()
+	full := hdr.NewRGB(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += rows {
+		band := image.Rect(bounds.Min.X, y, bounds.Max.X, min(y+rows, bounds.Max.Y))
+		dst := &hdr.RGB{Pix: full.Pix[full.PixOffset(bounds.Min.X, y):], Stride: full.Stride, Rect: band}
+		if err := r.ReadRows(dst); err != nil {
+			t.Fatalf("Failed to read rows at %d: %v", y, err)
+		}
+	}
+	return full
+}
+
+func TestOpenRowsMatchesLoadImage(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, img := range createPatternImages(23, 17) {
+		t.Run(name, func(t *testing.T) {
+			path := writePNG(t, dir, name, img)
+
+			r, err := OpenRows(path)
+			if err != nil {
+				t.Fatalf("Failed to open rows: %v", err)
+			}
+			defer r.Close()
+
+			// Read twice to exercise Reset
+			readAllRows(t, r, 5)
+			if err := r.Reset(); err != nil {
+				t.Fatalf("Failed to reset: %v", err)
+			}
+			got := readAllRows(t, r, 4)
+
+			want, err := LoadImage(path)
+			if err != nil {
+				t.Fatalf("Failed to load image: %v", err)
+			}
+			for i, v := range want.(*hdr.RGB).Pix {
+				if math.Abs(float64(v-got.Pix[i])) > 1e-6 {
+					t.Fatalf("Sample %d: expected %f, got %f", i, v, got.Pix[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenRowsJPEG(t *testing.T) {
+	path, cleanup := createTestImageFile(t, "jpeg", color.RGBA{R: 200, G: 100, A: 255})
+	defer cleanup()
+
+	r, err := OpenRows(path)
+	if err != nil {
+		t.Fatalf("Failed to open rows: %v", err)
+	}
+	defer r.Close()
+
+	if r.Buffered() == 0 {
+		t.Error("Expected decoded JPEG to report buffered memory")
+	}
+	got := readAllRows(t, r, 1)
+	if got.Bounds().Dx() != 2 || got.Bounds().Dy() != 2 {
+		t.Errorf("Expected 2x2 image, got %v", got.Bounds())
+	}
+}
+
+func TestCreateRows(t *testing.T) {
+	src := createPatternImages(37, 40)["rgba64"].(*image.RGBA64)
+	dir := t.TempDir()
+
+	for _, ext := range []string{".png", ".jpg"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(dir, "out"+ext)
+			w, err := CreateRows(path, src.Bounds())
+			if err != nil {
+				t.Fatalf("Failed to create writer: %v", err)
+			}
+
+			// Reuse one band buffer, as the streaming pipeline does
+			band := image.NewRGBA64(image.Rect(0, 0, 37, RowAlignment))
+			for y := 0; y < 40; y += RowAlignment {
+				rect := image.Rect(0, y, 37, min(y+RowAlignment, 40))
+				band.Rect = rect
+				band.Pix = band.Pix[:cap(band.Pix)][:rect.Dy()*band.Stride]
+				for yy := rect.Min.Y; yy < rect.Max.Y; yy++ {
+					for x := 0; x < 37; x++ {
+						band.SetRGBA64(x, yy, src.RGBA64At(x, yy))
+					}
+				}
+				if err := w.WriteRows(band); err != nil {
+					t.Fatalf("Failed to write rows at %d: %v", y, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Failed to close writer: %v", err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			var img image.Image
+			if ext == ".png" {
+				img, err = png.Decode(file)
+			} else {
+				img, err = jpeg.Decode(file)
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode output: %v", err)
+			}
+			if img.Bounds() != src.Bounds() {
+				t.Fatalf("Expected bounds %v, got %v", src.Bounds(), img.Bounds())
+			}
+
+			if ext == ".png" {
+				for y := 0; y < 40; y++ {
+					for x := 0; x < 37; x++ {
+						if img.At(x, y) != color.Color(src.RGBA64At(x, y)) {
+							t.Fatalf("Pixel (%d, %d): expected %v, got %v", x, y, src.RGBA64At(x, y), img.At(x, y))
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCreateRowsUnsupportedFormat(t *testing.T) {
+	if _, err := CreateRows(filepath.Join(t.TempDir(), "out.bmp"), image.Rect(0, 0, 2, 2)); err == nil {
+		t.Error("Expected error for unsupported format, got nil")
+	}
+}
@@ -0,0 +1,128 @@
+// Package jobs runs the HDR pipeline asynchronously. Jobs are kept in a
+// Store on disk, so they survive restarts, and a Manager processes the
+// queued ones in the background, recording their progress as they go.
+//
+// A job moves from StateQueued to StateRunning and ends as StateSucceeded,
+// StateFailed or StateCanceled. Jobs interrupted by a shutdown return to the
+// queue and are run again by the next Manager of the store.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// State is the stage of a job's life
+type State string
+
+// States of a job
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+)
+
+// Finished reports whether a job in state s is done
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCanceled
+}
+
+// Format describes an output format
+type Format struct {
+	Ext         string
+	ContentType string
+}
+
+// Formats maps the output formats to their file extension and content type.
+// The hdr and pfm formats save the radiance map instead of tone mapping it.
+var Formats = map[string]Format{
+	"jpg": {".jpg", "image/jpeg"},
+	"png": {".png", "image/png"},
+	"hdr": {".hdr", "image/vnd.radiance"},
+	"pfm": {".pfm", "image/x-portable-floatmap"},
+}
+
+// Spec is what a job should produce
+type Spec struct {
+	Settings config.Config `json:"settings"`
+	// Format is a key of Formats
+	Format string `json:"format"`
+}
+
+// Validate checks the settings and format of s
+func (s Spec) Validate() error {
+	if _, ok := Formats[s.Format]; !ok {
+		return fmt.Errorf("unsupported format %q (jpg, png, hdr, pfm)", s.Format)
+	}
+	return s.Settings.Validate()
+}
+
+// Job is the record of a job
+type Job struct {
+	ID string `json:"id"`
+	Spec
+	State State `json:"state"`
+	// Stage is the pipeline stage being run (see package progress)
+	Stage string `json:"stage,omitempty"`
+	// Progress is the completed fraction of the job, from 0 to 1
+	Progress float64 `json:"progress"`
+	// Inputs are the names of the exposures in the job directory
+	Inputs []string `json:"inputs"`
+	// Result is the name of the output file once the job succeeded
+	Result     string     `json:"result,omitempty"`
+	Warnings   []string   `json:"warnings,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Run merges the exposures at inputs with p, ordering them from darkest to
+// brightest first, and saves the result to output: the radiance map for
+// .hdr and .pfm paths, the tone mapped image otherwise. When the exposures
+// cannot be ordered or aligned, the run goes on and the failure is
+// returned as a warning.
+func Run(ctx context.Context, p *processor.HDRProcessor, inputs []string, output string) ([]string, error) {
+	var warnings []string
+	frames := imaging.ReadFrames(inputs)
+	if err := imaging.OrderByExposure(frames); err != nil {
+		warnings = append(warnings, fmt.Sprintf("could not order exposures: %v", err))
+	}
+
+	radiance := imaging.IsRadiance(output)
+	res, err := p.RunPipeline(ctx, processor.PipelineOptions{SkipToneMap: radiance}, imaging.Paths(frames)...)
+	if res.AlignErr != nil {
+		warnings = append(warnings, fmt.Sprintf("image alignment failed: %v", res.AlignErr))
+	}
+	if err != nil {
+		return warnings, err
+	}
+
+	if radiance {
+		err = p.SaveContext(ctx, res.Denoised, output)
+	} else {
+		err = p.SaveContext(ctx, res.Result, output)
+	}
+	if err != nil {
+		return warnings, fmt.Errorf("saving output image: %w", err)
+	}
+	return warnings, nil
+}
+
+// ErrNotFound is returned for unknown job IDs
+var ErrNotFound = errors.New("job not found")
+
+// ErrFinished is returned when canceling a job that is already done
+var ErrFinished = errors.New("job already finished")
+
+// ErrNotReady is returned when asking for the result of a job that did not
+// succeed
+var ErrNotReady = errors.New("job has no result")
@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// writeBracket saves a bracket of three 16x12 gradients in dir, brightest
+// first, and returns their paths
+func writeBracket(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	for i, exposure := range []float64{3, 1, 0.25} {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 12))
+		for y := 0; y < 12; y++ {
+			for x := 0; x < 16; x++ {
+				v := min(255, float64(x*16+y*4)*exposure)
+				img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+			}
+		}
+		path := filepath.Join(dir, string(rune('a'+i))+".png")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeBracket(t, dir)
+
+	for _, name := range []string{"out.png", "out.hdr"} {
+		t.Run(name, func(t *testing.T) {
+			output := filepath.Join(dir, name)
+			if _, err := Run(context.Background(), processor.NewHDRProcessor(), inputs, output); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			if imaging.IsRadiance(output) {
+				_, err = imaging.LoadRadiance(output)
+			} else {
+				_, err = imaging.LoadImage(output)
+			}
+			if err != nil {
+				t.Errorf("Expected a readable output: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeBracket(t, dir)
+
+	_, err := Run(context.Background(), processor.NewHDRProcessor(), append(inputs, filepath.Join(dir, "missing.png")), filepath.Join(dir, "out.png"))
+	var inputErr *processor.InputError
+	if !errors.As(err, &inputErr) {
+		t.Errorf("Expected an input error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, processor.NewHDRProcessor(), inputs, filepath.Join(dir, "out.png")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	invalid := config.Default()
+	invalid.ToneMap.Operator = "aces"
+
+	tests := []struct {
+		name    string
+		spec    Spec
+		wantErr bool
+	}{
+		{"jpeg", Spec{Settings: config.Default(), Format: "jpg"}, false},
+		{"radiance", Spec{Settings: config.Default(), Format: "pfm"}, false},
+		{"unknown format", Spec{Settings: config.Default(), Format: "gif"}, true},
+		{"invalid settings", Spec{Settings: invalid, Format: "png"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.spec.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStateFinished(t *testing.T) {
+	for state, want := range map[State]bool{
+		StateQueued:    false,
+		StateRunning:   false,
+		StateSucceeded: true,
+		StateFailed:    true,
+		StateCanceled:  true,
+	} {
+		if got := state.Finished(); got != want {
+			t.Errorf("%s.Finished() = %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestFormatsAreSupported(t *testing.T) {
+	for name, f := range Formats {
+		if !imaging.SupportedFormats[f.Ext] && !imaging.IsRadiance(f.Ext) {
+			t.Errorf("Format %s: extension %s cannot be saved", name, f.Ext)
+		}
+	}
+}
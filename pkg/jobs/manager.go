@@ -0,0 +1,432 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+)
+
+// Defaults of the manager settings
+const (
+	DefaultConcurrency  = 1
+	DefaultPollInterval = time.Second
+)
+
+// progressInterval is how often the progress of a running job is saved
+const progressInterval = 250 * time.Millisecond
+
+// stages are the pipeline stages in the order a job runs them, to turn the
+// progress of a stage into the progress of the job
+var stages = []string{
+	progress.StageLoad,
+	progress.StageAlign,
+	progress.StageLinearize,
+	progress.StageMerge,
+	progress.StageDenoise,
+	progress.StageToneMap,
+	progress.StageSave,
+}
+
+// ErrActive is returned when deleting a job that is queued or running
+var ErrActive = errors.New("job is queued or running, cancel it first")
+
+// Manager runs the queued jobs of a Store. Jobs submitted by other
+// processes are picked up at every poll, and their cancel requests are
+// honored, but a store should be run by a single Manager at a time.
+type Manager struct {
+	store        *Store
+	concurrency  int
+	retention    time.Duration
+	pollInterval time.Duration
+	procOpts     []processor.Option
+	// run runs the pipeline of a job, Run outside of tests
+	run func(ctx context.Context, p *processor.HDRProcessor, inputs []string, output string) ([]string, error)
+
+	mu sync.Mutex
+	// running holds the jobs being run by this manager
+	running map[string]*runningJob
+	wake    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// runningJob is a job being run by the manager
+type runningJob struct {
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// WithConcurrency sets the number of jobs run at once
+func WithConcurrency(n int) Option {
+	return func(m *Manager) {
+		m.concurrency = max(1, n)
+	}
+}
+
+// WithRetention deletes finished jobs once they are older than d. Zero, the
+// default, keeps them until they are deleted.
+func WithRetention(d time.Duration) Option {
+	return func(m *Manager) {
+		m.retention = max(0, d)
+	}
+}
+
+// WithPollInterval sets how often the store is checked for new jobs, cancel
+// requests and expired jobs
+func WithPollInterval(d time.Duration) Option {
+	return func(m *Manager) {
+		if d > 0 {
+			m.pollInterval = d
+		}
+	}
+}
+
+// WithProcessorOptions sets processor options applied to every job before
+// its settings
+func WithProcessorOptions(opts ...processor.Option) Option {
+	return func(m *Manager) {
+		m.procOpts = append(m.procOpts, opts...)
+	}
+}
+
+// NewManager creates a Manager for store. Unless processor options say
+// otherwise, the CPUs are shared evenly between the concurrent jobs.
+func NewManager(store *Store, opts ...Option) *Manager {
+	m := &Manager{
+		store:        store,
+		concurrency:  DefaultConcurrency,
+		pollInterval: DefaultPollInterval,
+		run:          Run,
+		running:      map[string]*runningJob{},
+		wake:         make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	workers := processor.WithWorkers(max(1, runtime.NumCPU()/m.concurrency))
+	m.procOpts = append([]processor.Option{workers}, m.procOpts...)
+	return m
+}
+
+// Store returns the store of m
+func (m *Manager) Store() *Store {
+	return m.store
+}
+
+// Submit queues a job producing spec from the exposures at inputs. The
+// files are copied into the store.
+func (m *Manager) Submit(spec Spec, inputs []string) (*Job, error) {
+	j, err := m.store.Create(spec, inputs)
+	if err != nil {
+		return nil, err
+	}
+	m.notify()
+	return j, nil
+}
+
+// Get returns the record of job id
+func (m *Manager) Get(id string) (*Job, error) {
+	return m.store.Get(id)
+}
+
+// List returns all jobs, oldest first
+func (m *Manager) List() ([]*Job, error) {
+	return m.store.List()
+}
+
+// Result returns the job and the path of its output once it succeeded
+func (m *Manager) Result(id string) (*Job, string, error) {
+	j, err := m.store.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if j.State != StateSucceeded {
+		return j, "", ErrNotReady
+	}
+	return j, m.store.Path(id, j.Result), nil
+}
+
+// Cancel cancels job id. A queued job is canceled at once; a running job
+// stops at the next check of its context, so the returned record may still
+// show it running.
+func (m *Manager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, err := m.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case j.State.Finished():
+		return j, ErrFinished
+	case j.State == StateQueued:
+		finish(j, StateCanceled)
+		return j, m.store.Save(j)
+	}
+	if r, ok := m.running[id]; ok {
+		r.canceled = true
+		r.cancel()
+		return j, nil
+	}
+	// Running in another process
+	return j, m.store.RequestCancel(id)
+}
+
+// Delete removes a finished job and its files
+func (m *Manager) Delete(id string) error {
+	j, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if !j.State.Finished() {
+		return ErrActive
+	}
+	return m.store.Delete(id)
+}
+
+// Run processes jobs until ctx is done. Jobs left running by a previous
+// manager are queued again first. On return, the jobs that were running
+// are back in the queue.
+func (m *Manager) Run(ctx context.Context) error {
+	return m.loop(ctx, false)
+}
+
+// RunPending processes the queued jobs and returns once none is left
+func (m *Manager) RunPending(ctx context.Context) error {
+	return m.loop(ctx, true)
+}
+
+// loop polls the store and starts queued jobs, until ctx is done or, with
+// untilIdle, until no job is left to run
+func (m *Manager) loop(ctx context.Context, untilIdle bool) error {
+	if err := m.recoverJobs(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	defer m.wg.Wait()
+	// Stop the running jobs before waiting for them when polling fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for {
+		started, err := m.poll(ctx)
+		if err != nil {
+			return err
+		}
+		if untilIdle && started == 0 && m.active() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-m.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// recoverJobs queues again the jobs a previous manager left running, or
+// cancels them if it was asked to
+func (m *Manager) recoverJobs() error {
+	list, err := m.store.List()
+	if err != nil {
+		return err
+	}
+	for _, j := range list {
+		if j.State != StateRunning {
+			continue
+		}
+		if m.store.cancelRequested(j.ID) {
+			finish(j, StateCanceled)
+			os.Remove(m.store.Path(j.ID, cancelFile))
+		} else {
+			requeue(j)
+		}
+		if err := m.store.Save(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poll deletes expired jobs, forwards cancel requests and starts queued jobs
+// while slots are free. It returns the number of jobs started.
+func (m *Manager) poll(ctx context.Context) (int, error) {
+	if m.retention > 0 {
+		if _, err := m.store.Prune(time.Now().Add(-m.retention)); err != nil {
+			return 0, err
+		}
+	}
+	list, err := m.store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	started := 0
+	for _, j := range list {
+		if r, ok := m.running[j.ID]; ok {
+			if m.store.cancelRequested(j.ID) {
+				r.canceled = true
+				r.cancel()
+			}
+			continue
+		}
+		if j.State != StateQueued || len(m.running) >= m.concurrency || ctx.Err() != nil {
+			continue
+		}
+		ok, err := m.start(ctx, j.ID)
+		if err != nil {
+			return started, err
+		}
+		if ok {
+			started++
+		}
+	}
+	return started, nil
+}
+
+// start marks job id running and runs it in the background, reporting
+// whether it did. The job is read again, since it may have been canceled or
+// deleted since it was listed, and skipped unless still queued. m.mu must
+// be held.
+func (m *Manager) start(ctx context.Context, id string) (bool, error) {
+	j, err := m.store.Get(id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if j.State != StateQueued {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	j.State = StateRunning
+	j.StartedAt = &now
+	if err := m.store.Save(j); err != nil {
+		return false, err
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	r := &runningJob{cancel: cancel}
+	m.running[j.ID] = r
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.execute(jobCtx, j, r)
+	}()
+	return true, nil
+}
+
+// execute runs j and records how it ended. A job interrupted because the
+// manager stopped returns to the queue.
+func (m *Manager) execute(ctx context.Context, j *Job, r *runningJob) {
+	t := &tracker{store: m.store, job: j}
+	opts := append(slices.Clone(m.procOpts), j.Settings.Options()...)
+	p := processor.NewHDRProcessor(append(opts, processor.WithProgress(t))...)
+
+	inputs := make([]string, len(j.Inputs))
+	for i, name := range j.Inputs {
+		inputs[i] = m.store.Path(j.ID, name)
+	}
+	result := "result" + Formats[j.Format].Ext
+	warnings, err := m.run(ctx, p, inputs, m.store.Path(j.ID, result))
+	interrupted := err != nil && ctx.Err() != nil
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, j.ID)
+	r.cancel()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	j.Warnings = warnings
+	switch {
+	case r.canceled:
+		finish(j, StateCanceled)
+	case interrupted:
+		requeue(j)
+	case err != nil:
+		finish(j, StateFailed)
+		j.Error = err.Error()
+	default:
+		finish(j, StateSucceeded)
+		j.Progress = 1
+		j.Result = result
+	}
+	// A failed save leaves the job running until the next manager queues
+	// it again
+	m.store.Save(j)
+	os.Remove(m.store.Path(j.ID, cancelFile))
+	m.notify()
+}
+
+// active returns the number of jobs being run
+func (m *Manager) active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.running)
+}
+
+// notify wakes the loop up to start queued jobs
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish moves j to the final state
+func finish(j *Job, state State) {
+	now := time.Now().UTC()
+	j.State = state
+	j.Stage = ""
+	j.FinishedAt = &now
+}
+
+// requeue moves j back to the queue
+func requeue(j *Job) {
+	j.State = StateQueued
+	j.Stage = ""
+	j.Progress = 0
+	j.Warnings = nil
+	j.StartedAt = nil
+}
+
+// tracker records the progress of a running job in its record
+type tracker struct {
+	mu    sync.Mutex
+	store *Store
+	job   *Job
+	saved time.Time
+}
+
+// Report implements progress.Reporter
+func (t *tracker) Report(stage string, fraction float64) {
+	i := slices.Index(stages, stage)
+	if i < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := stage != t.job.Stage
+	t.job.Stage = stage
+	t.job.Progress = max(t.job.Progress, (float64(i)+fraction)/float64(len(stages)))
+	if changed || time.Since(t.saved) >= progressInterval {
+		t.store.Save(t.job)
+		t.saved = time.Now()
+	}
+}
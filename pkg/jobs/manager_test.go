@@ -0,0 +1,305 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// newTestManager creates a manager for a new store in a temporary directory
+func newTestManager(t *testing.T, opts ...Option) *Manager {
+	t.Helper()
+	s, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(s, append([]Option{WithPollInterval(10 * time.Millisecond)}, opts...)...)
+}
+
+// blockRuns makes the jobs of m wait until their context is done, and
+// returns a channel receiving the ID of every job started
+func blockRuns(m *Manager) <-chan string {
+	started := make(chan string, 8)
+	m.run = func(ctx context.Context, p *processor.HDRProcessor, inputs []string, output string) ([]string, error) {
+		started <- filepath.Base(filepath.Dir(output))
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return started
+}
+
+// waitState polls job id until it reaches state
+func waitState(t *testing.T, m *Manager, id string, state State) *Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		j, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State == state {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %s is %s, expected %s", id, j.State, state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerRunPending(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	m := newTestManager(t, WithConcurrency(2))
+
+	var ids []string
+	for _, format := range []string{"jpg", "hdr", "png"} {
+		j, err := m.Submit(Spec{Settings: config.Default(), Format: format}, inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID)
+	}
+	if err := m.RunPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		j, path, err := m.Result(id)
+		if err != nil {
+			t.Fatalf("Job %s: %v (%+v)", id, err, j)
+		}
+		if j.Progress != 1 || j.StartedAt == nil || j.FinishedAt == nil {
+			t.Errorf("Unexpected record of a finished job: %+v", j)
+		}
+		if filepath.Ext(path) != Formats[j.Format].Ext {
+			t.Errorf("Expected a %s result, got %s", j.Format, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected the result file: %v", err)
+		}
+	}
+}
+
+func TestManagerFailedJob(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeBracket(t, dir)
+	corrupt := filepath.Join(dir, "corrupt.png")
+	os.WriteFile(corrupt, []byte("not a png"), 0o644)
+
+	m := newTestManager(t)
+	j, err := m.Submit(Spec{Settings: config.Default(), Format: "jpg"}, []string{inputs[0], corrupt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RunPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ = m.Get(j.ID)
+	if j.State != StateFailed || j.Error == "" {
+		t.Errorf("Expected a failed job with an error, got %+v", j)
+	}
+	if _, _, err := m.Result(j.ID); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Expected ErrNotReady, got %v", err)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	m := newTestManager(t)
+	started := blockRuns(m)
+	spec := Spec{Settings: config.Default(), Format: "jpg"}
+
+	running, _ := m.Submit(spec, inputs)
+	queued, _ := m.Submit(spec, inputs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	if id := <-started; id != running.ID {
+		t.Fatalf("Expected the oldest job to start, got %s", id)
+	}
+
+	// The queued job is canceled at once and never runs
+	j, err := m.Cancel(queued.ID)
+	if err != nil || j.State != StateCanceled {
+		t.Errorf("Expected a canceled job, got %+v, %v", j, err)
+	}
+	if _, err := m.Cancel(queued.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("Expected ErrFinished, got %v", err)
+	}
+
+	if err := m.Delete(running.ID); !errors.Is(err, ErrActive) {
+		t.Errorf("Expected ErrActive, got %v", err)
+	}
+	if _, err := m.Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, running.ID, StateCanceled)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-started:
+		t.Errorf("Job %s started after being canceled", id)
+	default:
+	}
+	if err := m.Delete(running.ID); err != nil {
+		t.Errorf("Expected to delete a canceled job: %v", err)
+	}
+}
+
+func TestManagerStartRereadsJob(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	m := newTestManager(t)
+	started := blockRuns(m)
+
+	// A job listed as queued, then canceled before the manager starts it
+	j, err := m.Submit(Spec{Settings: config.Default(), Format: "jpg"}, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Cancel(j.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	ok, err := m.start(context.Background(), j.ID)
+	if err == nil && !ok {
+		// Jobs deleted since they were listed are skipped too
+		ok, err = m.start(context.Background(), "missing")
+	}
+	m.mu.Unlock()
+	if ok || err != nil {
+		t.Fatalf("Expected the job to be skipped, got %v, %v", ok, err)
+	}
+	if got, _ := m.Get(j.ID); got.State != StateCanceled {
+		t.Errorf("Expected the job to stay canceled, got %s", got.State)
+	}
+	select {
+	case id := <-started:
+		t.Errorf("Job %s started after being canceled", id)
+	default:
+	}
+}
+
+func TestManagerCancelFromAnotherProcess(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	m := newTestManager(t)
+	started := blockRuns(m)
+	j, _ := m.Submit(Spec{Settings: config.Default(), Format: "jpg"}, inputs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	<-started
+
+	// A manager that does not run the job leaves a request in the store
+	other := NewManager(m.Store())
+	if _, err := other.Cancel(j.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, j.ID, StateCanceled)
+}
+
+func TestManagerShutdownRequeues(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	m := newTestManager(t)
+	started := blockRuns(m)
+	j, _ := m.Submit(Spec{Settings: config.Default(), Format: "jpg"}, inputs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	<-started
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ = m.Get(j.ID)
+	if j.State != StateQueued || j.StartedAt != nil {
+		t.Errorf("Expected the interrupted job back in the queue, got %+v", j)
+	}
+
+	// The next manager runs it
+	next := NewManager(m.Store())
+	if err := next.RunPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ = next.Get(j.ID); j.State != StateSucceeded {
+		t.Errorf("Expected the job to succeed, got %+v", j)
+	}
+}
+
+func TestManagerRecoversRunningJobs(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	s, _ := OpenStore(t.TempDir())
+	spec := Spec{Settings: config.Default(), Format: "jpg"}
+
+	// Jobs left running by a process that died, one of them asked to cancel
+	var ids []string
+	for range 2 {
+		j, _ := s.Create(spec, inputs)
+		j.State = StateRunning
+		s.Save(j)
+		ids = append(ids, j.ID)
+	}
+	s.RequestCancel(ids[1])
+
+	if err := NewManager(s).RunPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []State{StateSucceeded, StateCanceled} {
+		if j, _ := s.Get(ids[i]); j.State != want {
+			t.Errorf("Job %d: expected %s, got %s", i, want, j.State)
+		}
+	}
+}
+
+func TestManagerRetention(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	m := newTestManager(t, WithRetention(time.Hour))
+	j, _ := m.Submit(Spec{Settings: config.Default(), Format: "jpg"}, inputs)
+	m.Cancel(j.ID)
+
+	expired, _ := m.Submit(Spec{Settings: config.Default(), Format: "jpg"}, inputs)
+	m.Cancel(expired.ID)
+	expired, _ = m.Get(expired.ID)
+	old := expired.FinishedAt.Add(-2 * time.Hour)
+	expired.FinishedAt = &old
+	m.Store().Save(expired)
+
+	if err := m.RunPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(expired.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the expired job to be deleted, got %v", err)
+	}
+	if _, err := m.Get(j.ID); err != nil {
+		t.Errorf("Expected the recent job to be kept, got %v", err)
+	}
+}
+
+func TestTrackerProgress(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	s, _ := OpenStore(t.TempDir())
+	j, _ := s.Create(Spec{Settings: config.Default(), Format: "jpg"}, inputs)
+	tr := &tracker{store: s, job: j}
+
+	tr.Report("load", 1)
+	tr.Report("merge", 0.5)
+	tr.Report("unknown", 1)
+	saved, _ := s.Get(j.ID)
+	if saved.Stage != "merge" || saved.Progress != 3.5/7 {
+		t.Errorf("Expected merge at %.3f, got %s at %.3f", 3.5/7, saved.Stage, saved.Progress)
+	}
+}
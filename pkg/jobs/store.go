@@ -0,0 +1,259 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+// File names inside a job directory
+const (
+	recordFile = "job.json"
+	cancelFile = "cancel"
+)
+
+// Store keeps jobs on disk, one directory per job holding its record, its
+// exposures and its result. Records are replaced atomically, so a store
+// may be read by other processes while a Manager updates it.
+type Store struct {
+	dir string
+}
+
+// OpenStore opens the store in dir, creating the directory if needed
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("opening job store: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Path returns the path of the file name in the directory of job id
+func (s *Store) Path(id, name string) string {
+	return filepath.Join(s.dir, id, name)
+}
+
+// Create adds a queued job producing spec from copies of the exposures at
+// inputs. The job appears in the store once all its files are written.
+func (s *Store) Create(spec Spec, inputs []string) (*Job, error) {
+	if len(inputs) < 2 {
+		return nil, errors.New("at least two images are required")
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp(s.dir, ".new-")
+	if err != nil {
+		return nil, fmt.Errorf("creating job: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	j := &Job{ID: id, Spec: spec, State: StateQueued, CreatedAt: time.Now().UTC()}
+	for i, input := range inputs {
+		ext := strings.ToLower(filepath.Ext(input))
+		if !imaging.SupportedFormats[ext] {
+			return nil, fmt.Errorf("%s: unsupported image format (PNG, JPEG)", input)
+		}
+		name := fmt.Sprintf("%02d%s", i+1, ext)
+		if err := copyFile(filepath.Join(tmp, name), input); err != nil {
+			return nil, fmt.Errorf("creating job: %w", err)
+		}
+		j.Inputs = append(j.Inputs, name)
+	}
+	if err := writeRecord(tmp, j); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, id)); err != nil {
+		return nil, fmt.Errorf("creating job: %w", err)
+	}
+	return j, nil
+}
+
+// Get reads the record of job id
+func (s *Store) Get(id string) (*Job, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.Path(id, recordFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading job %s: %w", id, err)
+	}
+	var j Job
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("reading job %s: %w", id, err)
+	}
+	return &j, nil
+}
+
+// List returns all jobs, oldest first
+func (s *Store) List() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+	var list []*Job
+	for _, entry := range entries {
+		if !entry.IsDir() || !validID(entry.Name()) {
+			continue
+		}
+		j, err := s.Get(entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, j)
+	}
+	slices.SortStableFunc(list, func(a, b *Job) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return list, nil
+}
+
+// Save replaces the record of j
+func (s *Store) Save(j *Job) error {
+	if !validID(j.ID) {
+		return ErrNotFound
+	}
+	return writeRecord(filepath.Join(s.dir, j.ID), j)
+}
+
+// Delete removes job id and its files
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+		return fmt.Errorf("deleting job %s: %w", id, err)
+	}
+	return nil
+}
+
+// Prune deletes the finished jobs that finished before t and returns how
+// many were deleted
+func (s *Store) Prune(t time.Time) (int, error) {
+	list, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, j := range list {
+		if !j.State.Finished() || j.FinishedAt == nil || !j.FinishedAt.Before(t) {
+			continue
+		}
+		if err := s.Delete(j.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RequestCancel asks the process running job id to cancel it
+func (s *Store) RequestCancel(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	if err := os.WriteFile(s.Path(id, cancelFile), nil, 0o644); err != nil {
+		return fmt.Errorf("canceling job %s: %w", id, err)
+	}
+	return nil
+}
+
+// cancelRequested reports whether RequestCancel was called for job id
+func (s *Store) cancelRequested(id string) bool {
+	_, err := os.Stat(s.Path(id, cancelFile))
+	return err == nil
+}
+
+// writeRecord atomically writes the record of j in dir
+func writeRecord(dir string, j *Job) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".job-")
+	if err != nil {
+		return fmt.Errorf("saving job %s: %w", j.ID, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, recordFile))
+	}
+	if err != nil {
+		return fmt.Errorf("saving job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+// copyFile copies the file at src to a new file at dst
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// idLength is the number of hexadecimal digits of a job ID
+const idLength = 16
+
+// newID returns a random job ID
+func newID() (string, error) {
+	b := make([]byte, idLength/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("creating job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validID reports whether id has the form of a job ID, so that it can be
+// used in paths
+func validID(id string) bool {
+	if len(id) != idLength {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
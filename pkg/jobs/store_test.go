@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+)
+
+func TestStoreCreate(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	s, err := OpenStore(filepath.Join(t.TempDir(), "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := s.Create(Spec{Settings: config.Default(), Format: "png"}, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State != StateQueued || len(j.ID) != idLength {
+		t.Errorf("Unexpected job %+v", j)
+	}
+	for _, name := range j.Inputs {
+		if _, err := os.Stat(s.Path(j.ID, name)); err != nil {
+			t.Errorf("Expected a copy of the input: %v", err)
+		}
+	}
+
+	got, err := s.Get(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Format != "png" || got.Settings.ToneMap.Operator != j.Settings.ToneMap.Operator || !got.CreatedAt.Equal(j.CreatedAt) {
+		t.Errorf("Expected the saved job %+v, got %+v", j, got)
+	}
+
+	// No temporary directory is left behind
+	entries, _ := os.ReadDir(s.Dir())
+	if len(entries) != 1 {
+		t.Errorf("Expected only the job directory, got %d entries", len(entries))
+	}
+}
+
+func TestStoreCreateErrors(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeBracket(t, dir)
+	s, _ := OpenStore(filepath.Join(dir, "jobs"))
+	valid := Spec{Settings: config.Default(), Format: "jpg"}
+
+	tests := []struct {
+		name   string
+		spec   Spec
+		inputs []string
+	}{
+		{"single image", valid, inputs[:1]},
+		{"unknown format", Spec{Settings: config.Default(), Format: "gif"}, inputs},
+		{"unsupported image", valid, []string{inputs[0], filepath.Join(dir, "frame.tiff")}},
+		{"missing image", valid, []string{inputs[0], filepath.Join(dir, "missing.png")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Create(tt.spec, tt.inputs); err == nil {
+				t.Error("Expected an error")
+			}
+			if list, _ := s.List(); len(list) != 0 {
+				t.Errorf("Expected no job, got %d", len(list))
+			}
+		})
+	}
+}
+
+func TestStoreGetUnknown(t *testing.T) {
+	s, _ := OpenStore(t.TempDir())
+	for _, id := range []string{"0123456789abcdef", "../../etc/passwd", ""} {
+		if _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): expected ErrNotFound, got %v", id, err)
+		}
+	}
+}
+
+func TestStoreListAndPrune(t *testing.T) {
+	inputs := writeBracket(t, t.TempDir())
+	s, _ := OpenStore(t.TempDir())
+	spec := Spec{Settings: config.Default(), Format: "jpg"}
+
+	now := time.Now().UTC()
+	old, recent := now.Add(-2*time.Hour), now.Add(-time.Minute)
+	var ids []string
+	for i, finished := range []*time.Time{&old, &recent, nil} {
+		j, err := s.Create(spec, inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.CreatedAt = now.Add(time.Duration(i-3) * time.Hour)
+		if finished != nil {
+			j.State = StateSucceeded
+			j.FinishedAt = finished
+		}
+		if err := s.Save(j); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID)
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].ID != ids[0] || list[2].ID != ids[2] {
+		t.Errorf("Expected the jobs oldest first")
+	}
+
+	n, err := s.Prune(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 pruned job, got %d", n)
+	}
+	if _, err := s.Get(ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected the old job to be deleted")
+	}
+	if list, _ := s.List(); len(list) != 2 {
+		t.Errorf("Expected 2 jobs left, got %d", len(list))
+	}
+}
@@ -0,0 +1,282 @@
+// Package metrics scores images against a reference, to compare merge
+// methods and tone mappers objectively: PSNR, SSIM and MS-SSIM between two
+// display images or two radiance maps, TMQI for a tone mapped image against
+// its radiance map, and a visibility map of the differences an observer
+// would notice, in the spirit of HDR-VDP.
+//
+// Display images are taken as sRGB. Radiance maps are compared on their PQ
+// encoded channels, which are perceptually uniform over the whole range of
+// luminance, with linear 1 at the white luminance given to NewFrame.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+)
+
+// Names lists the metrics in the order Compare reports them
+var Names = []string{"psnr", "ssim", "ms-ssim", "tmqi", "visibility"}
+
+// DefaultWhite is the luminance of display white, and of linear 1 in
+// radiance maps, in cd/m²
+const DefaultWhite = 100
+
+// ErrMismatch is returned when a metric does not apply to a pair of frames
+var ErrMismatch = errors.New("metric does not apply")
+
+// Frame is an image prepared for the metrics
+type Frame struct {
+	// HDR reports a radiance map
+	HDR    bool
+	Width  int
+	Height int
+	// rgb holds the encoded channels in [0, 1]: sRGB for display images, PQ
+	// for radiance maps
+	rgb [3]plane
+	// lum holds the luminance in cd/m²
+	lum plane
+}
+
+// NewFrame prepares img for the metrics. Radiance maps (hdr.Image) have
+// linear 1 at white cd/m², and display images their white at white cd/m².
+func NewFrame(img image.Image, white float64) *Frame {
+	b := img.Bounds()
+	f := &Frame{Width: b.Dx(), Height: b.Dy()}
+	for c := range f.rgb {
+		f.rgb[c] = newPlane(f.Width, f.Height)
+	}
+	f.lum = newPlane(f.Width, f.Height)
+
+	m, isHDR := img.(hdr.Image)
+	f.HDR = isHDR
+	curve := colorspace.SRGBCurve
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			var rgb [3]float64
+			i := y*f.Width + x
+			if isHDR {
+				rgb[0], rgb[1], rgb[2], _ = m.HDRAt(b.Min.X+x, b.Min.Y+y).HDRRGBA()
+				for c, v := range rgb {
+					rgb[c] = max(v, 0)
+					f.rgb[c].pix[i] = colorspace.PQEncode(rgb[c] * white)
+				}
+			} else {
+				r, g, bb, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				for c, v := range [3]uint32{r, g, bb} {
+					f.rgb[c].pix[i] = float64(v) / 0xffff
+					rgb[c] = curve.Decode(f.rgb[c].pix[i])
+				}
+			}
+			f.lum.pix[i] = white * colorspace.SRGB.Luminance(rgb[0], rgb[1], rgb[2])
+		}
+	}
+	return f
+}
+
+// luma returns the Rec. 709 luma of the encoded channels
+func (f *Frame) luma() plane {
+	p := newPlane(f.Width, f.Height)
+	for i := range p.pix {
+		p.pix[i] = 0.2126*f.rgb[0].pix[i] + 0.7152*f.rgb[1].pix[i] + 0.0722*f.rgb[2].pix[i]
+	}
+	return p
+}
+
+// kind names the kind of image of f in errors
+func (f *Frame) kind() string {
+	if f.HDR {
+		return "radiance map"
+	}
+	return "display image"
+}
+
+// checkPair checks that ref and test have the same size, and the same kind
+// unless mixed is set, in which case ref must be a radiance map and test a
+// display image
+func checkPair(name string, ref, test *Frame, mixed bool) error {
+	if ref.Width != test.Width || ref.Height != test.Height {
+		return fmt.Errorf("%s: sizes differ: %dx%d and %dx%d", name, ref.Width, ref.Height, test.Width, test.Height)
+	}
+	if mixed && (!ref.HDR || test.HDR) {
+		return fmt.Errorf("%s: %w to a %s against a %s reference, only to a display image against a radiance map",
+			name, ErrMismatch, test.kind(), ref.kind())
+	}
+	if !mixed && ref.HDR != test.HDR {
+		return fmt.Errorf("%s: %w to a %s against a %s reference", name, ErrMismatch, test.kind(), ref.kind())
+	}
+	return nil
+}
+
+// Applicable returns the metrics of Names that apply to test against ref
+func Applicable(ref, test *Frame) []string {
+	switch {
+	case ref.HDR == test.HDR:
+		return []string{"psnr", "ssim", "ms-ssim", "visibility"}
+	case ref.HDR:
+		return []string{"tmqi", "visibility"}
+	}
+	return nil
+}
+
+// Scores holds the metrics of an image against a reference. Metrics that
+// were not computed are nil.
+type Scores struct {
+	PSNR       *float64    `json:"psnr,omitempty"`
+	SSIM       *float64    `json:"ssim,omitempty"`
+	MSSSIM     *float64    `json:"ms_ssim,omitempty"`
+	TMQI       *TMQIScore  `json:"tmqi,omitempty"`
+	Visibility *Visibility `json:"visibility,omitempty"`
+}
+
+// Compare computes the named metrics of test against ref
+func Compare(ref, test *Frame, names []string) (Scores, error) {
+	var s Scores
+	for _, name := range names {
+		var err error
+		switch name {
+		case "psnr":
+			s.PSNR, err = score(PSNR(ref, test))
+		case "ssim":
+			s.SSIM, err = score(SSIM(ref, test))
+		case "ms-ssim":
+			s.MSSSIM, err = score(MSSSIM(ref, test))
+		case "tmqi":
+			var q TMQIScore
+			if q, err = TMQI(ref, test); err == nil {
+				s.TMQI = &q
+			}
+		case "visibility":
+			s.Visibility, err = VisibilityMap(ref, test, DefaultPixelsPerDegree)
+		default:
+			err = fmt.Errorf("unsupported metric: %s", name)
+		}
+		if err != nil {
+			return Scores{}, err
+		}
+	}
+	return s, nil
+}
+
+func score(v float64, err error) (*float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// plane is a single channel image
+type plane struct {
+	w, h int
+	pix  []float64
+}
+
+func newPlane(w, h int) plane {
+	return plane{w: w, h: h, pix: make([]float64, w*h)}
+}
+
+// mul returns the product of a and b pixel by pixel
+func (a plane) mul(b plane) plane {
+	p := newPlane(a.w, a.h)
+	for i := range p.pix {
+		p.pix[i] = a.pix[i] * b.pix[i]
+	}
+	return p
+}
+
+// mean returns the average of the pixels
+func (a plane) mean() float64 {
+	sum := 0.0
+	for _, v := range a.pix {
+		sum += v
+	}
+	return sum / float64(len(a.pix))
+}
+
+// half returns the plane averaged over 2x2 blocks, dropping an odd last
+// row or column
+func (a plane) half() plane {
+	p := newPlane(a.w/2, a.h/2)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			i := 2*y*a.w + 2*x
+			p.pix[y*p.w+x] = (a.pix[i] + a.pix[i+1] + a.pix[i+a.w] + a.pix[i+a.w+1]) / 4
+		}
+	}
+	return p
+}
+
+// gaussian returns a normalized Gaussian kernel of the given radius
+func gaussian(sigma float64, radius int) []float64 {
+	k := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range k {
+		d := float64(i - radius)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// filterValid convolves a with the separable kernel k where it fits
+// entirely, returning a plane smaller by len(k)-1 in each dimension
+func (a plane) filterValid(k []float64) plane {
+	n := len(k)
+	rows := newPlane(a.w-n+1, a.h)
+	for y := 0; y < a.h; y++ {
+		for x := 0; x < rows.w; x++ {
+			sum := 0.0
+			for i, w := range k {
+				sum += w * a.pix[y*a.w+x+i]
+			}
+			rows.pix[y*rows.w+x] = sum
+		}
+	}
+	p := newPlane(rows.w, a.h-n+1)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			sum := 0.0
+			for i, w := range k {
+				sum += w * rows.pix[(y+i)*rows.w+x]
+			}
+			p.pix[y*p.w+x] = sum
+		}
+	}
+	return p
+}
+
+// blur convolves a with a Gaussian of the given sigma, clamping at the
+// edges so the plane keeps its size
+func (a plane) blur(sigma float64) plane {
+	radius := int(math.Ceil(3 * sigma))
+	k := gaussian(sigma, radius)
+	clamp := func(v, n int) int { return min(max(v, 0), n-1) }
+	rows := newPlane(a.w, a.h)
+	for y := 0; y < a.h; y++ {
+		for x := 0; x < a.w; x++ {
+			sum := 0.0
+			for i, w := range k {
+				sum += w * a.pix[y*a.w+clamp(x+i-radius, a.w)]
+			}
+			rows.pix[y*a.w+x] = sum
+		}
+	}
+	p := newPlane(a.w, a.h)
+	for y := 0; y < a.h; y++ {
+		for x := 0; x < a.w; x++ {
+			sum := 0.0
+			for i, w := range k {
+				sum += w * rows.pix[clamp(y+i-radius, a.h)*a.w+x]
+			}
+			p.pix[y*a.w+x] = sum
+		}
+	}
+	return p
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// createScene returns a radiance map of a textured scene spanning four
+// decades of luminance from left to right
+func createScene(width, height int) *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			texture := 1 + 0.5*math.Sin(float64(x)/2)*math.Cos(float64(y)/3)
+			v := math.Pow(10, 4*float64(x)/float64(width)-2) * texture
+			m.SetRGB(x, y, hdrcolor.RGB{R: v, G: 0.8 * v, B: 0.6 * v})
+		}
+	}
+	return m
+}
+
+// render tone maps m for a display by mapping each channel through fn
+func render(m *hdr.RGB, fn func(v float64) float64) *image.RGBA64 {
+	img := image.NewRGBA64(m.Bounds())
+	for y := m.Bounds().Min.Y; y < m.Bounds().Max.Y; y++ {
+		for x := m.Bounds().Min.X; x < m.Bounds().Max.X; x++ {
+			r, g, b, _ := m.HDRAt(x, y).HDRRGBA()
+			c := func(v float64) uint16 { return uint16(math.Round(0xffff * min(max(fn(v), 0), 1))) }
+			img.SetRGBA64(x, y, color.RGBA64{R: c(r), G: c(g), B: c(b), A: 0xffff})
+		}
+	}
+	return img
+}
+
+// addNoise returns a copy of img with uniform noise of the given amplitude
+// added to every channel
+func addNoise(img *image.RGBA64, amplitude float64, seed int64) *image.RGBA64 {
+	rng := rand.New(rand.NewSource(seed))
+	out := image.NewRGBA64(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			c := img.RGBA64At(x, y)
+			n := func(v uint16) uint16 {
+				return uint16(min(max(float64(v)+(2*rng.Float64()-1)*amplitude*0xffff, 0), 0xffff))
+			}
+			out.SetRGBA64(x, y, color.RGBA64{R: n(c.R), G: n(c.G), B: n(c.B), A: 0xffff})
+		}
+	}
+	return out
+}
+
+// gammaMap is a plain tone mapping of the test scenes, compressing their
+// range into a display
+func gammaMap(v float64) float64 {
+	return math.Pow(v/(v+1), 1/2.2)
+}
+
+func TestNewFrame(t *testing.T) {
+	m := hdr.NewRGB(image.Rect(2, 3, 4, 4))
+	m.SetRGB(2, 3, hdrcolor.RGB{R: 2, G: 2, B: 2})
+	m.SetRGB(3, 3, hdrcolor.RGB{R: -1, G: 0, B: 0})
+	f := NewFrame(m, 100)
+	if !f.HDR || f.Width != 2 || f.Height != 1 {
+		t.Fatalf("Expected a 2x1 radiance frame, got %+v", f)
+	}
+	if math.Abs(f.lum.pix[0]-200) > 1e-6 || f.lum.pix[1] != 0 {
+		t.Errorf("Expected luminance [200 0], got %v", f.lum.pix)
+	}
+	if want := colorspace.PQEncode(200); math.Abs(f.rgb[0].pix[0]-want) > 1e-9 {
+		t.Errorf("Expected channels PQ encoded to %.4f, got %.4f", want, f.rgb[0].pix[0])
+	}
+
+	img := image.NewGray(image.Rect(0, 0, 2, 1))
+	img.Pix = []uint8{0xff, 0}
+	f = NewFrame(img, 80)
+	if f.HDR || math.Abs(f.lum.pix[0]-80) > 1e-6 || f.lum.pix[1] != 0 || f.rgb[1].pix[0] != 1 {
+		t.Errorf("Expected a display frame with white at 80 cd/m², got luminance %v", f.lum.pix)
+	}
+}
+
+func TestApplicable(t *testing.T) {
+	m := createScene(16, 16)
+	radiance, display := NewFrame(m, DefaultWhite), NewFrame(render(m, gammaMap), DefaultWhite)
+
+	tests := []struct {
+		name      string
+		ref, test *Frame
+		want      []string
+	}{
+		{"display images", display, display, []string{"psnr", "ssim", "ms-ssim", "visibility"}},
+		{"radiance maps", radiance, radiance, []string{"psnr", "ssim", "ms-ssim", "visibility"}},
+		{"tone mapped", radiance, display, []string{"tmqi", "visibility"}},
+		{"radiance map against display", display, radiance, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Applicable(tt.ref, tt.test); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	m := createScene(48, 32)
+	ref := render(m, gammaMap)
+	radiance := NewFrame(m, DefaultWhite)
+	display := NewFrame(ref, DefaultWhite)
+	noisy := NewFrame(addNoise(ref, 0.05, 1), DefaultWhite)
+
+	s, err := Compare(display, noisy, Applicable(display, noisy))
+	if err != nil {
+		t.Fatalf("Compare failed: %v", err)
+	}
+	if s.PSNR == nil || s.SSIM == nil || s.MSSSIM == nil || s.Visibility == nil || s.TMQI != nil {
+		t.Errorf("Expected every metric but TMQI, got %+v", s)
+	}
+
+	s, err = Compare(radiance, display, []string{"tmqi"})
+	if err != nil || s.TMQI == nil || s.PSNR != nil {
+		t.Errorf("Expected TMQI alone, got %+v, %v", s, err)
+	}
+
+	tests := []struct {
+		name      string
+		ref, test *Frame
+		names     []string
+		wantErr   string
+	}{
+		{"unknown metric", display, noisy, []string{"vmaf"}, "unsupported metric"},
+		{"psnr across kinds", radiance, display, []string{"psnr"}, "psnr: metric does not apply"},
+		{"tmqi between displays", display, noisy, []string{"tmqi"}, "tmqi: metric does not apply"},
+		{"sizes", display, NewFrame(render(createScene(40, 32), gammaMap), DefaultWhite), []string{"ssim"}, "sizes differ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compare(tt.ref, tt.test, tt.names)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if strings.Contains(tt.wantErr, "does not apply") && !errors.Is(err, ErrMismatch) {
+				t.Errorf("Expected ErrMismatch, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import "math"
+
+// MaxPSNR is the PSNR of identical images, in dB
+const MaxPSNR = 100
+
+// PSNR returns the peak signal-to-noise ratio of test against ref in dB,
+// over the encoded RGB channels with a peak of 1. Identical images score
+// MaxPSNR.
+func PSNR(ref, test *Frame) (float64, error) {
+	if err := checkPair("psnr", ref, test, false); err != nil {
+		return 0, err
+	}
+	sum := 0.0
+	for c := range ref.rgb {
+		for i, v := range ref.rgb[c].pix {
+			d := v - test.rgb[c].pix[i]
+			sum += d * d
+		}
+	}
+	mse := sum / float64(3*len(ref.lum.pix))
+	if mse == 0 {
+		return MaxPSNR, nil
+	}
+	return min(-10*math.Log10(mse), MaxPSNR), nil
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// flat returns a display frame of a single gray level
+func flat(level uint16) *Frame {
+	img := image.NewGray16(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		img.SetGray16(i%4, i/4, color.Gray16{Y: level})
+	}
+	return NewFrame(img, DefaultWhite)
+}
+
+func TestPSNR(t *testing.T) {
+	tests := []struct {
+		name      string
+		ref, test *Frame
+		want      float64
+	}{
+		{"identical", flat(0x8000), flat(0x8000), MaxPSNR},
+		// An error of 1/10 of the peak on every channel
+		{"tenth", flat(0), flat(6553.5 + 0.5), 20},
+		{"hundredth", flat(0x8000), flat(0x8000 + 655), 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PSNR(tt.ref, tt.test)
+			if err != nil {
+				t.Fatalf("PSNR failed: %v", err)
+			}
+			if math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("Expected %.2f dB, got %.2f dB", tt.want, got)
+			}
+		})
+	}
+
+	m := createScene(32, 32)
+	ref := render(m, gammaMap)
+	low, _ := PSNR(NewFrame(ref, DefaultWhite), NewFrame(addNoise(ref, 0.1, 1), DefaultWhite))
+	high, _ := PSNR(NewFrame(ref, DefaultWhite), NewFrame(addNoise(ref, 0.01, 1), DefaultWhite))
+	if low >= high {
+		t.Errorf("Expected more noise to lower PSNR, got %.2f dB and %.2f dB", low, high)
+	}
+}
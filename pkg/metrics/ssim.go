@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+)
+
+// ssimWindow is the size of the Gaussian window of SSIM, and ssimSigma its
+// standard deviation, as in Wang et al.
+const (
+	ssimWindow = 11
+	ssimSigma  = 1.5
+)
+
+// SSIM stabilizing constants for a dynamic range of 1
+const (
+	ssimC1 = 0.01 * 0.01
+	ssimC2 = 0.03 * 0.03
+)
+
+// msssimWeights are the weights of the scales of MS-SSIM, finest first
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// SSIM returns the mean structural similarity of test against ref, over
+// the luma of the encoded channels
+func SSIM(ref, test *Frame) (float64, error) {
+	if err := checkSSIM("ssim", ref, test); err != nil {
+		return 0, err
+	}
+	l, cs := ssim(ref.luma(), test.luma())
+	return l * cs, nil
+}
+
+// MSSSIM returns the multi-scale structural similarity of test against ref.
+// Images too small for the five scales of Wang et al. use the scales that
+// fit, with their weights renormalized.
+func MSSSIM(ref, test *Frame) (float64, error) {
+	if err := checkSSIM("ms-ssim", ref, test); err != nil {
+		return 0, err
+	}
+	a, b := ref.luma(), test.luma()
+	weights := scaleWeights(a.w, a.h)
+	result := 1.0
+	for i, w := range weights {
+		l, cs := ssim(a, b)
+		// Negative structure scores would make the product undefined
+		result *= math.Pow(max(cs, 0), w)
+		if i == len(weights)-1 {
+			result *= math.Pow(max(l, 0), w)
+		}
+		a, b = a.half(), b.half()
+	}
+	return result, nil
+}
+
+// checkSSIM checks that ref and test can be compared by SSIM
+func checkSSIM(name string, ref, test *Frame) error {
+	if err := checkPair(name, ref, test, false); err != nil {
+		return err
+	}
+	if ref.Width < ssimWindow || ref.Height < ssimWindow {
+		return fmt.Errorf("%s: images of %dx%d are smaller than the %dx%d window", name, ref.Width, ref.Height, ssimWindow, ssimWindow)
+	}
+	return nil
+}
+
+// scaleWeights returns the weights of the scales of a w x h image that are
+// at least a window wide, summing to 1
+func scaleWeights(w, h int) []float64 {
+	n := 1
+	for n < len(msssimWeights) && min(w>>n, h>>n) >= ssimWindow {
+		n++
+	}
+	weights := append([]float64(nil), msssimWeights[:n]...)
+	sum := 0.0
+	for _, v := range weights {
+		sum += v
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return weights
+}
+
+// ssim returns the mean luminance and contrast-structure terms of SSIM
+// between a and b
+func ssim(a, b plane) (l, cs float64) {
+	k := gaussian(ssimSigma, ssimWindow/2)
+	muA, muB := a.filterValid(k), b.filterValid(k)
+	aa, bb, ab := a.mul(a).filterValid(k), b.mul(b).filterValid(k), a.mul(b).filterValid(k)
+	for i := range muA.pix {
+		ma, mb := muA.pix[i], muB.pix[i]
+		varA, varB, cov := aa.pix[i]-ma*ma, bb.pix[i]-mb*mb, ab.pix[i]-ma*mb
+		l += (2*ma*mb + ssimC1) / (ma*ma + mb*mb + ssimC1)
+		cs += (2*cov + ssimC2) / (varA + varB + ssimC2)
+	}
+	n := float64(len(muA.pix))
+	return l / n, cs / n
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSSIM(t *testing.T) {
+	m := createScene(96, 64)
+	ref := render(m, gammaMap)
+	frame := NewFrame(ref, DefaultWhite)
+
+	for _, metric := range []struct {
+		name string
+		fn   func(ref, test *Frame) (float64, error)
+	}{{"ssim", SSIM}, {"ms-ssim", MSSSIM}} {
+		t.Run(metric.name, func(t *testing.T) {
+			same, err := metric.fn(frame, frame)
+			if err != nil {
+				t.Fatalf("%s failed: %v", metric.name, err)
+			}
+			if math.Abs(same-1) > 1e-9 {
+				t.Errorf("Expected identical images to score 1, got %v", same)
+			}
+
+			prev := same
+			for _, amplitude := range []float64{0.02, 0.1, 0.3} {
+				got, err := metric.fn(frame, NewFrame(addNoise(ref, amplitude, 1), DefaultWhite))
+				if err != nil {
+					t.Fatalf("%s failed: %v", metric.name, err)
+				}
+				if got >= prev || got < 0 {
+					t.Errorf("Expected noise of %v to score below %.4f, got %.4f", amplitude, prev, got)
+				}
+				prev = got
+			}
+
+			small := NewFrame(render(createScene(10, 32), gammaMap), DefaultWhite)
+			if _, err := metric.fn(small, small); err == nil || !strings.Contains(err.Error(), "smaller than") {
+				t.Errorf("Expected an error for a 10 pixel wide image, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScaleWeights(t *testing.T) {
+	tests := []struct {
+		w, h, want int
+	}{
+		{11, 11, 1},
+		{22, 100, 2},
+		{200, 100, 4},
+		{1000, 1000, 5},
+	}
+
+	for _, tt := range tests {
+		weights := scaleWeights(tt.w, tt.h)
+		if len(weights) != tt.want {
+			t.Errorf("%dx%d: expected %d scales, got %d", tt.w, tt.h, tt.want, len(weights))
+		}
+		sum := 0.0
+		for _, w := range weights {
+			sum += w
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("%dx%d: expected weights summing to 1, got %v", tt.w, tt.h, sum)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+)
+
+// TMQI constants of Yeganeh and Wang: the weight of structural fidelity and
+// the exponents of structural fidelity and naturalness
+const (
+	tmqiA     = 0.8012
+	tmqiAlpha = 0.3046
+	tmqiBeta  = 0.7088
+)
+
+// tmqiRange is the range the radiance map luminance is stretched to
+const tmqiRange = 1<<32 - 1
+
+// Structural fidelity constants: the stabilizing constants and the spatial
+// frequency of the finest scale, in cycles per degree
+const (
+	tmqiC1   = 0.01
+	tmqiC2   = 10
+	tmqiFreq = 16
+)
+
+// Naturalness statistics of natural images: the mean and deviation of the
+// brightness, and the beta distribution of the contrast
+const (
+	naturalMean     = 115.94
+	naturalDev      = 27.99
+	naturalContrast = 64.29
+	naturalBetaA    = 4.4
+	naturalBetaB    = 10.1
+	naturalBlock    = 11
+)
+
+// TMQIScore is the tone-mapped image quality index of Yeganeh and Wang,
+// from 0 to 1
+type TMQIScore struct {
+	// Q is the overall quality
+	Q float64 `json:"q"`
+	// S is the structural fidelity to the radiance map
+	S float64 `json:"s"`
+	// N is the statistical naturalness of the tone mapped image
+	N float64 `json:"n"`
+}
+
+// TMQI scores the tone mapped display image ldr against its radiance map
+// hdr. Images too small for five scales use the scales that fit, as
+// MSSSIM does.
+func TMQI(hdr, ldr *Frame) (TMQIScore, error) {
+	if err := checkPair("tmqi", hdr, ldr, true); err != nil {
+		return TMQIScore{}, err
+	}
+	if err := checkSSIM("tmqi", ldr, ldr); err != nil {
+		return TMQIScore{}, err
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range hdr.lum.pix {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	if hi <= lo {
+		return TMQIScore{}, errors.New("tmqi: the radiance map is flat")
+	}
+	a := newPlane(hdr.Width, hdr.Height)
+	scale := math.Round(tmqiRange / (hi - lo))
+	for i, v := range hdr.lum.pix {
+		a.pix[i] = scale * (v - lo)
+	}
+	b := ldr.luma()
+	for i := range b.pix {
+		b.pix[i] *= 255
+	}
+
+	s := 1.0
+	freq := float64(tmqiFreq)
+	for _, w := range scaleWeights(a.w, a.h) {
+		s *= math.Pow(structuralFidelity(a, b, freq), w)
+		a, b = a.half(), b.half()
+		freq /= 2
+	}
+	n := naturalness(ldr.luma())
+	return TMQIScore{
+		Q: tmqiA*math.Pow(s, tmqiAlpha) + (1-tmqiA)*math.Pow(n, tmqiBeta),
+		S: s,
+		N: n,
+	}, nil
+}
+
+// structuralFidelity returns the local structural fidelity of the tone
+// mapped luminance b to the radiance map luminance a at a scale of the
+// given spatial frequency. Local contrasts count through the probability
+// of being seen, against a threshold set by the contrast sensitivity.
+func structuralFidelity(a, b plane, freq float64) float64 {
+	csf := 100 * 2.6 * (0.0192 + 0.114*freq) * math.Exp(-math.Pow(0.114*freq, 1.1))
+	mu := 128 / (1.4 * csf)
+	visible := func(sigma float64) float64 {
+		return normCDF(sigma, mu, mu/3)
+	}
+
+	k := gaussian(ssimSigma, ssimWindow/2)
+	muA, muB := a.filterValid(k), b.filterValid(k)
+	aa, bb, ab := a.mul(a).filterValid(k), b.mul(b).filterValid(k), a.mul(b).filterValid(k)
+	sum := 0.0
+	for i := range muA.pix {
+		sa := math.Sqrt(max(aa.pix[i]-muA.pix[i]*muA.pix[i], 0))
+		sb := math.Sqrt(max(bb.pix[i]-muB.pix[i]*muB.pix[i], 0))
+		cov := ab.pix[i] - muA.pix[i]*muB.pix[i]
+		pa, pb := visible(sa), visible(sb)
+		sum += (2*pa*pb + tmqiC1) / (pa*pa + pb*pb + tmqiC1) * (cov + tmqiC2) / (sa*sb + tmqiC2)
+	}
+	return sum / float64(len(muA.pix))
+}
+
+// naturalness returns how likely the brightness and contrast of the luma
+// in [0, 1] are among natural images, relative to the most likely
+func naturalness(luma plane) float64 {
+	// The contrast is the average deviation over blocks, weighted by their
+	// size at the edges
+	contrast := 0.0
+	for y := 0; y < luma.h; y += naturalBlock {
+		for x := 0; x < luma.w; x += naturalBlock {
+			var sum, sq float64
+			n := 0
+			for yy := y; yy < min(y+naturalBlock, luma.h); yy++ {
+				for xx := x; xx < min(x+naturalBlock, luma.w); xx++ {
+					v := 255 * luma.pix[yy*luma.w+xx]
+					sum += v
+					sq += v * v
+					n++
+				}
+			}
+			if n > 1 {
+				mean := sum / float64(n)
+				contrast += math.Sqrt(max(sq-float64(n)*mean*mean, 0)/float64(n-1)) * float64(n)
+			}
+		}
+	}
+	contrast /= float64(len(luma.pix))
+	brightness := 255 * luma.mean()
+
+	mode := (naturalBetaA - 1) / (naturalBetaA + naturalBetaB - 2)
+	pc := betaPDF(contrast/naturalContrast, naturalBetaA, naturalBetaB) / betaPDF(mode, naturalBetaA, naturalBetaB)
+	d := (brightness - naturalMean) / naturalDev
+	pb := math.Exp(-d * d / 2)
+	return pb * pc
+}
+
+// normCDF is the cumulative normal distribution
+func normCDF(x, mu, sigma float64) float64 {
+	return 0.5 * (1 + math.Erf((x-mu)/(sigma*math.Sqrt2)))
+}
+
+// betaPDF is the density of the beta distribution, 0 outside [0, 1]
+func betaPDF(x, a, b float64) float64 {
+	if x <= 0 || x >= 1 {
+		return 0
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	return math.Exp((a-1)*math.Log(x) + (b-1)*math.Log(1-x) + lab - la - lb)
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTMQI(t *testing.T) {
+	m := createScene(128, 64)
+	radiance := NewFrame(m, DefaultWhite)
+
+	tests := []struct {
+		name string
+		fn   func(v float64) float64
+	}{
+		// Clipping loses the structure of the highlights
+		{"clipped", func(v float64) float64 { return math.Pow(v, 1/2.2) }},
+		// Scaling down keeps the structure but makes a dark, unnatural image
+		{"scaled", func(v float64) float64 { return math.Pow(v/100, 1/2.2) }},
+		{"compressed", gammaMap},
+	}
+
+	scores := make([]TMQIScore, len(tests))
+	for i, tt := range tests {
+		got, err := TMQI(radiance, NewFrame(render(m, tt.fn), DefaultWhite))
+		if err != nil {
+			t.Fatalf("%s: TMQI failed: %v", tt.name, err)
+		}
+		for _, v := range []float64{got.Q, got.S, got.N} {
+			if v < 0 || v > 1 || math.IsNaN(v) {
+				t.Errorf("%s: expected scores in [0, 1], got %+v", tt.name, got)
+			}
+		}
+		scores[i] = got
+	}
+	clipped, scaled, compressed := scores[0], scores[1], scores[2]
+	if clipped.S >= scaled.S || clipped.S >= compressed.S {
+		t.Errorf("Expected clipping to lose the most structure, got %+v", scores)
+	}
+	if scaled.N >= compressed.N {
+		t.Errorf("Expected the scaled image to be less natural, got %+v", scores)
+	}
+	if compressed.Q <= clipped.Q || compressed.Q <= scaled.Q {
+		t.Errorf("Expected compression to score best, got %+v", scores)
+	}
+}
+
+func TestNaturalness(t *testing.T) {
+	// Mid-gray with some contrast is more natural than black or white
+	textured := func(level float64) plane {
+		p := newPlane(33, 33)
+		for i := range p.pix {
+			p.pix[i] = min(max(level+0.2*math.Sin(float64(i)), 0), 1)
+		}
+		return p
+	}
+	mid, dark, bright := naturalness(textured(0.45)), naturalness(textured(0.05)), naturalness(textured(0.95))
+	if mid <= dark || mid <= bright || mid > 1 {
+		t.Errorf("Expected mid-gray to be most natural, got %.3f (dark %.3f, bright %.3f)", mid, dark, bright)
+	}
+	if flat := naturalness(newPlane(33, 33)); flat != 0 {
+		t.Errorf("Expected a flat black image to score 0, got %v", flat)
+	}
+}
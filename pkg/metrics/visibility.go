@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// DefaultPixelsPerDegree is the angular resolution of the viewer assumed by
+// VisibilityMap unless set, about that of a 24 inch full HD display seen
+// from 60 cm
+const DefaultPixelsPerDegree = 40
+
+// VisibleProbability is the probability of detection above which
+// Visibility counts a pixel as visibly different
+const VisibleProbability = 0.75
+
+// Visual model constants: the smallest visible change of log10 luminance
+// at the peak of the contrast sensitivity, the luminance below which
+// sensitivity drops, the slope of the psychometric function and the
+// darkest luminance, in cd/m²
+const (
+	peakThreshold = 0.004
+	adaptKnee     = 0.5
+	psychometric  = 3.5
+	minLuminance  = 1e-3
+)
+
+// maxBands is the number of frequency bands of the visual model
+const maxBands = 6
+
+// Visibility is a map of the probability that an observer notices the
+// differences between two images
+type Visibility struct {
+	Width  int `json:"-"`
+	Height int `json:"-"`
+	// Prob holds the probability of detection of each pixel, row by row
+	Prob []float64 `json:"-"`
+	// Mean is the average probability of detection
+	Mean float64 `json:"mean"`
+	// Visible is the fraction of pixels detected with a probability of at
+	// least VisibleProbability
+	Visible float64 `json:"visible"`
+}
+
+// VisibilityMap predicts where an observer with the given angular
+// resolution notices the differences between test and ref, from their
+// luminance in cd/m². Like HDR-VDP, it splits the log luminance into
+// frequency bands, sets a detection threshold per band from the contrast
+// sensitivity and the local adaptation luminance of ref, and sums the
+// probabilities of detection over the bands. Unlike HDR-VDP, it models
+// neither optics, color nor contrast masking.
+func VisibilityMap(ref, test *Frame, pixelsPerDegree float64) (*Visibility, error) {
+	if err := checkPair("visibility", ref, test, ref.HDR && !test.HDR); err != nil {
+		return nil, err
+	}
+	logRef, logTest := logLuminance(ref.lum), logLuminance(test.lum)
+
+	miss := make([]float64, len(logRef.pix))
+	for i := range miss {
+		miss[i] = 1
+	}
+	// Each band is the difference between blurs an octave apart, starting
+	// from the image itself
+	lowRef, lowTest := logRef, logTest
+	sigma := 0.5
+	for b := 0; b < maxBands; b++ {
+		sigma *= 2
+		if b > 0 && 4*sigma > float64(min(ref.Width, ref.Height)) {
+			break
+		}
+		blurRef, blurTest := logRef.blur(sigma), logTest.blur(sigma)
+		// The peak frequency of the band, in cycles per degree
+		freq := 0.3 / sigma * pixelsPerDegree
+		csf := max(2.6*(0.0192+0.114*freq)*math.Exp(-math.Pow(0.114*freq, 1.1)), 0.01)
+		for i := range miss {
+			d := (lowTest.pix[i] - blurTest.pix[i]) - (lowRef.pix[i] - blurRef.pix[i])
+			adapt := 1 / (1 + math.Sqrt(adaptKnee/math.Pow(10, blurRef.pix[i])))
+			threshold := peakThreshold / (csf * adapt)
+			miss[i] *= math.Exp(-math.Pow(math.Abs(d)/threshold, psychometric))
+		}
+		lowRef, lowTest = blurRef, blurTest
+	}
+
+	v := &Visibility{Width: ref.Width, Height: ref.Height, Prob: make([]float64, len(miss))}
+	visible := 0
+	for i, m := range miss {
+		v.Prob[i] = 1 - m
+		v.Mean += v.Prob[i]
+		if v.Prob[i] >= VisibleProbability {
+			visible++
+		}
+	}
+	v.Mean /= float64(len(miss))
+	v.Visible = float64(visible) / float64(len(miss))
+	return v, nil
+}
+
+// logLuminance returns the log10 of the luminance, floored at minLuminance
+func logLuminance(lum plane) plane {
+	p := newPlane(lum.w, lum.h)
+	for i, v := range lum.pix {
+		p.pix[i] = math.Log10(max(v, minLuminance))
+	}
+	return p
+}
+
+// Image renders the map over a dimmed grayscale of the reference image,
+// tinted from yellow to red as the probability of detection rises
+func (v *Visibility) Image(ref *Frame) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, v.Width, v.Height))
+	luma := ref.luma()
+	for i, p := range v.Prob {
+		gray := 0.15 + 0.35*luma.pix[i]
+		r := gray + (1-gray)*p
+		g := gray + (1-p-gray)*p
+		b := gray * (1 - p)
+		img.SetRGBA(i%v.Width, i/v.Width, color.RGBA{R: unit(r), G: unit(g), B: unit(b), A: 0xff})
+	}
+	return img
+}
+
+// unit converts a value in [0, 1] to 8 bits
+func unit(v float64) uint8 {
+	return uint8(math.Round(255 * min(max(v, 0), 1)))
+}
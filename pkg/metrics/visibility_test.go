@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestVisibilityMap(t *testing.T) {
+	m := createScene(64, 48)
+	ref := render(m, gammaMap)
+	frame := NewFrame(ref, DefaultWhite)
+
+	same, err := VisibilityMap(frame, frame, DefaultPixelsPerDegree)
+	if err != nil {
+		t.Fatalf("VisibilityMap failed: %v", err)
+	}
+	if same.Mean != 0 || same.Visible != 0 {
+		t.Errorf("Expected no visible difference between identical images, got %+v", same)
+	}
+
+	// Darken a patch strongly and leave the rest barely touched
+	test := addNoise(ref, 0.0005, 1)
+	for y := 20; y < 28; y++ {
+		for x := 30; x < 38; x++ {
+			c := test.RGBA64At(x, y)
+			test.SetRGBA64(x, y, color.RGBA64{R: c.R / 3, G: c.G / 3, B: c.B / 3, A: 0xffff})
+		}
+	}
+	v, err := VisibilityMap(frame, NewFrame(test, DefaultWhite), DefaultPixelsPerDegree)
+	if err != nil {
+		t.Fatalf("VisibilityMap failed: %v", err)
+	}
+	if p := v.Prob[24*v.Width+34]; p < VisibleProbability {
+		t.Errorf("Expected the patch to be visible, got probability %.3f", p)
+	}
+	if p := v.Prob[5*v.Width+5]; p > 0.1 {
+		t.Errorf("Expected the faint noise to be invisible, got probability %.3f", p)
+	}
+	// The patch covers 2% of the image; the coarse bands spread its edges
+	if v.Visible < 0.02 || v.Visible > 0.5 {
+		t.Errorf("Expected the visible fraction to stay around the patch, got %.3f", v.Visible)
+	}
+
+	img := v.Image(frame)
+	if img.Bounds().Dx() != 64 || img.Bounds().Dy() != 48 {
+		t.Errorf("Expected a 64x48 map, got %v", img.Bounds())
+	}
+	if c := img.RGBAAt(34, 24); c.R < 200 || c.G > 100 {
+		t.Errorf("Expected the patch to be drawn red, got %v", c)
+	}
+}
+
+func TestVisibilityMapDark(t *testing.T) {
+	// The same relative change is harder to see in the dark
+	m := createScene(64, 48)
+	ref := render(m, gammaMap)
+	test := addNoise(ref, 0.01, 2)
+	bright, _ := VisibilityMap(NewFrame(ref, 200), NewFrame(test, 200), DefaultPixelsPerDegree)
+	dark, _ := VisibilityMap(NewFrame(ref, 0.05), NewFrame(test, 0.05), DefaultPixelsPerDegree)
+	if dark.Mean >= bright.Mean {
+		t.Errorf("Expected lower visibility at 0.05 cd/m² than at 200, got %.3f and %.3f", dark.Mean, bright.Mean)
+	}
+}
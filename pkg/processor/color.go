@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"context"
+	"image"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+)
+
+// WorkingSpace returns the color space the pipeline merges and tone maps in
+func (p *HDRProcessor) WorkingSpace() *colorspace.Space {
+	return p.working
+}
+
+// OutputSpace returns the color space of the saved images
+func (p *HDRProcessor) OutputSpace() *colorspace.Space {
+	return p.output
+}
+
+// inputSpace returns the color space the file at path is tagged with.
+// Untagged files and unsupported profiles are assumed to be sRGB.
+func inputSpace(path string) *colorspace.Space {
+	s, err := imaging.ReadColorSpace(path)
+	if err != nil {
+		return colorspace.SRGB
+	}
+	return s
+}
+
+// toWorking converts an exposure read from path to the working space.
+// Loaded radiance buffers are converted in place.
+func (p *HDRProcessor) toWorking(ctx context.Context, img image.Image, path string) (image.Image, error) {
+	t := colorspace.NewTransform(inputSpace(path), p.working)
+	if t.Identity() {
+		return img, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch m := img.(type) {
+	case *hdr.RGB:
+		t.ConvertRGB(m)
+		return m, nil
+	case hdr.Image:
+		return img, nil
+	}
+	return t.ConvertImage(ctx, img, p.workers)
+}
+
+// displaySpace returns the space of tone mapped images: the primaries of the
+// working space with the curve of the output space. Tone operators produce
+// display-encoded values, so converting them to the output space only
+// changes their gamut.
+func (p *HDRProcessor) displaySpace() *colorspace.Space {
+	return p.working.WithCurve(p.output.Curve())
+}
+
+// toOutput converts a tone mapped image from the display space to the
+// output space
+func (p *HDRProcessor) toOutput(ctx context.Context, img image.Image) (image.Image, error) {
+	t := colorspace.NewTransform(p.displaySpace(), p.output)
+	if t.Identity() {
+		return img, nil
+	}
+	// Gain maps hold luminance ratios, which the conversion keeps
+	if gm, ok := img.(*imaging.GainMapImage); ok {
+		base, err := t.ConvertImage(ctx, gm.Image, p.workers)
+		if err != nil {
+			return nil, err
+		}
+		return &imaging.GainMapImage{Image: base, GainMap: gm.GainMap, Metadata: gm.Metadata}, nil
+	}
+	return t.ConvertImage(ctx, img, p.workers)
+}
+
+// encodeOptions returns the encoder settings with the output profile,
+// unless the caller set one
+func (p *HDRProcessor) encodeOptions() imaging.EncodeOptions {
+	opts := p.encode
+	if opts.Profile == nil {
+		opts.Profile = p.output.ICC()
+	}
+	return opts
+}
+
+// workingReader converts the rows of an exposure to the working space and
+// decodes them to linear light
+type workingReader struct {
+	imaging.RowReader
+	t *colorspace.Transform
+	// decode is the decode table of the working space curve
+	decode []float32
+}
+
+func (r workingReader) ReadRows(dst *hdr.RGB) error {
+	if err := r.RowReader.ReadRows(dst); err != nil {
+		return err
+	}
+	r.t.ConvertRGB(dst)
+	b := dst.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(b.Min.X, y):dst.PixOffset(b.Max.X, y)]
+		for i, v := range row {
+			row[i] = r.decode[int(min(max(v, 0), 1)*0xffff+0.5)]
+		}
+	}
+	return nil
+}
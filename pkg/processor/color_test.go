@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+// writeTaggedImage writes a uniform PNG tagged with space to dir
+func writeTaggedImage(t *testing.T, dir, name string, c color.Color, space *colorspace.Space) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	opts := imaging.DefaultEncodeOptions()
+	if space != nil {
+		opts.Profile = space.ICC()
+	}
+	path := filepath.Join(dir, name)
+	if err := imaging.SaveImageOptions(img, path, opts); err != nil {
+		t.Fatal("Failed to save test image:", err)
+	}
+	return path
+}
+
+func TestLoadConvertsToWorkingSpace(t *testing.T) {
+	dir := t.TempDir()
+	green := color.RGBA{40, 200, 40, 255}
+
+	tests := []struct {
+		name    string
+		tag     *colorspace.Space
+		working *colorspace.Space
+		same    bool
+	}{
+		{name: "untagged sRGB", working: colorspace.SRGB, same: true},
+		{name: "tagged sRGB", tag: colorspace.SRGB, working: colorspace.SRGB, same: true},
+		{name: "Display P3 to sRGB", tag: colorspace.DisplayP3, working: colorspace.SRGB},
+		{name: "untagged to Rec.2020", working: colorspace.Rec2020},
+		{name: "Display P3 to Display P3", tag: colorspace.DisplayP3, working: colorspace.DisplayP3, same: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := []string{
+				writeTaggedImage(t, dir, string(rune('a'+2*i))+".png", green, tt.tag),
+				writeTaggedImage(t, dir, string(rune('b'+2*i))+".png", green, tt.tag),
+			}
+			images, err := NewHDRProcessor(WithWorkingSpace(tt.working)).Load(paths...)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+
+			r, g, b, _ := images[0].At(1, 1).RGBA()
+			same := r>>8 == 40 && g>>8 == 200 && b>>8 == 40
+			if same != tt.same {
+				t.Errorf("Expected unchanged pixels %v, got %d %d %d", tt.same, r>>8, g>>8, b>>8)
+			}
+		})
+	}
+}
+
+func TestSaveEmbedsOutputSpace(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 16, 16)
+
+	for _, space := range []*colorspace.Space{colorspace.SRGB, colorspace.DisplayP3, colorspace.Rec2020} {
+		t.Run(space.Name, func(t *testing.T) {
+			for _, ext := range []string{".png", ".jpg"} {
+				output := filepath.Join(dir, space.Name+ext)
+				if err := NewHDRProcessor(WithOutputSpace(space)).Run(output, inputs...); err != nil {
+					t.Fatalf("Run failed: %v", err)
+				}
+				got, err := imaging.ReadColorSpace(output)
+				if err != nil {
+					t.Fatalf("ReadColorSpace failed: %v", err)
+				}
+				if got != space {
+					t.Errorf("%s: expected %v profile, got %v", ext, space, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputSpaceConvertsPixels(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 16, 16)
+
+	srgb := filepath.Join(dir, "srgb.png")
+	if err := NewHDRProcessor().Run(srgb, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	p3 := filepath.Join(dir, "p3.png")
+	if err := NewHDRProcessor(WithOutputSpace(colorspace.DisplayP3)).Run(p3, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// Converting the sRGB result gives the Display P3 one
+	want, err := colorspace.NewTransform(colorspace.SRGB, colorspace.DisplayP3).
+		ConvertImage(context.Background(), decodePNG(t, srgb), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodePNG(t, p3)
+	if diff := maxChannelDiff(want, got); diff > 0x101 {
+		t.Errorf("Display P3 output differs from the converted sRGB output by %d", diff)
+	}
+	if diff := maxChannelDiff(decodePNG(t, srgb), got); diff == 0 {
+		t.Error("Expected Display P3 output to differ from sRGB output")
+	}
+}
+
+func TestWorkingSpaceKeepsOutput(t *testing.T) {
+	dir := t.TempDir()
+	inputs, err := filepath.Glob(filepath.Join(goldenDir, "brackets", "window", "*.png"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("Missing window bracket: %v", err)
+	}
+	want := filepath.Join(dir, "srgb.png")
+	if err := NewHDRProcessor(WithToneMapper("drago03")).Run(want, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// The sRGB bracket fits every working space, so nothing is clipped and
+	// Drago03, which scales the channels of a pixel alike, renders the same
+	// sRGB image in all of them
+	for _, working := range []*colorspace.Space{colorspace.DisplayP3, colorspace.Rec2020, colorspace.ACEScg} {
+		t.Run(working.Name, func(t *testing.T) {
+			got := filepath.Join(dir, working.Name+".png")
+			if err := NewHDRProcessor(WithToneMapper("drago03"), WithWorkingSpace(working)).Run(got, inputs...); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if diff := maxChannelDiff(decodePNG(t, want), decodePNG(t, got)); diff > 0x202 {
+				t.Errorf("Output differs from the sRGB working space by %d", diff)
+			}
+		})
+	}
+}
+
+func TestRunStreamingColorSpaces(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 30, 60)
+	opts := []Option{WithWorkingSpace(colorspace.Rec2020), WithOutputSpace(colorspace.DisplayP3)}
+
+	want := filepath.Join(dir, "run.png")
+	if err := NewHDRProcessor(opts...).Run(want, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	got := filepath.Join(dir, "stream.png")
+	if err := NewHDRProcessor(append(opts, WithMemoryBudget(24*30*(12*4+8)))...).RunStreaming(got, inputs...); err != nil {
+		t.Fatalf("RunStreaming failed: %v", err)
+	}
+
+	if diff := maxChannelDiff(decodePNG(t, want), decodePNG(t, got)); diff > 0x202 {
+		t.Errorf("Streamed output differs from Run by %d", diff)
+	}
+	if s, err := imaging.ReadColorSpace(got); err != nil || s != colorspace.DisplayP3 {
+		t.Errorf("Expected Display P3 profile, got %v, %v", s, err)
+	}
+}
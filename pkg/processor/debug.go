@@ -0,0 +1,317 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/analysis"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// falseColors are the colors of the false color map, one per stop from
+// -falseColorStops to +falseColorStops around the log-average luminance.
+// Darker pixels are black and brighter ones white.
+var falseColors = []color.RGBA{
+	{0x30, 0x00, 0x50, 0xff},
+	{0x50, 0x00, 0x90, 0xff},
+	{0x10, 0x10, 0xd0, 0xff},
+	{0x00, 0x60, 0xff, 0xff},
+	{0x00, 0xb0, 0xd0, 0xff},
+	{0x00, 0xa0, 0x60, 0xff},
+	{0x80, 0x80, 0x80, 0xff},
+	{0x40, 0xc0, 0x40, 0xff},
+	{0xc0, 0xe0, 0x00, 0xff},
+	{0xff, 0xc0, 0x00, 0xff},
+	{0xff, 0x70, 0x00, 0xff},
+	{0xff, 0x20, 0x00, 0xff},
+	{0xff, 0x90, 0xc0, 0xff},
+}
+
+// falseColorStops is the number of stops on each side of the log-average
+// luminance the false color map distinguishes
+const falseColorStops = 6
+
+// Clipping overlay colors
+var (
+	clippedHighlight = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	crushedShadow    = color.RGBA{0x00, 0x40, 0xff, 0xff}
+)
+
+// legendHeight is the height of the legend strip under the false color and
+// contribution maps, in pixels
+const legendHeight = 17
+
+// Diagnostics holds the diagnostic images of a merge, to see where the
+// radiance map comes from and where the bracket fell short
+type Diagnostics struct {
+	// FalseColor colors the luminance of the radiance map by stops from its
+	// log-average, with a legend
+	FalseColor *image.RGBA
+	// Contribution colors each pixel by the exposures weighing in its
+	// merge, blue for the darkest to red for the brightest, blended by their
+	// frame weights, with a legend numbering the exposures
+	Contribution *image.RGBA
+	// Weights holds the share of each exposure in the merge weight of each
+	// pixel, from black for none to white for all of it
+	Weights []*image.Gray
+	// Clipping shows the radiance map in gray, with the highlights clipped
+	// in every exposure in red and the shadows crushed in every exposure in
+	// blue
+	Clipping *image.RGBA
+}
+
+// Diagnose renders the diagnostic images of the merge of the linearized
+// exposures into merged, with the merge settings of the processor
+func (p *HDRProcessor) Diagnose(exposures []hdr.Image, merged hdr.Image) (*Diagnostics, error) {
+	return p.DiagnoseContext(context.Background(), exposures, merged)
+}
+
+// DiagnoseContext is Diagnose with cancellation and progress reporting
+func (p *HDRProcessor) DiagnoseContext(ctx context.Context, exposures []hdr.Image, merged hdr.Image) (*Diagnostics, error) {
+	ctx = p.withProgress(ctx)
+	if merged == nil {
+		return nil, errors.New("radiance map is nil")
+	}
+	if err := validateImageProperties(exposures); err != nil {
+		return nil, err
+	}
+	if merged.Bounds() != exposures[0].Bounds() {
+		return nil, fmt.Errorf("radiance map is %v, exposures are %v", merged.Bounds(), exposures[0].Bounds())
+	}
+	if err := p.checkMerge(len(exposures)); err != nil {
+		return nil, err
+	}
+
+	m, err := p.asRGB(ctx, merged)
+	if err != nil {
+		return nil, err
+	}
+	buffers := make([]*hdr.RGB, len(exposures))
+	for i, img := range exposures {
+		if buffers[i], err = p.asRGB(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+
+	b := m.Bounds()
+	withLegend := image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Max.Y+legendHeight)
+	d := &Diagnostics{
+		FalseColor:   image.NewRGBA(withLegend),
+		Contribution: image.NewRGBA(withLegend),
+		Weights:      make([]*image.Gray, len(buffers)),
+		Clipping:     image.NewRGBA(b),
+	}
+	for i := range d.Weights {
+		d.Weights[i] = image.NewGray(b)
+	}
+	palette := exposureColors(len(buffers))
+
+	// The first pass finds the log-average luminance the stops count from
+	tiles := p.tiles(b)
+	key, err := p.logAverageLuminance(ctx, m, tiles, progress.StageDebug, 0, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every pixel of an exposure has the same share of the merge weight
+	shares := make([]float64, len(buffers))
+	total := 0.0
+	for i := range shares {
+		shares[i] = float64(p.frameWeight(i))
+		total += shares[i]
+	}
+	var mix [3]float64
+	for i := range shares {
+		shares[i] /= total
+		mix[0] += shares[i] * float64(palette[i].R)
+		mix[1] += shares[i] * float64(palette[i].G)
+		mix[2] += shares[i] * float64(palette[i].B)
+	}
+	contribution := color.RGBA{uint8(mix[0]), uint8(mix[1]), uint8(mix[2]), 0xff}
+
+	// The exposures are linear, the clipping thresholds encoded
+	clipHighlight := p.working.Curve().Decode(analysis.ClipHighlight)
+	clipShadow := p.working.Curve().Decode(analysis.ClipShadow)
+
+	err = p.forEachTile(ctx, tiles, progress.StageDebug, 1, 2, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				l := p.luminanceAt(m, x, y)
+				d.FalseColor.SetRGBA(x, y, falseColor(math.Log2(l/key)))
+
+				for i, s := range shares {
+					d.Weights[i].SetGray(x, y, color.Gray{uint8(math.Round(255 * s))})
+				}
+				d.Contribution.SetRGBA(x, y, contribution)
+
+				// The brightest channel of the pixel in the exposures where
+				// it is darkest and brightest
+				lowest, highest := math.Inf(1), 0.0
+				for _, buf := range buffers {
+					j := buf.PixOffset(x, y)
+					c := buf.Pix[j : j+3 : j+3]
+					v := float64(max(c[0], c[1], c[2]))
+					lowest, highest = min(lowest, v), max(highest, v)
+				}
+
+				switch {
+				case lowest >= clipHighlight:
+					d.Clipping.SetRGBA(x, y, clippedHighlight)
+				case highest <= clipShadow:
+					d.Clipping.SetRGBA(x, y, crushedShadow)
+				default:
+					// Reinhard's global operator, enough to recognize the scene
+					v := 0.18 * l / key
+					g := uint8(math.Round(255 * colorspace.SRGBCurve.Encode(v/(1+v))))
+					d.Clipping.SetRGBA(x, y, color.RGBA{g, g, g, 0xff})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stops := []legendEntry{{fmt.Sprintf("<%d", -falseColorStops), falseColor(math.Inf(-1))}}
+	for ev := -falseColorStops; ev <= falseColorStops; ev++ {
+		label := fmt.Sprintf("%+d", ev)
+		if ev == 0 {
+			label = "0"
+		}
+		stops = append(stops, legendEntry{label, falseColor(float64(ev))})
+	}
+	stops = append(stops, legendEntry{fmt.Sprintf(">%+d", falseColorStops), falseColor(math.Inf(1))})
+	drawLegend(d.FalseColor, stops)
+
+	frames := make([]legendEntry, len(palette))
+	for i, c := range palette {
+		frames[i] = legendEntry{strconv.Itoa(i + 1), c}
+	}
+	drawLegend(d.Contribution, frames)
+	return d, nil
+}
+
+// luminanceAt returns the luminance of pixel x, y of m in the working space
+func (p *HDRProcessor) luminanceAt(m *hdr.RGB, x, y int) float64 {
+	i := m.PixOffset(x, y)
+	return max(p.working.Luminance(float64(m.Pix[i]), float64(m.Pix[i+1]), float64(m.Pix[i+2])), 0)
+}
+
+// falseColor returns the color of a luminance ev stops from the log-average
+func falseColor(ev float64) color.RGBA {
+	stop := math.Round(ev)
+	switch {
+	case math.IsNaN(ev) || stop < -falseColorStops:
+		return color.RGBA{0, 0, 0, 0xff}
+	case stop > falseColorStops:
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}
+	}
+	return falseColors[int(stop)+falseColorStops]
+}
+
+// exposureColors returns n saturated colors from blue for the darkest
+// exposure to red for the brightest
+func exposureColors(n int) []color.RGBA {
+	colors := make([]color.RGBA, n)
+	for i := range colors {
+		hue := 240.0
+		if n > 1 {
+			hue = 240 * (1 - float64(i)/float64(n-1))
+		}
+		colors[i] = hueColor(hue)
+	}
+	return colors
+}
+
+// hueColor returns the fully saturated, full value color of a hue in degrees
+func hueColor(hue float64) color.RGBA {
+	h := hue / 60
+	f := uint8(math.Round(255 * (1 - math.Abs(math.Mod(h, 2)-1))))
+	switch int(h) % 6 {
+	case 0:
+		return color.RGBA{0xff, f, 0, 0xff}
+	case 1:
+		return color.RGBA{f, 0xff, 0, 0xff}
+	case 2:
+		return color.RGBA{0, 0xff, f, 0xff}
+	case 3:
+		return color.RGBA{0, f, 0xff, 0xff}
+	case 4:
+		return color.RGBA{f, 0, 0xff, 0xff}
+	}
+	return color.RGBA{0xff, 0, f, 0xff}
+}
+
+// legendEntry is a labeled swatch of a legend
+type legendEntry struct {
+	label string
+	color color.RGBA
+}
+
+// drawLegend fills the bottom legendHeight rows of img with a row of
+// swatches of equal width, labeled where the label fits
+func drawLegend(img *image.RGBA, entries []legendEntry) {
+	b := img.Bounds()
+	top := b.Max.Y - legendHeight
+	face := basicfont.Face7x13
+	for i, e := range entries {
+		left := b.Min.X + i*b.Dx()/len(entries)
+		right := b.Min.X + (i+1)*b.Dx()/len(entries)
+		swatch := image.Rect(left, top, right, b.Max.Y)
+		draw.Draw(img, swatch, image.NewUniform(e.color), image.Point{}, draw.Src)
+
+		width := font.MeasureString(face, e.label).Ceil()
+		if width > swatch.Dx() {
+			continue
+		}
+		text := color.Color(color.White)
+		if color.GrayModel.Convert(e.color).(color.Gray).Y > 128 {
+			text = color.Black
+		}
+		drawer := font.Drawer{Dst: img, Src: image.NewUniform(text), Face: face}
+		drawer.Dot = fixed.P(left+(swatch.Dx()-width)/2, top+(legendHeight-face.Height)/2+face.Ascent)
+		drawer.DrawString(e.label)
+	}
+}
+
+// paths returns the paths of the diagnostic images of a result saved to
+// output: next to it, named after it with a suffix
+func (d *Diagnostics) paths(output string) []string {
+	stem := strings.TrimSuffix(output, filepath.Ext(output))
+	paths := []string{stem + "_falsecolor.png", stem + "_contribution.png", stem + "_clipping.png"}
+	for i := range d.Weights {
+		paths = append(paths, fmt.Sprintf("%s_weights_%d.png", stem, i+1))
+	}
+	return paths
+}
+
+// Save writes the diagnostic images as PNG files next to output, named
+// after it with the suffixes _falsecolor, _contribution, _clipping and
+// _weights_<n>, and returns their paths
+func (d *Diagnostics) Save(output string) ([]string, error) {
+	paths := d.paths(output)
+	images := []image.Image{d.FalseColor, d.Contribution, d.Clipping}
+	for _, w := range d.Weights {
+		images = append(images, w)
+	}
+	for i, img := range images {
+		if err := imaging.SaveImage(img, paths[i]); err != nil {
+			return nil, fmt.Errorf("saving %s: %w", paths[i], err)
+		}
+	}
+	return paths, nil
+}
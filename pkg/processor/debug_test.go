@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/internal/synthetic"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// rowHDR returns a one row radiance map of gray pixels
+func rowHDR(values ...float64) *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, len(values), 1))
+	for x, v := range values {
+		m.SetRGB(x, 0, hdrcolor.RGB{R: v, G: v, B: v})
+	}
+	return m
+}
+
+func TestDiagnose(t *testing.T) {
+	b, err := synthetic.Camera{Exposures: []float64{0.005, 0.2, 2}}.Shoot(synthetic.Window(48, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewHDRProcessor(WithFrameWeights([]float64{1, 2, 1}))
+	linear, err := p.Linearize(b.Frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := p.Merge(linear)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := p.Diagnose(linear, merged)
+	if err != nil {
+		t.Fatalf("Diagnose failed: %v", err)
+	}
+	bounds := merged.Bounds()
+	withLegend := image.Rect(0, 0, 48, 32+legendHeight)
+	if d.FalseColor.Bounds() != withLegend || d.Contribution.Bounds() != withLegend {
+		t.Errorf("Expected maps with a legend of %v, got %v and %v", withLegend, d.FalseColor.Bounds(), d.Contribution.Bounds())
+	}
+	if d.Clipping.Bounds() != bounds {
+		t.Errorf("Expected a clipping overlay of %v, got %v", bounds, d.Clipping.Bounds())
+	}
+	if len(d.Weights) != 3 {
+		t.Fatalf("Expected 3 weight maps, got %d", len(d.Weights))
+	}
+
+	// The shares of the weight of every pixel add up
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sum := 0
+			for _, w := range d.Weights {
+				sum += int(w.GrayAt(x, y).Y)
+			}
+			if sum < 253 || sum > 257 {
+				t.Fatalf("Expected the weights at %d,%d to add up to 255, got %d", x, y, sum)
+			}
+		}
+	}
+
+	// Every pixel shares the weight by frame weight
+	window := image.Pt(36, 10)
+	for i, want := range []uint8{64, 128, 64} {
+		if got := d.Weights[i].GrayAt(window.X, window.Y).Y; got < want-1 || got > want+1 {
+			t.Errorf("Expected exposure %d to weigh %d, got %d", i+1, want, got)
+		}
+	}
+	palette := exposureColors(3)
+	if c := d.Contribution.RGBAAt(window.X, window.Y); c.G < palette[1].G/2 {
+		t.Errorf("Expected the middle exposure to dominate the contribution map, got %v", c)
+	}
+	if c := d.FalseColor.RGBAAt(window.X, window.Y); c == falseColor(0) {
+		t.Errorf("Expected the window to be brighter than the log-average, got %v", c)
+	}
+}
+
+func TestDiagnoseClipping(t *testing.T) {
+	// Clipped in every exposure, crushed in every exposure, and clipped in
+	// a single one
+	exposures := []hdr.Image{rowHDR(1, 0, 1), rowHDR(1, 0, 0.5)}
+	merged := rowHDR(1, 0, 0.75)
+
+	d, err := NewHDRProcessor().Diagnose(exposures, merged)
+	if err != nil {
+		t.Fatalf("Diagnose failed: %v", err)
+	}
+	if c := d.Clipping.RGBAAt(0, 0); c != clippedHighlight {
+		t.Errorf("Expected clipped highlights to be red, got %v", c)
+	}
+	if c := d.Clipping.RGBAAt(1, 0); c != crushedShadow {
+		t.Errorf("Expected crushed shadows to be blue, got %v", c)
+	}
+	if c := d.Clipping.RGBAAt(2, 0); c.R != c.G || c.G != c.B {
+		t.Errorf("Expected a pixel clipped in one exposure to be gray, got %v", c)
+	}
+}
+
+func TestFalseColor(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   float64
+		want int
+	}{
+		{"log-average", 0, falseColorStops},
+		{"rounded", 2.4, falseColorStops + 2},
+		{"darkest stop", -6.4, 0},
+		{"brightest stop", 6.4, len(falseColors) - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := falseColor(tt.ev); got != falseColors[tt.want] {
+				t.Errorf("Expected %v, got %v", falseColors[tt.want], got)
+			}
+		})
+	}
+
+	if c := falseColor(-7); c.R != 0 || c.G != 0 || c.B != 0 {
+		t.Errorf("Expected black below the range, got %v", c)
+	}
+	if c := falseColor(7); c.R != 0xff || c.G != 0xff || c.B != 0xff {
+		t.Errorf("Expected white above the range, got %v", c)
+	}
+}
+
+func TestDiagnoseErrors(t *testing.T) {
+	two := []hdr.Image{rowHDR(0.5, 0.5), rowHDR(0.5, 0.5)}
+	tests := []struct {
+		name      string
+		opts      []Option
+		exposures []hdr.Image
+		merged    hdr.Image
+	}{
+		{"nil radiance map", nil, two, nil},
+		{"one exposure", nil, two[:1], rowHDR(0.5, 0.5)},
+		{"size mismatch", nil, two, rowHDR(0.5)},
+		{"unsupported merge", []Option{WithMergeMethod("median")}, two, rowHDR(0.5, 0.5)},
+		{"frame weights", []Option{WithFrameWeights([]float64{1})}, two, rowHDR(0.5, 0.5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHDRProcessor(tt.opts...).Diagnose(tt.exposures, tt.merged); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestRunDebugOutputs(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 16, 8)
+	output := filepath.Join(dir, "out", "result.jpg")
+
+	if err := NewHDRProcessor(WithDebugOutputs(true)).Run(output, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	for _, suffix := range []string{"_falsecolor", "_contribution", "_clipping", "_weights_1", "_weights_3"} {
+		path := filepath.Join(dir, "out", "result"+suffix+".png")
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected debug output %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// Denoisers lists the noise reduction methods of the Denoise stage.
+// "bilateral" averages each pixel with the neighbors of similar color;
+// "wavelet" shrinks the fine detail of an à trous wavelet decomposition,
+// which keeps more texture at the cost of a pass per level.
+var Denoisers = []string{"bilateral", "wavelet"}
+
+// Default noise reduction strengths, in multiples of the estimated noise
+const (
+	DefaultDenoiseStrength = 1.0
+	DefaultDenoiseShadows  = 2.0
+)
+
+// denoiseShadowStops is how far below the log-average luminance, in stops,
+// the shadow strength fully applies. The strength blends linearly in stops
+// between the log-average and there.
+const denoiseShadowStops = 4
+
+// denoiseFloorStops bounds the noise model in the darkest shadows: noise is
+// taken as relative to the luminance plus a floor this many stops below the
+// log-average, so black pixels are still smoothed
+const denoiseFloorStops = 8
+
+// noiseSamples bounds the number of pixel pairs the noise is estimated from
+const noiseSamples = 1 << 20
+
+// Bilateral filter settings: the spatial standard deviation in pixels, and
+// the range standard deviation in multiples of the noise
+const (
+	bilateralSigma = 1.5
+	bilateralRange = 2.0
+)
+
+// rangeWeights tabulates the range weight exp(-x) of the bilateral filter
+// for x in steps of 1/rangeSteps, up to where it no longer matters
+var rangeWeights = func() []float64 {
+	w := make([]float64, 16*rangeSteps)
+	for i := range w {
+		w[i] = math.Exp(-float64(i) / rangeSteps)
+	}
+	return w
+}()
+
+const rangeSteps = 64
+
+// Wavelet settings: the garrote threshold of the detail coefficients in multiples
+// of their noise, and the standard deviation of the coefficients of unit
+// white noise at each level of the B3 spline à trous transform
+const waveletThreshold = 1.5
+
+var waveletNoise = []float64{0.889, 0.200, 0.086, 0.041}
+
+// b3Spline is the kernel of the à trous transform
+var b3Spline = [5]float64{1.0 / 16, 4.0 / 16, 6.0 / 16, 4.0 / 16, 1.0 / 16}
+
+// denoise holds the noise reduction settings of the processor
+type denoise struct {
+	// method is one of Denoisers, or empty to leave radiance maps as merged
+	method string
+	// strength scales the noise removed at and above the log-average
+	// luminance, shadows the noise removed in the shadows
+	strength, shadows float64
+}
+
+// at returns the strength of noise reduction ev stops from the log-average
+// luminance
+func (d denoise) at(ev float64) float64 {
+	t := max(0, min(1, -ev/denoiseShadowStops))
+	return d.strength + (d.shadows-d.strength)*t
+}
+
+// check reports settings Denoise cannot run with
+func (d denoise) check() error {
+	if !slices.Contains(Denoisers, d.method) {
+		return fmt.Errorf("unsupported denoiser: %s", d.method)
+	}
+	if d.strength < 0 || d.shadows < 0 {
+		return fmt.Errorf("invalid denoise strength: %v, shadows %v", d.strength, d.shadows)
+	}
+	return nil
+}
+
+// Denoiser returns the noise reduction method of the Denoise stage, or an
+// empty string when radiance maps are left as merged
+func (p *HDRProcessor) Denoiser() string {
+	return p.denoise.method
+}
+
+// Denoise reduces the noise of a merged radiance map with the method set
+// by WithDenoise, and returns it unchanged when none is set. The noise is
+// estimated from the map itself, as relative to the radiance, and removed
+// from the linear radiance before tone mapping lifts the shadows.
+func (p *HDRProcessor) Denoise(merged hdr.Image) (hdr.Image, error) {
+	return p.DenoiseContext(context.Background(), merged)
+}
+
+// DenoiseContext is Denoise with cancellation and progress reporting.
+// Cancellation is checked between tiles.
+func (p *HDRProcessor) DenoiseContext(ctx context.Context, merged hdr.Image) (hdr.Image, error) {
+	if p.denoise.method == "" {
+		return merged, nil
+	}
+	ctx = p.withProgress(ctx)
+	if merged == nil {
+		return nil, errors.New("radiance map is nil")
+	}
+	if err := p.denoise.check(); err != nil {
+		return nil, err
+	}
+	m, err := p.asRGB(ctx, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	passes := 2
+	if p.denoise.method == "wavelet" {
+		passes = 2 + 2*len(waveletNoise)
+	}
+	tiles := p.tiles(m.Bounds())
+	key, err := p.logAverageLuminance(ctx, m, tiles, progress.StageDenoise, 0, passes)
+	if err != nil {
+		return nil, err
+	}
+	n := noiseModel{key: key, floor: key * math.Exp2(-denoiseFloorStops)}
+	n.sigma = p.estimateNoise(m, n.floor)
+	if n.sigma == 0 {
+		return m, nil
+	}
+
+	if p.denoise.method == "wavelet" {
+		return p.waveletDenoise(ctx, m, tiles, n)
+	}
+	return p.bilateralDenoise(ctx, m, tiles, n)
+}
+
+// noiseModel describes the noise of a radiance map: its standard deviation
+// relative to the samples plus floor, and the log-average luminance key
+// the strength is set around
+type noiseModel struct {
+	key, floor, sigma float64
+}
+
+// strength returns the strength of d at luminance l
+func (n noiseModel) strength(d denoise, l float64) float64 {
+	return d.at(math.Log2((l + n.floor) / n.key))
+}
+
+// deviation returns the standard deviation of the noise to remove at
+// luminance l, scaled by the strength of d and by k
+func (n noiseModel) deviation(d denoise, l, k float64) float64 {
+	return k * n.strength(d, l) * n.sigma * (l + n.floor)
+}
+
+// estimateNoise returns the standard deviation of the noise of m relative
+// to its channels plus floor, from the median absolute difference of
+// horizontal neighbors. Pairs of equal samples, such as clipped areas, are
+// left out.
+func (p *HDRProcessor) estimateNoise(m *hdr.RGB, floor float64) float64 {
+	b := m.Bounds()
+	if b.Dx() < 2 {
+		return 0
+	}
+	step := max(1, int(math.Ceil(math.Sqrt(float64(b.Dx()*b.Dy())/noiseSamples))))
+	var diffs []float64
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X-1; x += step {
+			i := m.PixOffset(x, y)
+			for k := i; k < i+3; k++ {
+				v1, v2 := max(float64(m.Pix[k]), 0), max(float64(m.Pix[k+3]), 0)
+				if v1 != v2 {
+					diffs = append(diffs, math.Abs(v1-v2)/((v1+v2)/2+floor))
+				}
+			}
+		}
+	}
+	if len(diffs) == 0 {
+		return 0
+	}
+	slices.Sort(diffs)
+	// The difference of two samples has √2 times their deviation, and the
+	// median absolute deviation of a normal distribution is 0.6745 of it
+	return diffs[len(diffs)/2] / 0.6745 / math.Sqrt2
+}
+
+// bilateralDenoise averages each pixel of m with its neighbors, weighted by
+// their distance and by how far their color is from its own relative to the
+// noise
+func (p *HDRProcessor) bilateralDenoise(ctx context.Context, m *hdr.RGB, tiles []image.Rectangle, n noiseModel) (*hdr.RGB, error) {
+	radius := int(math.Ceil(2 * bilateralSigma))
+	size := 2*radius + 1
+	spatial := make([]float64, size*size)
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			spatial[(dy+radius)*size+dx+radius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * bilateralSigma * bilateralSigma))
+		}
+	}
+
+	b := m.Bounds()
+	out := hdr.NewRGB(b)
+	err := p.forEachTile(ctx, tiles, progress.StageDenoise, 1, 2, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := m.PixOffset(x, y)
+				c := m.Pix[i : i+3 : i+3]
+				sigma := n.deviation(p.denoise, p.luminanceAt(m, x, y), bilateralRange)
+				if sigma <= 0 {
+					copy(out.Pix[i:i+3], c)
+					continue
+				}
+				scale := rangeSteps / (2 * 3 * sigma * sigma)
+
+				var sum [3]float64
+				total := 0.0
+				for ny := max(y-radius, b.Min.Y); ny < min(y+radius+1, b.Max.Y); ny++ {
+					row := (ny - y + radius) * size
+					for nx := max(x-radius, b.Min.X); nx < min(x+radius+1, b.Max.X); nx++ {
+						j := m.PixOffset(nx, ny)
+						dr := float64(m.Pix[j] - c[0])
+						dg := float64(m.Pix[j+1] - c[1])
+						db := float64(m.Pix[j+2] - c[2])
+						k := int((dr*dr + dg*dg + db*db) * scale)
+						if k >= len(rangeWeights) {
+							continue
+						}
+						w := spatial[row+nx-x+radius] * rangeWeights[k]
+						sum[0] += w * float64(m.Pix[j])
+						sum[1] += w * float64(m.Pix[j+1])
+						sum[2] += w * float64(m.Pix[j+2])
+						total += w
+					}
+				}
+				for k := range sum {
+					out.Pix[i+k] = float32(sum[k] / total)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// waveletDenoise decomposes the logarithm of m, where the noise relative
+// to the radiance is the same at every level of brightness and edges
+// against highlights are steps like any other, with the B3 spline à trous
+// transform. Each level takes a horizontal and a vertical pass, the latter
+// shrinking the detail of the level against the noise at the luminance of
+// the coarser one; the last level turns the sum back into radiance.
+func (p *HDRProcessor) waveletDenoise(ctx context.Context, m *hdr.RGB, tiles []image.Rectangle, n noiseModel) (*hdr.RGB, error) {
+	b := m.Bounds()
+	passes := 2 + 2*len(waveletNoise)
+	cur := hdr.NewRGB(b)
+	err := p.forEachTile(ctx, tiles, progress.StageDenoise, 1, passes, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i, end := m.PixOffset(r.Min.X, y), m.PixOffset(r.Max.X, y)
+			for ; i < end; i++ {
+				cur.Pix[i] = float32(math.Log(max(float64(m.Pix[i]), 0) + n.floor))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out, rows, next := hdr.NewRGB(b), hdr.NewRGB(b), hdr.NewRGB(b)
+	for level, noise := range waveletNoise {
+		step := 1 << level
+		last := level == len(waveletNoise)-1
+		err := p.forEachTile(ctx, tiles, progress.StageDenoise, 2+2*level, passes, func(_ int, r image.Rectangle) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					var smooth [3]float64
+					for k, w := range b3Spline {
+						j := cur.PixOffset(max(b.Min.X, min(b.Max.X-1, x+(k-2)*step)), y)
+						smooth[0] += w * float64(cur.Pix[j])
+						smooth[1] += w * float64(cur.Pix[j+1])
+						smooth[2] += w * float64(cur.Pix[j+2])
+					}
+					i := rows.PixOffset(x, y)
+					for k, v := range smooth {
+						rows.Pix[i+k] = float32(v)
+					}
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.forEachTile(ctx, tiles, progress.StageDenoise, 3+2*level, passes, func(_ int, r image.Rectangle) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					var smooth [3]float64
+					for k, w := range b3Spline {
+						j := rows.PixOffset(x, max(b.Min.Y, min(b.Max.Y-1, y+(k-2)*step)))
+						smooth[0] += w * float64(rows.Pix[j])
+						smooth[1] += w * float64(rows.Pix[j+1])
+						smooth[2] += w * float64(rows.Pix[j+2])
+					}
+
+					// The luminance of the log channels is close enough to
+					// that of the radiance to pick the strength
+					i := cur.PixOffset(x, y)
+					l := max(math.Exp(p.working.Luminance(smooth[0], smooth[1], smooth[2]))-n.floor, 0)
+					t := waveletThreshold * noise * n.sigma * n.strength(p.denoise, l)
+					for k, s := range smooth {
+						next.Pix[i+k] = float32(s)
+						v := float64(out.Pix[i+k]) + shrink(float64(cur.Pix[i+k])-s, t)
+						if last {
+							v = max(math.Exp(v+s)-n.floor, 0)
+						}
+						out.Pix[i+k] = float32(v)
+					}
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		cur, next = next, cur
+	}
+	return out, nil
+}
+
+// shrink applies the non-negative garrote threshold t to v, which zeroes
+// the coefficients below t like soft thresholding but shrinks strong edges
+// less
+func shrink(v, t float64) float64 {
+	if math.Abs(v) <= t {
+		return 0
+	}
+	return v - t*t/v
+}
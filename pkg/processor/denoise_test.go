@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/internal/synthetic"
+	"github.com/mdouchement/hdr"
+)
+
+// addNoise returns m with normal noise of deviation sigma relative to each
+// sample
+func addNoise(m *hdr.RGB, sigma float64) *hdr.RGB {
+	rng := rand.New(rand.NewSource(1))
+	noisy := hdr.NewRGB(m.Bounds())
+	for i, v := range m.Pix {
+		noisy.Pix[i] = v * float32(1+sigma*rng.NormFloat64())
+	}
+	return noisy
+}
+
+// relativeError returns the root mean square error of m relative to truth
+// over r
+func relativeError(m, truth *hdr.RGB, r image.Rectangle) float64 {
+	sum := 0.0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := truth.PixOffset(x, y)
+			for k := i; k < i+3; k++ {
+				d := float64(m.Pix[k]-truth.Pix[k]) / float64(truth.Pix[k])
+				sum += d * d
+			}
+		}
+	}
+	return math.Sqrt(sum / float64(3*r.Dx()*r.Dy()))
+}
+
+func TestDenoise(t *testing.T) {
+	// Edges against the window leave less to gain than a smooth ramp, but
+	// must not add error
+	tests := []struct {
+		name   string
+		scene  synthetic.Scene
+		factor float64
+	}{
+		{"gradient", synthetic.Gradient(96, 64, 3), 0.5},
+		{"window", synthetic.Window(96, 64), 0.75},
+	}
+	for _, tt := range tests {
+		clean := tt.scene.Render()
+		noisy := addNoise(clean, 0.1)
+		before := relativeError(noisy, clean, clean.Bounds())
+
+		for _, method := range Denoisers {
+			t.Run(tt.name+"/"+method, func(t *testing.T) {
+				p := NewHDRProcessor(WithDenoise(method, 1, 1), WithTileSize(16))
+				got, err := p.Denoise(noisy)
+				if err != nil {
+					t.Fatalf("Denoise failed: %v", err)
+				}
+				m := got.(*hdr.RGB)
+				if m.Bounds() != clean.Bounds() {
+					t.Fatalf("Expected %v, got %v", clean.Bounds(), m.Bounds())
+				}
+				if after := relativeError(m, clean, clean.Bounds()); after > tt.factor*before {
+					t.Errorf("Expected the error to drop from %.4f to under %.4f, got %.4f", before, tt.factor*before, after)
+				}
+				for i, v := range m.Pix {
+					if v < 0 {
+						t.Fatalf("Expected non-negative radiance, got %v at %d", v, i)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDenoiseShadows(t *testing.T) {
+	// The left of the ramp is over 4 stops below the log-average and the
+	// right above it, so only the left is denoised
+	clean := synthetic.Gradient(96, 64, 3).Render()
+	noisy := addNoise(clean, 0.1)
+	dark, bright := image.Rect(0, 0, 16, 64), image.Rect(80, 0, 96, 64)
+
+	for _, method := range Denoisers {
+		t.Run(method, func(t *testing.T) {
+			got, err := NewHDRProcessor(WithDenoise(method, 0, 1)).Denoise(noisy)
+			if err != nil {
+				t.Fatalf("Denoise failed: %v", err)
+			}
+			m := got.(*hdr.RGB)
+			if before, after := relativeError(noisy, clean, dark), relativeError(m, clean, dark); after > before/2 {
+				t.Errorf("Expected the error in the shadows to drop from %.4f to under half, got %.4f", before, after)
+			}
+			if e := relativeError(m, noisy, bright); e > 1e-3 {
+				t.Errorf("Expected the highlights unchanged, got a relative change of %.4f", e)
+			}
+		})
+	}
+}
+
+func TestDenoiseNone(t *testing.T) {
+	noisy := addNoise(synthetic.Window(32, 24).Render(), 0.1)
+
+	got, err := NewHDRProcessor().Denoise(noisy)
+	if err != nil || got != hdr.Image(noisy) {
+		t.Errorf("Expected the radiance map unchanged without a denoiser, got %v", err)
+	}
+
+	// Without strength, every method leaves the pixels as they are
+	for _, method := range Denoisers {
+		t.Run(method, func(t *testing.T) {
+			got, err := NewHDRProcessor(WithDenoise(method, 0, 0)).Denoise(noisy)
+			if err != nil {
+				t.Fatalf("Denoise failed: %v", err)
+			}
+			if e := relativeError(got.(*hdr.RGB), noisy, noisy.Bounds()); e > 1e-6 {
+				t.Errorf("Expected no change at strength 0, got a relative error of %g", e)
+			}
+		})
+	}
+}
+
+func TestDenoiseStrengthAt(t *testing.T) {
+	d := denoise{strength: 1, shadows: 3}
+	tests := []struct {
+		ev   float64
+		want float64
+	}{
+		{2, 1},
+		{0, 1},
+		{-denoiseShadowStops / 2, 2},
+		{-denoiseShadowStops, 3},
+		{-10, 3},
+	}
+	for _, tt := range tests {
+		if got := d.at(tt.ev); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("at(%v) = %v, want %v", tt.ev, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateNoise(t *testing.T) {
+	tests := []struct {
+		name  string
+		sigma float64
+	}{
+		{"noisy", 0.1},
+		{"faint", 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flat := hdr.NewRGB(image.Rect(0, 0, 256, 256))
+			for i := range flat.Pix {
+				flat.Pix[i] = 0.5
+			}
+			p := NewHDRProcessor()
+			got := p.estimateNoise(addNoise(flat, tt.sigma), 0)
+			if math.Abs(got-tt.sigma) > 0.1*tt.sigma {
+				t.Errorf("Expected a noise of %v, got %v", tt.sigma, got)
+			}
+		})
+	}
+
+	if got := NewHDRProcessor().estimateNoise(rowHDR(1, 1, 1), 0); got != 0 {
+		t.Errorf("Expected no noise in a flat image, got %v", got)
+	}
+}
+
+func TestDenoiseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		merged hdr.Image
+	}{
+		{"nil radiance map", []Option{WithDenoise("wavelet", 1, 1)}, nil},
+		{"unsupported method", []Option{WithDenoise("median", 1, 1)}, rowHDR(0.5, 0.5)},
+		{"negative strength", []Option{WithDenoise("bilateral", -1, 1)}, rowHDR(0.5, 0.5)},
+		{"negative shadows", []Option{WithDenoise("bilateral", 1, -1)}, rowHDR(0.5, 0.5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHDRProcessor(tt.opts...).Denoise(tt.merged); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func BenchmarkDenoise(b *testing.B) {
+	m := addNoise(synthetic.Gradient(2048, 1365, 3).Render(), 0.05)
+
+	for _, method := range Denoisers {
+		b.Run(method, func(b *testing.B) {
+			p := NewHDRProcessor(WithDenoise(method, DefaultDenoiseStrength, DefaultDenoiseShadows))
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Denoise(m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+)
+
+// HDRTransfers lists the transfer functions of HDR display output
+var HDRTransfers = []string{"pq", "hlg"}
+
+// Defaults of HDR display output
+const (
+	// DefaultReferenceWhite is the luminance of diffuse white in cd/m², as
+	// recommended by ITU-R BT.2408
+	DefaultReferenceWhite = 203
+	// DefaultPeakLuminance is the luminance of the brightest highlights in
+	// cd/m²
+	DefaultPeakLuminance = 1000
+	// DefaultBitDepth is the bit depth of TIFF output
+	DefaultBitDepth = 16
+)
+
+// middleGray is the fraction of reference white the log-average luminance
+// of the radiance map is exposed to
+const middleGray = 0.18
+
+// hdrOutput holds the settings of HDR display output
+type hdrOutput struct {
+	// transfer is one of HDRTransfers, or empty to tone map to SDR
+	transfer       string
+	referenceWhite float64
+	peak           float64
+	bits           int
+}
+
+// HDROutput returns the transfer function of HDR display output, or an
+// empty string when the result is tone mapped to SDR
+func (p *HDRProcessor) HDROutput() string {
+	return p.hdr.transfer
+}
+
+// check validates the HDR output settings
+func (h hdrOutput) check() error {
+	if !slices.Contains(HDRTransfers, h.transfer) {
+		return fmt.Errorf("unsupported HDR transfer function: %s", h.transfer)
+	}
+	if h.referenceWhite <= 0 || h.peak > colorspace.PQPeak || h.referenceWhite > h.peak {
+		return fmt.Errorf("invalid HDR luminance: reference white %v and peak %v cd/m² must satisfy 0 < white ≤ peak ≤ %d",
+			h.referenceWhite, h.peak, colorspace.PQPeak)
+	}
+	if h.bits != 10 && h.bits != 16 {
+		return fmt.Errorf("unsupported HDR bit depth: %d (10, 16)", h.bits)
+	}
+	return nil
+}
+
+// hdrEncoder encodes a radiance map for HDR displays as BT.2100 signal
+// values in BT.2020 primaries. It runs as a tone operator so the ToneMap
+// stage and streaming can use it.
+//
+// The map is exposed so its log-average luminance sits at middleGray times
+// the reference white. Highlights brighter than a knee at 3/4 of the peak
+// luminance roll off smoothly towards the peak.
+type hdrEncoder struct {
+	transfer string
+	working  *colorspace.Space
+	toBT2020 *colorspace.Transform
+	white    float64
+	peak     float64
+	knee     float64
+	// gamma is the HLG system gamma for the peak luminance
+	gamma float64
+	// scale converts map values to cd/m², once the statistics are known
+	scale float64
+}
+
+func newHDREncoder(h hdrOutput, working *colorspace.Space) (*hdrEncoder, error) {
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	return &hdrEncoder{
+		transfer: h.transfer,
+		working:  working,
+		toBT2020: colorspace.NewTransform(working, colorspace.Rec2020),
+		white:    h.referenceWhite,
+		peak:     h.peak,
+		knee:     max(h.referenceWhite, 0.75*h.peak),
+		gamma:    colorspace.HLGSystemGamma(h.peak),
+		scale:    h.referenceWhite,
+	}, nil
+}
+
+type hdrStats struct {
+	space  *colorspace.Space
+	logSum float64
+	count  float64
+}
+
+func (s *hdrStats) add(row []float32, weight int) {
+	for j := 0; j < len(row); j += 3 {
+		lum := s.space.Luminance(float64(row[j]), float64(row[j+1]), float64(row[j+2]))
+		s.logSum += math.Log(max(lum, 0)+1e-4) * float64(weight)
+	}
+	s.count += float64(len(row) / 3 * weight)
+}
+
+func (e *hdrEncoder) passes() int { return 1 }
+
+func (e *hdrEncoder) rowWeight(pass, y int) int { return 1 }
+
+func (e *hdrEncoder) newStats(pass int) toneStats {
+	return &hdrStats{space: e.working}
+}
+
+func (e *hdrEncoder) endPass(pass int, stats []toneStats) {
+	if avg := logAverage(stats); avg > 0 {
+		e.scale = middleGray * e.white / avg
+	}
+}
+
+// logAverage combines hdrStats into the log-average luminance, or zero
+// without pixels
+func logAverage(stats []toneStats) float64 {
+	var total hdrStats
+	for _, s := range stats {
+		s := s.(*hdrStats)
+		total.logSum += s.logSum
+		total.count += s.count
+	}
+	if total.count == 0 {
+		return 0
+	}
+	return math.Exp(total.logSum / total.count)
+}
+
+func (e *hdrEncoder) mapRow(row []float32, out []uint8) {
+	for j, o := 0, 0; j < len(row); j, o = j+3, o+8 {
+		r, g, b := e.encode(float64(row[j]), float64(row[j+1]), float64(row[j+2]))
+		setRGB(out[o:], quantize(r), quantize(g), quantize(b))
+	}
+}
+
+// encode maps a radiance value to signal values
+func (e *hdrEncoder) encode(r, g, b float64) (float64, float64, float64) {
+	r, g, b = e.toBT2020.ConvertLinear(r, g, b)
+	r, g, b = max(r, 0)*e.scale, max(g, 0)*e.scale, max(b, 0)*e.scale
+
+	// Roll the brightest channel off so hues are kept
+	if m := max(r, g, b); m > e.knee {
+		over, room := m-e.knee, e.peak-e.knee
+		f := (e.knee + room*over/(over+room)) / m
+		if room <= 0 {
+			f = e.peak / m
+		}
+		r, g, b = r*f, g*f, b*f
+	}
+
+	if e.transfer == "pq" {
+		return colorspace.PQEncode(r), colorspace.PQEncode(g), colorspace.PQEncode(b)
+	}
+	// HLG carries scene light: undo the OOTF of a display with this peak
+	r, g, b = r/e.peak, g/e.peak, b/e.peak
+	if y := colorspace.Rec2020.Luminance(r, g, b); y > 0 {
+		f := math.Pow(y, (1-e.gamma)/e.gamma)
+		r, g, b = r*f, g*f, b*f
+	}
+	return colorspace.HLGEncode(r), colorspace.HLGEncode(g), colorspace.HLGEncode(b)
+}
+
+// saveHDR saves an HDR display image. Radiance maps are encoded first;
+// other images must come from ToneMap with HDR output.
+func (p *HDRProcessor) saveHDR(ctx context.Context, img image.Image, outputPath string) error {
+	if !imaging.IsDisplayFormat(outputPath) {
+		return fmt.Errorf("%s: HDR output must be saved as PNG or TIFF, not %s",
+			outputPath, strings.TrimPrefix(filepath.Ext(outputPath), "."))
+	}
+	if m, ok := img.(hdr.Image); ok {
+		encoded, err := p.ToneMapContext(ctx, m)
+		if err != nil {
+			return err
+		}
+		img = encoded
+	}
+	signal, ok := img.(*image.RGBA64)
+	if !ok {
+		return errors.New("HDR output needs a radiance map or the result of ToneMap")
+	}
+	transfer := imaging.TransferPQ
+	if p.hdr.transfer == "hlg" {
+		transfer = imaging.TransferHLG
+	}
+	return imaging.SaveDisplayImage(signal, outputPath, transfer, p.hdr.bits)
+}
@@ -0,0 +1,162 @@
+package processor
+
+import (
+	"errors"
+	"image"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+func TestHDREncoderSignals(t *testing.T) {
+	tests := []struct {
+		name     string
+		transfer string
+		value    float64
+		want     float64
+	}{
+		// Before statistics, a value of 1 is reference white
+		{"PQ black", "pq", 0, colorspace.PQEncode(0)},
+		{"PQ reference white", "pq", 1, 0.5807},
+		{"PQ above white", "pq", 2, colorspace.PQEncode(406)},
+		// BT.2408 puts reference white at 75% HLG on a 1000 cd/m² display
+		{"HLG reference white", "hlg", 1, 0.75},
+		{"HLG black", "hlg", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newHDREncoder(hdrOutput{transfer: tt.transfer, referenceWhite: 203, peak: 1000, bits: 16}, colorspace.SRGB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, g, b := e.encode(tt.value, tt.value, tt.value)
+			for _, c := range []float64{r, g, b} {
+				if math.Abs(c-tt.want) > 2e-3 {
+					t.Errorf("Expected signal %.4f, got %.4f %.4f %.4f", tt.want, r, g, b)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestHDREncoderRollsOffHighlights(t *testing.T) {
+	e, err := newHDREncoder(hdrOutput{transfer: "pq", referenceWhite: 100, peak: 600, bits: 16}, colorspace.SRGB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := colorspace.PQEncode(600)
+	prev := 0.0
+	for _, v := range []float64{1, 4, 4.5, 5, 10, 100, 1e4} {
+		got, _, _ := e.encode(v, v, v)
+		if got <= prev || got > limit+1e-9 {
+			t.Errorf("Value %v: signal %.4f is not increasing up to the peak %.4f", v, got, limit)
+		}
+		prev = got
+	}
+	// Below the knee, luminance is linear
+	if got, _, _ := e.encode(4, 4, 4); math.Abs(got-colorspace.PQEncode(400)) > 1e-6 {
+		t.Errorf("Expected 400 cd/m² below the knee, got %.4f", got)
+	}
+}
+
+func TestHDROutputCheck(t *testing.T) {
+	valid := hdrOutput{transfer: "pq", referenceWhite: 203, peak: 1000, bits: 10}
+	tests := []struct {
+		name   string
+		modify func(*hdrOutput)
+		want   string
+	}{
+		{"valid", func(*hdrOutput) {}, ""},
+		{"transfer", func(h *hdrOutput) { h.transfer = "srgb" }, "transfer"},
+		{"white above peak", func(h *hdrOutput) { h.referenceWhite = 2000 }, "luminance"},
+		{"peak above PQ", func(h *hdrOutput) { h.peak = 12000 }, "luminance"},
+		{"zero white", func(h *hdrOutput) { h.referenceWhite = 0 }, "luminance"},
+		{"bit depth", func(h *hdrOutput) { h.bits = 12 }, "bit depth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := valid
+			tt.modify(&h)
+			err := h.check()
+			if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRunHDROutput(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []string{
+		writeTestImage(t, dir, "low.png", 100),
+		writeTestImage(t, dir, "high.png", 100),
+	}
+
+	for _, name := range []string{"out.png", "out.tif"} {
+		t.Run(name, func(t *testing.T) {
+			output := filepath.Join(dir, name)
+			p := NewHDRProcessor(WithHDROutput("pq"), WithBitDepth(16))
+			if err := p.Run(output, inputs...); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if filepath.Ext(name) != ".png" {
+				return
+			}
+
+			img := decodePNG(t, output)
+			rgba, ok := img.(*image.RGBA64)
+			if !ok {
+				t.Fatalf("Expected a 16-bit image, got %T", img)
+			}
+			// A uniform map is exposed to middle gray
+			want := colorspace.PQEncode(middleGray * DefaultReferenceWhite)
+			got := float64(rgba.RGBA64At(1, 1).R) / 0xffff
+			if math.Abs(got-want) > 2e-3 {
+				t.Errorf("Expected PQ signal %.4f for middle gray, got %.4f", want, got)
+			}
+		})
+	}
+}
+
+func TestHDROutputErrors(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []string{
+		writeTestImage(t, dir, "low.png", 50),
+		writeTestImage(t, dir, "high.png", 200),
+	}
+
+	var encodeErr *EncodeError
+	err := NewHDRProcessor(WithHDROutput("hlg")).Run(filepath.Join(dir, "out.jpg"), inputs...)
+	if !errors.As(err, &encodeErr) {
+		t.Errorf("Expected an EncodeError for JPEG output, got %v", err)
+	}
+
+	err = NewHDRProcessor(WithHDROutput("pq")).RunStreaming(filepath.Join(dir, "out.png"), inputs...)
+	if err == nil || !strings.Contains(err.Error(), "streaming") {
+		t.Errorf("Expected streaming to be rejected, got %v", err)
+	}
+
+	err = NewHDRProcessor(WithHDROutput("pq"), WithPeakLuminance(100)).Run(filepath.Join(dir, "bad.png"), inputs...)
+	if err == nil || !strings.Contains(err.Error(), "luminance") {
+		t.Errorf("Expected invalid luminance to be rejected, got %v", err)
+	}
+}
+
+func TestSaveHDRRadianceMap(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.png")
+	p := NewHDRProcessor(WithHDROutput("hlg"))
+	if err := p.Save(createRadianceMap(8, 8), output); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := imaging.LoadImage(output); err != nil {
+		t.Errorf("Failed to load output: %v", err)
+	}
+}
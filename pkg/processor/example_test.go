@@ -0,0 +1,59 @@
+package processor_test
+
+import (
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+func uniform(value uint8) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = value
+	}
+	return img
+}
+
+func Example() {
+	p := processor.NewHDRProcessor(
+		processor.WithToneMapper("drago03"),
+		processor.WithGamma(0.85),
+	)
+
+	result, err := p.Process([]image.Image{uniform(40), uniform(128), uniform(220)})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(result.Bounds())
+	// Output: (0,0)-(8,8)
+}
+
+// Run the stages individually to inspect the radiance map before tone mapping.
+func ExampleHDRProcessor_Merge() {
+	p := processor.NewHDRProcessor()
+
+	linear, err := p.Linearize([]image.Image{uniform(0), uniform(255)})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merged, err := p.Merge(linear)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r, _, _, _ := merged.HDRAt(0, 0).HDRRGBA()
+	fmt.Printf("%.2f\n", r)
+	// Output: 0.50
+}
+
+func ExampleHDRProcessor_Run() {
+	p := processor.NewHDRProcessor(processor.WithToneMapper("reinhard05"))
+
+	if err := p.Run("hdr_output.jpg", "low.jpg", "mid.jpg", "high.jpg"); err != nil {
+		log.Println(err)
+	}
+}
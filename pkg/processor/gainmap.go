@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/mdouchement/hdr"
+)
+
+// gainMapScale is the factor by which gain maps are smaller than the image
+// in each direction
+const gainMapScale = 4
+
+// gainMapOffset is added to linear SDR and HDR values before taking their
+// ratio, so black pixels get finite gains
+const gainMapOffset = 1.0 / 64
+
+// GainMap reports whether tone mapped output carries a gain map
+func (p *HDRProcessor) GainMap() bool {
+	return p.gainMap
+}
+
+// withGainMap computes the gain map restoring m from its tone mapped
+// rendition sdr. The HDR rendition is the radiance map exposed as for HDR
+// display output, with SDR white at the reference white and highlights
+// capped at the peak luminance.
+func (p *HDRProcessor) withGainMap(ctx context.Context, m *hdr.RGB, sdr *image.RGBA64) (*imaging.GainMapImage, error) {
+	bounds := m.Bounds()
+	gb := image.Rect(0, 0, (bounds.Dx()+gainMapScale-1)/gainMapScale, (bounds.Dy()+gainMapScale-1)/gainMapScale)
+
+	// Expose the map like HDR display output, with SDR white at 1. The
+	// tone mapping stage already reported its progress, so these passes
+	// report none.
+	tiles := p.tiles(bounds)
+	stats := make([]toneStats, len(tiles))
+	err := parallel.Run(ctx, tiles, p.workers, func(i int, r image.Rectangle) {
+		s := &hdrStats{space: p.working}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			s.add(rowOf(m, r, y), 1)
+		}
+		stats[i] = s
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	scale := 1.0
+	if avg := logAverage(stats); avg > 0 {
+		scale = middleGray / avg
+	}
+	capacity := math.Log2(p.hdr.peak / p.hdr.referenceWhite)
+
+	// Average the log gains over blocks of pixels
+	gains := make([]float64, gb.Dx()*gb.Dy())
+	curve := p.output.Curve()
+	err = parallel.Run(ctx, p.tiles(gb), p.workers, func(_ int, r image.Rectangle) {
+		for gy := r.Min.Y; gy < r.Max.Y; gy++ {
+			for gx := r.Min.X; gx < r.Max.X; gx++ {
+				block := image.Rect(gx*gainMapScale, gy*gainMapScale, (gx+1)*gainMapScale, (gy+1)*gainMapScale).
+					Add(bounds.Min).Intersect(bounds)
+				sum := 0.0
+				for y := block.Min.Y; y < block.Max.Y; y++ {
+					row := rowOf(m, block, y)
+					o := sdr.PixOffset(block.Min.X, y)
+					for j := 0; j < len(row); j, o = j+3, o+8 {
+						yh := p.working.Luminance(float64(row[j]), float64(row[j+1]), float64(row[j+2]))
+						yh = min(max(yh*scale, 0), math.Exp2(capacity))
+						px := sdr.Pix[o : o+6]
+						ys := p.working.Luminance(
+							curve.Decode(float64(uint16(px[0])<<8|uint16(px[1]))/ldrMax),
+							curve.Decode(float64(uint16(px[2])<<8|uint16(px[3]))/ldrMax),
+							curve.Decode(float64(uint16(px[4])<<8|uint16(px[5]))/ldrMax))
+						sum += math.Log2((yh + gainMapOffset) / (max(ys, 0) + gainMapOffset))
+					}
+				}
+				gains[gy*gb.Dx()+gx] = sum / float64(block.Dx()*block.Dy())
+			}
+		}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, g := range gains {
+		lo, hi = min(lo, g), max(hi, g)
+	}
+	if hi-lo < 1e-3 {
+		hi = lo + 1e-3
+	}
+	gainMap := image.NewGray(gb)
+	for i, g := range gains {
+		gainMap.Pix[i] = uint8(math.Round((g - lo) / (hi - lo) * 0xff))
+	}
+
+	return &imaging.GainMapImage{
+		Image:   sdr,
+		GainMap: gainMap,
+		Metadata: imaging.GainMapMetadata{
+			GainMapMin:     lo,
+			GainMapMax:     hi,
+			Gamma:          1,
+			OffsetSDR:      gainMapOffset,
+			OffsetHDR:      gainMapOffset,
+			HDRCapacityMin: 0,
+			HDRCapacityMax: max(capacity, 1e-3),
+		},
+	}, nil
+}
@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"errors"
+	"image"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+)
+
+func TestToneMapGainMap(t *testing.T) {
+	m := createRadianceMap(37, 22)
+	p := NewHDRProcessor(WithToneMapper("drago03"), WithGainMap(true))
+	result, err := p.ToneMap(m)
+	if err != nil {
+		t.Fatalf("ToneMap failed: %v", err)
+	}
+	gm, ok := result.(*imaging.GainMapImage)
+	if !ok {
+		t.Fatalf("Expected a gain map image, got %T", result)
+	}
+
+	if want := image.Rect(0, 0, 10, 6); gm.GainMap.Bounds() != want {
+		t.Errorf("Expected a %v gain map, got %v", want, gm.GainMap.Bounds())
+	}
+	md := gm.Metadata
+	capacity := math.Log2(float64(DefaultPeakLuminance) / DefaultReferenceWhite)
+	if md.GainMapMin >= md.GainMapMax || math.Abs(md.HDRCapacityMax-capacity) > 1e-9 {
+		t.Errorf("Unexpected metadata %+v for a capacity of %.2f stops", md, capacity)
+	}
+
+	// On a display with enough headroom, the highlights exceed SDR white
+	// and the HDR rendition keeps the order of the radiance map
+	rendition := gm.HDR(colorspace.SRGB.Curve(), math.Exp2(md.HDRCapacityMax))
+	lum := func(x int) float64 {
+		r, g, b, _ := rendition.HDRAt(x, 11).HDRRGBA()
+		return luminance(r, g, b)
+	}
+	if lum(36) <= 1 {
+		t.Errorf("Expected highlights above SDR white, got %.3f", lum(36))
+	}
+	if lum(0) >= lum(18) || lum(18) >= lum(36) {
+		t.Errorf("Expected increasing luminance, got %.3f, %.3f, %.3f", lum(0), lum(18), lum(36))
+	}
+}
+
+func TestRunGainMap(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 24, 16)
+
+	output := filepath.Join(dir, "ultra.jpg")
+	p := NewHDRProcessor(WithGainMap(true), WithOutputSpace(colorspace.DisplayP3))
+	if err := p.Run(output, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	gm, err := imaging.ReadGainMap(output)
+	if err != nil {
+		t.Fatalf("ReadGainMap failed: %v", err)
+	}
+	if gm.Bounds() != image.Rect(0, 0, 24, 16) || gm.GainMap.Bounds() != image.Rect(0, 0, 6, 4) {
+		t.Errorf("Unexpected sizes: base %v, gain map %v", gm.Bounds(), gm.GainMap.Bounds())
+	}
+	if s, err := imaging.ReadColorSpace(output); err != nil || s != colorspace.DisplayP3 {
+		t.Errorf("Expected a Display P3 profile, got %v, %v", s, err)
+	}
+
+	// PNG output keeps the SDR image only
+	png := filepath.Join(dir, "sdr.png")
+	if err := p.Run(png, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	err = p.RunStreaming(filepath.Join(dir, "stream.jpg"), inputs...)
+	if err == nil || !strings.Contains(err.Error(), "gain map") {
+		t.Errorf("Expected streaming to be rejected, got %v", err)
+	}
+}
+
+func TestGainMapWithoutOption(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 8, 8)
+	output := filepath.Join(dir, "plain.jpg")
+	if err := NewHDRProcessor().Run(output, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := imaging.ReadGainMap(output); !errors.Is(err, imaging.ErrNoGainMap) {
+		t.Errorf("Expected no gain map, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/internal/synthetic"
+	"github.com/harperreed/hdarrrr/pkg/metrics"
+)
+
+// update re-blesses the golden outputs:
+//
+//	go test ./pkg/processor -run TestGolden -update
+var update = flag.Bool("update", false, "rewrite the golden outputs of TestGolden")
+
+// Golden tolerances: outputs pass when their SSIM against the golden
+// output is at least goldenSSIM and at most goldenVisible of their pixels
+// differ visibly from it
+const (
+	goldenSSIM    = 0.99
+	goldenVisible = 0.01
+)
+
+// goldenDir holds the checked-in brackets and golden outputs, and
+// goldenFailures the outputs and diff images of failed comparisons
+const (
+	goldenDir      = "testdata/golden"
+	goldenFailures = "testdata/golden/failed"
+)
+
+// goldenBrackets are the scenes and cameras of the checked-in brackets.
+// They are only rendered when missing, so changes to the synthetic package
+// do not alter the inputs of the goldens.
+var goldenBrackets = []struct {
+	name  string
+	scene synthetic.Scene
+	cam   synthetic.Camera
+}{
+	{"window", synthetic.Window(64, 48), synthetic.Camera{
+		Exposures: []float64{0.02, 0.2, 2},
+		Noise:     0.002,
+		Seed:      1,
+	}},
+	{"gradient", synthetic.Gradient(48, 32, 4), synthetic.Camera{
+		Exposures: []float64{0.01, 0.1, 1},
+		Response:  synthetic.Gamma(2.2),
+	}},
+	{"handheld", synthetic.Window(64, 48), synthetic.Camera{
+		Exposures: []float64{0.05, 0.5, 5},
+		Noise:     0.004,
+		Seed:      2,
+		Motions:   []synthetic.Motion{{Shift: [2]float64{-2, 1}}, {}, {Shift: [2]float64{1.6, -1.2}}},
+		Objects:   []synthetic.Object{{Start: [2]float64{12, 36}, Velocity: [2]float64{6, 0}, Radius: 5, Radiance: [3]float64{0.6, 0.1, 0.1}}},
+	}},
+}
+
+func TestGolden(t *testing.T) {
+	for _, b := range goldenBrackets {
+		inputs := goldenBracket(t, b.name, b.scene, b.cam)
+		for _, merge := range MergeMethods {
+			for _, op := range ToneMappers {
+				name := fmt.Sprintf("%s_%s_%s", b.name, merge, op)
+				t.Run(name, func(t *testing.T) {
+					output := filepath.Join(t.TempDir(), "output.png")
+					p := NewHDRProcessor(WithMergeMethod(merge), WithToneMapper(op))
+					if err := p.Run(output, inputs...); err != nil {
+						t.Fatalf("Run failed: %v", err)
+					}
+					checkGolden(t, name, decodePNG(t, output))
+				})
+			}
+		}
+	}
+}
+
+// goldenBracket returns the paths of a checked-in bracket, rendering it
+// first when it is missing
+func goldenBracket(t *testing.T, name string, scene synthetic.Scene, cam synthetic.Camera) []string {
+	t.Helper()
+	dir := filepath.Join(goldenDir, "brackets", name)
+	paths, err := filepath.Glob(filepath.Join(dir, "frame_*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == len(cam.Exposures) {
+		return paths
+	}
+	if !*update {
+		t.Fatalf("Bracket %s is missing; render it with -update", name)
+	}
+	b, err := cam.Shoot(scene)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if paths, err = b.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+// checkGolden compares got with the golden output of name, or rewrites the
+// golden output with -update. On failure it writes got and a map of the
+// visible differences to goldenFailures.
+func checkGolden(t *testing.T, name string, got image.Image) {
+	t.Helper()
+	path := filepath.Join(goldenDir, name+".png")
+	failed := filepath.Join(goldenFailures, name+".png")
+	diff := filepath.Join(goldenFailures, name+"_diff.png")
+	for _, stale := range []string{failed, diff} {
+		if err := os.Remove(stale); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+	}
+
+	if *update {
+		writeGoldenPNG(t, path, got)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Golden output %s is missing; bless it with -update", path)
+	}
+	want := metrics.NewFrame(decodePNG(t, path), metrics.DefaultWhite)
+	frame := metrics.NewFrame(got, metrics.DefaultWhite)
+	scores, err := metrics.Compare(want, frame, []string{"ssim", "visibility"})
+	if err != nil {
+		t.Fatalf("Comparing with %s: %v", path, err)
+	}
+	if *scores.SSIM >= goldenSSIM && scores.Visibility.Visible <= goldenVisible {
+		return
+	}
+
+	if err := os.MkdirAll(goldenFailures, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeGoldenPNG(t, failed, got)
+	writeGoldenPNG(t, diff, scores.Visibility.Image(want))
+	t.Errorf("Output differs from %s: SSIM %.4f (min %v), %.1f%% of pixels visibly different (max %.0f%%); see %s and %s",
+		path, *scores.SSIM, goldenSSIM, 100*scores.Visibility.Visible, 100*goldenVisible, failed, diff)
+}
+
+// writeGoldenPNG writes img to path as a PNG
+func writeGoldenPNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package processor implements the HDR pipeline: loading exposure brackets,
+// linearizing, aligning, merging them into a radiance map, tone mapping the
+// result and saving it.
+//
+// Each stage is exposed as a method on HDRProcessor so callers can run the
+// whole pipeline with Run or compose the stages they need.
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// ToneMappers lists the supported tone mapping operators
+var ToneMappers = []string{"reinhard05", "drago03"}
+
+// MergeMethods lists the supported ways of combining exposures
+var MergeMethods = []string{"average"}
+
+// HDRProcessor handles HDR image processing
+type HDRProcessor struct {
+	toneMapper   string
+	params       map[string]float64
+	mergeMethod  string
+	frameWeights []float64
+	encode       imaging.EncodeOptions
+	aligner      align.Aligner
+	progress     progress.Reporter
+	workers      int
+	tileSize     int
+	// memoryBudget bounds the pixel buffers of RunStreaming, in bytes
+	memoryBudget int64
+	// working is the space exposures are merged and tone mapped in, output
+	// the space of the saved images
+	working, output *colorspace.Space
+	hdr             hdrOutput
+	// gainMap attaches a gain map to tone mapped results
+	gainMap bool
+	wb      whiteBalance
+	color   colorPreservation
+	denoise denoise
+	// debugOutputs makes Run write the diagnostic images of the merge
+	debugOutputs bool
+}
+
+// NewHDRProcessor creates a new HDR processor with default settings.
+// Options are applied in order on top of the defaults.
+func NewHDRProcessor(opts ...Option) *HDRProcessor {
+	p := &HDRProcessor{
+		toneMapper: "reinhard05",
+		params: map[string]float64{
+			"gamma":      1.0,
+			"intensity":  1.0,
+			"light":      0.0,
+			"saturation": 1.0,
+			"vibrance":   0.0,
+		},
+		mergeMethod:  "average",
+		encode:       imaging.DefaultEncodeOptions(),
+		aligner:      align.NewBasicAligner(),
+		tileSize:     parallel.DefaultTileSize,
+		memoryBudget: DefaultMemoryBudget,
+		working:      colorspace.SRGB,
+		output:       colorspace.SRGB,
+		hdr: hdrOutput{
+			referenceWhite: DefaultReferenceWhite,
+			peak:           DefaultPeakLuminance,
+			bits:           DefaultBitDepth,
+		},
+		wb: whiteBalance{
+			temperature: DefaultTemperature,
+			adaptation:  "bradford",
+		},
+		color:   colorPreservation{exponent: 1},
+		denoise: denoise{strength: DefaultDenoiseStrength, shadows: DefaultDenoiseShadows},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithToneMapper sets the tone mapping operator
+func (p *HDRProcessor) WithToneMapper(mapper string) *HDRProcessor {
+	p.toneMapper = mapper
+	return p
+}
+
+// WithParams sets tone mapping parameters
+func (p *HDRProcessor) WithParams(params map[string]float64) *HDRProcessor {
+	for k, v := range params {
+		p.params[k] = v
+	}
+	return p
+}
+
+// ToneMapper returns the configured tone mapping operator
+func (p *HDRProcessor) ToneMapper() string {
+	return p.toneMapper
+}
+
+// MergeMethod returns the configured merge method
+func (p *HDRProcessor) MergeMethod() string {
+	return p.mergeMethod
+}
+
+// Param returns the value of a tone mapping parameter
+func (p *HDRProcessor) Param(name string) float64 {
+	return p.params[name]
+}
+
+// Process creates an HDR image from multiple exposure images.
+// It runs the Linearize, Merge, Denoise and ToneMap stages.
+func (p *HDRProcessor) Process(images []image.Image) (image.Image, error) {
+	return p.ProcessContext(context.Background(), images)
+}
+
+// ProcessContext is Process with cancellation and progress reporting
+func (p *HDRProcessor) ProcessContext(ctx context.Context, images []image.Image) (image.Image, error) {
+	hdrImages, err := p.LinearizeContext(ctx, images)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := p.MergeContext(ctx, hdrImages)
+	if err != nil {
+		return nil, err
+	}
+	if merged, err = p.DenoiseContext(ctx, merged); err != nil {
+		return nil, err
+	}
+
+	return p.ToneMapContext(ctx, merged)
+}
+
+// validateImageProperties checks if all images have matching properties
+func validateImageProperties(images []hdr.Image) error {
+	if len(images) < 2 {
+		return errors.New("at least two images are required")
+	}
+
+	base := images[0]
+	baseBounds := base.Bounds()
+
+	for i, img := range images[1:] {
+		if img == nil {
+			return fmt.Errorf("image %d is nil", i+2)
+		}
+
+		if img.Bounds() != baseBounds {
+			return fmt.Errorf("image %d has different dimensions than the first image", i+2)
+		}
+	}
+
+	return nil
+}
@@ -93,3 +93,34 @@ func TestHDRProcessor_Process(t *testing.T) {
 		})
 	}
 }
+
+func TestNewHDRProcessorOptions(t *testing.T) {
+	p := NewHDRProcessor(
+		WithToneMapper("drago03"),
+		WithGamma(0.8),
+		WithIntensity(2.0),
+		WithLight(0.5),
+	)
+
+	if p.ToneMapper() != "drago03" {
+		t.Errorf("Expected tone mapper drago03, got %s", p.ToneMapper())
+	}
+
+	expected := map[string]float64{"gamma": 0.8, "intensity": 2.0, "light": 0.5}
+	for name, want := range expected {
+		if got := p.Param(name); got != want {
+			t.Errorf("Expected %s %.2f, got %.2f", name, want, got)
+		}
+	}
+}
+
+func TestNewHDRProcessorDefaults(t *testing.T) {
+	p := NewHDRProcessor()
+
+	if p.ToneMapper() != "reinhard05" {
+		t.Errorf("Expected default tone mapper reinhard05, got %s", p.ToneMapper())
+	}
+	if p.Param("gamma") != 1.0 {
+		t.Errorf("Expected default gamma 1.0, got %.2f", p.Param("gamma"))
+	}
+}
@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// checkMerge validates the merge settings for n exposures
+func (p *HDRProcessor) checkMerge(n int) error {
+	if p.mergeMethod != "average" {
+		return fmt.Errorf("unsupported merge method: %s", p.mergeMethod)
+	}
+
+	if p.frameWeights == nil {
+		return nil
+	}
+	if len(p.frameWeights) != n {
+		return fmt.Errorf("got %d frame weights for %d images", len(p.frameWeights), n)
+	}
+	total := 0.0
+	for _, w := range p.frameWeights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("invalid frame weight: %v", w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.New("frame weights must not all be zero")
+	}
+	return nil
+}
+
+// frameWeight returns the weight of exposure i in the merge. The settings
+// have been validated by checkMerge.
+func (p *HDRProcessor) frameWeight(i int) float32 {
+	if p.frameWeights == nil {
+		return 1
+	}
+	return float32(p.frameWeights[i])
+}
+
+// mergeImages merges the exposures tile by tile into a new radiance map,
+// reading and writing the float32 pixel buffers directly
+func (p *HDRProcessor) mergeImages(ctx context.Context, images []*hdr.RGB) (*hdr.RGB, error) {
+	merged := hdr.NewRGB(images[0].Bounds())
+	if err := p.mergeInto(ctx, merged, images); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeInto writes the merge of images into dst, overwriting its previous
+// contents. All buffers cover the same bounds and the settings have been
+// validated by checkMerge.
+func (p *HDRProcessor) mergeInto(ctx context.Context, dst *hdr.RGB, images []*hdr.RGB) error {
+	if p.frameWeights == nil {
+		return p.mergeMean(ctx, dst, images)
+	}
+
+	var total float32
+	for i := range images {
+		total += p.frameWeight(i)
+	}
+	return p.forEachTile(ctx, p.tiles(dst.Bounds()), progress.StageMerge, 0, 1, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := rowOf(dst, r, y)
+			clear(row)
+			for i, img := range images {
+				w := p.frameWeight(i) / total
+				for j, v := range rowOf(img, r, y) {
+					row[j] += w * v
+				}
+			}
+		}
+	})
+}
+
+// mergeMean writes the plain average of images into dst
+func (p *HDRProcessor) mergeMean(ctx context.Context, dst *hdr.RGB, images []*hdr.RGB) error {
+	n := float32(len(images))
+
+	return p.forEachTile(ctx, p.tiles(dst.Bounds()), progress.StageMerge, 0, 1, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := rowOf(dst, r, y)
+			copy(row, rowOf(images[0], r, y))
+			for _, img := range images[1:] {
+				for i, v := range rowOf(img, r, y) {
+					row[i] += v
+				}
+			}
+			for i := range row {
+				row[i] /= n
+			}
+		}
+	})
+}
+
+// rowOf returns the pixel values of row y of img within r
+func rowOf(img *hdr.RGB, r image.Rectangle, y int) []float32 {
+	start := img.PixOffset(r.Min.X, y)
+	return img.Pix[start : start+3*r.Dx()]
+}
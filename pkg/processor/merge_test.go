@@ -0,0 +1,257 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/internal/synthetic"
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// referenceConvert is the original per-pixel conversion, kept as a baseline
+func referenceConvert(img image.Image) hdr.Image {
+	bounds := img.Bounds()
+	hdrImg := hdr.NewRGB(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			hdrImg.Set(x, y, hdrcolor.RGB{
+				R: float64(r) / 0xffff,
+				G: float64(g) / 0xffff,
+				B: float64(b) / 0xffff,
+			})
+		}
+	}
+
+	return hdrImg
+}
+
+// referenceMerge is the original per-pixel average, kept as a baseline
+func referenceMerge(images []hdr.Image) hdr.Image {
+	bounds := images[0].Bounds()
+	merged := hdr.NewRGB(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var sumR, sumG, sumB float64
+			for _, img := range images {
+				r, g, b, _ := img.HDRAt(x, y).HDRRGBA()
+				sumR += r
+				sumG += g
+				sumB += b
+			}
+			n := float64(len(images))
+			merged.Set(x, y, hdrcolor.RGB{
+				R: sumR / n,
+				G: sumG / n,
+				B: sumB / n,
+			})
+		}
+	}
+
+	return merged
+}
+
+// createGradientImage creates an LDR image with a gradient scaled by exposure
+func createGradientImage(width, height int, exposure float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := math.Min(255, exposure*float64(x+y)/float64(width+height)*255)
+			img.SetRGBA(x, y, color.RGBA{R: uint8(v), G: uint8(v * 0.9), B: uint8(v * 0.7), A: 255})
+		}
+	}
+	return img
+}
+
+func TestMergeMatchesReference(t *testing.T) {
+	images := make([]hdr.Image, 3)
+	for i, exposure := range []float64{0.5, 1, 2} {
+		images[i] = referenceConvert(createGradientImage(67, 45, exposure))
+	}
+
+	got, err := NewHDRProcessor(WithTileSize(16), WithWorkers(4)).Merge(images)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := referenceMerge(images)
+
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 67; x++ {
+			gr, gg, gb, _ := got.HDRAt(x, y).HDRRGBA()
+			wr, wg, wb, _ := want.HDRAt(x, y).HDRRGBA()
+			if math.Abs(gr-wr) > 1e-6 || math.Abs(gg-wg) > 1e-6 || math.Abs(gb-wb) > 1e-6 {
+				t.Fatalf("Pixel (%d, %d): expected (%f, %f, %f), got (%f, %f, %f)", x, y, wr, wg, wb, gr, gg, gb)
+			}
+		}
+	}
+}
+
+// uniformHDR creates an HDR image with every channel set to v
+func uniformHDR(width, height int, v float64) *hdr.RGB {
+	img := hdr.NewRGB(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGB(x, y, hdrcolor.RGB{R: v, G: v, B: v})
+		}
+	}
+	return img
+}
+
+func TestMergeMethods(t *testing.T) {
+	images := []hdr.Image{uniformHDR(5, 3, 0), uniformHDR(5, 3, 0.5), uniformHDR(5, 3, 1)}
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		want        float64
+		expectError bool
+	}{
+		{"average", nil, 0.5, false},
+		{"frame weights", []Option{WithFrameWeights([]float64{0, 1, 3})}, 0.875, false},
+		{"unknown method", []Option{WithMergeMethod("median")}, 0, true},
+		{"weighted", []Option{WithMergeMethod("weighted")}, 0, true},
+		{"frame weight count", []Option{WithFrameWeights([]float64{1, 1})}, 0, true},
+		{"negative frame weight", []Option{WithFrameWeights([]float64{1, -1, 1})}, 0, true},
+		{"zero frame weights", []Option{WithFrameWeights([]float64{0, 0, 0})}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewHDRProcessor(tt.opts...).Merge(images)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			r, _, _, _ := got.HDRAt(2, 1).HDRRGBA()
+			if math.Abs(r-tt.want) > 1e-3 {
+				t.Errorf("Expected %f, got %f", tt.want, r)
+			}
+		})
+	}
+}
+
+// TestMergeSyntheticBracket is a sanity check of the merge on a realistic
+// bracket, not a check of its radiance: the frames are averaged without
+// dividing by their exposure time, so the merged map only keeps the rough
+// brightness order of the scene.
+func TestMergeSyntheticBracket(t *testing.T) {
+	cam := synthetic.Camera{Exposures: []float64{0.02, 0.2, 2}, Noise: 0.002, Seed: 1}
+	b, err := cam.Shoot(synthetic.Window(96, 64))
+	if err != nil {
+		t.Fatalf("Shoot failed: %v", err)
+	}
+
+	p := NewHDRProcessor()
+	frames, err := p.Linearize(b.Frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := p.Merge(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lum := func(m hdr.Image, x, y int) float64 {
+		r, g, b, _ := m.HDRAt(x, y).HDRRGBA()
+		return luminance(r, g, b)
+	}
+	// Most pairs of pixels are ordered by brightness like in the scene, from
+	// the dim walls to the clouds. Some are reordered where one exposure
+	// clips and the others take over.
+	agree, pairs := 0, 0
+	for y := 0; y < 64; y += 5 {
+		for x := 0; x < 96; x += 3 {
+			for _, q := range []image.Point{{x + 17, y}, {x, y + 11}, {x + 29, y + 23}} {
+				if !q.In(merged.Bounds()) {
+					continue
+				}
+				want := lum(b.Truth, x, y) - lum(b.Truth, q.X, q.Y)
+				got := lum(merged, x, y) - lum(merged, q.X, q.Y)
+				if math.Abs(want) < 0.01 {
+					continue
+				}
+				pairs++
+				if (want > 0) == (got > 0) {
+					agree++
+				}
+			}
+		}
+	}
+	if ratio := float64(agree) / float64(pairs); ratio < 0.9 {
+		t.Errorf("Expected the merged map to order %d pairs like the scene, %.1f%% agree", pairs, 100*ratio)
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	images := make([]hdr.Image, 3)
+	for i, exposure := range []float64{0.5, 1, 2} {
+		images[i] = referenceConvert(createGradientImage(2048, 1365, exposure))
+	}
+
+	b.Run("reference", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			referenceMerge(images)
+		}
+	})
+	b.Run("tiled", func(b *testing.B) {
+		p := NewHDRProcessor()
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Merge(images); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkLinearize(b *testing.B) {
+	img := createGradientImage(2048, 1365, 1)
+
+	images := []image.Image{img, img}
+
+	b.Run("reference", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, img := range images {
+				referenceConvert(img)
+			}
+		}
+	})
+	b.Run("tiled", func(b *testing.B) {
+		p := NewHDRProcessor()
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Linearize(images); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkProcessSyntheticBracket(b *testing.B) {
+	cam := synthetic.Camera{
+		Exposures: []float64{0.02, 0.2, 2},
+		Noise:     0.002,
+		Motions:   []synthetic.Motion{{Shift: [2]float64{-3.5, 1.2}}, {}, {Shift: [2]float64{2.3, -4}}},
+	}
+	bracket, err := cam.Shoot(synthetic.Window(1024, 683))
+	if err != nil {
+		b.Fatal(err)
+	}
+	p := NewHDRProcessor(WithAligner(align.NewMTBAligner()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Process(bracket.Frames); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package processor
+
+import (
+	"image"
+
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+)
+
+// Option configures an HDRProcessor
+type Option func(*HDRProcessor)
+
+// WithToneMapper sets the tone mapping operator (see ToneMappers)
+func WithToneMapper(mapper string) Option {
+	return func(p *HDRProcessor) {
+		p.toneMapper = mapper
+	}
+}
+
+// WithParams sets tone mapping parameters by name
+func WithParams(params map[string]float64) Option {
+	return func(p *HDRProcessor) {
+		p.WithParams(params)
+	}
+}
+
+// WithGamma sets the gamma correction value
+func WithGamma(gamma float64) Option {
+	return WithParams(map[string]float64{"gamma": gamma})
+}
+
+// WithIntensity sets the intensity adjustment
+func WithIntensity(intensity float64) Option {
+	return WithParams(map[string]float64{"intensity": intensity})
+}
+
+// WithLight sets the light adaptation (Reinhard05 only)
+func WithLight(light float64) Option {
+	return WithParams(map[string]float64{"light": light})
+}
+
+// WithSaturation scales the saturation of tone mapped colors: 0 removes it
+// and 1 keeps it
+func WithSaturation(saturation float64) Option {
+	return WithParams(map[string]float64{"saturation": saturation})
+}
+
+// WithVibrance raises the saturation of muted tone mapped colors more than
+// that of saturated ones, from -1 to 1. 0 keeps them.
+func WithVibrance(vibrance float64) Option {
+	return WithParams(map[string]float64{"vibrance": vibrance})
+}
+
+// WithColorPreservation makes tone mapping map luminance alone and keep the
+// colors of the radiance map with method (see ColorPreservations). The
+// exponent scales the saturation of the ratio method; 1 keeps it. The empty
+// string lets the operator map each channel.
+func WithColorPreservation(method string, exponent float64) Option {
+	return func(p *HDRProcessor) {
+		p.color = colorPreservation{method: method, exponent: exponent}
+	}
+}
+
+// WithMergeMethod sets how the exposures are combined (see MergeMethods)
+func WithMergeMethod(method string) Option {
+	return func(p *HDRProcessor) {
+		p.mergeMethod = method
+	}
+}
+
+// WithFrameWeights scales the contribution of each exposure, in the order
+// the exposures are passed to Merge. Nil weighs all exposures equally.
+func WithFrameWeights(weights []float64) Option {
+	return func(p *HDRProcessor) {
+		p.frameWeights = append([]float64(nil), weights...)
+	}
+}
+
+// WithDenoise reduces the noise of merged radiance maps with method (see
+// Denoisers) before they are tone mapped. Strength scales the noise removed
+// at and above the log-average luminance, shadows the noise removed 4 stops
+// below it and darker; 1 removes the noise estimated in the map. The empty
+// string leaves radiance maps as merged.
+func WithDenoise(method string, strength, shadows float64) Option {
+	return func(p *HDRProcessor) {
+		p.denoise = denoise{method: method, strength: strength, shadows: shadows}
+	}
+}
+
+// WithDebugOutputs makes Run write the diagnostic images of the merge next
+// to the output (see Diagnose and Diagnostics.Save). RunStreaming ignores
+// it.
+func WithDebugOutputs(enabled bool) Option {
+	return func(p *HDRProcessor) {
+		p.debugOutputs = enabled
+	}
+}
+
+// WithEncodeOptions sets the encoder settings used by the Save stage
+func WithEncodeOptions(opts imaging.EncodeOptions) Option {
+	return func(p *HDRProcessor) {
+		p.encode = opts
+	}
+}
+
+// WithAligner sets the aligner used by the Align stage
+func WithAligner(aligner align.Aligner) Option {
+	return func(p *HDRProcessor) {
+		p.aligner = aligner
+	}
+}
+
+// WithProgress sets the reporter notified as the stages advance. A reporter
+// carried by the context passed to the Context methods takes precedence.
+func WithProgress(r progress.Reporter) Option {
+	return func(p *HDRProcessor) {
+		p.progress = r
+	}
+}
+
+// WithWorkers sets the number of goroutines used by the merge and tone
+// mapping stages. Zero or a negative value uses all available CPUs.
+func WithWorkers(n int) Option {
+	return func(p *HDRProcessor) {
+		p.workers = n
+	}
+}
+
+// WithTileSize sets the edge length, in pixels, of the tiles distributed to
+// the workers
+func WithTileSize(size int) Option {
+	return func(p *HDRProcessor) {
+		if size > 0 {
+			p.tileSize = size
+		}
+	}
+}
+
+// WithMemoryBudget sets the memory, in bytes, RunStreaming may use for pixel
+// buffers. It is ignored if bytes is not positive.
+func WithMemoryBudget(bytes int64) Option {
+	return func(p *HDRProcessor) {
+		if bytes > 0 {
+			p.memoryBudget = bytes
+		}
+	}
+}
+
+// WithWorkingSpace sets the color space the exposures are merged and tone
+// mapped in. Exposures tagged with another space are converted when loaded;
+// radiance maps are saved in the working space. Nil keeps sRGB.
+func WithWorkingSpace(s *colorspace.Space) Option {
+	return func(p *HDRProcessor) {
+		if s != nil {
+			p.working = s
+		}
+	}
+}
+
+// WithOutputSpace sets the color space of the saved images, whose profile
+// is embedded in them. Nil keeps sRGB.
+func WithOutputSpace(s *colorspace.Space) Option {
+	return func(p *HDRProcessor) {
+		if s != nil {
+			p.output = s
+		}
+	}
+}
+
+// WithHDROutput encodes the result for HDR displays with transfer (see
+// HDRTransfers) instead of tone mapping it to SDR. The empty string tone
+// maps as usual.
+func WithHDROutput(transfer string) Option {
+	return func(p *HDRProcessor) {
+		p.hdr.transfer = transfer
+	}
+}
+
+// WithReferenceWhite sets the luminance of diffuse white of HDR output, in
+// cd/m²
+func WithReferenceWhite(nits float64) Option {
+	return func(p *HDRProcessor) {
+		p.hdr.referenceWhite = nits
+	}
+}
+
+// WithPeakLuminance sets the luminance highlights of HDR output roll off
+// to, in cd/m²
+func WithPeakLuminance(nits float64) Option {
+	return func(p *HDRProcessor) {
+		p.hdr.peak = nits
+	}
+}
+
+// WithBitDepth sets the bits per sample, 10 or 16, of HDR output saved as
+// TIFF. PNG output is always 16-bit.
+func WithBitDepth(bits int) Option {
+	return func(p *HDRProcessor) {
+		p.hdr.bits = bits
+	}
+}
+
+// WithGainMap attaches a gain map to the tone mapped result, so JPEG output
+// is saved as an Ultra HDR file that HDR displays show with the highlights
+// of the radiance map. The reference white and peak luminance set the HDR
+// rendition as for HDR output; HDR output takes precedence.
+func WithGainMap(enabled bool) Option {
+	return func(p *HDRProcessor) {
+		p.gainMap = enabled
+	}
+}
+
+// WithWhiteBalance white balances the radiance map before tone mapping with
+// mode (see WhiteBalances). The empty string leaves colors unchanged.
+func WithWhiteBalance(mode string) Option {
+	return func(p *HDRProcessor) {
+		p.wb.mode = mode
+	}
+}
+
+// WithTemperature white balances the radiance map for a light of color
+// temperature kelvin and tint (see colorspace.Planckian)
+func WithTemperature(kelvin, tint float64) Option {
+	return func(p *HDRProcessor) {
+		p.wb.mode = "temperature"
+		p.wb.temperature = kelvin
+		p.wb.tint = tint
+	}
+}
+
+// WithGrayPoint white balances the radiance map so that the pixels around
+// (x, y) are neutral
+func WithGrayPoint(x, y int) Option {
+	return func(p *HDRProcessor) {
+		p.wb.mode = "gray-point"
+		p.wb.point = image.Pt(x, y)
+	}
+}
+
+// WithAdaptation sets the chromatic adaptation transform of the white
+// balance (see colorspace.Adaptations)
+func WithAdaptation(method string) Option {
+	return func(p *HDRProcessor) {
+		p.wb.adaptation = method
+	}
+}
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// StageError reports the pipeline stage RunPipeline failed in. Its message
+// names the step, e.g. "loading images: ...", followed by that of the error
+// it wraps.
+type StageError struct {
+	// Stage is the progress stage that failed, e.g. progress.StageLoad
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	switch e.Stage {
+	case progress.StageLoad:
+		return "loading images: " + e.Err.Error()
+	case progress.StageAlign:
+		return "aligning images: " + e.Err.Error()
+	default:
+		return "processing HDR: " + e.Err.Error()
+	}
+}
+
+func (e *StageError) Unwrap() error { return e.Err }
+
+// PipelineOptions controls RunPipeline
+type PipelineOptions struct {
+	// StrictAlignment fails the pipeline when alignment fails instead of
+	// merging the unaligned exposures
+	StrictAlignment bool
+	// SkipToneMap stops the pipeline after denoising, for callers that only
+	// need the radiance map
+	SkipToneMap bool
+}
+
+// StageTiming is the time taken by a stage of RunPipeline
+type StageTiming struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// PipelineResult holds the output of every stage of RunPipeline
+type PipelineResult struct {
+	// Images are the exposures as loaded
+	Images []image.Image
+	// Aligned are the registered exposures, or Images when alignment failed
+	Aligned []image.Image
+	// Offsets are those found by the aligner, nil when it does not report
+	// them or failed
+	Offsets []image.Point
+	// AlignErr is the alignment failure the exposures were merged despite
+	AlignErr error
+	Linear   []hdr.Image
+	Merged   hdr.Image
+	// Denoised is Merged after noise reduction, or Merged itself without a
+	// denoiser
+	Denoised hdr.Image
+	// Result is the tone mapped image, nil with SkipToneMap
+	Result image.Image
+	// Timings lists the stages that ran, in order. Denoising is only listed
+	// with a denoiser.
+	Timings []StageTiming
+}
+
+// RunPipeline loads the exposures at inputs and aligns, linearizes, merges,
+// denoises and tone maps them, returning the result of every stage. Each
+// stage reports its progress. On failure, the result holds the stages
+// completed so far and the error is a *StageError.
+func (p *HDRProcessor) RunPipeline(ctx context.Context, opts PipelineOptions, inputs ...string) (*PipelineResult, error) {
+	res := &PipelineResult{}
+	start := time.Now()
+	// timed records the time since start for stage and fails with err
+	timed := func(stage string, err error) error {
+		res.Timings = append(res.Timings, StageTiming{stage, time.Since(start)})
+		start = time.Now()
+		if err != nil {
+			return &StageError{stage, err}
+		}
+		return nil
+	}
+
+	var err error
+	res.Images, err = p.LoadContext(ctx, inputs...)
+	if err := timed(progress.StageLoad, err); err != nil {
+		return res, err
+	}
+
+	res.Aligned, res.Offsets, err = p.AlignOffsets(ctx, res.Images)
+	if err != nil && !opts.StrictAlignment && ctx.Err() == nil {
+		res.Aligned, res.Offsets, res.AlignErr = res.Images, nil, err
+		err = nil
+	}
+	if err := timed(progress.StageAlign, err); err != nil {
+		return res, err
+	}
+
+	res.Linear, err = p.LinearizeContext(ctx, res.Aligned)
+	if err := timed(progress.StageLinearize, err); err != nil {
+		return res, err
+	}
+	res.Merged, err = p.MergeContext(ctx, res.Linear)
+	if err := timed(progress.StageMerge, err); err != nil {
+		return res, err
+	}
+
+	res.Denoised = res.Merged
+	if p.Denoiser() != "" {
+		res.Denoised, err = p.DenoiseContext(ctx, res.Merged)
+		if err := timed(progress.StageDenoise, err); err != nil {
+			return res, err
+		}
+	}
+	if opts.SkipToneMap {
+		return res, nil
+	}
+
+	res.Result, err = p.ToneMapContext(ctx, res.Denoised)
+	if err := timed(progress.StageToneMap, err); err != nil {
+		return res, err
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+)
+
+// failingAligner is an Aligner that always fails
+type failingAligner struct{}
+
+func (failingAligner) Align([]image.Image) ([]image.Image, error) {
+	return nil, errors.New("no features")
+}
+
+// stageNames returns the stages listed in timings
+func stageNames(timings []StageTiming) []string {
+	names := make([]string, len(timings))
+	for i, t := range timings {
+		names[i] = t.Stage
+	}
+	return names
+}
+
+func TestRunPipeline(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []string{writeTestImage(t, dir, "low.png", 50), writeTestImage(t, dir, "high.png", 200)}
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		pipeline   PipelineOptions
+		wantStages []string
+	}{
+		{"default", nil, PipelineOptions{}, []string{"load", "align", "linearize", "merge", "tonemap"}},
+		{"denoised", []Option{WithDenoise("bilateral", 1, 1)}, PipelineOptions{}, []string{"load", "align", "linearize", "merge", "denoise", "tonemap"}},
+		{"radiance only", nil, PipelineOptions{SkipToneMap: true}, []string{"load", "align", "linearize", "merge"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewHDRProcessor(tt.opts...).RunPipeline(context.Background(), tt.pipeline, inputs...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := stageNames(res.Timings); !slices.Equal(got, tt.wantStages) {
+				t.Errorf("Expected stages %v, got %v", tt.wantStages, got)
+			}
+			if len(res.Images) != 2 || len(res.Aligned) != 2 || len(res.Linear) != 2 || res.Merged == nil || res.Denoised == nil {
+				t.Errorf("Expected every intermediate result, got %+v", res)
+			}
+			if (res.Result == nil) != tt.pipeline.SkipToneMap {
+				t.Errorf("Expected a tone mapped result only without SkipToneMap, got %v", res.Result)
+			}
+		})
+	}
+}
+
+func TestRunPipelineAlignmentFailure(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []string{writeTestImage(t, dir, "low.png", 50), writeTestImage(t, dir, "high.png", 200)}
+	p := NewHDRProcessor(WithAligner(failingAligner{}))
+
+	// The unaligned exposures are merged and the failure is kept
+	res, err := p.RunPipeline(context.Background(), PipelineOptions{}, inputs...)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.AlignErr == nil || res.Result == nil || res.Aligned[0] != res.Images[0] {
+		t.Errorf("Expected a result from the unaligned exposures, got %+v", res)
+	}
+
+	res, err = p.RunPipeline(context.Background(), PipelineOptions{StrictAlignment: true}, inputs...)
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != progress.StageAlign {
+		t.Fatalf("Expected an alignment StageError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "aligning images: ") {
+		t.Errorf("Unexpected message %q", err)
+	}
+	if got := stageNames(res.Timings); !slices.Equal(got, []string{"load", "align"}) {
+		t.Errorf("Expected the stages up to the failure, got %v", got)
+	}
+}
+
+func TestRunPipelineLoadFailure(t *testing.T) {
+	dir := t.TempDir()
+	low := writeTestImage(t, dir, "low.png", 50)
+
+	_, err := NewHDRProcessor().RunPipeline(context.Background(), PipelineOptions{}, low, filepath.Join(dir, "missing.png"))
+	var stageErr *StageError
+	var inputErr *InputError
+	if !errors.As(err, &stageErr) || stageErr.Stage != progress.StageLoad || !errors.As(err, &inputErr) {
+		t.Fatalf("Expected a load StageError wrapping an InputError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "loading images: ") {
+		t.Errorf("Unexpected message %q", err)
+	}
+}
@@ -0,0 +1,275 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// InputError reports input images that cannot be read or do not form a
+// bracket. Its message is that of the error it wraps.
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string { return e.Err.Error() }
+
+func (e *InputError) Unwrap() error { return e.Err }
+
+// EncodeError reports that the output image could not be written. Its
+// message is that of the error it wraps.
+type EncodeError struct {
+	Err error
+}
+
+func (e *EncodeError) Error() string { return e.Err.Error() }
+
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// Load reads the exposure images from disk
+func (p *HDRProcessor) Load(paths ...string) ([]image.Image, error) {
+	return p.LoadContext(context.Background(), paths...)
+}
+
+// LoadContext is Load with cancellation and progress reporting. Exposures
+// tagged with a color space other than the working space are converted to
+// it; untagged ones are assumed to be sRGB.
+func (p *HDRProcessor) LoadContext(ctx context.Context, paths ...string) ([]image.Image, error) {
+	if len(paths) < 2 {
+		return nil, &InputError{errors.New("at least two images are required")}
+	}
+	images, err := imaging.LoadImagesContext(p.withProgress(ctx), paths...)
+	if err != nil {
+		if ctx.Err() == nil {
+			return nil, &InputError{err}
+		}
+		return nil, err
+	}
+	for i, img := range images {
+		if images[i], err = p.toWorking(ctx, img, paths[i]); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// Linearize converts the exposures to HDR images in linear light, decoding
+// the transfer curve of the working space. Exposures are expected to be
+// encoded in the working space, as Load returns them, including those that
+// already are HDR images.
+func (p *HDRProcessor) Linearize(images []image.Image) ([]hdr.Image, error) {
+	return p.LinearizeContext(context.Background(), images)
+}
+
+// LinearizeContext is Linearize with cancellation and progress reporting
+func (p *HDRProcessor) LinearizeContext(ctx context.Context, images []image.Image) ([]hdr.Image, error) {
+	ctx = p.withProgress(ctx)
+	if len(images) < 2 {
+		return nil, errors.New("at least two images are required")
+	}
+
+	hdrImages := make([]hdr.Image, len(images))
+	for i, img := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if img == nil {
+			return nil, fmt.Errorf("image %d is nil", i+1)
+		}
+
+		hdrImg, err := imaging.LinearizeContext(ctx, img, p.working.Curve(), p.workers)
+		if err != nil {
+			return nil, err
+		}
+		hdrImages[i] = hdrImg
+		progress.Step(ctx, progress.StageLinearize, i+1, len(images))
+	}
+
+	return hdrImages, nil
+}
+
+// Align registers the exposures against each other with the configured aligner
+func (p *HDRProcessor) Align(images []image.Image) ([]image.Image, error) {
+	return p.AlignContext(context.Background(), images)
+}
+
+// AlignContext is Align with cancellation and progress reporting
+func (p *HDRProcessor) AlignContext(ctx context.Context, images []image.Image) ([]image.Image, error) {
+	return align.AlignContext(p.withProgress(ctx), p.aligner, images)
+}
+
+// AlignOffsets is AlignContext that also returns the offset found for every
+// exposure. The offsets are nil when the aligner does not report them.
+func (p *HDRProcessor) AlignOffsets(ctx context.Context, images []image.Image) ([]image.Image, []image.Point, error) {
+	return align.AlignOffsets(p.withProgress(ctx), p.aligner, images)
+}
+
+// Merge combines the linearized exposures into a single radiance map
+func (p *HDRProcessor) Merge(images []hdr.Image) (hdr.Image, error) {
+	return p.MergeContext(context.Background(), images)
+}
+
+// MergeContext is Merge with cancellation and progress reporting.
+// Cancellation is checked between tiles.
+func (p *HDRProcessor) MergeContext(ctx context.Context, images []hdr.Image) (hdr.Image, error) {
+	ctx = p.withProgress(ctx)
+	if err := validateImageProperties(images); err != nil {
+		return nil, err
+	}
+	if err := p.checkMerge(len(images)); err != nil {
+		return nil, err
+	}
+
+	buffers := make([]*hdr.RGB, len(images))
+	for i, img := range images {
+		buf, err := p.asRGB(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		buffers[i] = buf
+	}
+
+	merged, err := p.mergeImages(ctx, buffers)
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// ToneMap maps a radiance map to a displayable image with the configured
+// tone mapping operator
+func (p *HDRProcessor) ToneMap(merged hdr.Image) (image.Image, error) {
+	return p.ToneMapContext(context.Background(), merged)
+}
+
+// ToneMapContext is ToneMap with cancellation and progress reporting.
+// Cancellation is checked between tiles. The radiance map is white balanced
+// first when set with WithWhiteBalance.
+//
+// With HDR output (see WithHDROutput) the radiance map is encoded for HDR
+// displays instead: the result holds PQ or HLG signal values in BT.2020
+// primaries, to be written by Save. With WithGainMap, the result is an
+// *imaging.GainMapImage that restores the radiance map on HDR displays.
+func (p *HDRProcessor) ToneMapContext(ctx context.Context, merged hdr.Image) (image.Image, error) {
+	ctx = p.withProgress(ctx)
+	if merged == nil {
+		return nil, errors.New("radiance map is nil")
+	}
+
+	m, err := p.asRGB(ctx, merged)
+	if err != nil {
+		return nil, err
+	}
+	if m, err = p.balance(ctx, m); err != nil {
+		return nil, err
+	}
+
+	op, err := p.newToneOperator(m.Bounds())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.toneMapRGB(ctx, op, m)
+	if err != nil {
+		return nil, err
+	}
+	if p.gainMap && p.hdr.transfer == "" {
+		return p.withGainMap(ctx, m, result)
+	}
+
+	return result, nil
+}
+
+// Save writes the image to outputPath, creating the parent directory if
+// needed. Radiance maps may be saved to .hdr and .pfm files. With HDR
+// output, images are saved as 16-bit PNG or TIFF.
+func (p *HDRProcessor) Save(img image.Image, outputPath string) error {
+	return p.SaveContext(context.Background(), img, outputPath)
+}
+
+// SaveContext is Save with cancellation and progress reporting
+func (p *HDRProcessor) SaveContext(ctx context.Context, img image.Image, outputPath string) error {
+	ctx = p.withProgress(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := p.save(ctx, img, outputPath); err != nil {
+		return &EncodeError{err}
+	}
+
+	progress.FromContext(ctx).Report(progress.StageSave, 1)
+	return nil
+}
+
+// save writes img to outputPath in the format given by its extension,
+// converting images other than radiance maps to the output space
+func (p *HDRProcessor) save(ctx context.Context, img image.Image, outputPath string) error {
+	if dir := filepath.Dir(outputPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+	}
+	if imaging.IsRadiance(outputPath) {
+		m, ok := img.(hdr.Image)
+		if !ok {
+			return fmt.Errorf("%s: only radiance maps can be saved as %s", outputPath, filepath.Ext(outputPath))
+		}
+		return imaging.SaveRadiance(m, outputPath)
+	}
+	if p.hdr.transfer != "" {
+		return p.saveHDR(ctx, img, outputPath)
+	}
+	img, err := p.toOutput(ctx, img)
+	if err != nil {
+		return err
+	}
+	return imaging.SaveImageOptions(img, outputPath, p.encodeOptions())
+}
+
+// Run executes the full pipeline: it loads the exposures at inputs, aligns,
+// merges, denoises and tone maps them, and saves the result to output. With
+// WithDebugOutputs, the diagnostic images of the merge are saved next to it.
+func (p *HDRProcessor) Run(output string, inputs ...string) error {
+	return p.RunContext(context.Background(), output, inputs...)
+}
+
+// RunContext is Run with cancellation and progress reporting
+func (p *HDRProcessor) RunContext(ctx context.Context, output string, inputs ...string) error {
+	res, err := p.RunPipeline(ctx, PipelineOptions{StrictAlignment: true}, inputs...)
+	if err != nil {
+		return err
+	}
+
+	if err := p.SaveContext(ctx, res.Result, output); err != nil {
+		return fmt.Errorf("saving output image: %w", err)
+	}
+	if !p.debugOutputs {
+		return nil
+	}
+	d, err := p.DiagnoseContext(ctx, res.Linear, res.Merged)
+	if err != nil {
+		return fmt.Errorf("diagnosing merge: %w", err)
+	}
+	if _, err := d.Save(output); err != nil {
+		return fmt.Errorf("saving debug outputs: %w", &EncodeError{err})
+	}
+	return nil
+}
+
+// withProgress attaches the processor's reporter to ctx unless ctx already
+// carries one
+func (p *HDRProcessor) withProgress(ctx context.Context) context.Context {
+	if p.progress == nil || progress.HasReporter(ctx) {
+		return ctx
+	}
+	return progress.NewContext(ctx, p.progress)
+}
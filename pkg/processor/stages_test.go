@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/align"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// writeTestImage writes a test PNG to dir and returns its path
+func writeTestImage(t *testing.T, dir, name string, value uint8) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal("Failed to create test image:", err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, createTestImage(4, 4, value)); err != nil {
+		t.Fatal("Failed to encode test image:", err)
+	}
+	return path
+}
+
+func TestHDRProcessor_Merge(t *testing.T) {
+	p := NewHDRProcessor()
+
+	hdrImages, err := p.Linearize([]image.Image{
+		createTestImage(2, 2, 0),
+		createTestImage(2, 2, 255),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	merged, err := p.Merge(hdrImages)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r, g, b, _ := merged.HDRAt(1, 1).HDRRGBA()
+	for _, c := range []float64{r, g, b} {
+		if c < 0.49 || c > 0.51 {
+			t.Errorf("Expected averaged channel 0.5, got %f", c)
+		}
+	}
+}
+
+func TestHDRProcessor_Linearize(t *testing.T) {
+	loaded := imaging.ConvertToHDR(createTestImage(2, 2, 128))
+
+	tests := []struct {
+		name    string
+		working *colorspace.Space
+		want    float64
+	}{
+		// Exposures are decoded with the curve of the working space
+		{"srgb", colorspace.SRGB, 0.2158605},
+		{"acescg", colorspace.ACEScg, 128.0 / 255},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewHDRProcessor(WithWorkingSpace(tt.working))
+			linear, err := p.Linearize([]image.Image{createTestImage(2, 2, 128), loaded})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for i, m := range linear {
+				if r, _, _, _ := m.HDRAt(1, 1).HDRRGBA(); math.Abs(r-tt.want) > 1e-5 {
+					t.Errorf("Exposure %d: expected %f, got %f", i+1, tt.want, r)
+				}
+			}
+		})
+	}
+}
+
+func TestHDRProcessor_ToneMap(t *testing.T) {
+	merged := hdr.NewRGB(image.Rect(0, 0, 2, 2))
+
+	tests := []struct {
+		name        string
+		toneMapper  string
+		expectError bool
+	}{
+		{name: "Reinhard05", toneMapper: "reinhard05"},
+		{name: "Drago03", toneMapper: "drago03"},
+		{name: "Unknown operator", toneMapper: "unknown", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewHDRProcessor(WithToneMapper(tt.toneMapper)).ToneMap(merged)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Bounds() != merged.Bounds() {
+				t.Errorf("Expected dimensions %v, got %v", merged.Bounds(), result.Bounds())
+			}
+		})
+	}
+}
+
+func TestHDRProcessor_Run(t *testing.T) {
+	dir := t.TempDir()
+	low := writeTestImage(t, dir, "low.png", 50)
+	mid := writeTestImage(t, dir, "mid.png", 128)
+	high := writeTestImage(t, dir, "high.png", 200)
+	output := filepath.Join(dir, "out", "result.png")
+
+	if err := NewHDRProcessor(WithToneMapper("drago03")).Run(output, low, mid, high); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("Failed to open output: %v", err)
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 4 {
+		t.Errorf("Expected 4x4 output, got %v", img.Bounds())
+	}
+}
+
+func TestHDRProcessor_SaveRadiance(t *testing.T) {
+	dir := t.TempDir()
+	p := NewHDRProcessor()
+	merged := hdr.NewRGB(image.Rect(0, 0, 3, 2))
+	merged.Set(1, 1, hdrcolor.RGB{R: 4, G: 2, B: 0.5})
+
+	output := filepath.Join(dir, "out", "merged.hdr")
+	if err := p.Save(merged, output); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded, err := imaging.LoadRadiance(output)
+	if err != nil {
+		t.Fatalf("Failed to load radiance map: %v", err)
+	}
+	if r, _, _, _ := loaded.HDRAt(1, 1).HDRRGBA(); r != 4 {
+		t.Errorf("Expected red 4, got %f", r)
+	}
+
+	if err := p.Save(createTestImage(3, 2, 0), filepath.Join(dir, "ldr.pfm")); err == nil {
+		t.Error("Expected error saving an LDR image as .pfm, got nil")
+	}
+}
+
+func TestHDRProcessor_AlignOffsets(t *testing.T) {
+	images := []image.Image{createTestImage(32, 32, 50), createTestImage(32, 32, 200)}
+
+	tests := []struct {
+		name        string
+		aligner     align.Aligner
+		wantOffsets int
+	}{
+		{"basic", align.NewBasicAligner(), 0},
+		{"mtb", align.NewMTBAligner(), 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewHDRProcessor(WithAligner(tt.aligner))
+			aligned, offsets, err := p.AlignOffsets(context.Background(), images)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(aligned) != len(images) || len(offsets) != tt.wantOffsets {
+				t.Errorf("Expected %d images and %d offsets, got %d and %d", len(images), tt.wantOffsets, len(aligned), len(offsets))
+			}
+		})
+	}
+}
+
+func TestHDRProcessor_RunMissingInput(t *testing.T) {
+	dir := t.TempDir()
+	low := writeTestImage(t, dir, "low.png", 50)
+
+	err := NewHDRProcessor().Run(filepath.Join(dir, "out.png"), low, filepath.Join(dir, "missing.png"))
+	if err == nil {
+		t.Error("Expected error for missing input, got nil")
+	}
+}
+
+func TestHDRProcessor_ErrorKinds(t *testing.T) {
+	dir := t.TempDir()
+	low := writeTestImage(t, dir, "low.png", 50)
+	high := writeTestImage(t, dir, "high.png", 200)
+	missing := filepath.Join(dir, "missing.png")
+	// A file in place of the output directory cannot be written to
+	blocked := filepath.Join(low, "out.png")
+
+	var inputErr *InputError
+	var encodeErr *EncodeError
+	tests := []struct {
+		name   string
+		run    func(p *HDRProcessor) error
+		target any
+	}{
+		{"run missing input", func(p *HDRProcessor) error { return p.Run(filepath.Join(dir, "a.png"), low, missing) }, &inputErr},
+		{"run unwritable output", func(p *HDRProcessor) error { return p.Run(blocked, low, high) }, &encodeErr},
+		{"stream missing input", func(p *HDRProcessor) error { return p.RunStreaming(filepath.Join(dir, "b.png"), low, missing) }, &inputErr},
+		{"stream unwritable output", func(p *HDRProcessor) error { return p.RunStreaming(blocked, low, high) }, &encodeErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run(NewHDRProcessor())
+			if !errors.As(err, tt.target) {
+				t.Errorf("Expected %T, got %v", tt.target, err)
+			}
+		})
+	}
+}
+
+func TestHDRProcessor_ProcessContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewHDRProcessor().ProcessContext(ctx, []image.Image{
+		createTestImage(4, 4, 50),
+		createTestImage(4, 4, 200),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestHDRProcessor_RunContextProgress(t *testing.T) {
+	dir := t.TempDir()
+	low := writeTestImage(t, dir, "low.png", 50)
+	high := writeTestImage(t, dir, "high.png", 200)
+
+	var mu sync.Mutex
+	completed := map[string]bool{}
+	reporter := progress.ReporterFunc(func(stage string, fraction float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fraction < 0 || fraction > 1 {
+			t.Errorf("Stage %s reported fraction %.2f outside [0, 1]", stage, fraction)
+		}
+		if fraction == 1 {
+			completed[stage] = true
+		}
+	})
+
+	p := NewHDRProcessor(WithProgress(reporter))
+	if err := p.RunContext(context.Background(), filepath.Join(dir, "out.png"), low, high); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	stages := []string{
+		progress.StageLoad,
+		progress.StageAlign,
+		progress.StageLinearize,
+		progress.StageMerge,
+		progress.StageToneMap,
+		progress.StageSave,
+	}
+	for _, stage := range stages {
+		if !completed[stage] {
+			t.Errorf("Expected stage %s to report completion", stage)
+		}
+	}
+}
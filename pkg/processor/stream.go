@@ -0,0 +1,302 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// DefaultMemoryBudget is the memory, in bytes, RunStreaming may use for
+// pixel buffers unless WithMemoryBudget is given
+const DefaultMemoryBudget = 1 << 30
+
+// RunStreaming is Run for images too large to hold in memory. The exposures
+// are decoded, merged, tone mapped and encoded band by band, with the band
+// height chosen so the pixel buffers fit in the memory budget (see
+// WithMemoryBudget). Operators that need image statistics read the inputs
+// once more per statistics pass.
+//
+// The exposures are not aligned in streaming mode; they must already be
+// registered and have the same dimensions. Only the temperature white
+// balance, which needs no statistics, is supported, and radiance maps are
+// not denoised.
+func (p *HDRProcessor) RunStreaming(output string, inputs ...string) error {
+	return p.RunStreamingContext(context.Background(), output, inputs...)
+}
+
+// RunStreamingContext is RunStreaming with cancellation and progress
+// reporting. Progress is reported for progress.StageStream.
+func (p *HDRProcessor) RunStreamingContext(ctx context.Context, output string, inputs ...string) (err error) {
+	ctx = p.withProgress(ctx)
+	if len(inputs) < 2 {
+		return fmt.Errorf("loading images: %w", &InputError{errors.New("at least two images are required")})
+	}
+
+	readers := make([]imaging.RowReader, 0, len(inputs))
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+	decode := p.working.Curve().DecodeTable()
+	for _, path := range inputs {
+		r, err := imaging.OpenRows(path)
+		if err != nil {
+			return fmt.Errorf("loading images: %w", &InputError{fmt.Errorf("%s: %w", path, err)})
+		}
+		t := colorspace.NewTransform(inputSpace(path), p.working)
+		readers = append(readers, workingReader{RowReader: r, t: t, decode: decode})
+	}
+
+	bounds := readers[0].Bounds()
+	for i, r := range readers[1:] {
+		if r.Bounds() != bounds {
+			return fmt.Errorf("processing HDR: %w", &InputError{fmt.Errorf("image %d has different dimensions than the first image", i+2)})
+		}
+	}
+
+	if err := p.checkMerge(len(readers)); err != nil {
+		return fmt.Errorf("processing HDR: %w", err)
+	}
+	if p.hdr.transfer != "" {
+		return errors.New("processing HDR: HDR display output is not supported in streaming mode")
+	}
+	if p.gainMap {
+		return errors.New("processing HDR: gain maps are not supported in streaming mode")
+	}
+	if p.denoise.method != "" {
+		return errors.New("processing HDR: denoising is not supported in streaming mode")
+	}
+	var balance *colorspace.Transform
+	switch p.wb.mode {
+	case "":
+	case "temperature":
+		if balance, err = p.whiteBalanceTransform(ctx, nil); err != nil {
+			return fmt.Errorf("processing HDR: %w", err)
+		}
+	default:
+		return fmt.Errorf("processing HDR: %s white balance is not supported in streaming mode", p.wb.mode)
+	}
+	op, err := p.newToneOperator(bounds)
+	if err != nil {
+		return fmt.Errorf("processing HDR: %w", err)
+	}
+
+	rows, err := p.bandRows(bounds, readers)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(output); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("saving output image: %w", &EncodeError{fmt.Errorf("creating output directory: %w", err)})
+		}
+	}
+	writer, err := imaging.CreateRowsOptions(output, bounds, p.encodeOptions())
+	if err != nil {
+		return fmt.Errorf("saving output image: %w", &EncodeError{err})
+	}
+	defer func() {
+		if cerr := writer.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("saving output image: %w", &EncodeError{cerr})
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
+
+	s := p.newBandStream(ctx, bounds, rows, readers)
+	s.balance = balance
+
+	// Statistics passes only read up to the last row they use
+	ends := make([]int, op.passes())
+	for pass := range ends {
+		ends[pass] = bounds.Min.Y
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if op.rowWeight(pass, y) > 0 {
+				ends[pass] = y + 1
+			}
+		}
+		s.total += s.bands(ends[pass])
+	}
+	s.total += s.bands(bounds.Max.Y)
+
+	for pass, end := range ends {
+		var stats []toneStats
+		err := s.each(end, func(band *hdr.RGB) error {
+			tiles := p.tiles(band.Bounds())
+			tileStats := make([]toneStats, len(tiles))
+			err := p.forEachTile(s.inner, tiles, progress.StageToneMap, 0, 1, func(i int, r image.Rectangle) {
+				st := op.newStats(pass)
+				for y := r.Min.Y; y < r.Max.Y; y++ {
+					if w := op.rowWeight(pass, y); w > 0 {
+						st.add(rowOf(band, r, y), w)
+					}
+				}
+				tileStats[i] = st
+			})
+			stats = append(stats, tileStats...)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("processing HDR: %w", err)
+		}
+		op.endPass(pass, stats)
+	}
+
+	toOutput := colorspace.NewTransform(p.displaySpace(), p.output)
+	var writeErr error
+	out := image.NewRGBA64(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+rows))
+	err = s.each(bounds.Max.Y, func(band *hdr.RGB) error {
+		r := band.Bounds()
+		out.Rect = r
+		out.Pix = out.Pix[:r.Dy()*out.Stride]
+		err := p.forEachTile(s.inner, p.tiles(r), progress.StageToneMap, 0, 1, func(_ int, t image.Rectangle) {
+			for y := t.Min.Y; y < t.Max.Y; y++ {
+				o := out.PixOffset(t.Min.X, y)
+				op.mapRow(rowOf(band, t, y), out.Pix[o:o+8*t.Dx()])
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if toOutput.Identity() {
+			writeErr = writer.WriteRows(out)
+			return writeErr
+		}
+		converted, err := toOutput.ConvertImage(s.inner, out, p.workers)
+		if err != nil {
+			return err
+		}
+		writeErr = writer.WriteRows(converted)
+		return writeErr
+	})
+	if writeErr != nil {
+		return fmt.Errorf("saving output image: %w", &EncodeError{writeErr})
+	}
+	if err != nil {
+		return fmt.Errorf("processing HDR: %w", err)
+	}
+	return nil
+}
+
+// bandRows returns the band height that keeps the pixel buffers of a
+// streaming run within the memory budget
+func (p *HDRProcessor) bandRows(bounds image.Rectangle, readers []imaging.RowReader) (int, error) {
+	fixed := int64(0)
+	for _, r := range readers {
+		fixed += r.Buffered()
+	}
+	// One float32 RGB row per input and for the merged band, plus one
+	// 16-bit RGBA output row
+	perRow := int64(bounds.Dx()) * int64(12*(len(readers)+1)+8)
+
+	rows := int64(0)
+	if p.memoryBudget > fixed {
+		rows = (p.memoryBudget - fixed) / perRow
+	}
+	if rows >= int64(bounds.Dy()) {
+		return bounds.Dy(), nil
+	}
+
+	rows -= rows % imaging.RowAlignment
+	if rows == 0 {
+		return 0, fmt.Errorf("memory budget of %d bytes is too small for %d %dx%d images, need at least %d",
+			p.memoryBudget, len(readers), bounds.Dx(), bounds.Dy(), fixed+imaging.RowAlignment*perRow)
+	}
+	return int(rows), nil
+}
+
+// bandStream reads the exposures band by band into reused buffers and
+// merges each band
+type bandStream struct {
+	p   *HDRProcessor
+	ctx context.Context
+	// inner carries no reporter so per-band work does not report progress
+	inner   context.Context
+	bounds  image.Rectangle
+	rows    int
+	readers []imaging.RowReader
+	inputs  []*hdr.RGB
+	merged  *hdr.RGB
+	// balance white balances the merged bands, when set
+	balance *colorspace.Transform
+	// done and total count the bands processed over all passes
+	done, total int
+}
+
+func (p *HDRProcessor) newBandStream(ctx context.Context, bounds image.Rectangle, rows int, readers []imaging.RowReader) *bandStream {
+	s := &bandStream{
+		p:       p,
+		ctx:     ctx,
+		inner:   progress.NewContext(ctx, progress.Discard),
+		bounds:  bounds,
+		rows:    rows,
+		readers: readers,
+		merged:  hdr.NewRGB(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+rows)),
+	}
+	for range readers {
+		s.inputs = append(s.inputs, hdr.NewRGB(s.merged.Rect))
+	}
+	return s
+}
+
+// bands returns the number of bands covering the rows above end
+func (s *bandStream) bands(end int) int {
+	return (end - s.bounds.Min.Y + s.rows - 1) / s.rows
+}
+
+// each rewinds the readers and calls fn with the merged band for every band
+// above row end
+func (s *bandStream) each(end int, fn func(band *hdr.RGB) error) error {
+	for _, r := range s.readers {
+		if err := r.Reset(); err != nil {
+			return err
+		}
+	}
+
+	reporter := progress.FromContext(s.ctx)
+	for y := s.bounds.Min.Y; y < end; y += s.rows {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+
+		rect := image.Rect(s.bounds.Min.X, y, s.bounds.Max.X, min(y+s.rows, s.bounds.Max.Y))
+		for i, r := range s.readers {
+			if err := r.ReadRows(resizeBand(s.inputs[i], rect)); err != nil {
+				return fmt.Errorf("reading image %d: %w", i+1, err)
+			}
+		}
+		if err := s.p.mergeInto(s.inner, resizeBand(s.merged, rect), s.inputs); err != nil {
+			return err
+		}
+		if s.balance != nil {
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				row := rowOf(s.merged, rect, y)
+				balanceRow(s.balance, row, row)
+			}
+		}
+		if err := fn(s.merged); err != nil {
+			return err
+		}
+
+		s.done++
+		reporter.Report(progress.StageStream, float64(s.done)/float64(s.total))
+	}
+	return nil
+}
+
+// resizeBand points a band buffer at rect, reusing its allocation
+func resizeBand(band *hdr.RGB, rect image.Rectangle) *hdr.RGB {
+	band.Rect = rect
+	band.Pix = band.Pix[:cap(band.Pix)][:rect.Dy()*band.Stride]
+	return band
+}
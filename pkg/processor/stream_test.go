@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+)
+
+// writeGradientImages writes a bracket of gradient exposures to dir
+func writeGradientImages(t *testing.T, dir string, width, height int) []string {
+	t.Helper()
+
+	var paths []string
+	for i, exposure := range []float64{0.5, 1, 2} {
+		path := filepath.Join(dir, string(rune('a'+i))+".png")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, createGradientImage(width, height, exposure)); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// decodePNG reads the PNG image at path
+func decodePNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRunStreamingMatchesRun(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 67, 150)
+
+	for _, mapper := range ToneMappers {
+		t.Run(mapper, func(t *testing.T) {
+			p := NewHDRProcessor(WithToneMapper(mapper), WithTileSize(16), WithWorkers(4))
+			want := filepath.Join(dir, mapper+"-run.png")
+			if err := p.Run(want, inputs...); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			// A budget of a few dozen rows forces several bands
+			var mu sync.Mutex
+			var fractions []float64
+			p = NewHDRProcessor(WithToneMapper(mapper), WithTileSize(16), WithWorkers(4),
+				WithMemoryBudget(40*67*(12*4+8)),
+				WithProgress(progress.ReporterFunc(func(stage string, fraction float64) {
+					mu.Lock()
+					defer mu.Unlock()
+					if stage != progress.StageStream {
+						t.Errorf("Unexpected stage %q", stage)
+					}
+					fractions = append(fractions, fraction)
+				})))
+			got := filepath.Join(dir, mapper+"-stream.png")
+			if err := p.RunStreaming(got, inputs...); err != nil {
+				t.Fatalf("RunStreaming failed: %v", err)
+			}
+
+			if diff := maxChannelDiff(decodePNG(t, want), decodePNG(t, got)); diff > 2 {
+				t.Errorf("Streamed output differs from Run by %d", diff)
+			}
+			if len(fractions) < 2 || fractions[len(fractions)-1] != 1 {
+				t.Errorf("Expected several progress updates ending at 1, got %v", fractions)
+			}
+		})
+	}
+}
+
+func TestRunStreamingJPEG(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 40, 70)
+
+	output := filepath.Join(dir, "out", "result.jpg")
+	p := NewHDRProcessor(WithMemoryBudget(24 * 40 * (12*4 + 8)))
+	if err := p.RunStreaming(output, inputs...); err != nil {
+		t.Fatalf("RunStreaming failed: %v", err)
+	}
+
+	img, err := imaging.LoadImage(output)
+	if err != nil {
+		t.Fatalf("Failed to load output: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 40, 70) {
+		t.Errorf("Expected 40x70 output, got %v", img.Bounds())
+	}
+}
+
+func TestRunStreamingErrors(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 40, 70)
+	small := writeTestImage(t, dir, "small.png", 10)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		opts    []Option
+		inputs  []string
+		wantErr string
+	}{
+		{"single input", context.Background(), nil, inputs[:1], "at least two images"},
+		{"different sizes", context.Background(), nil, []string{inputs[0], small}, "different dimensions"},
+		{"budget too small", context.Background(), []Option{WithMemoryBudget(1024)}, inputs, "memory budget"},
+		{"invalid tone mapper", context.Background(), []Option{WithToneMapper("invalid")}, inputs, "unsupported tone mapper"},
+		{"denoise", context.Background(), []Option{WithDenoise("wavelet", 1, 1)}, inputs, "denoising is not supported"},
+		{"canceled", canceled, nil, inputs, context.Canceled.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, "out.png")
+			err := NewHDRProcessor(tt.opts...).RunStreamingContext(tt.ctx, output, tt.inputs...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if _, err := os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected no output file after failure, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"maps"
+	"slices"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// ToneMapSettings is one combination of tone mapping parameters
+type ToneMapSettings struct {
+	Operator  string  `json:"operator"`
+	Gamma     float64 `json:"gamma"`
+	Intensity float64 `json:"intensity"`
+	Light     float64 `json:"light"`
+}
+
+// SweepGrid lists the values tried for each tone mapping parameter
+type SweepGrid struct {
+	Operators   []string
+	Gammas      []float64
+	Intensities []float64
+	Lights      []float64
+}
+
+// Settings expands the grid into every combination, varying the light
+// fastest and the operator slowest. Drago03 ignores the light adaptation, so
+// its combinations use only the first light value.
+func (g SweepGrid) Settings() []ToneMapSettings {
+	lights := g.Lights
+	if len(lights) == 0 {
+		lights = []float64{0}
+	}
+
+	var settings []ToneMapSettings
+	for _, op := range g.Operators {
+		for _, gamma := range g.Gammas {
+			for _, intensity := range g.Intensities {
+				for i, light := range lights {
+					if op == "drago03" && i > 0 {
+						break
+					}
+					settings = append(settings, ToneMapSettings{Operator: op, Gamma: gamma, Intensity: intensity, Light: light})
+				}
+			}
+		}
+	}
+	return settings
+}
+
+// ToneMapSweep tone maps merged with each of settings, running the variants
+// concurrently on the processor's workers. The radiance map is converted
+// and white balanced once and shared by all variants. Progress is reported
+// per variant.
+func (p *HDRProcessor) ToneMapSweep(ctx context.Context, merged hdr.Image, settings []ToneMapSettings) ([]image.Image, error) {
+	ctx = p.withProgress(ctx)
+	if merged == nil {
+		return nil, errors.New("radiance map is nil")
+	}
+	if len(settings) == 0 {
+		return nil, errors.New("no tone mapping settings to sweep")
+	}
+	for _, s := range settings {
+		if !slices.Contains(ToneMappers, s.Operator) {
+			return nil, fmt.Errorf("unsupported tone mapper: %s", s.Operator)
+		}
+	}
+
+	m, err := p.asRGB(ctx, merged)
+	if err != nil {
+		return nil, err
+	}
+	if m, err = p.balance(ctx, m); err != nil {
+		return nil, err
+	}
+
+	// Split the workers between the variants rendered at the same time
+	workers := parallel.Workers(p.workers)
+	concurrent := min(len(settings), workers)
+	inner := max(1, workers/concurrent)
+	quiet := progress.NewContext(ctx, progress.Discard)
+
+	results := make([]image.Image, len(settings))
+	errs := make([]error, len(settings))
+	err = parallel.Run(ctx, make([]image.Rectangle, len(settings)), concurrent, func(i int, _ image.Rectangle) {
+		v := p.withToneMapSettings(settings[i])
+		v.workers = inner
+		op, err := v.newToneOperator(m.Bounds())
+		if err == nil {
+			results[i], err = v.toneMapRGB(quiet, op, m)
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("variant %d (%s): %w", i+1, settings[i].Operator, err)
+		}
+	}, func(done, total int) {
+		progress.Step(ctx, progress.StageToneMap, done, total)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// withToneMapSettings returns a copy of p using settings s
+func (p *HDRProcessor) withToneMapSettings(s ToneMapSettings) *HDRProcessor {
+	v := *p
+	v.toneMapper = s.Operator
+	v.params = maps.Clone(p.params)
+	v.params["gamma"] = s.Gamma
+	v.params["intensity"] = s.Intensity
+	v.params["light"] = s.Light
+	return &v
+}
@@ -0,0 +1,119 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+func TestSweepGridSettings(t *testing.T) {
+	grid := SweepGrid{
+		Operators:   []string{"drago03", "reinhard05"},
+		Gammas:      []float64{0.8, 1},
+		Intensities: []float64{1},
+		Lights:      []float64{0, 0.5},
+	}
+
+	got := grid.Settings()
+	// Drago03 ignores the light, so it gets one variant per gamma
+	want := []ToneMapSettings{
+		{"drago03", 0.8, 1, 0},
+		{"drago03", 1, 1, 0},
+		{"reinhard05", 0.8, 1, 0},
+		{"reinhard05", 0.8, 1, 0.5},
+		{"reinhard05", 1, 1, 0},
+		{"reinhard05", 1, 1, 0.5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d settings, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Setting %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestToneMapSweepMatchesToneMap(t *testing.T) {
+	merged := referenceConvert(createGradientImage(37, 23, 1.5))
+	settings := []ToneMapSettings{
+		{Operator: "drago03", Gamma: 0.8, Intensity: 1},
+		{Operator: "reinhard05", Gamma: 1, Intensity: 1.5, Light: 0.3},
+		{Operator: "reinhard05", Gamma: 1.2, Intensity: 0.8},
+	}
+
+	steps := 0
+	reporter := progress.ReporterFunc(func(stage string, fraction float64) {
+		if stage == progress.StageToneMap {
+			steps++
+		}
+	})
+	p := NewHDRProcessor(WithTileSize(8), WithWorkers(2), WithProgress(reporter))
+	got, err := p.ToneMapSweep(context.Background(), merged, settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if steps != len(settings) {
+		t.Errorf("Expected %d progress steps, got %d", len(settings), steps)
+	}
+
+	for i, s := range settings {
+		single := NewHDRProcessor(WithToneMapper(s.Operator), WithGamma(s.Gamma), WithIntensity(s.Intensity), WithLight(s.Light))
+		want, err := single.ToneMap(merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, w := got[i].(*image.RGBA64), want.(*image.RGBA64)
+		for j := range w.Pix {
+			if d := int(g.Pix[j]) - int(w.Pix[j]); d > 1 || d < -1 {
+				t.Fatalf("Variant %d differs from a single tone mapping at byte %d", i, j)
+			}
+		}
+	}
+}
+
+func TestToneMapSweepWhiteBalance(t *testing.T) {
+	// A warm cast for gray-world to remove
+	merged := referenceConvert(createGradientImage(37, 23, 1.5)).(*hdr.RGB)
+	for i := 0; i < len(merged.Pix); i += 3 {
+		merged.Pix[i] *= 1.5
+		merged.Pix[i+2] *= 0.6
+	}
+	s := ToneMapSettings{Operator: "reinhard05", Gamma: 1, Intensity: 1}
+
+	p := NewHDRProcessor(WithWhiteBalance("gray-world"))
+	got, err := p.ToneMapSweep(context.Background(), merged, []ToneMapSettings{s})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	single := NewHDRProcessor(WithWhiteBalance("gray-world"), WithToneMapper(s.Operator), WithGamma(s.Gamma), WithIntensity(s.Intensity), WithLight(s.Light))
+	want, err := single.ToneMapContext(context.Background(), merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, w := got[0].(*image.RGBA64), want.(*image.RGBA64)
+	for j := range w.Pix {
+		if d := int(g.Pix[j]) - int(w.Pix[j]); d > 1 || d < -1 {
+			t.Fatalf("Sweep differs from a white balanced tone mapping at byte %d", j)
+		}
+	}
+}
+
+func TestToneMapSweepErrors(t *testing.T) {
+	merged := hdr.NewRGB(image.Rect(0, 0, 2, 2))
+	p := NewHDRProcessor()
+
+	if _, err := p.ToneMapSweep(context.Background(), merged, nil); err == nil {
+		t.Error("Expected error for no settings, got nil")
+	}
+	if _, err := p.ToneMapSweep(context.Background(), merged, []ToneMapSettings{{Operator: "aces"}}); err == nil {
+		t.Error("Expected error for an unknown operator, got nil")
+	}
+	if _, err := p.ToneMapSweep(context.Background(), nil, []ToneMapSettings{{Operator: "drago03"}}); err == nil {
+		t.Error("Expected error for a nil radiance map, got nil")
+	}
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// tiles splits bounds into the processor's tile size
+func (p *HDRProcessor) tiles(bounds image.Rectangle) []image.Rectangle {
+	return parallel.Split(bounds, p.tileSize)
+}
+
+// forEachTile runs fn over tiles on the processor's worker pool. Progress is
+// reported for stage as pass out of passes, so multi-pass operators report a
+// single monotonic fraction.
+func (p *HDRProcessor) forEachTile(ctx context.Context, tiles []image.Rectangle, stage string, pass, passes int, fn func(i int, r image.Rectangle)) error {
+	reporter := progress.FromContext(ctx)
+	return parallel.Run(ctx, tiles, p.workers, fn, func(done, total int) {
+		reporter.Report(stage, (float64(pass)+float64(done)/float64(total))/float64(passes))
+	})
+}
+
+// asRGB returns img as a contiguous float32 buffer, converting it if needed
+func (p *HDRProcessor) asRGB(ctx context.Context, img image.Image) (*hdr.RGB, error) {
+	if m, ok := img.(*hdr.RGB); ok {
+		return m, nil
+	}
+	return imaging.ConvertToHDRContext(ctx, img, p.workers)
+}
+
+// logAverageLuminance returns the log-average luminance of m in a pass over
+// tiles, reported as pass out of passes of stage
+func (p *HDRProcessor) logAverageLuminance(ctx context.Context, m *hdr.RGB, tiles []image.Rectangle, stage string, pass, passes int) (float64, error) {
+	logSums := make([]float64, len(tiles))
+	err := p.forEachTile(ctx, tiles, stage, pass, passes, func(i int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				logSums[i] += math.Log(p.luminanceAt(m, x, y) + 1e-6)
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	logSum := 0.0
+	for _, s := range logSums {
+		logSum += s
+	}
+	b := m.Bounds()
+	return math.Exp(logSum / float64(b.Dx()*b.Dy())), nil
+}
@@ -0,0 +1,160 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+)
+
+// ColorPreservations lists the ways tone mapping can keep the colors of the
+// radiance map while the operator maps luminance alone: "ratio" scales the
+// channels with the luminance, raising their ratios to the luminance to the
+// color exponent; "ictcp" and "oklab" map the intensity or lightness of the
+// ICtCp or Oklab perceptual space and scale the chroma along with it.
+var ColorPreservations = []string{"ratio", "ictcp", "oklab"}
+
+// ictcpWhite is the luminance of linear 1 in cd/m² when tone mapping in
+// ICtCp, that of SDR reference white
+const ictcpWhite = 100
+
+// colorPreservation holds the color settings of tone mapping
+type colorPreservation struct {
+	// method is one of ColorPreservations, or empty to let the operator map
+	// each channel
+	method string
+	// exponent is the saturation exponent of the ratio method
+	exponent float64
+}
+
+// ColorPreservation returns the color preservation method of tone mapping,
+// or an empty string when the operator maps the channels itself
+func (p *HDRProcessor) ColorPreservation() string {
+	return p.color.method
+}
+
+// colorOperator wraps a tone operator to map luminance alone and rebuild
+// the colors with a color preservation method, then adjusts the saturation
+// and vibrance of the result
+type colorOperator struct {
+	toneOperator
+	preserve colorPreservation
+	// saturation scales the chroma of every pixel, and vibrance that of
+	// muted pixels more than saturated ones
+	saturation, vibrance float64
+	space                *colorspace.Space
+	curve                colorspace.Curve
+	oklab                *colorspace.Oklab
+	ictcp                *colorspace.ICtCp
+}
+
+// withColor wraps op with the color settings of the processor, or returns
+// it unchanged when they leave colors to the operator
+func (p *HDRProcessor) withColor(op toneOperator) (toneOperator, error) {
+	saturation := max(p.params["saturation"], 0)
+	vibrance := max(-1, min(1, p.params["vibrance"]))
+	if p.color.method == "" && saturation == 1 && vibrance == 0 {
+		return op, nil
+	}
+	if p.color.method != "" && !slices.Contains(ColorPreservations, p.color.method) {
+		return nil, fmt.Errorf("unsupported color preservation: %s", p.color.method)
+	}
+	if p.color.method == "ratio" && p.color.exponent <= 0 {
+		return nil, fmt.Errorf("invalid color exponent: %v is not positive", p.color.exponent)
+	}
+
+	c := &colorOperator{
+		toneOperator: op,
+		preserve:     p.color,
+		saturation:   saturation,
+		vibrance:     vibrance,
+		space:        p.working,
+		curve:        p.output.Curve(),
+	}
+	switch p.color.method {
+	case "oklab":
+		c.oklab = colorspace.NewOklab(p.working)
+	case "ictcp":
+		c.ictcp = colorspace.NewICtCp(p.working, ictcpWhite)
+	}
+	return c, nil
+}
+
+func (c *colorOperator) mapRow(row []float32, out []uint8) {
+	if c.preserve.method == "" {
+		c.toneOperator.mapRow(row, out)
+	} else {
+		// Map the luminance of every pixel as a gray
+		gray := make([]float32, len(row))
+		for j := 0; j < len(row); j += 3 {
+			l := float32(c.space.Luminance(float64(row[j]), float64(row[j+1]), float64(row[j+2])))
+			gray[j], gray[j+1], gray[j+2] = l, l, l
+		}
+		c.toneOperator.mapRow(gray, out)
+	}
+
+	for j, o := 0, 0; j < len(row); j, o = j+3, o+8 {
+		r, g, b := c.decode(out[o:]), c.decode(out[o+2:]), c.decode(out[o+4:])
+		if c.preserve.method != "" {
+			r, g, b = c.preserveColor(float64(row[j]), float64(row[j+1]), float64(row[j+2]), c.space.Luminance(r, g, b))
+		}
+		r, g, b = c.adjust(r, g, b)
+		setRGB(out[o:], c.encode(r), c.encode(g), c.encode(b))
+	}
+}
+
+// decode returns the linear value of a 16-bit output channel
+func (c *colorOperator) decode(pix []uint8) float64 {
+	return c.curve.Decode(float64(uint16(pix[0])<<8|uint16(pix[1])) / ldrMax)
+}
+
+// encode returns the 16-bit output channel of a linear value
+func (c *colorOperator) encode(v float64) uint16 {
+	return quantize(c.curve.Encode(max(v, 0)))
+}
+
+// preserveColor returns the linear color with luminance target that keeps
+// the color of the radiance map pixel r, g, b
+func (c *colorOperator) preserveColor(r, g, b, target float64) (float64, float64, float64) {
+	switch c.preserve.method {
+	case "ratio":
+		lum := c.space.Luminance(r, g, b)
+		if lum <= 0 {
+			return target, target, target
+		}
+		ratio := func(v float64) float64 {
+			return math.Pow(max(v, 0)/lum, c.preserve.exponent) * target
+		}
+		return ratio(r), ratio(g), ratio(b)
+	case "oklab":
+		l, a, bb := c.oklab.Lab(r, g, b)
+		lt, _, _ := c.oklab.Lab(target, target, target)
+		if l <= 0 {
+			return target, target, target
+		}
+		return c.oklab.RGB(lt, a*lt/l, bb*lt/l)
+	default:
+		i, ct, cp := c.ictcp.ITP(r, g, b)
+		it, _, _ := c.ictcp.ITP(target, target, target)
+		if i <= 0 {
+			return target, target, target
+		}
+		return c.ictcp.RGB(it, ct*it/i, cp*it/i)
+	}
+}
+
+// adjust scales the chroma of a linear color by the saturation, and by the
+// vibrance in proportion to how muted the color is
+func (c *colorOperator) adjust(r, g, b float64) (float64, float64, float64) {
+	k := c.saturation
+	if hi := max(r, g, b); c.vibrance != 0 && hi > 0 {
+		muted := 1 - min((hi-min(r, g, b))/hi, 1)
+		k *= 1 + c.vibrance*muted
+	}
+	if k == 1 {
+		return r, g, b
+	}
+	lum := c.space.Luminance(r, g, b)
+	return lum + (r-lum)*k, lum + (g-lum)*k, lum + (b-lum)*k
+}
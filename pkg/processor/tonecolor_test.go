@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// colorRows are the colors of the rows of createColorMap: a saturated
+// orange and a muted beige
+var colorRows = []hdrcolor.RGB{{R: 1, G: 0.4, B: 0.1}, {R: 1, G: 0.9, B: 0.8}}
+
+// createColorMap returns a radiance map with a row per color of colorRows,
+// brightening over four decades from left to right
+func createColorMap(width int) *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, width, len(colorRows)))
+	for y, c := range colorRows {
+		for x := 0; x < width; x++ {
+			base := math.Pow(10, 4*float64(x)/float64(width)-3)
+			m.SetRGB(x, y, hdrcolor.RGB{R: c.R * base, G: c.G * base, B: c.B * base})
+		}
+	}
+	return m
+}
+
+// toneMapLinear tone maps m and returns the pixel at x, y decoded to linear
+// sRGB
+func toneMapLinear(t *testing.T, m *hdr.RGB, x, y int, opts ...Option) [3]float64 {
+	t.Helper()
+	out, err := NewHDRProcessor(append([]Option{WithToneMapper("drago03")}, opts...)...).ToneMap(m)
+	if err != nil {
+		t.Fatalf("ToneMap failed: %v", err)
+	}
+	r, g, b, _ := out.At(x, y).RGBA()
+	c := colorspace.SRGBCurve
+	return [3]float64{c.Decode(float64(r) / ldrMax), c.Decode(float64(g) / ldrMax), c.Decode(float64(b) / ldrMax)}
+}
+
+// saturation returns the chroma of a color relative to its largest channel
+func saturation(c [3]float64) float64 {
+	return (max(c[0], c[1], c[2]) - min(c[0], c[1], c[2])) / max(c[0], c[1], c[2])
+}
+
+func TestColorPreservation(t *testing.T) {
+	m := createColorMap(64)
+	oklab := colorspace.NewOklab(colorspace.SRGB)
+	ictcp := colorspace.NewICtCp(colorspace.SRGB, ictcpWhite)
+
+	tests := []struct {
+		method string
+		// hue returns the hue the method keeps
+		hue func(c [3]float64) float64
+	}{
+		{"ratio", func(c [3]float64) float64 { return math.Atan2(c[1]-c[2], c[0]-c[2]) }},
+		{"oklab", func(c [3]float64) float64 {
+			_, a, b := oklab.Lab(c[0], c[1], c[2])
+			return math.Atan2(b, a)
+		}},
+		{"ictcp", func(c [3]float64) float64 {
+			_, ct, cp := ictcp.ITP(c[0], c[1], c[2])
+			return math.Atan2(cp, ct)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			prev := 0.0
+			for _, x := range []int{16, 24, 32} {
+				got := toneMapLinear(t, m, x, 0, WithColorPreservation(tt.method, 1))
+				r, g, b, _ := m.HDRAt(x, 0).HDRRGBA()
+				want := tt.hue([3]float64{r, g, b})
+				if math.Abs(tt.hue(got)-want) > 0.02 {
+					t.Errorf("x=%d: expected hue %.3f, got %.3f from %v", x, want, tt.hue(got), got)
+				}
+				lum := luminance(got[0], got[1], got[2])
+				if lum <= prev {
+					t.Errorf("x=%d: expected increasing luminance, got %.4f after %.4f", x, lum, prev)
+				}
+				prev = lum
+			}
+		})
+	}
+}
+
+func TestColorExponent(t *testing.T) {
+	m := createColorMap(64)
+	full := toneMapLinear(t, m, 24, 0, WithColorPreservation("ratio", 1))
+	half := toneMapLinear(t, m, 24, 0, WithColorPreservation("ratio", 0.5))
+	if saturation(half) >= saturation(full) {
+		t.Errorf("Expected an exponent of 0.5 to desaturate, got %.3f from %.3f", saturation(half), saturation(full))
+	}
+}
+
+func TestSaturationVibrance(t *testing.T) {
+	m := createColorMap(64)
+	x := 28
+	vivid := toneMapLinear(t, m, x, 0)
+	muted := toneMapLinear(t, m, x, 1)
+
+	if gray := toneMapLinear(t, m, x, 0, WithSaturation(0)); saturation(gray) > 1e-3 {
+		t.Errorf("Expected a saturation of 0 to give gray, got %v", gray)
+	}
+	if more := toneMapLinear(t, m, x, 0, WithSaturation(1.3)); saturation(more) <= saturation(vivid) {
+		t.Errorf("Expected a saturation of 1.3 to saturate, got %.3f from %.3f", saturation(more), saturation(vivid))
+	}
+
+	// Vibrance raises muted colors more than saturated ones
+	vividGain := saturation(toneMapLinear(t, m, x, 0, WithVibrance(0.5))) / saturation(vivid)
+	mutedGain := saturation(toneMapLinear(t, m, x, 1, WithVibrance(0.5))) / saturation(muted)
+	if vividGain < 1 || mutedGain <= vividGain {
+		t.Errorf("Expected vibrance to favor muted colors, got gains %.3f (vivid) and %.3f (muted)", vividGain, mutedGain)
+	}
+}
+
+func TestWithColor(t *testing.T) {
+	bounds := image.Rect(0, 0, 4, 4)
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantWrapped bool
+		wantErr     bool
+	}{
+		{"defaults", nil, false, false},
+		{"neutral settings", []Option{WithSaturation(1), WithVibrance(0), WithColorPreservation("", 2)}, false, false},
+		{"saturation", []Option{WithSaturation(1.2)}, true, false},
+		{"oklab", []Option{WithColorPreservation("oklab", 1)}, true, false},
+		{"unknown method", []Option{WithColorPreservation("lab", 1)}, false, true},
+		{"zero exponent", []Option{WithColorPreservation("ratio", 0)}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := NewHDRProcessor(tt.opts...).newToneOperator(bounds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if _, wrapped := op.(*colorOperator); !tt.wantErr && wrapped != tt.wantWrapped {
+				t.Errorf("Expected wrapped %v, got %T", tt.wantWrapped, op)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/harperreed/hdarrrr/pkg/progress"
+	"github.com/mdouchement/hdr"
+)
+
+// The operators below are ports of the Drago03 and Reinhard05 operators from
+// github.com/mdouchement/hdr/tmo that produce the same 16-bit output. Each one
+// is split into statistics passes over rows of the radiance map followed by a
+// per-row mapping, so it can run over tiles of an in-memory image or over
+// bands of a streamed one.
+
+const (
+	// ldrMax is the largest 16-bit output value
+	ldrMax = 0xffff
+	// reinhardGamma is the display gamma applied by Reinhard05
+	reinhardGamma = 1.8
+	// reinhardSampling is the fraction of rows Reinhard05 samples for its statistics
+	reinhardSampling = float32(0.6)
+)
+
+// luminance returns the CIE Y of a linear sRGB color
+func luminance(r, g, b float64) float64 {
+	return 0.21263900587151036*r + 0.71516867876775593*g + 0.072192315360733715*b
+}
+
+// quantize maps a channel in [0, 1] to 16 bits like tmo.LinearInversePixelMapping
+// followed by tmo.LDRClamp: the result is the index p of the largest
+// (p-1)/0xffff that does not exceed v, clamped to [0, 0xffff].
+func quantize(v float64) uint16 {
+	if math.IsNaN(v) {
+		return ldrMax
+	}
+	if v < 0 {
+		return 0
+	}
+	if v >= 1 {
+		return ldrMax
+	}
+
+	p := math.Floor(v*ldrMax) + 1
+	for p > 1 && v < (p-1)/ldrMax {
+		p--
+	}
+	for v >= p/ldrMax {
+		p++
+	}
+	if p > ldrMax {
+		return ldrMax
+	}
+	return uint16(p)
+}
+
+// setRGB writes an opaque 16-bit pixel to the start of pix
+func setRGB(pix []uint8, r, g, b uint16) {
+	pix[0] = uint8(r >> 8)
+	pix[1] = uint8(r)
+	pix[2] = uint8(g >> 8)
+	pix[3] = uint8(g)
+	pix[4] = uint8(b >> 8)
+	pix[5] = uint8(b)
+	pix[6] = 0xff
+	pix[7] = 0xff
+}
+
+// toneStats accumulates the statistics of one pass over part of the image
+type toneStats interface {
+	// add accumulates a row of RGB values counted weight times
+	add(row []float32, weight int)
+}
+
+// toneOperator is a tone mapping operator split into passes
+type toneOperator interface {
+	// passes returns the number of statistics passes before mapping
+	passes() int
+	// rowWeight returns how many times row y counts in the statistics of pass
+	rowWeight(pass, y int) int
+	// newStats returns an empty accumulator for pass
+	newStats(pass int) toneStats
+	// endPass combines the accumulators filled during pass
+	endPass(pass int, stats []toneStats)
+	// mapRow maps a row of RGB values to 16-bit RGBA pixels
+	mapRow(row []float32, out []uint8)
+}
+
+// newToneOperator creates the configured operator for an image with bounds,
+// wrapped with the color settings of the processor
+func (p *HDRProcessor) newToneOperator(bounds image.Rectangle) (toneOperator, error) {
+	if p.hdr.transfer != "" {
+		return newHDREncoder(p.hdr, p.working)
+	}
+	var op toneOperator
+	switch p.toneMapper {
+	case "reinhard05":
+		op = newReinhard05(bounds, p.params["intensity"], p.params["light"], p.params["gamma"])
+	case "drago03":
+		op = newDrago03(p.params["gamma"])
+	default:
+		return nil, fmt.Errorf("unsupported tone mapper: %s", p.toneMapper)
+	}
+	return p.withColor(op)
+}
+
+// toneMapRGB runs op over the tiles of m
+func (p *HDRProcessor) toneMapRGB(ctx context.Context, op toneOperator, m *hdr.RGB) (*image.RGBA64, error) {
+	bounds := m.Bounds()
+	tiles := p.tiles(bounds)
+	passes := op.passes() + 1
+
+	for pass := 0; pass < op.passes(); pass++ {
+		stats := make([]toneStats, len(tiles))
+		err := p.forEachTile(ctx, tiles, progress.StageToneMap, pass, passes, func(i int, r image.Rectangle) {
+			s := op.newStats(pass)
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				if w := op.rowWeight(pass, y); w > 0 {
+					s.add(rowOf(m, r, y), w)
+				}
+			}
+			stats[i] = s
+		})
+		if err != nil {
+			return nil, err
+		}
+		op.endPass(pass, stats)
+	}
+
+	out := image.NewRGBA64(bounds)
+	err := p.forEachTile(ctx, tiles, progress.StageToneMap, passes-1, passes, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			o := out.PixOffset(r.Min.X, y)
+			op.mapRow(rowOf(m, r, y), out.Pix[o:o+8*r.Dx()])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// drago03 is the adaptive logarithmic operator by Drago et al. (2003)
+type drago03 struct {
+	biasP   float64
+	avgLum  float64
+	maxLum  float64
+	divider float64
+}
+
+type drago03Stats struct {
+	logSum float64
+	maxLum float64
+	count  float64
+}
+
+func (s *drago03Stats) add(row []float32, weight int) {
+	for j := 0; j < len(row); j += 3 {
+		lum := luminance(float64(row[j]), float64(row[j+1]), float64(row[j+2]))
+		s.logSum += math.Log(lum+1e-4) * float64(weight)
+		s.maxLum = math.Max(s.maxLum, lum)
+	}
+	s.count += float64(len(row) / 3 * weight)
+}
+
+// newDrago03 creates the operator; bias is clamped to [0, 1]
+func newDrago03(bias float64) *drago03 {
+	bias = math.Max(0, math.Min(1, bias))
+	return &drago03{biasP: math.Log10(bias) / math.Log(0.5)}
+}
+
+func (t *drago03) passes() int { return 1 }
+
+func (t *drago03) rowWeight(pass, y int) int { return 1 }
+
+func (t *drago03) newStats(pass int) toneStats {
+	return &drago03Stats{maxLum: math.Inf(-1)}
+}
+
+func (t *drago03) endPass(pass int, stats []toneStats) {
+	total := drago03Stats{maxLum: math.Inf(-1)}
+	for _, s := range stats {
+		s := s.(*drago03Stats)
+		total.logSum += s.logSum
+		total.maxLum = math.Max(total.maxLum, s.maxLum)
+		total.count += s.count
+	}
+
+	t.avgLum = math.Exp(total.logSum / total.count)
+	t.maxLum = total.maxLum / t.avgLum
+	t.divider = math.Log10(t.maxLum + 1.0)
+}
+
+func (t *drago03) mapRow(row []float32, out []uint8) {
+	for j, o := 0, 0; j < len(row); j, o = j+3, o+8 {
+		cr, cg, cb := float64(row[j]), float64(row[j+1]), float64(row[j+2])
+		lum := luminance(cr, cg, cb)
+
+		// Core Drago equation, rescaling the color to the new luminance
+		var scale float64
+		if lum > 0 {
+			ratio := lum / t.avgLum
+			newLum := (math.Log(ratio+1.0) / math.Log(2.0+math.Pow(ratio/t.maxLum, t.biasP)*8.0)) / t.divider
+			scale = newLum / lum
+		}
+
+		setRGB(out[o:], quantize(cr*scale), quantize(cg*scale), quantize(cb*scale))
+	}
+}
+
+// reinhard05 is the photoreceptor-based operator by Reinhard and Devlin (2005)
+type reinhard05 struct {
+	f, chromatic, light float64
+	// weights counts how often each row is sampled for the statistics
+	weights []int
+	minY    int
+
+	cav, globalAdaptation [3]float64
+	lav, contrast         float64
+	minSample, maxSample  float64
+}
+
+type reinhard05LumStats struct {
+	minLum, maxLum, worldLum, lav, count float64
+	cav                                  [3]float64
+}
+
+func (s *reinhard05LumStats) add(row []float32, weight int) {
+	w := float64(weight)
+	for j := 0; j < len(row); j += 3 {
+		cr, cg, cb := float64(row[j]), float64(row[j+1]), float64(row[j+2])
+		lum := luminance(cr, cg, cb)
+		s.minLum = math.Min(s.minLum, lum)
+		s.maxLum = math.Max(s.maxLum, lum)
+		s.worldLum += math.Log(2.3e-5+lum) * w
+		s.cav[0] += cr * w
+		s.cav[1] += cg * w
+		s.cav[2] += cb * w
+		s.lav += lum * w
+	}
+	s.count += float64(len(row)/3) * w
+}
+
+type reinhard05SampleStats struct {
+	t        *reinhard05
+	min, max float64
+}
+
+func (s *reinhard05SampleStats) add(row []float32, weight int) {
+	for j := 0; j < len(row); j += 3 {
+		cr, cg, cb := float64(row[j]), float64(row[j+1]), float64(row[j+2])
+		lum := luminance(cr, cg, cb)
+		if lum == 0.0 {
+			continue
+		}
+		for c, v := range [3]float64{cr, cg, cb} {
+			sample := s.t.sampling(v, lum, c)
+			s.min = math.Min(s.min, sample)
+			s.max = math.Max(s.max, sample)
+		}
+	}
+}
+
+// newReinhard05 creates the operator for an image with bounds. brightness is
+// clamped to [-20, 20], chromatic and light to [0, 1].
+func newReinhard05(bounds image.Rectangle, brightness, chromatic, light float64) *reinhard05 {
+	t := &reinhard05{
+		f:         math.Exp(-math.Max(-20, math.Min(20, brightness))),
+		chromatic: math.Max(0, math.Min(1, chromatic)),
+		light:     math.Max(0, math.Min(1, light)),
+		weights:   make([]int, bounds.Dy()),
+		minY:      bounds.Min.Y,
+	}
+
+	// Statistics are gathered on the same subset of rows as the reference
+	// implementation so both produce identical images. Rows are counted from
+	// the top of the image, wherever its bounds start.
+	sampled := false
+	for i := 0; i < int(float32(bounds.Dy())*reinhardSampling); i++ {
+		t.weights[int(float32(i)*reinhardSampling)]++
+		sampled = true
+	}
+	if !sampled {
+		for i := range t.weights {
+			t.weights[i] = 1
+		}
+	}
+	return t
+}
+
+func (t *reinhard05) passes() int { return 2 }
+
+func (t *reinhard05) rowWeight(pass, y int) int {
+	return t.weights[y-t.minY]
+}
+
+func (t *reinhard05) newStats(pass int) toneStats {
+	if pass == 0 {
+		return &reinhard05LumStats{minLum: math.Inf(1), maxLum: math.Inf(-1)}
+	}
+	return &reinhard05SampleStats{t: t, min: 1.0, max: 0.0}
+}
+
+func (t *reinhard05) endPass(pass int, stats []toneStats) {
+	if pass == 1 {
+		t.minSample, t.maxSample = 1.0, 0.0
+		for _, s := range stats {
+			s := s.(*reinhard05SampleStats)
+			t.minSample = math.Min(t.minSample, s.min)
+			t.maxSample = math.Max(t.maxSample, s.max)
+		}
+		return
+	}
+
+	total := reinhard05LumStats{minLum: math.Inf(1), maxLum: math.Inf(-1)}
+	for _, s := range stats {
+		s := s.(*reinhard05LumStats)
+		total.minLum = math.Min(total.minLum, s.minLum)
+		total.maxLum = math.Max(total.maxLum, s.maxLum)
+		total.worldLum += s.worldLum
+		total.lav += s.lav
+		total.count += s.count
+		for c := range total.cav {
+			total.cav[c] += s.cav[c]
+		}
+	}
+
+	worldLum := total.worldLum / total.count
+	t.lav = total.lav / total.count
+	for c := range t.cav {
+		t.cav[c] = total.cav[c] / total.count
+	}
+	minLum := math.Log(total.minLum)
+	maxLum := math.Log(total.maxLum)
+
+	// Image key and contrast
+	k := (maxLum - worldLum) / (maxLum - minLum)
+	t.contrast = 0.3 + (0.7 * math.Pow(k, 1.4))
+
+	// Without local adaptation the photoreceptor term only depends on the
+	// channel, so it is computed once instead of per sample
+	for c := range t.globalAdaptation {
+		ig := t.chromatic*t.cav[c] + (1-t.chromatic)*t.lav
+		t.globalAdaptation[c] = math.Pow(t.f*ig, t.contrast)
+	}
+}
+
+// sampling applies the photoreceptor model to one channel
+func (t *reinhard05) sampling(sample, lum float64, c int) float64 {
+	if sample == 0.0 {
+		return sample
+	}
+	if t.light == 0 {
+		return sample / (sample + t.globalAdaptation[c])
+	}
+
+	// Local light adaptation
+	il := t.chromatic*sample + (1-t.chromatic)*lum
+	// Global light adaptation
+	ig := t.chromatic*t.cav[c] + (1-t.chromatic)*t.lav
+	// Interpolated light adaptation
+	ia := t.light*il + (1-t.light)*ig
+	// Photoreceptor equation
+	return sample / (sample + math.Pow(t.f*ia, t.contrast))
+}
+
+// normalize stretches an adapted channel to the sampled range and applies
+// the display gamma
+func (t *reinhard05) normalize(channel float64) uint16 {
+	channel = (channel - t.minSample) / (t.maxSample - t.minSample)
+	if channel > 0 {
+		channel = math.Pow(channel, 1/reinhardGamma)
+	}
+	return quantize(channel)
+}
+
+func (t *reinhard05) mapRow(row []float32, out []uint8) {
+	for j, o := 0, 0; j < len(row); j, o = j+3, o+8 {
+		cr, cg, cb := float64(row[j]), float64(row[j+1]), float64(row[j+2])
+		lum := luminance(cr, cg, cb)
+		setRGB(out[o:],
+			t.normalize(t.sampling(cr, lum, 0)),
+			t.normalize(t.sampling(cg, lum, 1)),
+			t.normalize(t.sampling(cb, lum, 2)),
+		)
+	}
+}
@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+	"github.com/mdouchement/hdr/tmo"
+)
+
+// createRadianceMap creates a radiance map with smooth gradients spanning
+// several orders of magnitude
+func createRadianceMap(width, height int) *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			base := math.Pow(10, 3*float64(x)/float64(width)-1)
+			m.SetRGB(x, y, hdrcolor.RGB{
+				R: base * (0.5 + 0.5*float64(y)/float64(height)),
+				G: base * 0.8,
+				B: base * (1 - 0.5*float64(y)/float64(height)),
+			})
+		}
+	}
+	return m
+}
+
+// maxChannelDiff returns the largest 16-bit channel difference between a and b
+func maxChannelDiff(a, b image.Image) uint32 {
+	var max uint32
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ar, ag, ab, _ := a.At(x, y).RGBA()
+			br, bg, bb, _ := b.At(x, y).RGBA()
+			for _, d := range [][2]uint32{{ar, br}, {ag, bg}, {ab, bb}} {
+				diff := d[0] - d[1]
+				if d[1] > d[0] {
+					diff = d[1] - d[0]
+				}
+				if diff > max {
+					max = diff
+				}
+			}
+		}
+	}
+	return max
+}
+
+func TestQuantizeMatchesReference(t *testing.T) {
+	values := []float64{-1, 0, 1e-9, 0.25, 0.5, 0.999999, 1, 1.5, math.Inf(1), math.NaN()}
+	for k := 0; k <= 0xffff; k += 97 {
+		v := float64(k) / 0xffff
+		values = append(values, v, math.Nextafter(v, 0), math.Nextafter(v, 2))
+	}
+
+	for _, v := range values {
+		want := uint16(tmo.LDRClamp(tmo.LinearInversePixelMapping(v, tmo.LumPixFloor, tmo.LumSize)))
+		if got := quantize(v); got != want {
+			t.Errorf("quantize(%v): expected %d, got %d", v, want, got)
+		}
+	}
+}
+
+func TestToneMappersMatchReference(t *testing.T) {
+	m := createRadianceMap(97, 61)
+
+	tests := []struct {
+		name      string
+		params    map[string]float64
+		mapper    string
+		reference tmo.ToneMappingOperator
+	}{
+		{
+			name:      "Drago03 default",
+			mapper:    "drago03",
+			params:    map[string]float64{"gamma": 1.0},
+			reference: tmo.NewDrago03(m, 1.0),
+		},
+		{
+			name:      "Drago03 bias",
+			mapper:    "drago03",
+			params:    map[string]float64{"gamma": 0.85},
+			reference: tmo.NewDrago03(m, 0.85),
+		},
+		{
+			name:      "Reinhard05 default",
+			mapper:    "reinhard05",
+			params:    map[string]float64{"intensity": 1.0, "light": 0.0, "gamma": 1.0},
+			reference: tmo.NewReinhard05(m, 1.0, 0.0, 1.0),
+		},
+		{
+			name:      "Reinhard05 adapted",
+			mapper:    "reinhard05",
+			params:    map[string]float64{"intensity": -2.0, "light": 0.6, "gamma": 0.3},
+			reference: tmo.NewReinhard05(m, -2.0, 0.6, 0.3),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewHDRProcessor(WithToneMapper(tt.mapper), WithParams(tt.params), WithTileSize(16), WithWorkers(3))
+			got, err := p.ToneMap(m)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Allow for float rounding differences in the parallel sums
+			if diff := maxChannelDiff(got, tt.reference.Perform()); diff > 2 {
+				t.Errorf("Expected output to match reference, max channel difference %d", diff)
+			}
+		})
+	}
+}
+
+func TestToneMapOffsetBounds(t *testing.T) {
+	m := createRadianceMap(40, 30)
+	shifted := hdr.NewRGB(image.Rect(0, 100, 40, 130))
+	for y := 0; y < 30; y++ {
+		copy(shifted.Pix[shifted.PixOffset(0, y+100):], m.Pix[m.PixOffset(0, y):m.PixOffset(40, y)])
+	}
+	// The lower half of a taller map, sharing its pixels as a sub-image would
+	tall := hdr.NewRGB(image.Rect(0, 0, 40, 60))
+	for y := 0; y < 30; y++ {
+		copy(tall.Pix[tall.PixOffset(0, y+30):], m.Pix[m.PixOffset(0, y):m.PixOffset(40, y)])
+	}
+	sub := &hdr.RGB{Pix: tall.Pix[tall.PixOffset(0, 30):], Stride: tall.Stride, Rect: image.Rect(0, 30, 40, 60)}
+
+	for _, mapper := range ToneMappers {
+		want, err := NewHDRProcessor(WithToneMapper(mapper)).ToneMap(m)
+		if err != nil {
+			t.Fatalf("%s: %v", mapper, err)
+		}
+		for _, tt := range []struct {
+			name string
+			m    *hdr.RGB
+		}{{"shifted", shifted}, {"sub-image", sub}} {
+			t.Run(mapper+"/"+tt.name, func(t *testing.T) {
+				got, err := NewHDRProcessor(WithToneMapper(mapper), WithTileSize(8)).ToneMap(tt.m)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if got.Bounds() != tt.m.Bounds() {
+					t.Fatalf("Expected bounds %v, got %v", tt.m.Bounds(), got.Bounds())
+				}
+				min := tt.m.Bounds().Min
+				for y := 0; y < 30; y++ {
+					for x := 0; x < 40; x++ {
+						gr, gg, gb, _ := got.At(x+min.X, y+min.Y).RGBA()
+						wr, wg, wb, _ := want.At(x, y).RGBA()
+						if absDiff(gr, wr) > 2 || absDiff(gg, wg) > 2 || absDiff(gb, wb) > 2 {
+							t.Fatalf("Pixel (%d,%d) differs from the map at the origin", x, y)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// absDiff returns |a - b|
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestToneMapContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewHDRProcessor().ToneMapContext(ctx, createRadianceMap(8, 8)); err == nil {
+		t.Error("Expected error for canceled context, got nil")
+	}
+}
+
+func BenchmarkToneMap(b *testing.B) {
+	m := createRadianceMap(2048, 1365)
+
+	for _, mapper := range ToneMappers {
+		b.Run(mapper+"/reference", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var tm tmo.ToneMappingOperator
+				if mapper == "drago03" {
+					tm = tmo.NewDrago03(m, 1.0)
+				} else {
+					tm = tmo.NewReinhard05(m, 1.0, 0.0, 1.0)
+				}
+				tm.Perform()
+			}
+		})
+		b.Run(mapper+"/tiled", func(b *testing.B) {
+			p := NewHDRProcessor(WithToneMapper(mapper))
+			for i := 0; i < b.N; i++ {
+				if _, err := p.ToneMap(m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
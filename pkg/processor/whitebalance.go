@@ -0,0 +1,241 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/harperreed/hdarrrr/internal/parallel"
+	"github.com/harperreed/hdarrrr/pkg/colorspace"
+	"github.com/mdouchement/hdr"
+)
+
+// WhiteBalances lists the ways of white balancing the radiance map:
+// "temperature" corrects a light of a given color temperature and tint,
+// "gray-point" makes the pixel at a given point neutral, "gray-world"
+// makes the average color neutral and "white-patch" the brightest pixels.
+var WhiteBalances = []string{"temperature", "gray-point", "gray-world", "white-patch"}
+
+// DefaultTemperature is the color temperature of the light corrected by the
+// temperature white balance unless set, in kelvins
+const DefaultTemperature = 6500
+
+// grayPointRadius is the radius of the window averaged around a gray point
+const grayPointRadius = 2
+
+// whitePatchFraction is the fraction of the brightest pixels averaged by
+// the white-patch estimate
+const whitePatchFraction = 0.01
+
+// whitePatchBins is the number of luminance bins of the white-patch estimate
+const whitePatchBins = 1024
+
+// whiteBalance holds the white balance settings
+type whiteBalance struct {
+	// mode is one of WhiteBalances, or empty to leave colors unchanged
+	mode        string
+	temperature float64
+	tint        float64
+	point       image.Point
+	// adaptation is one of colorspace.Adaptations
+	adaptation string
+}
+
+// WhiteBalance returns the white balance mode, or an empty string when
+// colors are left unchanged
+func (p *HDRProcessor) WhiteBalance() string {
+	return p.wb.mode
+}
+
+// check validates the white balance settings
+func (w whiteBalance) check() error {
+	if !slices.Contains(WhiteBalances, w.mode) {
+		return fmt.Errorf("unsupported white balance: %s", w.mode)
+	}
+	if !slices.Contains(colorspace.Adaptations, w.adaptation) {
+		return fmt.Errorf("unsupported chromatic adaptation: %s (%s)", w.adaptation, strings.Join(colorspace.Adaptations, ", "))
+	}
+	if w.mode == "temperature" && (w.temperature < colorspace.MinTemperature || w.temperature > colorspace.MaxTemperature) {
+		return fmt.Errorf("invalid color temperature: %vK is not between %dK and %dK",
+			w.temperature, colorspace.MinTemperature, colorspace.MaxTemperature)
+	}
+	return nil
+}
+
+// balance returns a white balanced copy of the radiance map m, or m itself
+// without white balance
+func (p *HDRProcessor) balance(ctx context.Context, m *hdr.RGB) (*hdr.RGB, error) {
+	if p.wb.mode == "" {
+		return m, nil
+	}
+	t, err := p.whiteBalanceTransform(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	out := hdr.NewRGB(m.Bounds())
+	err = parallel.Run(ctx, p.tiles(m.Bounds()), p.workers, func(_ int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			balanceRow(t, rowOf(m, r, y), rowOf(out, r, y))
+		}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// balanceRow applies the white balance t to the linear pixels of src,
+// clipping the channels that fall below zero
+func balanceRow(t *colorspace.Transform, src, dst []float32) {
+	for j := 0; j < len(src); j += 3 {
+		r, g, b := t.ConvertLinear(float64(src[j]), float64(src[j+1]), float64(src[j+2]))
+		dst[j], dst[j+1], dst[j+2] = float32(max(r, 0)), float32(max(g, 0)), float32(max(b, 0))
+	}
+}
+
+// whiteBalanceTransform returns the transform correcting the light of the
+// radiance map m. Only the estimating modes read m.
+func (p *HDRProcessor) whiteBalanceTransform(ctx context.Context, m *hdr.RGB) (*colorspace.Transform, error) {
+	if err := p.wb.check(); err != nil {
+		return nil, err
+	}
+	var x, y float64
+	if p.wb.mode == "temperature" {
+		x, y = colorspace.Planckian(p.wb.temperature, p.wb.tint)
+	} else {
+		light, err := p.estimateLight(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if light[0] <= 0 || light[1] <= 0 || light[2] <= 0 {
+			return nil, fmt.Errorf("cannot white balance on the %s: a channel is black", p.wb.mode)
+		}
+		x, y = p.working.Chromaticity(light[0], light[1], light[2])
+	}
+	return p.working.WhiteBalance(x, y, p.wb.adaptation)
+}
+
+// estimateLight returns the color of a neutral surface of the radiance map
+// under its light, as found by the estimating white balance modes
+func (p *HDRProcessor) estimateLight(ctx context.Context, m *hdr.RGB) ([3]float64, error) {
+	bounds := m.Bounds()
+	switch p.wb.mode {
+	case "gray-point":
+		if !p.wb.point.In(bounds) {
+			return [3]float64{}, fmt.Errorf("gray point %v is outside the image %v", p.wb.point, bounds)
+		}
+		window := image.Rectangle{p.wb.point, p.wb.point.Add(image.Pt(1, 1))}.
+			Inset(-grayPointRadius).Intersect(bounds)
+		sum := sumRect(m, window)
+		return sum.mean(), nil
+	case "gray-world":
+		tiles := p.tiles(bounds)
+		sums := make([]pixelSum, len(tiles))
+		err := parallel.Run(ctx, tiles, p.workers, func(i int, r image.Rectangle) {
+			sums[i] = sumRect(m, r)
+		}, nil)
+		if err != nil {
+			return [3]float64{}, err
+		}
+		var total pixelSum
+		for _, s := range sums {
+			total.merge(s)
+		}
+		return total.mean(), nil
+	default:
+		return p.whitePatch(ctx, m)
+	}
+}
+
+// whitePatch returns the average color of the brightest pixels of m
+func (p *HDRProcessor) whitePatch(ctx context.Context, m *hdr.RGB) ([3]float64, error) {
+	// Find the brightest luminance, then bin the pixels by luminance to
+	// average the top whitePatchFraction of them
+	tiles := p.tiles(m.Bounds())
+	peaks := make([]float64, len(tiles))
+	err := parallel.Run(ctx, tiles, p.workers, func(i int, r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := rowOf(m, r, y)
+			for j := 0; j < len(row); j += 3 {
+				peaks[i] = max(peaks[i], p.working.Luminance(float64(row[j]), float64(row[j+1]), float64(row[j+2])))
+			}
+		}
+	}, nil)
+	if err != nil {
+		return [3]float64{}, err
+	}
+	peak := slices.Max(peaks)
+	if peak <= 0 {
+		return [3]float64{}, errors.New("cannot white balance on the white-patch: the image is black")
+	}
+
+	bins := make([][]pixelSum, len(tiles))
+	err = parallel.Run(ctx, tiles, p.workers, func(i int, r image.Rectangle) {
+		bins[i] = make([]pixelSum, whitePatchBins)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := rowOf(m, r, y)
+			for j := 0; j < len(row); j += 3 {
+				l := p.working.Luminance(float64(row[j]), float64(row[j+1]), float64(row[j+2]))
+				bin := min(int(max(l, 0)/peak*whitePatchBins), whitePatchBins-1)
+				bins[i][bin].add(row[j : j+3])
+			}
+		}
+	}, nil)
+	if err != nil {
+		return [3]float64{}, err
+	}
+
+	var top pixelSum
+	want := int(math.Ceil(whitePatchFraction * float64(m.Bounds().Dx()*m.Bounds().Dy())))
+	for bin := whitePatchBins - 1; bin >= 0 && top.count < want; bin-- {
+		for _, b := range bins {
+			top.merge(b[bin])
+		}
+	}
+	return top.mean(), nil
+}
+
+// pixelSum accumulates the colors of pixels
+type pixelSum struct {
+	rgb   [3]float64
+	count int
+}
+
+func (s *pixelSum) add(px []float32) {
+	s.rgb[0] += float64(px[0])
+	s.rgb[1] += float64(px[1])
+	s.rgb[2] += float64(px[2])
+	s.count++
+}
+
+func (s *pixelSum) merge(o pixelSum) {
+	for c := range s.rgb {
+		s.rgb[c] += o.rgb[c]
+	}
+	s.count += o.count
+}
+
+// mean returns the average color, or black without pixels
+func (s pixelSum) mean() [3]float64 {
+	if s.count == 0 {
+		return [3]float64{}
+	}
+	n := float64(s.count)
+	return [3]float64{s.rgb[0] / n, s.rgb[1] / n, s.rgb[2] / n}
+}
+
+// sumRect sums the pixels of m in r
+func sumRect(m *hdr.RGB, r image.Rectangle) pixelSum {
+	var s pixelSum
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := rowOf(m, r, y)
+		for j := 0; j < len(row); j += 3 {
+			s.add(row[j : j+3])
+		}
+	}
+	return s
+}
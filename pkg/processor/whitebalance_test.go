@@ -0,0 +1,184 @@
+package processor
+
+import (
+	"context"
+	"image"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// castLight is the color of the light casting over the test scenes
+var castLight = hdrcolor.RGB{R: 1, G: 0.8, B: 0.5}
+
+// createCastScene returns a radiance map of gray surfaces of increasing
+// brightness under castLight, with a saturated red column on the left
+func createCastScene(width, height int) *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray := 0.05 + 0.5*float64(x)/float64(width)
+			c := hdrcolor.RGB{R: gray * castLight.R, G: gray * castLight.G, B: gray * castLight.B}
+			if x == 0 {
+				c = hdrcolor.RGB{R: 0.3, G: 0.02, B: 0.02}
+			}
+			m.SetRGB(x, y, c)
+		}
+	}
+	return m
+}
+
+// chroma returns how far a color is from neutral, relative to its level
+func chroma(r, g, b float64) float64 {
+	return (max(r, g, b) - min(r, g, b)) / max(r, g, b)
+}
+
+func TestBalanceNeutralizesCast(t *testing.T) {
+	m := createCastScene(40, 20)
+
+	tests := []struct {
+		name string
+		opts []Option
+		// tolerance is the chroma left on the grays
+		tolerance float64
+	}{
+		{"gray point", []Option{WithGrayPoint(20, 10)}, 0.001},
+		{"gray point cat02", []Option{WithGrayPoint(20, 10), WithAdaptation("cat02")}, 0.001},
+		// The red column biases the gray-world estimate slightly
+		{"gray world", []Option{WithWhiteBalance("gray-world")}, 0.05},
+		{"white patch", []Option{WithWhiteBalance("white-patch")}, 0.001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewHDRProcessor(tt.opts...)
+			got, err := p.balance(context.Background(), m)
+			if err != nil {
+				t.Fatalf("balance failed: %v", err)
+			}
+			if got == m {
+				t.Fatal("Expected a copy of the radiance map")
+			}
+			for _, x := range []int{5, 20, 39} {
+				r, g, b, _ := got.HDRAt(x, 10).HDRRGBA()
+				if c := chroma(r, g, b); c > tt.tolerance {
+					t.Errorf("Expected a neutral gray at x=%d, got (%.3f, %.3f, %.3f)", x, r, g, b)
+				}
+			}
+			if r, g, b, _ := got.HDRAt(0, 10).HDRRGBA(); r <= g || r <= b {
+				t.Errorf("Expected the red column to stay red, got (%.3f, %.3f, %.3f)", r, g, b)
+			}
+		})
+	}
+}
+
+func TestBalanceTemperature(t *testing.T) {
+	m := createCastScene(8, 4)
+	before := append([]float32(nil), m.Pix...)
+
+	// Correcting a warm light makes the image cooler, and a cool light
+	// warmer
+	ratio := func(kelvin float64) float64 {
+		got, err := NewHDRProcessor(WithTemperature(kelvin, 0)).balance(context.Background(), m)
+		if err != nil {
+			t.Fatalf("balance failed: %v", err)
+		}
+		r, _, b, _ := got.HDRAt(4, 2).HDRRGBA()
+		return r / b
+	}
+	orig := float64(castLight.R / castLight.B)
+	if warm, cool := ratio(3000), ratio(10000); warm >= orig || cool <= orig {
+		t.Errorf("Expected red/blue below %.2f for 3000K and above for 10000K, got %.2f and %.2f", orig, warm, cool)
+	}
+	for i := range before {
+		if m.Pix[i] != before[i] {
+			t.Fatal("Expected the radiance map to be left unchanged")
+		}
+	}
+
+	// Tint corrects along green and magenta
+	got, err := NewHDRProcessor(WithTemperature(6500, 50)).balance(context.Background(), m)
+	if err != nil {
+		t.Fatalf("balance failed: %v", err)
+	}
+	r, g, b, _ := got.HDRAt(4, 2).HDRRGBA()
+	r0, g0, b0, _ := m.HDRAt(4, 2).HDRRGBA()
+	if g/math.Sqrt(r*b) >= g0/math.Sqrt(r0*b0) {
+		t.Errorf("Expected a green tint to be corrected towards magenta, got (%.3f, %.3f, %.3f)", r, g, b)
+	}
+}
+
+func TestBalanceDisabled(t *testing.T) {
+	m := createCastScene(8, 4)
+	p := NewHDRProcessor()
+	if p.WhiteBalance() != "" {
+		t.Errorf("Expected no white balance by default, got %q", p.WhiteBalance())
+	}
+	if got, err := p.balance(context.Background(), m); err != nil || got != m {
+		t.Errorf("Expected the radiance map itself, got %p, %v", got, err)
+	}
+}
+
+func TestBalanceErrors(t *testing.T) {
+	black := hdr.NewRGB(image.Rect(0, 0, 8, 8))
+
+	tests := []struct {
+		name    string
+		m       *hdr.RGB
+		opts    []Option
+		wantErr string
+	}{
+		{"unknown mode", createCastScene(8, 8), []Option{WithWhiteBalance("auto")}, "unsupported white balance"},
+		{"unknown adaptation", createCastScene(8, 8), []Option{WithWhiteBalance("gray-world"), WithAdaptation("xyz")}, "chromatic adaptation"},
+		{"temperature too low", createCastScene(8, 8), []Option{WithTemperature(1000, 0)}, "color temperature"},
+		{"gray point outside", createCastScene(8, 8), []Option{WithGrayPoint(8, 2)}, "outside the image"},
+		{"black gray point", createCastScene(8, 8), []Option{WithGrayPoint(0, 0)}, "a channel is black"},
+		{"black gray world", black, []Option{WithWhiteBalance("gray-world")}, "a channel is black"},
+		{"black white patch", black, []Option{WithWhiteBalance("white-patch")}, "image is black"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "black gray point" {
+				// A window with no blue at all
+				for y := 0; y < 3; y++ {
+					for x := 0; x < 3; x++ {
+						tt.m.SetRGB(x, y, hdrcolor.RGB{R: 0.3, G: 0.2})
+					}
+				}
+			}
+			_, err := NewHDRProcessor(tt.opts...).balance(context.Background(), tt.m)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRunStreamingWhiteBalance(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeGradientImages(t, dir, 30, 80)
+
+	opts := []Option{WithTemperature(4000, 10), WithAdaptation("cat02")}
+	want := filepath.Join(dir, "run.png")
+	if err := NewHDRProcessor(opts...).Run(want, inputs...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	got := filepath.Join(dir, "stream.png")
+	p := NewHDRProcessor(append(opts, WithMemoryBudget(24*30*(12*4+8)))...)
+	if err := p.RunStreaming(got, inputs...); err != nil {
+		t.Fatalf("RunStreaming failed: %v", err)
+	}
+	if diff := maxChannelDiff(decodePNG(t, want), decodePNG(t, got)); diff > 2 {
+		t.Errorf("Streamed output differs from Run by %d", diff)
+	}
+
+	err := NewHDRProcessor(WithWhiteBalance("gray-world")).RunStreaming(filepath.Join(dir, "gw.png"), inputs...)
+	if err == nil || !strings.Contains(err.Error(), "not supported in streaming mode") {
+		t.Errorf("Expected gray-world to be rejected in streaming mode, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package progress reports how far the HDR pipeline stages have advanced.
+//
+// A Reporter is carried through a context.Context so every package of the
+// pipeline can report progress without changing its function signatures.
+package progress
+
+import "context"
+
+// Stage names reported by the pipeline
+const (
+	StageLoad      = "load"
+	StageLinearize = "linearize"
+	StageAlign     = "align"
+	StageMerge     = "merge"
+	StageToneMap   = "tonemap"
+	StageSave      = "save"
+	// StageDenoise reduces the noise of the merged radiance map
+	StageDenoise = "denoise"
+	// StageDebug renders the diagnostic images of the merge
+	StageDebug = "debug"
+	// StageStream covers the whole pipeline when it runs band by band
+	StageStream = "stream"
+)
+
+// Reporter receives progress updates. Fraction is in [0, 1] and reaches 1
+// when the stage completes. Implementations must be safe for concurrent use.
+type Reporter interface {
+	Report(stage string, fraction float64)
+}
+
+// ReporterFunc adapts a function to the Reporter interface
+type ReporterFunc func(stage string, fraction float64)
+
+// Report calls f(stage, fraction)
+func (f ReporterFunc) Report(stage string, fraction float64) {
+	f(stage, fraction)
+}
+
+type discard struct{}
+
+func (discard) Report(string, float64) {}
+
+// Discard is a Reporter that ignores all updates
+var Discard Reporter = discard{}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the reporter r
+func NewContext(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the Reporter carried by ctx, or Discard if there is none
+func FromContext(ctx context.Context) Reporter {
+	if r, ok := ctx.Value(contextKey{}).(Reporter); ok && r != nil {
+		return r
+	}
+	return Discard
+}
+
+// HasReporter reports whether ctx carries a Reporter
+func HasReporter(ctx context.Context) bool {
+	r, ok := ctx.Value(contextKey{}).(Reporter)
+	return ok && r != nil
+}
+
+// Step reports done/total completion of stage to the Reporter in ctx
+func Step(ctx context.Context, stage string, done, total int) {
+	if total <= 0 {
+		return
+	}
+	FromContext(ctx).Report(stage, float64(done)/float64(total))
+}
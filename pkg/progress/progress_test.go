@@ -0,0 +1,41 @@
+package progress
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Discard {
+		t.Error("Expected Discard reporter for empty context")
+	}
+
+	var gotStage string
+	var gotFraction float64
+	r := ReporterFunc(func(stage string, fraction float64) {
+		gotStage = stage
+		gotFraction = fraction
+	})
+
+	ctx := NewContext(context.Background(), r)
+	if !HasReporter(ctx) {
+		t.Fatal("Expected context to carry a reporter")
+	}
+
+	Step(ctx, StageMerge, 1, 4)
+	if gotStage != StageMerge || gotFraction != 0.25 {
+		t.Errorf("Expected (merge, 0.25), got (%s, %.2f)", gotStage, gotFraction)
+	}
+}
+
+func TestStepIgnoresEmptyTotal(t *testing.T) {
+	called := false
+	ctx := NewContext(context.Background(), ReporterFunc(func(string, float64) {
+		called = true
+	}))
+
+	Step(ctx, StageLoad, 0, 0)
+	if called {
+		t.Error("Expected no report for zero total")
+	}
+}
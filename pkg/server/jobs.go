@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+)
+
+// handleSubmit queues an uploaded bracket as a job
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp(s.tempDir, "hdarrrr-")
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	j, received, ok := s.receive(w, r, dir)
+	if !ok {
+		return
+	}
+	queued, err := s.jobs.Submit(jobs.Spec{Settings: j.cfg, Format: j.format}, j.paths)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.metrics.transfer(received, 0)
+	s.metrics.request(http.StatusAccepted)
+	w.Header().Set("Location", "/v1/jobs/"+queued.ID)
+	writeJSON(w, http.StatusAccepted, queued)
+}
+
+// handleListJobs answers with all jobs, oldest first
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	list, err := s.jobs.List()
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	if list == nil {
+		list = []*jobs.Job{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGetJob answers with the record of a job
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	j, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// handleJobResult answers with the output of a job that succeeded
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	j, path, err := s.jobs.Result(r.PathValue("id"))
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	sent, err := s.sendFile(w, path, j.Format, j.Warnings)
+	if err == nil {
+		s.metrics.transfer(0, sent)
+	}
+}
+
+// handleCancelJob cancels a queued or running job
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	j, err := s.jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// handleDeleteJob removes a finished job
+func (s *Server) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	if err := s.jobs.Delete(r.PathValue("id")); err != nil {
+		jobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jobError answers with the status matching a job API error
+func jobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, jobs.ErrFinished), errors.Is(err, jobs.ErrActive), errors.Is(err, jobs.ErrNotReady):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+)
+
+// newJobServer creates a server serving the job API of a new store
+func newJobServer(t *testing.T) (*Server, *jobs.Manager) {
+	t.Helper()
+	store, err := jobs.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := jobs.NewManager(store)
+	return New(WithTempDir(t.TempDir()), WithJobs(m)), m
+}
+
+// do serves req and decodes the JSON answer into v unless it is nil
+func do(t *testing.T, s *Server, req *http.Request, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+		}
+	}
+	return rec
+}
+
+func TestJobLifecycle(t *testing.T) {
+	s, m := newJobServer(t)
+
+	var submitted jobs.Job
+	rec := do(t, s, uploadRequest(t, "/v1/jobs", bracketPNGs(t), map[string]string{"params": `{"preset": "natural"}`}), &submitted)
+	if rec.Code != http.StatusAccepted || submitted.State != jobs.StateQueued {
+		t.Fatalf("Expected 202 and a queued job, got %d %+v", rec.Code, submitted)
+	}
+	location := rec.Header().Get("Location")
+	if location != "/v1/jobs/"+submitted.ID {
+		t.Errorf("Unexpected Location %q", location)
+	}
+
+	// The result is not ready while the job is queued
+	rec = do(t, s, httptest.NewRequest(http.MethodGet, location+"/result", nil), nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 before the job ran, got %d", rec.Code)
+	}
+
+	if err := m.RunPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var status jobs.Job
+	do(t, s, httptest.NewRequest(http.MethodGet, location, nil), &status)
+	if status.State != jobs.StateSucceeded || status.Progress != 1 || status.Settings.Preset != "natural" {
+		t.Errorf("Unexpected job %+v", status)
+	}
+
+	rec = do(t, s, httptest.NewRequest(http.MethodGet, location+"/result", nil), nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Expected a JPEG result, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if _, err := jpeg.Decode(rec.Body); err != nil {
+		t.Error(err)
+	}
+
+	var list []jobs.Job
+	do(t, s, httptest.NewRequest(http.MethodGet, "/v1/jobs", nil), &list)
+	if len(list) != 1 || list[0].ID != submitted.ID {
+		t.Errorf("Expected the job in the list, got %+v", list)
+	}
+
+	rec = do(t, s, httptest.NewRequest(http.MethodPost, location+"/cancel", nil), nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 canceling a finished job, got %d", rec.Code)
+	}
+	rec = do(t, s, httptest.NewRequest(http.MethodDelete, location, nil), nil)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	rec = do(t, s, httptest.NewRequest(http.MethodGet, location, nil), nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted job, got %d", rec.Code)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	s, _ := newJobServer(t)
+
+	var submitted jobs.Job
+	do(t, s, uploadRequest(t, "/v1/jobs", bracketPNGs(t), nil), &submitted)
+
+	var canceled jobs.Job
+	rec := do(t, s, httptest.NewRequest(http.MethodPost, "/v1/jobs/"+submitted.ID+"/cancel", nil), &canceled)
+	if rec.Code != http.StatusAccepted || canceled.State != jobs.StateCanceled {
+		t.Errorf("Expected a canceled job, got %d %+v", rec.Code, canceled)
+	}
+}
+
+func TestJobErrors(t *testing.T) {
+	s, _ := newJobServer(t)
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantCode int
+	}{
+		{"single image", uploadRequest(t, "/v1/jobs", bracketPNGs(t)[:1], nil), http.StatusBadRequest},
+		{"unknown format", uploadRequest(t, "/v1/jobs?format=gif", bracketPNGs(t), nil), http.StatusBadRequest},
+		{"unknown job", httptest.NewRequest(http.MethodGet, "/v1/jobs/0123456789abcdef", nil), http.StatusNotFound},
+		{"invalid id", httptest.NewRequest(http.MethodGet, "/v1/jobs/..%2f..%2fetc/result", nil), http.StatusNotFound},
+		{"cancel unknown job", httptest.NewRequest(http.MethodPost, "/v1/jobs/0123456789abcdef/cancel", nil), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body struct{ Error string }
+			rec := do(t, s, tt.req, &body)
+			if rec.Code != tt.wantCode || body.Error == "" {
+				t.Errorf("Expected %d and an error message, got %d %q", tt.wantCode, rec.Code, body.Error)
+			}
+		})
+	}
+}
+
+func TestJobsDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New().ServeHTTP(rec, uploadRequest(t, "/v1/jobs", bracketPNGs(t), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a job manager, got %d", rec.Code)
+	}
+}
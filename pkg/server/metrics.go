@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the job duration histogram, in
+// seconds
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metrics counts requests and jobs, and writes them in the Prometheus text
+// exposition format
+type metrics struct {
+	mu sync.Mutex
+	// requests counts the answered processing requests by status code
+	requests map[int]int64
+	// rejected counts the requests turned away by reason
+	rejected map[string]int64
+	running  int64
+	queued   int64
+	// buckets counts the job durations up to each of durationBuckets
+	buckets       []int64
+	durationSum   float64
+	durationCount int64
+	bytesIn       int64
+	bytesOut      int64
+}
+
+// newMetrics creates empty metrics
+func newMetrics() *metrics {
+	return &metrics{
+		requests: map[int]int64{},
+		rejected: map[string]int64{},
+		buckets:  make([]int64, len(durationBuckets)),
+	}
+}
+
+// request records the status code of an answered request
+func (m *metrics) request(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[code]++
+}
+
+// reject records a request turned away for reason
+func (m *metrics) reject(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejected[reason]++
+}
+
+// addQueued and addRunning move the gauges of waiting and running jobs
+func (m *metrics) addQueued(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued += delta
+}
+
+func (m *metrics) addRunning(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running += delta
+}
+
+// job records the duration of a processed job
+func (m *metrics) job(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// transfer records the bytes uploaded and sent back for a request
+func (m *metrics) transfer(in, out int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesIn += in
+	m.bytesOut += out
+}
+
+// gauges returns the number of waiting and running jobs
+func (m *metrics) gauges() (queued, running int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queued, m.running
+}
+
+// write prints the metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP hdarrrr_requests_total Processing requests answered, by status code.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_requests_total counter")
+	for _, code := range slices.Sorted(maps.Keys(m.requests)) {
+		fmt.Fprintf(w, "hdarrrr_requests_total{code=\"%d\"} %d\n", code, m.requests[code])
+	}
+
+	fmt.Fprintln(w, "# HELP hdarrrr_requests_rejected_total Processing requests turned away, by reason.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_requests_rejected_total counter")
+	for _, reason := range slices.Sorted(maps.Keys(m.rejected)) {
+		fmt.Fprintf(w, "hdarrrr_requests_rejected_total{reason=%q} %d\n", reason, m.rejected[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP hdarrrr_jobs_running Jobs being processed.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_jobs_running gauge")
+	fmt.Fprintf(w, "hdarrrr_jobs_running %d\n", m.running)
+	fmt.Fprintln(w, "# HELP hdarrrr_jobs_queued Jobs waiting for a processing slot.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_jobs_queued gauge")
+	fmt.Fprintf(w, "hdarrrr_jobs_queued %d\n", m.queued)
+
+	fmt.Fprintln(w, "# HELP hdarrrr_job_duration_seconds Time spent processing a job.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_job_duration_seconds histogram")
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(w, "hdarrrr_job_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "hdarrrr_job_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "hdarrrr_job_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "hdarrrr_job_duration_seconds_count %d\n", m.durationCount)
+
+	fmt.Fprintln(w, "# HELP hdarrrr_received_bytes_total Bytes of images uploaded.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_received_bytes_total counter")
+	fmt.Fprintf(w, "hdarrrr_received_bytes_total %d\n", m.bytesIn)
+	fmt.Fprintln(w, "# HELP hdarrrr_sent_bytes_total Bytes of results sent.")
+	fmt.Fprintln(w, "# TYPE hdarrrr_sent_bytes_total counter")
+	fmt.Fprintf(w, "hdarrrr_sent_bytes_total %d\n", m.bytesOut)
+}
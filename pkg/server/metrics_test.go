@@ -0,0 +1,47 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetrics()
+	m.request(200)
+	m.request(200)
+	m.request(503)
+	m.reject("queue_full")
+	m.addQueued(2)
+	m.addRunning(1)
+	m.job(300 * time.Millisecond)
+	m.job(20 * time.Second)
+	m.transfer(1000, 250)
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		`hdarrrr_requests_total{code="200"} 2`,
+		`hdarrrr_requests_total{code="503"} 1`,
+		`hdarrrr_requests_rejected_total{reason="queue_full"} 1`,
+		"hdarrrr_jobs_queued 2",
+		"hdarrrr_jobs_running 1",
+		// Buckets are cumulative
+		`hdarrrr_job_duration_seconds_bucket{le="0.25"} 0`,
+		`hdarrrr_job_duration_seconds_bucket{le="0.5"} 1`,
+		`hdarrrr_job_duration_seconds_bucket{le="30"} 2`,
+		`hdarrrr_job_duration_seconds_bucket{le="+Inf"} 2`,
+		"hdarrrr_job_duration_seconds_sum 20.3",
+		"hdarrrr_job_duration_seconds_count 2",
+		"hdarrrr_received_bytes_total 1000",
+		"hdarrrr_sent_bytes_total 250",
+		"# TYPE hdarrrr_job_duration_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+}
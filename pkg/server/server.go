@@ -0,0 +1,460 @@
+// Package server exposes the HDR pipeline as an HTTP service.
+//
+// Clients POST a bracket to /v1/process as multipart/form-data: one "images"
+// part per exposure, in any order, an optional "params" part holding
+// pipeline settings in the JSON form of a config file (see package config)
+// and an optional "format" field. The response is the tone mapped image, or
+// the radiance map for the hdr and pfm formats.
+//
+// Jobs run at most Concurrency at a time; a bounded number of requests wait
+// for a slot and the others are turned away with 503. /health reports the
+// state of the queue and /metrics exposes counters in the Prometheus text
+// format.
+//
+// With WithJobs, brackets can also be processed asynchronously through the
+// /v1/jobs endpoints: POST /v1/jobs takes the same form as /v1/process and
+// answers 202 Accepted with the job record, GET /v1/jobs/{id} reports its
+// state and progress, GET /v1/jobs/{id}/result returns the output once it
+// succeeded, POST /v1/jobs/{id}/cancel cancels it and DELETE /v1/jobs/{id}
+// removes a finished job. GET /v1/jobs lists all jobs.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/jobs"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+)
+
+// Defaults of the server limits
+const (
+	DefaultConcurrency     = 2
+	DefaultQueueSize       = 8
+	DefaultMaxRequestBytes = 256 << 20
+	DefaultMaxPixels       = 100_000_000
+)
+
+// MaxImages is the largest number of exposures accepted in a request
+const MaxImages = 16
+
+// maxParamsBytes bounds the size of the params part
+const maxParamsBytes = 1 << 20
+
+// errQueueFull is returned by acquire when no request may wait for a slot
+var errQueueFull = errors.New("too many jobs waiting, try again later")
+
+// errTooManyPixels is returned by readJob for images over the pixel limit
+var errTooManyPixels = errors.New("image too large")
+
+// Server answers the HTTP API. It implements http.Handler.
+type Server struct {
+	concurrency     int
+	queueSize       int
+	maxRequestBytes int64
+	maxPixels       int64
+	tempDir         string
+	procOpts        []processor.Option
+	jobs            *jobs.Manager
+
+	// slots holds a token for every running job
+	slots   chan struct{}
+	waiting atomic.Int64
+	metrics *metrics
+	mux     *http.ServeMux
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithConcurrency sets the number of jobs processed at once
+func WithConcurrency(n int) Option {
+	return func(s *Server) {
+		s.concurrency = max(1, n)
+	}
+}
+
+// WithQueueSize sets the number of requests that may wait for a processing
+// slot. Requests beyond it are answered with 503 Service Unavailable.
+func WithQueueSize(n int) Option {
+	return func(s *Server) {
+		s.queueSize = max(0, n)
+	}
+}
+
+// WithMaxRequestBytes bounds the size of a request body. Larger requests
+// are answered with 413 Request Entity Too Large.
+func WithMaxRequestBytes(n int64) Option {
+	return func(s *Server) {
+		s.maxRequestBytes = n
+	}
+}
+
+// WithMaxPixels bounds the width × height of every uploaded image, read from
+// its header before anything is decoded. Larger images are answered with
+// 413 Request Entity Too Large. Zero disables the limit.
+func WithMaxPixels(n int64) Option {
+	return func(s *Server) {
+		s.maxPixels = n
+	}
+}
+
+// WithTempDir sets the directory uploads are stored in while they are
+// processed (default the system temporary directory)
+func WithTempDir(dir string) Option {
+	return func(s *Server) {
+		s.tempDir = dir
+	}
+}
+
+// WithProcessorOptions sets processor options applied to every job before
+// the settings of the request
+func WithProcessorOptions(opts ...processor.Option) Option {
+	return func(s *Server) {
+		s.procOpts = append(s.procOpts, opts...)
+	}
+}
+
+// WithJobs serves the asynchronous job API backed by m. Running m is left
+// to the caller.
+func WithJobs(m *jobs.Manager) Option {
+	return func(s *Server) {
+		s.jobs = m
+	}
+}
+
+// New creates a Server. Unless processor options say otherwise, the CPUs
+// are shared evenly between the concurrent jobs.
+func New(opts ...Option) *Server {
+	s := &Server{
+		concurrency:     DefaultConcurrency,
+		queueSize:       DefaultQueueSize,
+		maxRequestBytes: DefaultMaxRequestBytes,
+		maxPixels:       DefaultMaxPixels,
+		metrics:         newMetrics(),
+		mux:             http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	workers := processor.WithWorkers(max(1, runtime.NumCPU()/s.concurrency))
+	s.procOpts = append([]processor.Option{workers}, s.procOpts...)
+	s.slots = make(chan struct{}, s.concurrency)
+
+	s.mux.HandleFunc("POST /v1/process", s.handleProcess)
+	if s.jobs != nil {
+		s.mux.HandleFunc("POST /v1/jobs", s.handleSubmit)
+		s.mux.HandleFunc("GET /v1/jobs", s.handleListJobs)
+		s.mux.HandleFunc("GET /v1/jobs/{id}", s.handleGetJob)
+		s.mux.HandleFunc("GET /v1/jobs/{id}/result", s.handleJobResult)
+		s.mux.HandleFunc("POST /v1/jobs/{id}/cancel", s.handleCancelJob)
+		s.mux.HandleFunc("DELETE /v1/jobs/{id}", s.handleDeleteJob)
+	}
+	s.mux.HandleFunc("GET /health", s.handleHealth)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// job is a parsed processing request
+type job struct {
+	paths  []string
+	cfg    config.Config
+	format string
+}
+
+// handleProcess runs the pipeline on an uploaded bracket
+func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp(s.tempDir, "hdarrrr-")
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	j, received, ok := s.receive(w, r, dir)
+	if !ok {
+		return
+	}
+
+	release, err := s.acquire(r.Context())
+	if err != nil {
+		if errors.Is(err, errQueueFull) {
+			s.metrics.reject("queue_full")
+			w.Header().Set("Retry-After", "5")
+			s.fail(w, http.StatusServiceUnavailable, err)
+		}
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	output, warnings, err := s.run(r.Context(), j, dir)
+	s.metrics.job(time.Since(start))
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		var inputErr *processor.InputError
+		if errors.As(err, &inputErr) {
+			s.fail(w, http.StatusUnprocessableEntity, err)
+		} else {
+			s.fail(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	sent, err := s.sendFile(w, output, j.format, warnings)
+	s.metrics.transfer(received, sent)
+	if err != nil {
+		return
+	}
+	s.metrics.request(http.StatusOK)
+}
+
+// receive reads the request into dir, enforcing the size limits. On
+// failure it answers the request and returns false.
+func (s *Server) receive(w http.ResponseWriter, r *http.Request, dir string) (job, int64, bool) {
+	if r.ContentLength > s.maxRequestBytes {
+		s.metrics.reject("too_large")
+		s.fail(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request larger than %d bytes", s.maxRequestBytes))
+		return job{}, 0, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxRequestBytes)
+
+	j, received, err := s.readJob(r, dir)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.metrics.reject("too_large")
+			s.fail(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request larger than %d bytes", s.maxRequestBytes))
+			return j, received, false
+		}
+		if errors.Is(err, errTooManyPixels) {
+			s.metrics.reject("too_many_pixels")
+			s.fail(w, http.StatusRequestEntityTooLarge, err)
+			return j, received, false
+		}
+		s.fail(w, http.StatusBadRequest, err)
+		return j, received, false
+	}
+	return j, received, true
+}
+
+// readJob stores the uploaded images in dir and parses the settings. It
+// returns the number of image bytes received.
+func (s *Server) readJob(r *http.Request, dir string) (job, int64, error) {
+	j := job{cfg: config.Default(), format: r.URL.Query().Get("format")}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return j, 0, err
+	}
+
+	var params []byte
+	var received int64
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return j, received, err
+		}
+
+		switch part.FormName() {
+		case "images":
+			if len(j.paths) == MaxImages {
+				return j, received, fmt.Errorf("more than %d images", MaxImages)
+			}
+			ext := strings.ToLower(filepath.Ext(part.FileName()))
+			if !imaging.SupportedFormats[ext] {
+				return j, received, fmt.Errorf("%s: unsupported image format (PNG, JPEG)", part.FileName())
+			}
+			path := filepath.Join(dir, fmt.Sprintf("%02d%s", len(j.paths)+1, ext))
+			n, err := saveFile(path, part)
+			received += n
+			if err != nil {
+				return j, received, err
+			}
+			if err := s.checkPixels(path, part.FileName()); err != nil {
+				return j, received, err
+			}
+			j.paths = append(j.paths, path)
+		case "params":
+			if params, err = io.ReadAll(io.LimitReader(part, maxParamsBytes+1)); err != nil {
+				return j, received, err
+			}
+			if len(params) > maxParamsBytes {
+				return j, received, fmt.Errorf("params larger than %d bytes", maxParamsBytes)
+			}
+		case "format":
+			value, err := io.ReadAll(io.LimitReader(part, 16))
+			if err != nil {
+				return j, received, err
+			}
+			j.format = strings.TrimSpace(string(value))
+		}
+		part.Close()
+	}
+
+	if len(j.paths) < 2 {
+		return j, received, errors.New("at least two images are required")
+	}
+	if j.format == "" {
+		j.format = "jpg"
+	}
+	j.format = strings.ToLower(j.format)
+	if _, ok := jobs.Formats[j.format]; !ok {
+		return j, received, fmt.Errorf("unsupported format %q (jpg, png, hdr, pfm)", j.format)
+	}
+	if len(params) > 0 {
+		if j.cfg, err = config.Parse(params, "json", ""); err != nil {
+			return j, received, fmt.Errorf("params: %w", err)
+		}
+	}
+	return j, received, nil
+}
+
+// checkPixels rejects the image at path if its header declares more pixels
+// than the limit. Unreadable headers are left for the pipeline to report.
+func (s *Server) checkPixels(path, name string) error {
+	if s.maxPixels <= 0 {
+		return nil
+	}
+	props, err := imaging.ReadImageProperties(path)
+	if err != nil {
+		return nil
+	}
+	if pixels := int64(props.Width) * int64(props.Height); pixels > s.maxPixels {
+		return fmt.Errorf("%w: %s is %dx%d, more than %d pixels", errTooManyPixels, name, props.Width, props.Height, s.maxPixels)
+	}
+	return nil
+}
+
+// saveFile copies r to a new file at path
+func saveFile(path string, r io.Reader) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// acquire waits for a processing slot and returns the function releasing
+// it. It fails with errQueueFull when too many requests are waiting.
+func (s *Server) acquire(ctx context.Context) (func(), error) {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		if s.waiting.Add(1) > int64(s.queueSize) {
+			s.waiting.Add(-1)
+			return nil, errQueueFull
+		}
+		s.metrics.addQueued(1)
+		defer func() {
+			s.waiting.Add(-1)
+			s.metrics.addQueued(-1)
+		}()
+
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.metrics.addRunning(1)
+	return func() {
+		s.metrics.addRunning(-1)
+		<-s.slots
+	}, nil
+}
+
+// run merges the bracket of j and saves the result in dir
+func (s *Server) run(ctx context.Context, j job, dir string) (string, []string, error) {
+	p := processor.NewHDRProcessor(append(s.procOpts, j.cfg.Options()...)...)
+	output := filepath.Join(dir, "output"+jobs.Formats[j.format].Ext)
+	warnings, err := jobs.Run(ctx, p, j.paths, output)
+	return output, warnings, err
+}
+
+// sendFile answers with the file at path and returns the bytes written
+func (s *Server) sendFile(w http.ResponseWriter, path, format string, warnings []string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err)
+		return 0, err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", jobs.Formats[format].ContentType)
+	h.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "hdr"+jobs.Formats[format].Ext))
+	for _, warning := range warnings {
+		h.Add("Hdarrrr-Warning", warning)
+	}
+	w.WriteHeader(http.StatusOK)
+	return io.Copy(w, file)
+}
+
+// fail answers a processing request with status and a JSON error message
+func (s *Server) fail(w http.ResponseWriter, status int, err error) {
+	s.metrics.request(status)
+	writeError(w, status, err)
+}
+
+// writeError answers with status and a JSON error message
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// handleHealth reports that the server is up and the state of the queue
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	queued, running := s.metrics.gauges()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":      "ok",
+		"running":     running,
+		"queued":      queued,
+		"concurrency": s.concurrency,
+	})
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.write(w)
+}
+
+// writeJSON answers with status and v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
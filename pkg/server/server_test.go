@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// bracketPNGs encodes a bracket of three 16x12 gradients, darkest first
+func bracketPNGs(t *testing.T) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	for _, exposure := range []float64{0.25, 1, 3} {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 12))
+		for y := 0; y < 12; y++ {
+			for x := 0; x < 16; x++ {
+				v := min(255, float64(x*16+y*4)*exposure)
+				img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, buf.Bytes())
+	}
+	return frames
+}
+
+// uploadRequest builds a multipart request with the images and the given
+// form fields
+func uploadRequest(t *testing.T, target string, images [][]byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, data := range images {
+		part, err := mw.CreateFormFile("images", string(rune('a'+i))+".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestProcess(t *testing.T) {
+	frames := bracketPNGs(t)
+	// Upload the frames out of order
+	frames[0], frames[2] = frames[2], frames[0]
+
+	tests := []struct {
+		name        string
+		target      string
+		fields      map[string]string
+		contentType string
+	}{
+		{"default jpeg", "/v1/process", nil, "image/jpeg"},
+		{"png with params", "/v1/process", map[string]string{"format": "png", "params": `{"preset": "dramatic", "tonemap": {"gamma": 0.9}}`}, "image/png"},
+		{"radiance map", "/v1/process?format=hdr", nil, "image/vnd.radiance"},
+	}
+
+	s := New(WithTempDir(t.TempDir()))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, uploadRequest(t, tt.target, frames, tt.fields))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Expected content type %s, got %s", tt.contentType, got)
+			}
+
+			var size image.Point
+			switch tt.contentType {
+			case "image/jpeg":
+				img, err := jpeg.Decode(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				size = img.Bounds().Size()
+			case "image/png":
+				img, err := png.Decode(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				size = img.Bounds().Size()
+			default:
+				size = image.Pt(16, 12)
+				if !strings.HasPrefix(rec.Body.String(), "#?") {
+					t.Error("Expected a Radiance RGBE file")
+				}
+			}
+			if size != image.Pt(16, 12) {
+				t.Errorf("Expected a 16x12 image, got %v", size)
+			}
+		})
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	frames := bracketPNGs(t)
+	small := &bytes.Buffer{}
+	png.Encode(small, image.NewGray(image.Rect(0, 0, 4, 4)))
+
+	tests := []struct {
+		name     string
+		server   *Server
+		images   [][]byte
+		fields   map[string]string
+		wantCode int
+	}{
+		{"single image", New(), frames[:1], nil, http.StatusBadRequest},
+		{"unknown format", New(), frames, map[string]string{"format": "gif"}, http.StatusBadRequest},
+		{"invalid params", New(), frames, map[string]string{"params": `{"tonemap": {"operator": "aces"}}`}, http.StatusBadRequest},
+		{"unknown param", New(), frames, map[string]string{"params": `{"sharpen": 1}`}, http.StatusBadRequest},
+		{"different sizes", New(), [][]byte{frames[0], small.Bytes()}, nil, http.StatusUnprocessableEntity},
+		{"corrupt image", New(), [][]byte{frames[0], []byte("not a png")}, nil, http.StatusUnprocessableEntity},
+		{"too large", New(WithMaxRequestBytes(512)), frames, nil, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.server.ServeHTTP(rec, uploadRequest(t, "/v1/process", tt.images, tt.fields))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			var body struct{ Error string }
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+				t.Errorf("Expected a JSON error message, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessTooManyPixels(t *testing.T) {
+	// A valid PNG header declaring a huge image, with no pixel data behind it
+	var header bytes.Buffer
+	header.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), 60000)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 60000)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0)
+	binary.Write(&header, binary.BigEndian, uint32(len(ihdr)-4))
+	header.Write(ihdr)
+	binary.Write(&header, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+
+	frames := bracketPNGs(t)
+	tests := []struct {
+		name     string
+		server   *Server
+		images   [][]byte
+		wantCode int
+	}{
+		{"oversized header", New(), [][]byte{frames[0], header.Bytes()}, http.StatusRequestEntityTooLarge},
+		{"over the limit", New(WithMaxPixels(16*12 - 1)), frames, http.StatusRequestEntityTooLarge},
+		{"at the limit", New(WithMaxPixels(16 * 12)), frames, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.server.ServeHTTP(rec, uploadRequest(t, "/v1/process", tt.images, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestProcessTooLargeStream(t *testing.T) {
+	// Without a content length the limit applies while reading
+	s := New(WithMaxRequestBytes(512))
+	req := uploadRequest(t, "/v1/process", bracketPNGs(t), nil)
+	req.ContentLength = -1
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProcessQueueFull(t *testing.T) {
+	s := New(WithConcurrency(1), WithQueueSize(0))
+	// Hold the only slot as a running job would
+	release, err := s.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, uploadRequest(t, "/v1/process", bracketPNGs(t), nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	release()
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, uploadRequest(t, "/v1/process", bracketPNGs(t), nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the slot is free, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := New(WithConcurrency(3))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var health struct {
+		Status      string
+		Concurrency int
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || health.Status != "ok" || health.Concurrency != 3 {
+		t.Errorf("Unexpected health: %d %+v", rec.Code, health)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := New()
+	frames := bracketPNGs(t)
+	for _, images := range [][][]byte{frames, frames[:1]} {
+		s.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/process", images, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		`hdarrrr_requests_total{code="200"} 1`,
+		`hdarrrr_requests_total{code="400"} 1`,
+		`hdarrrr_job_duration_seconds_count 1`,
+		`hdarrrr_jobs_running 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, out)
+		}
+	}
+}
+
+func TestProcessMethods(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/process", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
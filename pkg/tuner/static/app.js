@@ -0,0 +1,192 @@
+// Tone-map tuner: renders previews through the tuner API as the sliders
+// move, and exports the settings as a preset file or a CLI command.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+let info = null;
+let settings = {};
+let initial = {};
+let current = null;   // last render of the current settings
+let original = null;  // render of the initial settings
+let pending = null;   // debounce timer
+let request = null;   // AbortController of the running render
+
+function query(s) {
+  return new URLSearchParams({
+    operator: s.operator,
+    gamma: s.gamma,
+    intensity: s.intensity,
+    light: s.light,
+    preserve_color: s.preserve_color,
+    color_exponent: s.color_exponent,
+    saturation: s.saturation,
+    vibrance: s.vibrance,
+  }).toString();
+}
+
+async function fetchJSON(path, signal) {
+  const res = await fetch(path, { signal });
+  const body = await res.json();
+  if (!res.ok) {
+    throw new Error(body.error || res.statusText);
+  }
+  return body;
+}
+
+function slider(p) {
+  const label = document.createElement("label");
+  const value = document.createElement("span");
+  value.className = "value";
+  value.textContent = settings[p.name];
+  const input = document.createElement("input");
+  input.type = "range";
+  input.min = p.min;
+  input.max = p.max;
+  input.step = p.step;
+  input.value = settings[p.name];
+  input.addEventListener("input", () => {
+    settings[p.name] = Number(input.value);
+    value.textContent = input.value;
+    schedule();
+  });
+  label.append(p.label, value, input);
+  return label;
+}
+
+function buildSliders() {
+  $("params").replaceChildren(...(info.operators[settings.operator] || []).map(slider));
+  // The color exponent only applies to the ratio color preservation
+  const colors = info.colors.filter((p) => p.name !== "color_exponent" || settings.preserve_color === "ratio");
+  $("colors").replaceChildren(...colors.map(slider));
+}
+
+function schedule() {
+  $("before").checked = false;
+  clearTimeout(pending);
+  pending = setTimeout(render, 120);
+}
+
+async function render() {
+  if (request) {
+    request.abort();
+  }
+  request = new AbortController();
+  const signal = request.signal;
+  $("status").textContent = "Rendering…";
+  try {
+    current = await fetchJSON("api/render?" + query(settings), signal);
+    if (!original) {
+      original = current;
+    }
+    show(current);
+    $("status").textContent = `Rendered in ${current.millis} ms`;
+    const exp = await fetchJSON("api/export?" + query(settings), signal);
+    $("command").textContent = exp.command;
+  } catch (err) {
+    if (err.name !== "AbortError") {
+      $("status").textContent = "Error: " + err.message;
+    }
+  }
+}
+
+function show(r) {
+  $("preview").src = r.image;
+  drawHistogram(r.histogram);
+  const pct = (v) => (v * 100).toFixed(1) + "%";
+  $("clipping").textContent =
+    `Clipped shadows ${pct(r.histogram.shadows)}, highlights ${pct(r.histogram.highlights)}`;
+}
+
+function drawHistogram(h) {
+  const canvas = $("histogram");
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  const peak = Math.max(1, ...h.r, ...h.g, ...h.b, ...h.luma);
+  const bar = canvas.width / h.luma.length;
+  ctx.globalCompositeOperation = "lighter";
+  for (const [channel, color] of [["r", "#a33"], ["g", "#3a3"], ["b", "#33a"], ["luma", "#555"]]) {
+    ctx.fillStyle = color;
+    h[channel].forEach((count, i) => {
+      const height = (count / peak) * canvas.height;
+      ctx.fillRect(i * bar, canvas.height - height, bar, height);
+    });
+  }
+  ctx.globalCompositeOperation = "source-over";
+}
+
+async function download() {
+  try {
+    const exp = await fetchJSON("api/export?" + query(settings));
+    const blob = new Blob([exp.yaml], { type: "application/yaml" });
+    const a = document.createElement("a");
+    a.href = URL.createObjectURL(blob);
+    a.download = "hdarrrr.yaml";
+    a.click();
+    URL.revokeObjectURL(a.href);
+  } catch (err) {
+    $("status").textContent = "Error: " + err.message;
+  }
+}
+
+function apply(s) {
+  settings = { ...s };
+  $("operator").value = settings.operator;
+  $("preserve").value = settings.preserve_color;
+  buildSliders();
+  schedule();
+}
+
+async function init() {
+  info = await fetchJSON("api/info");
+  initial = { ...info.settings };
+  $("source").textContent =
+    `${info.sources.join(", ")} (${info.width}×${info.height}, preview ${info.preview.width}×${info.preview.height})`;
+
+  for (const name of Object.keys(info.operators).sort()) {
+    $("operator").append(new Option(name, name));
+  }
+  for (const name of info.preservations) {
+    $("preserve").append(new Option(name, name));
+  }
+  for (const name of Object.keys(info.presets).sort()) {
+    $("preset").append(new Option(name, name));
+  }
+
+  $("operator").addEventListener("change", () => {
+    settings.operator = $("operator").value;
+    buildSliders();
+    schedule();
+  });
+  $("preserve").addEventListener("change", () => {
+    settings.preserve_color = $("preserve").value;
+    buildSliders();
+    schedule();
+  });
+  $("preset").addEventListener("change", () => {
+    const p = info.presets[$("preset").value];
+    if (p) {
+      apply(p);
+    }
+  });
+  $("before").addEventListener("change", () => {
+    const r = $("before").checked ? original : current;
+    if (r) {
+      show(r);
+    }
+  });
+  $("reset").addEventListener("click", () => {
+    $("preset").value = "";
+    apply(initial);
+  });
+  $("download").addEventListener("click", download);
+  $("copy").addEventListener("click", () => {
+    navigator.clipboard.writeText($("command").textContent);
+  });
+
+  apply(initial);
+}
+
+init().catch((err) => {
+  $("status").textContent = "Error: " + err.message;
+});
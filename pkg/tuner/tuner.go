@@ -0,0 +1,397 @@
+// Package tuner serves a local web UI for tuning the tone mapping of a
+// radiance map. The page, embedded in the binary, shows a live preview with
+// a slider for every parameter of the selected operator, a histogram,
+// a toggle between the current and the initial settings, and exports the
+// settings as a config file or a CLI command.
+//
+// Previews are rendered from a copy of the radiance map shrunk to
+// DefaultPreviewSize pixels, so every slider move is answered quickly.
+//
+// The API behind the page:
+//
+//	GET /api/info     the source, operators, parameters, presets and initial settings
+//	GET /api/render   the preview and its histogram for the settings in the query
+//	GET /api/export   the settings in the query as YAML, JSON and a CLI command
+//
+// The settings in the query are operator, gamma, intensity, light,
+// preserve_color, color_exponent, saturation and vibrance.
+package tuner
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/imaging"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/mdouchement/hdr"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPreviewSize is the default longest edge of the preview, in pixels
+const DefaultPreviewSize = 1024
+
+// histogramBins is the number of bins of the preview histograms
+const histogramBins = 64
+
+// previewQuality is the JPEG quality of the previews sent to the page
+const previewQuality = 85
+
+//go:embed static
+var static embed.FS
+
+// Param describes the slider of a tone mapping parameter
+type Param struct {
+	// Name is the parameter's key in the config file and its CLI flag
+	Name  string  `json:"name"`
+	Label string  `json:"label"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Step  float64 `json:"step"`
+}
+
+// Operators lists the parameters each tone mapping operator uses, with the
+// range of values worth exploring
+var Operators = map[string][]Param{
+	"drago03": {
+		{Name: "gamma", Label: "Bias", Min: 0.05, Max: 1, Step: 0.01},
+	},
+	"reinhard05": {
+		{Name: "intensity", Label: "Brightness", Min: -4, Max: 4, Step: 0.05},
+		{Name: "light", Label: "Chromatic adaptation", Min: 0, Max: 1, Step: 0.01},
+		{Name: "gamma", Label: "Light adaptation", Min: 0, Max: 1, Step: 0.01},
+	},
+}
+
+// Colors lists the color parameters, shared by every operator, with the
+// range of values worth exploring. The color exponent only applies to the
+// ratio color preservation.
+var Colors = []Param{
+	{Name: "saturation", Label: "Saturation", Min: 0, Max: 2, Step: 0.01},
+	{Name: "vibrance", Label: "Vibrance", Min: -1, Max: 1, Step: 0.01},
+	{Name: "color_exponent", Label: "Color exponent", Min: 0.1, Max: 2, Step: 0.01},
+}
+
+// Tuner answers the UI and its API. It implements http.Handler.
+type Tuner struct {
+	sources     []string
+	radiance    hdr.Image
+	preview     *hdr.RGB
+	cfg         config.Config
+	previewSize int
+	procOpts    []processor.Option
+	mux         *http.ServeMux
+}
+
+// Option configures a Tuner
+type Option func(*Tuner)
+
+// WithPreviewSize sets the longest edge of the preview in pixels
+func WithPreviewSize(n int) Option {
+	return func(t *Tuner) {
+		if n > 0 {
+			t.previewSize = n
+		}
+	}
+}
+
+// WithProcessorOptions sets processor options applied to every preview
+// before its settings
+func WithProcessorOptions(opts ...processor.Option) Option {
+	return func(t *Tuner) {
+		t.procOpts = append(t.procOpts, opts...)
+	}
+}
+
+// New creates a Tuner for the radiance map m, starting from the settings
+// of cfg. Sources are the files m was read or merged from; they appear in
+// the exported command.
+func New(m hdr.Image, sources []string, cfg config.Config, opts ...Option) *Tuner {
+	t := &Tuner{
+		sources:     sources,
+		radiance:    m,
+		cfg:         cfg,
+		previewSize: DefaultPreviewSize,
+		mux:         http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.preview = imaging.ShrinkRadiance(m, t.previewSize)
+
+	files, _ := fs.Sub(static, "static")
+	t.mux.Handle("GET /", http.FileServerFS(files))
+	t.mux.HandleFunc("GET /api/info", t.handleInfo)
+	t.mux.HandleFunc("GET /api/render", t.handleRender)
+	t.mux.HandleFunc("GET /api/export", t.handleExport)
+	return t
+}
+
+// ServeHTTP implements http.Handler
+func (t *Tuner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mux.ServeHTTP(w, r)
+}
+
+// handleInfo describes the source and the choices offered by the page
+func (t *Tuner) handleInfo(w http.ResponseWriter, r *http.Request) {
+	presets := map[string]config.ToneMap{}
+	for _, name := range config.Presets() {
+		p, _ := config.Preset(name)
+		presets[name] = p.ToneMap
+	}
+	b, pb := t.radiance.Bounds(), t.preview.Bounds()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sources":       t.sources,
+		"width":         b.Dx(),
+		"height":        b.Dy(),
+		"preview":       map[string]int{"width": pb.Dx(), "height": pb.Dy()},
+		"settings":      t.cfg.ToneMap,
+		"operators":     Operators,
+		"colors":        Colors,
+		"preservations": processor.ColorPreservations,
+		"presets":       presets,
+	})
+}
+
+// histogram counts the pixels of an image by channel value
+type histogram struct {
+	R    []int `json:"r"`
+	G    []int `json:"g"`
+	B    []int `json:"b"`
+	Luma []int `json:"luma"`
+	// Shadows and Highlights are the fractions of pixels clipped to black
+	// (every channel) or to white (any channel)
+	Shadows    float64 `json:"shadows"`
+	Highlights float64 `json:"highlights"`
+}
+
+// render is the answer to /api/render
+type render struct {
+	// Image is the preview as a JPEG data URL
+	Image     string    `json:"image"`
+	Histogram histogram `json:"histogram"`
+	Millis    int64     `json:"millis"`
+}
+
+// handleRender tone maps the preview with the settings of the query
+func (t *Tuner) handleRender(w http.ResponseWriter, r *http.Request) {
+	cfg, err := t.settings(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	start := time.Now()
+	img, err := t.toneMap(r.Context(), cfg)
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: previewQuality}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, render{
+		Image:     "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Histogram: newHistogram(img),
+		Millis:    time.Since(start).Milliseconds(),
+	})
+}
+
+// toneMap renders the preview with cfg
+func (t *Tuner) toneMap(ctx context.Context, cfg config.Config) (image.Image, error) {
+	// Gray points are pixels of the radiance map; find them on the preview
+	if gp := cfg.WhiteBalance.GrayPoint; len(gp) == 2 {
+		b, pb := t.radiance.Bounds(), t.preview.Bounds()
+		cfg.WhiteBalance.GrayPoint = []int{
+			pb.Min.X + (gp[0]-b.Min.X)*pb.Dx()/b.Dx(),
+			pb.Min.Y + (gp[1]-b.Min.Y)*pb.Dy()/b.Dy(),
+		}
+	}
+	p := processor.NewHDRProcessor(append(t.procOpts, cfg.Options()...)...)
+	return p.ToneMapContext(ctx, t.preview)
+}
+
+// handleExport answers with the settings of the query as config files and a
+// CLI command
+func (t *Tuner) handleExport(w http.ResponseWriter, r *http.Request) {
+	cfg, err := t.settings(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// The exported file holds every setting, so it no longer depends on
+	// the preset it started from
+	cfg.Preset = ""
+	yamlData, err := yaml.Marshal(cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"yaml":    string(yamlData),
+		"json":    string(jsonData),
+		"command": Command(cfg, t.sources),
+	})
+}
+
+// settings returns the initial settings with the tone mapping parameters
+// of the query applied
+func (t *Tuner) settings(r *http.Request) (config.Config, error) {
+	cfg := t.cfg
+	q := r.URL.Query()
+	if op := q.Get("operator"); op != "" {
+		cfg.ToneMap.Operator = op
+	}
+	// An empty color preservation lets the operator map each channel
+	if q.Has("preserve_color") {
+		cfg.ToneMap.PreserveColor = q.Get("preserve_color")
+	}
+	for name, dst := range map[string]*float64{
+		"gamma":          &cfg.ToneMap.Gamma,
+		"intensity":      &cfg.ToneMap.Intensity,
+		"light":          &cfg.ToneMap.Light,
+		"color_exponent": &cfg.ToneMap.ColorExponent,
+		"saturation":     &cfg.ToneMap.Saturation,
+		"vibrance":       &cfg.ToneMap.Vibrance,
+	} {
+		if s := q.Get(name); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return cfg, fmt.Errorf("%s: invalid number %q", name, s)
+			}
+			*dst = v
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// Command returns the CLI command rendering sources with cfg: tonemap for
+// a radiance map, process for a bracket. Settings equal to the defaults
+// are left out.
+func Command(cfg config.Config, sources []string) string {
+	d := config.Default()
+	args := []string{"hdarrrr"}
+	flag := func(name, value string, isDefault bool) {
+		if !isDefault {
+			args = append(args, "-"+name, value)
+		}
+	}
+	number := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	if len(sources) == 1 && imaging.IsRadiance(sources[0]) {
+		args = append(args, "tonemap")
+	} else {
+		args = append(args, "process")
+		flag("align", cfg.Alignment.Method, cfg.Alignment.Method == d.Alignment.Method)
+		flag("merge", cfg.Merge.Method, cfg.Merge.Method == d.Merge.Method)
+	}
+	if cfg.Denoise.Method != "" {
+		args = append(args, "-denoise", cfg.Denoise.Method)
+		flag("denoise-strength", number(cfg.Denoise.Strength), cfg.Denoise.Strength == d.Denoise.Strength)
+		flag("denoise-shadows", number(cfg.Denoise.Shadows), cfg.Denoise.Shadows == d.Denoise.Shadows)
+	}
+	args = append(args, "-tonemapper", cfg.ToneMap.Operator)
+	flag("gamma", number(cfg.ToneMap.Gamma), cfg.ToneMap.Gamma == d.ToneMap.Gamma)
+	flag("intensity", number(cfg.ToneMap.Intensity), cfg.ToneMap.Intensity == d.ToneMap.Intensity)
+	flag("light", number(cfg.ToneMap.Light), cfg.ToneMap.Light == d.ToneMap.Light)
+	flag("preserve-color", cfg.ToneMap.PreserveColor, cfg.ToneMap.PreserveColor == d.ToneMap.PreserveColor)
+	flag("color-exponent", number(cfg.ToneMap.ColorExponent), cfg.ToneMap.ColorExponent == d.ToneMap.ColorExponent)
+	flag("saturation", number(cfg.ToneMap.Saturation), cfg.ToneMap.Saturation == d.ToneMap.Saturation)
+	flag("vibrance", number(cfg.ToneMap.Vibrance), cfg.ToneMap.Vibrance == d.ToneMap.Vibrance)
+	flag("quality", strconv.Itoa(cfg.Output.Quality), cfg.Output.Quality == d.Output.Quality)
+	wb := cfg.WhiteBalance
+	flag("white-balance", wb.Mode, wb.Mode == d.WhiteBalance.Mode)
+	switch wb.Mode {
+	case "temperature":
+		flag("temperature", number(wb.Temperature), false)
+		flag("tint", number(wb.Tint), wb.Tint == 0)
+	case "gray-point":
+		flag("gray-point", fmt.Sprintf("%d,%d", wb.GrayPoint[0], wb.GrayPoint[1]), false)
+	}
+	flag("adaptation", wb.Adaptation, wb.Adaptation == d.WhiteBalance.Adaptation)
+	flag("working-space", cfg.Color.Working, cfg.Color.Working == d.Color.Working)
+	flag("output-space", cfg.Color.Output, cfg.Color.Output == d.Color.Output)
+	args = append(args, "-output", "output.jpg")
+	args = append(args, sources...)
+
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s for a POSIX shell when it holds special characters
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// newHistogram counts the channel values of img
+func newHistogram(img image.Image) histogram {
+	h := histogram{
+		R:    make([]int, histogramBins),
+		G:    make([]int, histogramBins),
+		B:    make([]int, histogramBins),
+		Luma: make([]int, histogramBins),
+	}
+	b := img.Bounds()
+	var shadows, highlights int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			luma := (19595*r + 38470*g + 7471*bl + 1<<15) >> 16
+			h.R[r*histogramBins/0x10000]++
+			h.G[g*histogramBins/0x10000]++
+			h.B[bl*histogramBins/0x10000]++
+			h.Luma[luma*histogramBins/0x10000]++
+			if max(r, g, bl) <= 0x101 {
+				shadows++
+			}
+			if max(r, g, bl) >= 0xfefe {
+				highlights++
+			}
+		}
+	}
+	if n := float64(b.Dx() * b.Dy()); n > 0 {
+		h.Shadows = float64(shadows) / n
+		h.Highlights = float64(highlights) / n
+	}
+	return h
+}
+
+// writeJSON answers with status and v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with status and a JSON error message
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
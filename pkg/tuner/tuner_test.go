@@ -0,0 +1,258 @@
+package tuner
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/harperreed/hdarrrr/pkg/config"
+	"github.com/harperreed/hdarrrr/pkg/processor"
+	"github.com/mdouchement/hdr"
+	"github.com/mdouchement/hdr/hdrcolor"
+)
+
+// gradient returns a w x h radiance map spanning four stops
+func gradient(w, h int) *hdr.RGB {
+	m := hdr.NewRGB(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 0.05 * float64(1+x*15/w)
+			m.SetRGB(x, y, hdrcolor.RGB{R: v, G: v * 0.8, B: v * 0.6})
+		}
+	}
+	return m
+}
+
+// get serves a GET request for target and decodes the JSON answer into v
+func get(t *testing.T, h http.Handler, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestOperatorsCoverToneMappers(t *testing.T) {
+	for _, name := range processor.ToneMappers {
+		if len(Operators[name]) == 0 {
+			t.Errorf("No parameters for tone mapper %s", name)
+		}
+	}
+	for name := range Operators {
+		if !slices.Contains(processor.ToneMappers, name) {
+			t.Errorf("Parameters for unknown tone mapper %s", name)
+		}
+	}
+}
+
+func TestStaticFiles(t *testing.T) {
+	tn := New(gradient(8, 8), []string{"scene.hdr"}, config.Default())
+	for target, want := range map[string]string{
+		"/":          "text/html",
+		"/app.js":    "javascript",
+		"/style.css": "text/css",
+	} {
+		rec := httptest.NewRecorder()
+		tn.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Type"), want) {
+			t.Errorf("%s: got %d %q, want 200 %s", target, rec.Code, rec.Header().Get("Content-Type"), want)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	tn := New(gradient(40, 20), []string{"scene.hdr"}, config.Default(), WithPreviewSize(10))
+
+	var info struct {
+		Width, Height int
+		Preview       struct{ Width, Height int }
+		Settings      config.ToneMap
+		Operators     map[string][]Param
+		Colors        []Param
+		Preservations []string
+		Presets       map[string]config.ToneMap
+	}
+	if code := get(t, tn, "/api/info", &info); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if info.Width != 40 || info.Height != 20 || info.Preview.Width != 10 || info.Preview.Height != 5 {
+		t.Errorf("Unexpected sizes %+v", info)
+	}
+	if info.Settings != config.Default().ToneMap {
+		t.Errorf("Expected the initial settings, got %+v", info.Settings)
+	}
+	if len(info.Presets) != len(config.Presets()) || len(info.Operators) != len(Operators) {
+		t.Errorf("Unexpected presets %v or operators %v", info.Presets, info.Operators)
+	}
+	if len(info.Colors) != len(Colors) || !slices.Equal(info.Preservations, processor.ColorPreservations) {
+		t.Errorf("Unexpected colors %v or preservations %v", info.Colors, info.Preservations)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tn := New(gradient(64, 32), []string{"scene.hdr"}, config.Default(), WithPreviewSize(16))
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+	}{
+		{"initial settings", "", http.StatusOK},
+		{"drago03", "?operator=drago03&gamma=0.5", http.StatusOK},
+		{"reinhard05", "?operator=reinhard05&intensity=-1&light=0.3&gamma=0.7", http.StatusOK},
+		{"colors", "?preserve_color=ratio&color_exponent=0.8&saturation=1.2&vibrance=0.3", http.StatusOK},
+		{"unknown operator", "?operator=nope", http.StatusBadRequest},
+		{"bad number", "?gamma=high", http.StatusBadRequest},
+		{"unknown color preservation", "?preserve_color=hsv", http.StatusBadRequest},
+		{"vibrance out of range", "?vibrance=2", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r render
+			if code := get(t, tn, "/api/render"+tt.query, &r); code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d", tt.wantCode, code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if !strings.HasPrefix(r.Image, "data:image/jpeg;base64,") {
+				t.Errorf("Expected a JPEG data URL, got %.40q", r.Image)
+			}
+			var total int
+			for _, n := range r.Histogram.Luma {
+				total += n
+			}
+			if len(r.Histogram.Luma) != histogramBins || total != 16*8 {
+				t.Errorf("Expected %d bins counting 128 pixels, got %d bins counting %d", histogramBins, len(r.Histogram.Luma), total)
+			}
+		})
+	}
+}
+
+func TestRenderColors(t *testing.T) {
+	tn := New(gradient(64, 32), []string{"scene.hdr"}, config.Default(), WithPreviewSize(16))
+
+	// The gradient is orange until desaturated to gray
+	var colored, gray render
+	if code := get(t, tn, "/api/render", &colored); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := get(t, tn, "/api/render?saturation=0", &gray); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if slices.Equal(colored.Histogram.R, colored.Histogram.B) {
+		t.Error("Expected the initial preview to be colored")
+	}
+	if h := gray.Histogram; !slices.Equal(h.R, h.G) || !slices.Equal(h.G, h.B) {
+		t.Error("Expected a gray preview without saturation")
+	}
+
+	var exp map[string]string
+	if code := get(t, tn, "/api/export?preserve_color=ratio&color_exponent=0.8&vibrance=0.25", &exp); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if want := "-preserve-color ratio -color-exponent 0.8 -vibrance 0.25"; !strings.Contains(exp["command"], want) {
+		t.Errorf("Expected %q in the command, got %q", want, exp["command"])
+	}
+}
+
+func TestRenderGrayPoint(t *testing.T) {
+	cfg := config.Default()
+	cfg.WhiteBalance.Mode = "gray-point"
+	cfg.WhiteBalance.GrayPoint = []int{60, 30}
+	tn := New(gradient(64, 32), []string{"scene.hdr"}, cfg, WithPreviewSize(16))
+
+	// The gray point is outside the 16x8 preview until scaled to it
+	var r render
+	if code := get(t, tn, "/api/render", &r); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	var exp map[string]string
+	if code := get(t, tn, "/api/export", &exp); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if !strings.Contains(exp["command"], "-gray-point 60,30") {
+		t.Errorf("Expected the exported command to keep the full resolution gray point, got %q", exp["command"])
+	}
+}
+
+func TestExport(t *testing.T) {
+	cfg, _ := config.Preset("dramatic")
+	tn := New(gradient(8, 8), []string{"a.jpg", "b.jpg", "c.jpg"}, cfg)
+
+	var exp map[string]string
+	if code := get(t, tn, "/api/export?operator=reinhard05&intensity=1.5&light=0.2&gamma=0.9", &exp); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	parsed, err := config.Parse([]byte(exp["yaml"]), "yaml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cfg.ToneMap
+	want.Operator, want.Gamma, want.Intensity, want.Light = "reinhard05", 0.9, 1.5, 0.2
+	if parsed.ToneMap != want || parsed.Merge.Method != cfg.Merge.Method {
+		t.Errorf("Expected the exported preset to hold %+v and the merge settings, got %+v", want, parsed)
+	}
+	if !strings.HasPrefix(exp["command"], "hdarrrr process ") || !strings.HasSuffix(exp["command"], " a.jpg b.jpg c.jpg") {
+		t.Errorf("Unexpected command %q", exp["command"])
+	}
+}
+
+func TestCommand(t *testing.T) {
+	d := config.Default()
+	custom := d
+	custom.ToneMap.Operator, custom.ToneMap.Gamma = "drago03", 0.6
+	wide := d
+	wide.Color = config.Color{Working: "rec2020", Output: "display-p3"}
+	vivid := d
+	vivid.ToneMap.PreserveColor, vivid.ToneMap.ColorExponent, vivid.ToneMap.Vibrance = "ratio", 0.8, 0.25
+	warm := d
+	warm.WhiteBalance = config.WhiteBalance{Mode: "temperature", Temperature: 3200, Tint: 5, Adaptation: "cat02"}
+	gray := d
+	gray.WhiteBalance.Mode = "gray-point"
+	gray.WhiteBalance.GrayPoint = []int{40, 12}
+	denoised := d
+	denoised.Denoise.Method, denoised.Denoise.Shadows = "bilateral", 3
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		sources []string
+		want    string
+	}{
+		{"radiance defaults", d, []string{"scene.hdr"},
+			"hdarrrr tonemap -tonemapper " + d.ToneMap.Operator + " -output output.jpg scene.hdr"},
+		{"radiance custom", custom, []string{"my scene.hdr"},
+			"hdarrrr tonemap -tonemapper drago03 -gamma 0.6 -output output.jpg 'my scene.hdr'"},
+		{"bracket custom", custom, []string{"a.jpg", "b.jpg"},
+			"hdarrrr process -tonemapper drago03 -gamma 0.6 -output output.jpg a.jpg b.jpg"},
+		{"color spaces", wide, []string{"a.jpg", "b.jpg"},
+			"hdarrrr process -tonemapper " + d.ToneMap.Operator + " -working-space rec2020 -output-space display-p3 -output output.jpg a.jpg b.jpg"},
+		{"color", vivid, []string{"scene.hdr"},
+			"hdarrrr tonemap -tonemapper " + d.ToneMap.Operator + " -preserve-color ratio -color-exponent 0.8 -vibrance 0.25 -output output.jpg scene.hdr"},
+		{"temperature", warm, []string{"scene.hdr"},
+			"hdarrrr tonemap -tonemapper " + d.ToneMap.Operator + " -white-balance temperature -temperature 3200 -tint 5 -adaptation cat02 -output output.jpg scene.hdr"},
+		{"gray point", gray, []string{"scene.hdr"},
+			"hdarrrr tonemap -tonemapper " + d.ToneMap.Operator + " -white-balance gray-point -gray-point 40,12 -output output.jpg scene.hdr"},
+		{"denoise", denoised, []string{"a.jpg", "b.jpg"},
+			"hdarrrr process -denoise bilateral -denoise-shadows 3 -tonemapper " + d.ToneMap.Operator + " -output output.jpg a.jpg b.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Command(tt.cfg, tt.sources); got != tt.want {
+				t.Errorf("Command() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}